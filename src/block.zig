@@ -0,0 +1,94 @@
+const std = @import("std");
+
+// Largest block number, NUM is a 20-bit unsigned integer if the option
+// value is 3 bytes long (see RFC 7959 Section 2.2).
+pub const MAX_BLOCK_NUM = (1 << 20) - 1;
+
+/// Value of a Block1 or Block2 option.
+///
+/// From RFC 7959:
+///
+///  The value of the Block option is a variable-size (0 to 3 byte)
+///  unsigned integer (uint, see Section 3.2 of [RFC7252]). This integer
+///  value encodes these three fields: NUM, M and SZX.
+///
+pub const BlockValue = struct {
+    /// Relative number of the block within a sequence of blocks.
+    num: u20,
+    /// Whether more blocks are following.
+    more: bool,
+    /// Size exponent, the block size is 2**(SZX + 4) bytes.
+    szx: u3,
+
+    /// Decode a Block option value.
+    pub fn decode(value: []const u8) !BlockValue {
+        if (value.len > 3)
+            return error.InvalidLength;
+
+        var v: u24 = 0;
+        for (value) |b| {
+            v = (v << 8) | b;
+        }
+
+        // From RFC 7959:
+        //
+        //  The value 7 for SZX (which would indicate a block size of
+        //  2048) is reserved, i.e., MUST NOT be sent and MUST lead to
+        //  a 4.00 Bad Request response code upon reception in a request.
+        //
+        const szx = @truncate(u3, v);
+        if (szx == 7)
+            return error.ReservedSize;
+
+        return BlockValue{
+            .num = @truncate(u20, v >> 4),
+            .more = (v & 0x8) != 0,
+            .szx = szx,
+        };
+    }
+
+    /// Encode the Block option value into the given buffer, returns the
+    /// slice of the buffer containing the value without leading zeros.
+    pub fn encode(self: BlockValue, buf: *[3]u8) []const u8 {
+        var v: u24 = @as(u24, self.num) << 4 | self.szx;
+        if (self.more)
+            v |= 0x8;
+
+        std.mem.writeIntBig(u24, buf, v);
+
+        var i: usize = 0;
+        while (i < buf.len and buf[i] == 0) : (i += 1) {}
+        return buf[i..];
+    }
+
+    /// Size of a block in bytes.
+    pub fn size(self: BlockValue) usize {
+        return @as(usize, 1) << (@as(u5, self.szx) + 4);
+    }
+
+    /// Offset of the first byte of this block within the body.
+    pub fn offset(self: BlockValue) usize {
+        return @as(usize, self.num) * self.size();
+    }
+
+    /// Returns the value for requesting (Block2) or sending (Block1) the
+    /// next block of the same size, with the M bit cleared.
+    pub fn next(self: BlockValue) !BlockValue {
+        if (self.num == MAX_BLOCK_NUM)
+            return error.Overflow;
+        return BlockValue{ .num = self.num + 1, .more = false, .szx = self.szx };
+    }
+
+    /// Returns the value for the block with the given (smaller) size
+    /// exponent which starts at the same offset, as required for late
+    /// block size negotiation (see RFC 7959 Section 2.4).
+    pub fn resize(self: BlockValue, szx: u3) !BlockValue {
+        std.debug.assert(szx <= self.szx);
+
+        const factor = @as(u20, 1) << (self.szx - szx);
+        const num = std.math.mul(u20, self.num, factor) catch {
+            return error.Overflow;
+        };
+        return BlockValue{ .num = num, .more = self.more, .szx = szx };
+    }
+};
@@ -0,0 +1,281 @@
+const std = @import("std");
+const testing = std.testing;
+
+const opts = @import("opts.zig");
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+
+// Freshness lifetime of a response without a Max-Age option in seconds.
+//
+// From RFC 7252:
+//
+//  The Max-Age Option indicates the maximum time a response may be
+//  cached before it is considered not fresh.
+//
+// If the option is not present, a default value of 60 seconds is
+// assumed (see RFC 7252 Section 5.10.5).
+pub const DEFAULT_MAX_AGE = 60;
+
+/// Write the cache key of the given request to the given writer. The
+/// cache key consists of the request method and all options which are
+/// not marked as NoCacheKey (see RFC 7252 Section 5.6). Each option is
+/// written as its Option Number and length followed by its value, hence
+/// the key does not depend on the option encoding. Options already
+/// consumed from the request are not included. For FETCH requests, the
+/// payload is part of the cache key as well (see RFC 8132 Section 2).
+pub fn writeKey(writer: anytype, req: *const pkt.Request) !void {
+    try writer.writeByte(@bitCast(u8, req.header.code));
+
+    var iter = req.options();
+    while (try iter.next()) |opt| {
+        if (opts.isNoCacheKey(opt.number))
+            continue;
+
+        try writer.writeIntBig(u16, @intCast(u16, opt.number));
+        try writer.writeIntBig(u32, @intCast(u32, opt.value.len));
+        try writer.writeAll(opt.value);
+    }
+
+    if (req.header.code.equal(codes.FETCH)) {
+        if (iter.payload()) |payload|
+            try writer.writeAll(payload);
+    }
+}
+
+const HashWriter = std.io.Writer(*std.hash.Wyhash, error{}, hashWrite);
+
+fn hashWrite(hasher: *std.hash.Wyhash, bytes: []const u8) error{}!usize {
+    hasher.update(bytes);
+    return bytes.len;
+}
+
+/// Returns a hash of the cache key of the given request, see writeKey.
+/// Since different keys may have the same hash, caches must compare the
+/// full key (or the request) before using a stored response.
+pub fn key(req: *const pkt.Request) !u64 {
+    var hasher = std.hash.Wyhash.init(0);
+    try writeKey(HashWriter{ .context = &hasher }, req);
+    return hasher.final();
+}
+
+/// Tracks the freshness of a cached response. Times are given in
+/// seconds using an arbitrary monotonic clock provided by the caller.
+pub const Freshness = struct {
+    /// Time at which the response was received.
+    received: u64,
+    /// Freshness lifetime of the response in seconds.
+    max_age: u32,
+
+    /// Determine the freshness lifetime of the given response received
+    /// at the given time from its Max-Age option.
+    pub fn init(resp: *const pkt.Request, now: u64) !Freshness {
+        return Freshness{ .received = now, .max_age = try resp.getMaxAge() };
+    }
+
+    /// Whether the response is still fresh at the given time.
+    pub fn isFresh(self: Freshness, now: u64) bool {
+        return now < self.received + self.max_age;
+    }
+
+    /// Returns the remaining freshness lifetime at the given time, zero
+    /// if the response is no longer fresh. When serving a response from
+    /// its cache, a proxy must use this as the value of the Max-Age
+    /// option (see RFC 7252 Section 5.6.1).
+    pub fn remaining(self: Freshness, now: u64) u32 {
+        if (!self.isFresh(now))
+            return 0;
+        return @intCast(u32, self.received + self.max_age - now);
+    }
+};
+
+/// Response stored by a ResponseCache, see ResponseCache.lookup.
+pub const CachedResponse = struct {
+    /// The stored response message, including the Message ID and token
+    /// of the response it was created from.
+    response: []const u8,
+    freshness: Freshness,
+};
+
+/// Cache of responses to GET and FETCH requests, used by servers to
+/// answer repeated requests without invoking the handler again while
+/// the response is fresh. Responses are identified by the cache key of
+/// their request (see writeKey). Memory is statically allocated for the
+/// given number of entries, each storing the cache key and the response
+/// in at most size bytes. Times are given in seconds, see Freshness.
+pub fn ResponseCache(comptime capacity: usize, comptime size: usize) type {
+    return struct {
+        entries: [capacity]?Entry = [_]?Entry{null} ** capacity,
+
+        const Self = @This();
+        const Entry = struct {
+            hash: u64,
+            // Cache key of the request followed by the response.
+            buf: [size]u8,
+            key_len: usize,
+            len: usize,
+            freshness: Freshness,
+
+            fn response(self: *const Entry) []const u8 {
+                return self.buf[self.key_len..self.len];
+            }
+        };
+
+        // Write the cache key of the given request to the given buffer,
+        // returns null if the key does not fit into the buffer.
+        fn writeTo(buf: []u8, req: *const pkt.Request) !?[]const u8 {
+            var fbs = std.io.fixedBufferStream(buf);
+            writeKey(fbs.writer(), req) catch |err| switch (err) {
+                error.NoSpaceLeft => return null,
+                else => return err,
+            };
+            return fbs.getWritten();
+        }
+
+        // Returns the entry storing the response for the given cache key.
+        fn find(self: *Self, hash: u64, req_key: []const u8) ?*?Entry {
+            for (self.entries) |*slot| {
+                if (slot.*) |*entry| {
+                    if (entry.hash == hash and std.mem.eql(u8, entry.buf[0..entry.key_len], req_key))
+                        return slot;
+                }
+            }
+            return null;
+        }
+
+        // Returns a free entry or, if all entries are in use, the entry
+        // expiring first.
+        fn free(self: *Self) ?*?Entry {
+            var first: ?*?Entry = null;
+            for (self.entries) |*slot| {
+                const entry = if (slot.*) |*e| e else return slot;
+                if (first == null or expires(entry.freshness) < expires(first.?.*.?.freshness))
+                    first = slot;
+            }
+            return first;
+        }
+
+        /// Returns the response stored for the given request, provided
+        /// that it is still fresh at the given time. Stale responses are
+        /// released while searching.
+        pub fn lookup(self: *Self, req: *const pkt.Request, now: u64) !?CachedResponse {
+            for (self.entries) |*slot| {
+                if (slot.*) |*entry| {
+                    if (!entry.freshness.isFresh(now))
+                        slot.* = null;
+                }
+            }
+
+            var buf: [size]u8 = undefined;
+            const req_key = (try writeTo(&buf, req)) orelse return null;
+            const slot = self.find(std.hash.Wyhash.hash(0, req_key), req_key) orelse return null;
+            const entry = &slot.*.?;
+            return CachedResponse{ .response = entry.response(), .freshness = entry.freshness };
+        }
+
+        /// Store the given response to the given request received (or
+        /// created) at the given time, replacing a response stored for
+        /// the same request. Responses with a Max-Age of zero and
+        /// responses which do not fit into an entry together with the
+        /// cache key are not stored. If all entries are in use, the
+        /// entry expiring first is replaced.
+        pub fn insert(self: *Self, req: *const pkt.Request, resp: []const u8, now: u64) !void {
+            const msg = try pkt.Request.init(resp);
+            const freshness = try Freshness.init(&msg, now);
+            if (freshness.max_age == 0)
+                return;
+
+            var buf: [size]u8 = undefined;
+            const req_key = (try writeTo(&buf, req)) orelse return;
+            if (req_key.len + resp.len > size)
+                return;
+
+            const hash = std.hash.Wyhash.hash(0, req_key);
+            const slot = self.find(hash, req_key) orelse self.free() orelse return;
+            slot.* = Entry{
+                .hash = hash,
+                .buf = undefined,
+                .key_len = req_key.len,
+                .len = req_key.len + resp.len,
+                .freshness = freshness,
+            };
+            std.mem.copy(u8, &slot.*.?.buf, req_key);
+            std.mem.copy(u8, slot.*.?.buf[req_key.len..], resp);
+        }
+
+        /// Release all stored responses, e.g. after the state of a
+        /// resource has been changed.
+        pub fn clear(self: *Self) void {
+            for (self.entries) |*entry|
+                entry.* = null;
+        }
+
+        /// Returns the number of responses currently stored, including
+        /// stale ones which have not been released yet.
+        pub fn count(self: *const Self) usize {
+            var n: usize = 0;
+            for (self.entries) |entry| {
+                if (entry != null)
+                    n += 1;
+            }
+            return n;
+        }
+    };
+}
+
+// Returns the time at which a response becomes stale.
+fn expires(freshness: Freshness) u64 {
+    return freshness.received + freshness.max_age;
+}
+
+test "test cache key computation" {
+    // Size1 is a NoCacheKey option and does not affect the cache key.
+    const small = try pkt.Request.init(@embedFile("../testvectors/size1-request-1.bin"));
+    const large = try pkt.Request.init(@embedFile("../testvectors/size1-request-3.bin"));
+    try testing.expect((try key(&small)) == (try key(&large)));
+
+    const first = try pkt.Request.init(@embedFile("../testvectors/block2-szx0-0.bin"));
+    const second = try pkt.Request.init(@embedFile("../testvectors/block2-szx0-2.bin"));
+    try testing.expect((try key(&first)) != (try key(&second)));
+
+    var buf: [32]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&buf);
+    try writeKey(fbs.writer(), &first);
+    try testing.expectEqualSlices(u8, &[_]u8{ 0x01, 0, 11, 0, 0, 0, 5, 'l', 'a', 'r', 'g', 'e', 0, 23, 0, 0, 0, 0 }, fbs.getWritten());
+}
+
+test "test Max-Age freshness" {
+    var buf: [32]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.ack, codes.CONTENT, &[_]u8{}, 1);
+    try resp.addMaxAge(30);
+
+    const req = try pkt.Request.init(resp.marshal());
+    const fresh = try Freshness.init(&req, 1000);
+    try testing.expect(fresh.isFresh(1029) and !fresh.isFresh(1030));
+    try testing.expect(fresh.remaining(1010) == 20);
+    try testing.expect(fresh.remaining(2000) == 0);
+
+    // Responses without Max-Age option are fresh for 60 seconds.
+    const basic = try pkt.Request.init(@embedFile("../testvectors/basic-header.bin"));
+    try testing.expect((try Freshness.init(&basic, 0)).max_age == DEFAULT_MAX_AGE);
+}
+
+test "test cache key of FETCH requests" {
+    // The payload of FETCH requests is part of the cache key.
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.FETCH, &[_]u8{}, 1);
+    try msg.payloadWriter().writeAll("[\"temp\"]");
+    var req = try pkt.Request.init(msg.marshal());
+    const temp_key = try key(&req);
+
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.FETCH, &[_]u8{}, 1);
+    try msg.payloadWriter().writeAll("[\"hum\"]");
+    req = try pkt.Request.init(msg.marshal());
+    try testing.expect(temp_key != try key(&req));
+
+    msg.setCode(codes.GET);
+    req = try pkt.Request.init(msg.marshal());
+    const get_key = try key(&req);
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 1);
+    req = try pkt.Request.init(msg.marshal());
+    try testing.expect(get_key == try key(&req));
+}
@@ -0,0 +1,331 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+const ContentFormat = @import("contentformat.zig").ContentFormat;
+
+// Major type of a data item, encoded in the high-order 3 bits of the
+// initial byte (see RFC 8949 Section 3.1).
+const MajorType = enum(u3) {
+    uint = 0,
+    negint = 1,
+    bytes = 2,
+    text = 3,
+    array = 4,
+    map = 5,
+    tag = 6,
+    simple = 7,
+};
+
+// Values of the additional information in the low-order 5 bits of the
+// initial byte, values below 24 contain the argument directly.
+const ONE_BYTE = 24;
+const TWO_BYTES = 25;
+const FOUR_BYTES = 26;
+const EIGHT_BYTES = 27;
+const INDEFINITE = 31;
+
+// Simple values of major type 7 (see RFC 8949 Section 3.3).
+const FALSE = 20;
+const TRUE = 21;
+const NULL = 22;
+const UNDEFINED = 23;
+
+/// A CBOR data item as returned by the Decoder. Arrays, maps, and tags
+/// only contain the header, the enclosed data items follow separately.
+/// Indefinite-length items are not supported.
+pub const Item = union(enum) {
+    uint: u64,
+    /// Negative integer, the value is -1 minus the given number.
+    negint: u64,
+    bytes: []const u8,
+    text: []const u8,
+    /// Array with the given number of data items.
+    array: u64,
+    /// Map with the given number of key/value pairs.
+    map: u64,
+    /// Tag with the given number, the tagged data item follows.
+    tag: u64,
+    boolean: bool,
+    @"null": void,
+    @"undefined": void,
+    float: f64,
+
+    /// Returns the value of an integer data item. An error is returned
+    /// if the item is not an integer or if it does not fit into an i64.
+    pub fn int(self: Item) !i64 {
+        switch (self) {
+            .uint => |v| {
+                if (v > std.math.maxInt(i64))
+                    return error.Overflow;
+                return @intCast(i64, v);
+            },
+            .negint => |v| {
+                if (v > std.math.maxInt(i64))
+                    return error.Overflow;
+                return -1 - @intCast(i64, v);
+            },
+            else => return error.InvalidType,
+        }
+    }
+};
+
+/// Decoder for a sequence of CBOR data items (RFC 8949) stored in the
+/// given slice. Byte and text strings reference the slice, no memory
+/// is allocated.
+pub const Decoder = struct {
+    slice: []const u8,
+    pos: usize = 0,
+
+    fn read(self: *Decoder, n: u64) ![]const u8 {
+        if (n > self.slice.len - self.pos)
+            return error.FormatError;
+
+        const len = @intCast(usize, n);
+        const result = self.slice[self.pos .. self.pos + len];
+        self.pos += len;
+        return result;
+    }
+
+    fn argument(self: *Decoder, info: u5) !u64 {
+        return switch (info) {
+            0...23 => info,
+            ONE_BYTE => (try self.read(1))[0],
+            TWO_BYTES => std.mem.readIntBig(u16, (try self.read(2))[0..2]),
+            FOUR_BYTES => std.mem.readIntBig(u32, (try self.read(4))[0..4]),
+            EIGHT_BYTES => std.mem.readIntBig(u64, (try self.read(8))[0..8]),
+            INDEFINITE => error.Unsupported,
+            else => error.FormatError,
+        };
+    }
+
+    fn simple(self: *Decoder, info: u5) !Item {
+        return switch (info) {
+            FALSE => Item{ .boolean = false },
+            TRUE => Item{ .boolean = true },
+            NULL => Item{ .@"null" = {} },
+            UNDEFINED => Item{ .@"undefined" = {} },
+            TWO_BYTES => Item{ .float = @floatCast(f64, @bitCast(f16, std.mem.readIntBig(u16, (try self.read(2))[0..2]))) },
+            FOUR_BYTES => Item{ .float = @floatCast(f64, @bitCast(f32, std.mem.readIntBig(u32, (try self.read(4))[0..4]))) },
+            EIGHT_BYTES => Item{ .float = @bitCast(f64, std.mem.readIntBig(u64, (try self.read(8))[0..8])) },
+            else => error.Unsupported,
+        };
+    }
+
+    /// Returns the next data item or null if the end of the slice has
+    /// been reached.
+    pub fn next(self: *Decoder) !?Item {
+        if (self.pos == self.slice.len)
+            return null;
+
+        const initial = (try self.read(1))[0];
+        const major = @intToEnum(MajorType, @truncate(u3, initial >> 5));
+        const info = @truncate(u5, initial);
+        if (major == MajorType.simple)
+            return try self.simple(info);
+
+        const arg = try self.argument(info);
+        return switch (major) {
+            .uint => Item{ .uint = arg },
+            .negint => Item{ .negint = arg },
+            .bytes => Item{ .bytes = try self.read(arg) },
+            .text => blk: {
+                const str = try self.read(arg);
+                if (!std.unicode.utf8ValidateSlice(str))
+                    return error.InvalidString;
+                break :blk Item{ .text = str };
+            },
+            .array => Item{ .array = arg },
+            .map => Item{ .map = arg },
+            .tag => Item{ .tag = arg },
+            .simple => unreachable,
+        };
+    }
+
+    /// Skip the next data item, including all data items enclosed in it.
+    pub fn skip(self: *Decoder) !void {
+        var pending: u64 = 1;
+        while (pending > 0) : (pending -= 1) {
+            const item = (try self.next()) orelse return error.FormatError;
+            const enclosed: u64 = switch (item) {
+                .array => |n| n,
+                .map => |n| std.math.mul(u64, n, 2) catch {
+                    return error.FormatError;
+                },
+                .tag => 1,
+                else => 0,
+            };
+            pending = std.math.add(u64, pending, enclosed) catch {
+                return error.FormatError;
+            };
+        }
+    }
+};
+
+/// Encoder writing CBOR data items (RFC 8949) to the given writer. The
+/// argument of each data item is encoded in the shortest form, floating
+/// point values are encoded in the shortest form that preserves them.
+pub fn Encoder(comptime Writer: type) type {
+    return struct {
+        writer: Writer,
+
+        const Self = @This();
+
+        fn head(self: Self, major: MajorType, arg: u64) !void {
+            const mt = @as(u8, @enumToInt(major)) << 5;
+            if (arg < ONE_BYTE) {
+                try self.writer.writeByte(mt | @intCast(u8, arg));
+            } else if (arg <= std.math.maxInt(u8)) {
+                try self.writer.writeByte(mt | ONE_BYTE);
+                try self.writer.writeByte(@intCast(u8, arg));
+            } else if (arg <= std.math.maxInt(u16)) {
+                try self.writer.writeByte(mt | TWO_BYTES);
+                try self.writer.writeIntBig(u16, @intCast(u16, arg));
+            } else if (arg <= std.math.maxInt(u32)) {
+                try self.writer.writeByte(mt | FOUR_BYTES);
+                try self.writer.writeIntBig(u32, @intCast(u32, arg));
+            } else {
+                try self.writer.writeByte(mt | EIGHT_BYTES);
+                try self.writer.writeIntBig(u64, arg);
+            }
+        }
+
+        pub fn uint(self: Self, value: u64) !void {
+            try self.head(MajorType.uint, value);
+        }
+
+        pub fn int(self: Self, value: i64) !void {
+            if (value >= 0) {
+                try self.head(MajorType.uint, @intCast(u64, value));
+            } else {
+                try self.head(MajorType.negint, @intCast(u64, -1 - value));
+            }
+        }
+
+        pub fn bytes(self: Self, value: []const u8) !void {
+            try self.head(MajorType.bytes, value.len);
+            try self.writer.writeAll(value);
+        }
+
+        pub fn text(self: Self, value: []const u8) !void {
+            try self.head(MajorType.text, value.len);
+            try self.writer.writeAll(value);
+        }
+
+        /// Write the header of an array, the given number of data items
+        /// must be written afterwards.
+        pub fn array(self: Self, len: u64) !void {
+            try self.head(MajorType.array, len);
+        }
+
+        /// Write the header of a map, the given number of key/value
+        /// pairs must be written afterwards.
+        pub fn map(self: Self, len: u64) !void {
+            try self.head(MajorType.map, len);
+        }
+
+        /// Write a tag, the tagged data item must be written afterwards.
+        pub fn tag(self: Self, number: u64) !void {
+            try self.head(MajorType.tag, number);
+        }
+
+        pub fn boolean(self: Self, value: bool) !void {
+            const simple: u64 = if (value) TRUE else FALSE;
+            try self.head(MajorType.simple, simple);
+        }
+
+        pub fn @"null"(self: Self) !void {
+            try self.head(MajorType.simple, NULL);
+        }
+
+        pub fn float(self: Self, value: f64) !void {
+            const mt = @as(u8, @enumToInt(MajorType.simple)) << 5;
+
+            const half = @floatCast(f16, value);
+            if (@floatCast(f64, half) == value) {
+                try self.writer.writeByte(mt | TWO_BYTES);
+                try self.writer.writeIntBig(u16, @bitCast(u16, half));
+                return;
+            }
+
+            const single = @floatCast(f32, value);
+            if (@floatCast(f64, single) == value) {
+                try self.writer.writeByte(mt | FOUR_BYTES);
+                try self.writer.writeIntBig(u32, @bitCast(u32, single));
+                return;
+            }
+
+            try self.writer.writeByte(mt | EIGHT_BYTES);
+            try self.writer.writeIntBig(u64, @bitCast(u64, value));
+        }
+    };
+}
+
+/// Returns an Encoder for the given writer.
+pub fn encoder(writer: anytype) Encoder(@TypeOf(writer)) {
+    return Encoder(@TypeOf(writer)){ .writer = writer };
+}
+
+test "test CBOR encoding" {
+    var buf: [64]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&buf);
+    const enc = encoder(fbs.writer());
+
+    // Examples from RFC 8949 Appendix A.
+    try enc.uint(1000000);
+    try enc.int(-1000);
+    try enc.float(1.5);
+    try enc.float(100000.0);
+    try enc.float(1.1);
+    try enc.text("IETF");
+    try enc.boolean(true);
+    try enc.@"null"();
+
+    try testing.expectEqualSlices(u8, &[_]u8{
+        0x1a, 0x00, 0x0f, 0x42, 0x40,
+        0x39, 0x03, 0xe7,
+        0xf9, 0x3e, 0x00,
+        0xfa, 0x47, 0xc3, 0x50, 0x00,
+        0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a,
+        0x64, 0x49, 0x45, 0x54, 0x46,
+        0xf5,
+        0xf6,
+    }, fbs.getWritten());
+}
+
+test "test CBOR payload" {
+    const req = try pkt.Request.init(@embedFile("../testvectors/content-format-bin"));
+    var dec = try req.cborDecoder();
+    try testing.expect((try (try dec.next()).?.int()) == 0);
+    try testing.expect((try dec.next()) == null);
+
+    const other = try pkt.Request.init(@embedFile("../testvectors/content-format-json.bin"));
+    try testing.expectError(error.InvalidContentFormat, other.cborDecoder());
+
+    var buf: [64]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.ack, codes.CONTENT, &[_]u8{}, 1);
+    const enc = try resp.cborEncoder();
+    try enc.map(3);
+    try enc.uint(1);
+    try enc.array(2);
+    try enc.text("temp");
+    try enc.bytes(&[_]u8{ 0xca, 0xfe });
+    try enc.uint(2);
+    try enc.int(-5);
+    try enc.uint(3);
+    try enc.float(21.5);
+
+    const parsed = try pkt.Request.init(resp.marshal());
+    try testing.expect((try parsed.contentFormat()).? == ContentFormat.cbor);
+
+    dec = try parsed.cborDecoder();
+    try testing.expect((try dec.next()).?.map == 3);
+    try testing.expect((try dec.next()).?.uint == 1);
+    try dec.skip();
+    try testing.expect((try dec.next()).?.uint == 2);
+    try testing.expect((try (try dec.next()).?.int()) == -5);
+    try testing.expect((try dec.next()).?.uint == 3);
+    try testing.expect((try dec.next()).?.float == 21.5);
+    try testing.expect((try dec.next()) == null);
+}
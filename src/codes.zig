@@ -1,3 +1,5 @@
+const std = @import("std");
+
 pub const Code = packed struct {
     detail: u5,
     class: u3,
@@ -5,6 +7,178 @@ pub const Code = packed struct {
     pub fn equal(self: Code, other: Code) bool {
         return self.class == other.class and self.detail == other.detail;
     }
+
+    /// Whether this is the code 0.00 of an Empty message.
+    pub fn isEmpty(self: Code) bool {
+        return self.class == 0 and self.detail == 0;
+    }
+
+    /// Whether this is a request method code (0.01-0.31).
+    pub fn isRequest(self: Code) bool {
+        return self.class == 0 and self.detail != 0;
+    }
+
+    /// Whether this is the code of a safe request method, i.e. of GET or
+    /// FETCH (see RFC 7252 Section 5.1 and RFC 8132 Section 2).
+    pub fn isSafe(self: Code) bool {
+        return self.class == 0 and (self.detail == 01 or self.detail == 05);
+    }
+
+    /// Whether this is the code of an idempotent request method, i.e.
+    /// of GET, PUT, DELETE, FETCH, or iPATCH (see RFC 7252 Section 5.1
+    /// and RFC 8132 Section 2).
+    pub fn isIdempotent(self: Code) bool {
+        return self.class == 0 and switch (self.detail) {
+            01, 03, 04, 05, 07 => true,
+            else => false,
+        };
+    }
+
+    /// Whether this is a response code of the Success class (2.xx).
+    pub fn isSuccess(self: Code) bool {
+        return self.class == 2;
+    }
+
+    /// Whether this is a response code of the Client Error class (4.xx).
+    pub fn isClientError(self: Code) bool {
+        return self.class == 4;
+    }
+
+    /// Whether this is a response code of the Server Error class (5.xx).
+    pub fn isServerError(self: Code) bool {
+        return self.class == 5;
+    }
+
+    /// Parse a code in the "c.dd" notation (e.g. "4.04") used by
+    /// RFC 7252, where c is the class and dd the detail.
+    pub fn fromDotted(str: []const u8) !Code {
+        if (str.len != 4 or str[1] != '.')
+            return error.InvalidCode;
+
+        const class = std.fmt.parseUnsigned(u3, str[0..1], 10) catch {
+            return error.InvalidCode;
+        };
+        const detail = std.fmt.parseUnsigned(u5, str[2..4], 10) catch {
+            return error.InvalidCode;
+        };
+
+        return Code{ .class = class, .detail = detail };
+    }
+
+    /// Returns the name of the code as registered in the CoAP Method
+    /// Codes and Response Codes registries (e.g. "Not Found") or null if
+    /// the code is not registered.
+    pub fn name(self: Code) ?[]const u8 {
+        return switch (@as(u16, self.class) * 100 + self.detail) {
+            000 => "Empty",
+            001 => "GET",
+            002 => "POST",
+            003 => "PUT",
+            004 => "DELETE",
+            005 => "FETCH",
+            006 => "PATCH",
+            007 => "iPATCH",
+            201 => "Created",
+            202 => "Deleted",
+            203 => "Valid",
+            204 => "Changed",
+            205 => "Content",
+            231 => "Continue",
+            400 => "Bad Request",
+            401 => "Unauthorized",
+            402 => "Bad Option",
+            403 => "Forbidden",
+            404 => "Not Found",
+            405 => "Method Not Allowed",
+            406 => "Not Acceptable",
+            408 => "Request Entity Incomplete",
+            409 => "Conflict",
+            412 => "Precondition Failed",
+            413 => "Request Entity Too Large",
+            415 => "Unsupported Content-Format",
+            422 => "Unprocessable Entity",
+            429 => "Too Many Requests",
+            500 => "Internal Server Error",
+            501 => "Not Implemented",
+            502 => "Bad Gateway",
+            503 => "Service Unavailable",
+            504 => "Gateway Timeout",
+            505 => "Proxying Not Supported",
+            508 => "Hop Limit Reached",
+            else => null,
+        };
+    }
+
+    /// Returns the ResponseError corresponding to a response code of the
+    /// Client Error (4.xx) or Server Error (5.xx) class.
+    ///
+    /// From RFC 7252:
+    ///
+    ///  Unrecognized response codes MUST be treated as being equivalent
+    ///  to the generic response code of that class (e.g., 4.00 for codes
+    ///  not otherwise understood, as described in Section 5.9.2).
+    ///
+    pub fn check(self: Code) ResponseError!void {
+        switch (self.class) {
+            4 => return switch (self.detail) {
+                01 => error.Unauthorized,
+                02 => error.BadOption,
+                03 => error.Forbidden,
+                04 => error.NotFound,
+                05 => error.MethodNotAllowed,
+                06 => error.NotAcceptable,
+                08 => error.RequestEntityIncomplete,
+                09 => error.Conflict,
+                12 => error.PreconditionFailed,
+                13 => error.RequestEntityTooLarge,
+                15 => error.UnsupportedContentFormat,
+                22 => error.UnprocessableEntity,
+                29 => error.TooManyRequests,
+                else => error.BadRequest,
+            },
+            5 => return switch (self.detail) {
+                01 => error.NotImplemented,
+                02 => error.BadGateway,
+                03 => error.ServiceUnavailable,
+                04 => error.GatewayTimeout,
+                05 => error.ProxyingNotSupported,
+                else => error.InternalServerError,
+            },
+            else => {},
+        }
+    }
+
+    /// Format the code in the "c.dd" notation, e.g. "2.05".
+    pub fn format(self: Code, comptime fmt: []const u8, options: std.fmt.FormatOptions, writer: anytype) !void {
+        _ = fmt;
+        _ = options;
+        try writer.print("{d}.{d:0>2}", .{ self.class, self.detail });
+    }
+};
+
+/// Errors for the response codes of the Client Error (4.xx) and Server
+/// Error (5.xx) classes, see Code.check.
+pub const ResponseError = error{
+    BadRequest,
+    Unauthorized,
+    BadOption,
+    Forbidden,
+    NotFound,
+    MethodNotAllowed,
+    NotAcceptable,
+    RequestEntityIncomplete,
+    Conflict,
+    PreconditionFailed,
+    RequestEntityTooLarge,
+    UnsupportedContentFormat,
+    UnprocessableEntity,
+    TooManyRequests,
+    InternalServerError,
+    NotImplemented,
+    BadGateway,
+    ServiceUnavailable,
+    GatewayTimeout,
+    ProxyingNotSupported,
 };
 
 // See https://datatracker.ietf.org/doc/html/rfc7252#section-12.1
@@ -14,6 +188,10 @@ pub const GET = Code{ .class = 0, .detail = 01 };
 pub const POST = Code{ .class = 0, .detail = 02 };
 pub const PUT = Code{ .class = 0, .detail = 03 };
 pub const DELETE = Code{ .class = 0, .detail = 04 };
+// See RFC 8132 Section 6
+pub const FETCH = Code{ .class = 0, .detail = 05 };
+pub const PATCH = Code{ .class = 0, .detail = 06 };
+pub const IPATCH = Code{ .class = 0, .detail = 07 };
 
 // Responses
 pub const CREATED = Code{ .class = 2, .detail = 01 };
@@ -21,6 +199,7 @@ pub const DELETED = Code{ .class = 2, .detail = 02 };
 pub const VALID = Code{ .class = 2, .detail = 03 };
 pub const CHANGED = Code{ .class = 2, .detail = 04 };
 pub const CONTENT = Code{ .class = 2, .detail = 05 };
+pub const CONTINUE = Code{ .class = 2, .detail = 31 };
 //
 pub const BAD_REQ = Code{ .class = 4, .detail = 00 };
 pub const UNAUTH = Code{ .class = 4, .detail = 01 };
@@ -29,6 +208,17 @@ pub const FORBIDDEN = Code{ .class = 4, .detail = 03 };
 pub const NOT_FOUND = Code{ .class = 4, .detail = 04 };
 pub const BAD_METHOD = Code{ .class = 4, .detail = 05 };
 pub const NOT_ACCEPT = Code{ .class = 4, .detail = 06 };
+pub const INCOMPLETE = Code{ .class = 4, .detail = 08 };
+pub const CONFLICT = Code{ .class = 4, .detail = 09 };
+pub const PRECOND_FAILED = Code{ .class = 4, .detail = 12 };
+pub const TOO_LARGE = Code{ .class = 4, .detail = 13 };
+pub const UNSUPPORTED_FORMAT = Code{ .class = 4, .detail = 15 };
+pub const UNPROCESSABLE = Code{ .class = 4, .detail = 22 };
+pub const TOO_MANY = Code{ .class = 4, .detail = 29 };
 //
 pub const NOT_IMPL = Code{ .class = 5, .detail = 01 };
 pub const INTERNAL_ERR = Code{ .class = 5, .detail = 00 };
+pub const BAD_GATEWAY = Code{ .class = 5, .detail = 02 };
+pub const UNAVAILABLE = Code{ .class = 5, .detail = 03 };
+pub const GATEWAY_TIMEOUT = Code{ .class = 5, .detail = 04 };
+pub const NO_PROXY = Code{ .class = 5, .detail = 05 };
@@ -0,0 +1,196 @@
+const std = @import("std");
+const testing = std.testing;
+
+/// Content-Format of a representation, as indicated by the Content-Format
+/// and Accept options. The named values are taken from the IANA CoAP
+/// Content-Formats registry (see RFC 7252 Section 12.3). Since values
+/// may be registered later on, the enum is non-exhaustive.
+pub const ContentFormat = enum(u16) {
+    text_plain = 0,
+    cose_encrypt0 = 16,
+    cose_mac0 = 17,
+    cose_sign1 = 18,
+    ace_cbor = 19,
+    image_gif = 21,
+    image_jpeg = 22,
+    image_png = 23,
+    link_format = 40,
+    xml = 41,
+    octet_stream = 42,
+    exi = 47,
+    json = 50,
+    json_patch_json = 51,
+    merge_patch_json = 52,
+    cbor = 60,
+    cwt = 61,
+    multipart_core = 62,
+    cbor_seq = 63,
+    cose_encrypt = 96,
+    cose_mac = 97,
+    cose_sign = 98,
+    cose_key = 101,
+    cose_key_set = 102,
+    senml_json = 110,
+    sensml_json = 111,
+    senml_cbor = 112,
+    sensml_cbor = 113,
+    senml_exi = 114,
+    sensml_exi = 115,
+    yang_data_cbor_sid = 140,
+    coap_group_json = 256,
+    concise_problem_details_cbor = 257,
+    swid_cbor = 258,
+    dots_cbor = 271,
+    missing_blocks_cbor_seq = 272,
+    pkcs7_server_generated_key = 280,
+    pkcs7_certs_only = 281,
+    pkcs8 = 284,
+    csrattrs = 285,
+    pkcs10 = 286,
+    aif_cbor = 290,
+    aif_json = 291,
+    senml_xml = 310,
+    sensml_xml = 311,
+    senml_etch_json = 320,
+    senml_etch_cbor = 322,
+    yang_data_cbor = 340,
+    yang_data_cbor_name = 341,
+    td_json = 432,
+    vnd_ocf_cbor = 10000,
+    oscore = 10001,
+    lwm2m_tlv = 11542,
+    lwm2m_json = 11543,
+    lwm2m_cbor = 11544,
+    text_css = 20000,
+    image_svg_xml = 30000,
+    _,
+
+    /// Returns the media type (including parameters) registered for the
+    /// Content-Format or null if the Content-Format is not known.
+    pub fn mediaType(self: ContentFormat) ?[]const u8 {
+        for (registry) |entry| {
+            if (entry.format == self)
+                return entry.media_type;
+        }
+        return null;
+    }
+
+    /// Returns the Content-Format registered for the given media type or
+    /// null if the media type is not known. The media type must match
+    /// the registered one exactly, including parameters.
+    pub fn fromMediaType(media_type: []const u8) ?ContentFormat {
+        for (registry) |entry| {
+            if (std.mem.eql(u8, entry.media_type, media_type))
+                return entry.format;
+        }
+        return null;
+    }
+};
+
+/// Select the Content-Format of a response from the given offered
+/// Content-Formats, in order of the preference of the server, based on
+/// the given accepted Content-Formats, in order of the preference of the
+/// client. If the client did not indicate any accepted Content-Format,
+/// the first offered one is selected. Returns null if none of the
+/// accepted Content-Formats is offered, in which case the server should
+/// respond with 4.06 (Not Acceptable).
+pub fn negotiate(accepted: []const ContentFormat, offered: []const ContentFormat) ?ContentFormat {
+    if (accepted.len == 0) {
+        if (offered.len == 0)
+            return null;
+        return offered[0];
+    }
+
+    for (accepted) |a| {
+        for (offered) |o| {
+            if (a == o)
+                return a;
+        }
+    }
+    return null;
+}
+
+const Entry = struct {
+    format: ContentFormat,
+    media_type: []const u8,
+};
+
+const registry = [_]Entry{
+    .{ .format = .text_plain, .media_type = "text/plain; charset=utf-8" },
+    .{ .format = .cose_encrypt0, .media_type = "application/cose; cose-type=\"cose-encrypt0\"" },
+    .{ .format = .cose_mac0, .media_type = "application/cose; cose-type=\"cose-mac0\"" },
+    .{ .format = .cose_sign1, .media_type = "application/cose; cose-type=\"cose-sign1\"" },
+    .{ .format = .ace_cbor, .media_type = "application/ace+cbor" },
+    .{ .format = .image_gif, .media_type = "image/gif" },
+    .{ .format = .image_jpeg, .media_type = "image/jpeg" },
+    .{ .format = .image_png, .media_type = "image/png" },
+    .{ .format = .link_format, .media_type = "application/link-format" },
+    .{ .format = .xml, .media_type = "application/xml" },
+    .{ .format = .octet_stream, .media_type = "application/octet-stream" },
+    .{ .format = .exi, .media_type = "application/exi" },
+    .{ .format = .json, .media_type = "application/json" },
+    .{ .format = .json_patch_json, .media_type = "application/json-patch+json" },
+    .{ .format = .merge_patch_json, .media_type = "application/merge-patch+json" },
+    .{ .format = .cbor, .media_type = "application/cbor" },
+    .{ .format = .cwt, .media_type = "application/cwt" },
+    .{ .format = .multipart_core, .media_type = "application/multipart-core" },
+    .{ .format = .cbor_seq, .media_type = "application/cbor-seq" },
+    .{ .format = .cose_encrypt, .media_type = "application/cose; cose-type=\"cose-encrypt\"" },
+    .{ .format = .cose_mac, .media_type = "application/cose; cose-type=\"cose-mac\"" },
+    .{ .format = .cose_sign, .media_type = "application/cose; cose-type=\"cose-sign\"" },
+    .{ .format = .cose_key, .media_type = "application/cose-key" },
+    .{ .format = .cose_key_set, .media_type = "application/cose-key-set" },
+    .{ .format = .senml_json, .media_type = "application/senml+json" },
+    .{ .format = .sensml_json, .media_type = "application/sensml+json" },
+    .{ .format = .senml_cbor, .media_type = "application/senml+cbor" },
+    .{ .format = .sensml_cbor, .media_type = "application/sensml+cbor" },
+    .{ .format = .senml_exi, .media_type = "application/senml-exi" },
+    .{ .format = .sensml_exi, .media_type = "application/sensml-exi" },
+    .{ .format = .yang_data_cbor_sid, .media_type = "application/yang-data+cbor; id=sid" },
+    .{ .format = .coap_group_json, .media_type = "application/coap-group+json" },
+    .{ .format = .concise_problem_details_cbor, .media_type = "application/concise-problem-details+cbor" },
+    .{ .format = .swid_cbor, .media_type = "application/swid+cbor" },
+    .{ .format = .dots_cbor, .media_type = "application/dots+cbor" },
+    .{ .format = .missing_blocks_cbor_seq, .media_type = "application/missing-blocks+cbor-seq" },
+    .{ .format = .pkcs7_server_generated_key, .media_type = "application/pkcs7-mime; smime-type=server-generated-key" },
+    .{ .format = .pkcs7_certs_only, .media_type = "application/pkcs7-mime; smime-type=certs-only" },
+    .{ .format = .pkcs8, .media_type = "application/pkcs8" },
+    .{ .format = .csrattrs, .media_type = "application/csrattrs" },
+    .{ .format = .pkcs10, .media_type = "application/pkcs10" },
+    .{ .format = .aif_cbor, .media_type = "application/aif+cbor" },
+    .{ .format = .aif_json, .media_type = "application/aif+json" },
+    .{ .format = .senml_xml, .media_type = "application/senml+xml" },
+    .{ .format = .sensml_xml, .media_type = "application/sensml+xml" },
+    .{ .format = .senml_etch_json, .media_type = "application/senml-etch+json" },
+    .{ .format = .senml_etch_cbor, .media_type = "application/senml-etch+cbor" },
+    .{ .format = .yang_data_cbor, .media_type = "application/yang-data+cbor" },
+    .{ .format = .yang_data_cbor_name, .media_type = "application/yang-data+cbor; id=name" },
+    .{ .format = .td_json, .media_type = "application/td+json" },
+    .{ .format = .vnd_ocf_cbor, .media_type = "application/vnd.ocf+cbor" },
+    .{ .format = .oscore, .media_type = "application/oscore" },
+    .{ .format = .lwm2m_tlv, .media_type = "application/vnd.oma.lwm2m+tlv" },
+    .{ .format = .lwm2m_json, .media_type = "application/vnd.oma.lwm2m+json" },
+    .{ .format = .lwm2m_cbor, .media_type = "application/vnd.oma.lwm2m+cbor" },
+    .{ .format = .text_css, .media_type = "text/css" },
+    .{ .format = .image_svg_xml, .media_type = "image/svg+xml" },
+};
+
+test "test Content-Format registry lookup" {
+    try testing.expect(std.mem.eql(u8, ContentFormat.json.mediaType().?, "application/json"));
+    try testing.expect(std.mem.eql(u8, ContentFormat.text_plain.mediaType().?, "text/plain; charset=utf-8"));
+    try testing.expect(ContentFormat.fromMediaType("application/senml+cbor").? == .senml_cbor);
+    try testing.expect(ContentFormat.fromMediaType("application/x-unknown") == null);
+
+    // Values which are not registered.
+    const unknown = @intToEnum(ContentFormat, 65000);
+    try testing.expect(unknown.mediaType() == null);
+}
+
+test "test content negotiation" {
+    const offered = [_]ContentFormat{ ContentFormat.cbor, ContentFormat.json };
+    try testing.expect(negotiate(&[_]ContentFormat{}, &offered).? == ContentFormat.cbor);
+    try testing.expect(negotiate(&[_]ContentFormat{ContentFormat.json}, &offered).? == ContentFormat.json);
+    try testing.expect(negotiate(&[_]ContentFormat{ ContentFormat.text_plain, ContentFormat.json }, &offered).? == ContentFormat.json);
+    try testing.expect(negotiate(&[_]ContentFormat{ContentFormat.text_plain}, &offered) == null);
+    try testing.expect(negotiate(&[_]ContentFormat{}, &[_]ContentFormat{}) == null);
+}
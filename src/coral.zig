@@ -0,0 +1,233 @@
+const std = @import("std");
+const testing = std.testing;
+
+const cbor = @import("cbor.zig");
+
+// CoRAL is still an Internet-Draft (draft-ietf-core-coral). This file
+// implements a subset of the binary format described there: a document
+// is a CBOR array of elements, each element is an array starting with
+// its type. Links and forms may carry a nested body, i.e. another array
+// of elements. IRIs are represented as CBOR text strings, dictionary
+// compression is not supported. Since no Content-Format has been
+// registered for CoRAL yet, none is set automatically.
+
+// Maximum nesting depth of bodies supported by the Reader.
+pub const MAX_DEPTH = 8;
+
+// Element types of the binary format.
+const BASE_DIRECTIVE = 1;
+const LINK = 2;
+const FORM = 3;
+
+/// Kind of a CoRAL element.
+pub const Kind = enum {
+    /// Base directive, sets the base IRI for the following elements.
+    base,
+    /// Link from the context to a target resource or literal value.
+    link,
+    /// Form describing an operation on a submission target.
+    form,
+};
+
+/// An element of a CoRAL document.
+pub const Element = struct {
+    kind: Kind,
+    /// Nesting depth of the element, zero for top-level elements.
+    depth: usize,
+    /// Base IRI (base), relation type (link), or operation type (form).
+    name: []const u8,
+    /// Link target or submission target, absent for base directives.
+    target: ?cbor.Item,
+    /// Number of elements in the nested body, which are returned next
+    /// with an increased depth.
+    body_len: u64,
+};
+
+/// Reader for the elements of a CoRAL document, nested elements are
+/// returned in document order directly after the enclosing element.
+/// Unknown elements and form fields are skipped.
+pub const Reader = struct {
+    dec: cbor.Decoder,
+    // Number of remaining elements in each currently open body.
+    remaining: [MAX_DEPTH + 1]u64 = undefined,
+    depth: usize = 0,
+
+    pub fn init(data: []const u8) !Reader {
+        var reader = Reader{ .dec = cbor.Decoder{ .slice = data } };
+        const item = (try reader.dec.next()) orelse return error.FormatError;
+        switch (item) {
+            .array => |n| reader.remaining[0] = n,
+            else => return error.FormatError,
+        }
+        return reader;
+    }
+
+    fn expect(self: *Reader) !cbor.Item {
+        return (try self.dec.next()) orelse return error.FormatError;
+    }
+
+    fn text(self: *Reader) ![]const u8 {
+        switch (try self.expect()) {
+            .text => |v| return v,
+            else => return error.FormatError,
+        }
+    }
+
+    /// Returns the next element or null if the end of the document has
+    /// been reached.
+    pub fn next(self: *Reader) !?Element {
+        while (true) {
+            while (self.remaining[self.depth] == 0) {
+                if (self.depth == 0)
+                    return null;
+                self.depth -= 1;
+            }
+            self.remaining[self.depth] -= 1;
+
+            const len = switch (try self.expect()) {
+                .array => |n| n,
+                else => return error.FormatError,
+            };
+            if (len == 0)
+                return error.FormatError;
+
+            const element_type = try (try self.expect()).int();
+            switch (element_type) {
+                BASE_DIRECTIVE => {
+                    if (len != 2)
+                        return error.FormatError;
+                    return Element{ .kind = .base, .depth = self.depth, .name = try self.text(), .target = null, .body_len = 0 };
+                },
+                LINK, FORM => {
+                    if (len < 3 or len > 4)
+                        return error.FormatError;
+
+                    const kind = if (element_type == LINK) Kind.link else Kind.form;
+                    const name = try self.text();
+                    const target = try self.expect();
+                    switch (target) {
+                        // Structured targets (e.g. CRIs) are not supported.
+                        .array, .map, .tag => return error.Unsupported,
+                        else => {},
+                    }
+
+                    var element = Element{ .kind = kind, .depth = self.depth, .name = name, .target = target, .body_len = 0 };
+                    if (len == 3)
+                        return element;
+
+                    // Form fields are not supported and skipped.
+                    if (kind == Kind.form) {
+                        try self.dec.skip();
+                        return element;
+                    }
+
+                    element.body_len = switch (try self.expect()) {
+                        .array => |n| n,
+                        else => return error.FormatError,
+                    };
+                    if (element.body_len > 0) {
+                        if (self.depth == MAX_DEPTH)
+                            return error.Unsupported;
+                        self.depth += 1;
+                        self.remaining[self.depth] = element.body_len;
+                    }
+                    return element;
+                },
+                else => {
+                    var i: u64 = 1;
+                    while (i < len) : (i += 1)
+                        try self.dec.skip();
+                },
+            }
+        }
+    }
+};
+
+/// Encoder for CoRAL documents, the number of elements in the document
+/// and in each body must be known in advance.
+pub fn Writer(comptime W: type) type {
+    return struct {
+        enc: cbor.Encoder(W),
+
+        const Self = @This();
+
+        /// Begin the document or a nested body with the given number of
+        /// elements.
+        pub fn begin(self: Self, len: u64) !void {
+            try self.enc.array(len);
+        }
+
+        /// Write a base directive.
+        pub fn base(self: Self, iri: []const u8) !void {
+            try self.enc.array(2);
+            try self.enc.uint(BASE_DIRECTIVE);
+            try self.enc.text(iri);
+        }
+
+        /// Write a link with the given relation type to the given target
+        /// IRI. If the link has a body, it must be written afterwards
+        /// using begin.
+        pub fn link(self: Self, relation: []const u8, target: []const u8, has_body: bool) !void {
+            try self.enc.array(if (has_body) @as(u64, 4) else 3);
+            try self.enc.uint(LINK);
+            try self.enc.text(relation);
+            try self.enc.text(target);
+        }
+
+        /// Write a link with the given relation type to an integer
+        /// literal.
+        pub fn linkInt(self: Self, relation: []const u8, value: i64) !void {
+            try self.enc.array(3);
+            try self.enc.uint(LINK);
+            try self.enc.text(relation);
+            try self.enc.int(value);
+        }
+
+        /// Write a form with the given operation type and submission
+        /// target IRI.
+        pub fn form(self: Self, operation: []const u8, target: []const u8) !void {
+            try self.enc.array(3);
+            try self.enc.uint(FORM);
+            try self.enc.text(operation);
+            try self.enc.text(target);
+        }
+    };
+}
+
+/// Returns a Writer for the given writer.
+pub fn writer(w: anytype) Writer(@TypeOf(w)) {
+    return Writer(@TypeOf(w)){ .enc = cbor.encoder(w) };
+}
+
+test "test CoRAL encoding and decoding" {
+    var buf: [128]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&buf);
+
+    const w = writer(fbs.writer());
+    try w.begin(3);
+    try w.base("coap://[2001:db8::1]/");
+    try w.link("http://www.iana.org/assignments/relation/item", "/sensors/temp", true);
+    try w.begin(1);
+    try w.linkInt("http://coreapps.org/base#ct", 50);
+    try w.form("http://coreapps.org/base#update", "/sensors/temp");
+
+    var reader = try Reader.init(fbs.getWritten());
+
+    const base = (try reader.next()).?;
+    try testing.expect(base.kind == Kind.base and base.depth == 0);
+    try testing.expectEqualStrings("coap://[2001:db8::1]/", base.name);
+
+    const item = (try reader.next()).?;
+    try testing.expect(item.kind == Kind.link and item.body_len == 1);
+    try testing.expectEqualStrings("/sensors/temp", item.target.?.text);
+
+    const ct = (try reader.next()).?;
+    try testing.expect(ct.depth == 1);
+    try testing.expect((try ct.target.?.int()) == 50);
+
+    const update = (try reader.next()).?;
+    try testing.expect(update.kind == Kind.form and update.depth == 0);
+    try testing.expectEqualStrings("http://coreapps.org/base#update", update.name);
+
+    try testing.expect((try reader.next()) == null);
+}
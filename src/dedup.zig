@@ -0,0 +1,181 @@
+const std = @import("std");
+const testing = std.testing;
+
+// From RFC 7252:
+//
+//  EXCHANGE_LIFETIME is the time from starting to send a Confirmable
+//  message to the time when an acknowledgement is no longer expected,
+//  i.e., message-layer information about the message exchange can be
+//  purged.
+//
+// Derived from the default transmission parameters (see RFC 7252
+// Section 4.8.2), in milliseconds.
+pub const EXCHANGE_LIFETIME = 247 * 1000;
+
+// From RFC 7252:
+//
+//  NON_LIFETIME is the time from sending a Non-confirmable message to
+//  the time its Message ID can be safely reused.
+//
+pub const NON_LIFETIME = 145 * 1000;
+
+/// Whether the given endpoints are equal. Endpoints declaring a method
+/// `fn eql(a: Endpoint, b: Endpoint) bool` (e.g. `std.net.Address`) are
+/// compared using it, others are compared field by field. Endpoints are
+/// never compared bytewise since padding bytes are undefined.
+pub fn equalEndpoint(comptime Endpoint: type, a: Endpoint, b: Endpoint) bool {
+    if (comptime hasEql(Endpoint))
+        return a.eql(b);
+    return std.meta.eql(a, b);
+}
+
+fn hasEql(comptime Endpoint: type) bool {
+    return switch (@typeInfo(Endpoint)) {
+        .Struct, .Union, .Enum => @hasDecl(Endpoint, "eql"),
+        else => false,
+    };
+}
+
+/// Cache of Message IDs recently received from each endpoint, used to
+/// detect duplicate messages (see RFC 7252 Section 4.5). Alongside the
+/// Message ID, the reply sent in response to the message is stored and
+/// can be used to answer retransmissions of it. Memory is statically
+/// allocated for the given number of entries, each storing a reply of
+/// at most reply_size bytes. Times are given in milliseconds using an
+/// arbitrary monotonic clock provided by the caller.
+pub fn Deduplicator(comptime Endpoint: type, comptime capacity: usize, comptime reply_size: usize) type {
+    return struct {
+        entries: [capacity]Entry = undefined,
+        used: [capacity]bool = [_]bool{false} ** capacity,
+
+        const Self = @This();
+        const Entry = struct {
+            endpoint: Endpoint,
+            message_id: u16,
+            expires: u64,
+            reply: [reply_size]u8,
+            reply_len: usize,
+        };
+
+        // Returns the unexpired entry for the given message, expired
+        // entries are released while searching.
+        fn find(self: *Self, from: Endpoint, message_id: u16, now: u64) ?*Entry {
+            for (self.entries) |*entry, i| {
+                if (!self.used[i])
+                    continue;
+                if (entry.expires <= now) {
+                    self.used[i] = false;
+                    continue;
+                }
+
+                if (entry.message_id == message_id and equalEndpoint(Endpoint, entry.endpoint, from))
+                    return entry;
+            }
+            return null;
+        }
+
+        /// Returns the reply stored for the message with the given
+        /// Message ID received from the given endpoint or null if no such
+        /// message was received within its lifetime, i.e. if the message
+        /// is not a duplicate. An empty reply indicates that the
+        /// duplicate must be ignored.
+        pub fn lookup(self: *Self, from: Endpoint, message_id: u16, now: u64) ?[]const u8 {
+            const entry = self.find(from, message_id, now) orelse return null;
+            return entry.reply[0..entry.reply_len];
+        }
+
+        /// Remember the message with the given Message ID received from
+        /// the given endpoint for the given lifetime together with the
+        /// reply sent in response to it (empty if no reply was sent). If
+        /// the reply is larger than reply_size the message is remembered
+        /// without the reply, thus retransmissions are ignored instead of
+        /// being processed again. If all entries are in use, the entry
+        /// expiring first is replaced.
+        pub fn insert(self: *Self, from: Endpoint, message_id: u16, lifetime: u64, reply: []const u8, now: u64) void {
+            if (capacity == 0)
+                return;
+
+            const entry = self.find(from, message_id, now) orelse blk: {
+                var slot: usize = 0;
+                for (self.used) |used, i| {
+                    if (!used) {
+                        slot = i;
+                        break;
+                    }
+                    if (self.entries[i].expires < self.entries[slot].expires)
+                        slot = i;
+                }
+
+                self.used[slot] = true;
+                break :blk &self.entries[slot];
+            };
+
+            entry.endpoint = from;
+            entry.message_id = message_id;
+            entry.expires = now + lifetime;
+            if (reply.len > reply_size) {
+                entry.reply_len = 0;
+            } else {
+                std.mem.copy(u8, &entry.reply, reply);
+                entry.reply_len = reply.len;
+            }
+        }
+
+        /// Returns the number of messages currently remembered,
+        /// including expired ones which have not been released yet.
+        pub fn count(self: *const Self) usize {
+            var n: usize = 0;
+            for (self.used) |used| {
+                if (used)
+                    n += 1;
+            }
+            return n;
+        }
+    };
+}
+
+test "test message deduplication" {
+    var duplicates = Deduplicator(u16, 2, 4){};
+    try testing.expect(duplicates.lookup(1, 0x0d70, 0) == null);
+
+    duplicates.insert(1, 0x0d70, 100, "ack", 0);
+    try testing.expectEqualStrings("ack", duplicates.lookup(1, 0x0d70, 99).?);
+    try testing.expect(duplicates.lookup(2, 0x0d70, 99) == null);
+    try testing.expect(duplicates.lookup(1, 0x0d71, 99) == null);
+
+    // Entries are released after their lifetime.
+    try testing.expect(duplicates.lookup(1, 0x0d70, 100) == null);
+    try testing.expect(duplicates.count() == 0);
+
+    // If all entries are in use, the one expiring first is replaced.
+    duplicates.insert(1, 1, 50, "", 0);
+    duplicates.insert(1, 2, 10, "", 0);
+    duplicates.insert(1, 3, 30, "", 0);
+    try testing.expect(duplicates.count() == 2);
+    try testing.expect(duplicates.lookup(1, 2, 0) == null);
+    try testing.expectEqualStrings("", duplicates.lookup(1, 1, 0).?);
+    try testing.expectEqualStrings("", duplicates.lookup(1, 3, 0).?);
+
+    // Replies which do not fit into an entry are not stored, but the
+    // message is still remembered to suppress its processing.
+    duplicates.insert(1, 4, 10, "reply", 0);
+    try testing.expectEqualStrings("", duplicates.lookup(1, 4, 0).?);
+}
+
+const TestEndpoint = struct {
+    port: u16,
+    // Not significant for the equality of endpoints.
+    flow: u32 = 0,
+
+    pub fn eql(a: TestEndpoint, b: TestEndpoint) bool {
+        return a.port == b.port;
+    }
+};
+
+test "test endpoint comparison" {
+    try testing.expect(equalEndpoint(u16, 5683, 5683));
+    try testing.expect(!equalEndpoint(u16, 5683, 5684));
+    try testing.expect(equalEndpoint(TestEndpoint, .{ .port = 5683, .flow = 1 }, .{ .port = 5683, .flow = 2 }));
+    try testing.expect(!equalEndpoint(TestEndpoint, .{ .port = 5683 }, .{ .port = 5684 }));
+    try testing.expect(equalEndpoint(struct { a: u8, b: u32 }, .{ .a = 1, .b = 2 }, .{ .a = 1, .b = 2 }));
+}
@@ -0,0 +1,101 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+
+/// Formats an option value, token, or payload as hex string.
+const Bytes = struct {
+    slice: []const u8,
+
+    pub fn format(self: Bytes, comptime fmt: []const u8, options: std.fmt.FormatOptions, writer: anytype) !void {
+        _ = fmt;
+        _ = options;
+        if (self.slice.len == 0) {
+            try writer.writeAll("(empty)");
+        } else {
+            try writer.print("{}", .{std.fmt.fmtSliceHexLower(self.slice)});
+        }
+    }
+};
+
+fn bytes(slice: ?[]const u8) Bytes {
+    return Bytes{ .slice = slice orelse &[_]u8{} };
+}
+
+/// Compare two messages field by field and write a line describing
+/// each difference to the given writer, returns the number of
+/// differences. Options are compared in the order they are encoded in,
+/// thus the order of repeated options is significant. Neither message
+/// is modified, i.e. options already consumed are not compared.
+pub fn diff(writer: anytype, a: *const pkt.Request, b: *const pkt.Request) !usize {
+    var n: usize = 0;
+
+    if (a.header.type != b.header.type) {
+        try writer.print("type: {s} != {s}\n", .{ @tagName(a.header.type), @tagName(b.header.type) });
+        n += 1;
+    }
+    if (!a.header.code.equal(b.header.code)) {
+        try writer.print("code: {} != {}\n", .{ a.header.code, b.header.code });
+        n += 1;
+    }
+    if (a.header.message_id != b.header.message_id) {
+        try writer.print("message ID: {d} != {d}\n", .{ a.header.message_id, b.header.message_id });
+        n += 1;
+    }
+    if (!std.mem.eql(u8, a.token, b.token)) {
+        try writer.print("token: {} != {}\n", .{ bytes(a.token), bytes(b.token) });
+        n += 1;
+    }
+
+    var iter_a = a.options();
+    iter_a.enforce_rules = false;
+    var iter_b = b.options();
+    iter_b.enforce_rules = false;
+
+    var i: usize = 0;
+    while (true) : (i += 1) {
+        const opt_a = try iter_a.next();
+        const opt_b = try iter_b.next();
+        if (opt_a == null and opt_b == null)
+            break;
+
+        if (opt_a == null) {
+            try writer.print("option {d}: (missing) != {d}\n", .{ i, opt_b.?.number });
+        } else if (opt_b == null) {
+            try writer.print("option {d}: {d} != (missing)\n", .{ i, opt_a.?.number });
+        } else if (opt_a.?.number != opt_b.?.number) {
+            try writer.print("option {d}: {d} != {d}\n", .{ i, opt_a.?.number, opt_b.?.number });
+        } else if (!std.mem.eql(u8, opt_a.?.value, opt_b.?.value)) {
+            try writer.print("option {d} ({d}): {} != {}\n", .{ i, opt_a.?.number, bytes(opt_a.?.value), bytes(opt_b.?.value) });
+        } else {
+            continue;
+        }
+        n += 1;
+    }
+
+    const payload_a = iter_a.payload() orelse &[_]u8{};
+    const payload_b = iter_b.payload() orelse &[_]u8{};
+    if (!std.mem.eql(u8, payload_a, payload_b)) {
+        try writer.print("payload: {d} bytes != {d} bytes\n", .{ payload_a.len, payload_b.len });
+        n += 1;
+    }
+
+    return n;
+}
+
+/// Whether the two messages are equal, see diff.
+pub fn equal(a: *const pkt.Request, b: *const pkt.Request) !bool {
+    return (try diff(std.io.null_writer, a, b)) == 0;
+}
+
+test "test message diff" {
+    const first = try pkt.Request.init(@embedFile("../testvectors/block2-szx0-0.bin"));
+    const second = try pkt.Request.init(@embedFile("../testvectors/block2-szx0-2.bin"));
+    try testing.expect(try equal(&first, &first));
+    try testing.expect(!(try equal(&first, &second)));
+
+    var buf: [128]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&buf);
+    try testing.expect((try diff(fbs.writer(), &first, &second)) == 2);
+    try testing.expectEqualStrings("message ID: 2848 != 2849\noption 1 (23): (empty) != 10\n", fbs.getWritten());
+}
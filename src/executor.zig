@@ -0,0 +1,112 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+const res = @import("resource.zig");
+
+/// Request whose handler is invoked by an Executor, possibly on a
+/// different thread than the one running the server. Jobs are owned by
+/// the server and submitted to the executor, which must invoke run
+/// exactly once for each submitted job.
+pub const Job = struct {
+    /// Copy of the server's Dispatcher, using a buffer and peer
+    /// identity dedicated to this job.
+    dispatcher: res.Dispatcher,
+    request: []const u8,
+    /// Response created by the handler, only valid once the job is
+    /// completed. Null if the request could not be dispatched.
+    response: ?pkt.Response = null,
+    done: bool = false,
+
+    /// Invoke the handler of the request and mark the job as completed.
+    /// The response is sent by the server thread, see Server.poll.
+    pub fn run(self: *Job) void {
+        self.response = self.execute() catch null;
+        @atomicStore(bool, &self.done, true, .Release);
+    }
+
+    fn execute(self: *Job) !pkt.Response {
+        var req = try pkt.Request.init(self.request);
+        return self.dispatcher.dispatch(&req);
+    }
+
+    /// Whether run has completed.
+    pub fn completed(self: *const Job) bool {
+        return @atomicLoad(bool, &self.done, .Acquire);
+    }
+};
+
+/// Caller-provided executor which runs jobs concurrently, e.g. on a
+/// pool of worker threads. Submitting a job must not block, the job may
+/// be run at any later point in time (or right away).
+pub const Executor = struct {
+    ptr: *anyopaque,
+    submitFn: fn (ptr: *anyopaque, job: *Job) void,
+
+    /// Create an executor from the given pointer and submit function,
+    /// similar to std.rand.Random.init.
+    pub fn init(pointer: anytype, comptime submitFn: fn (ptr: @TypeOf(pointer), job: *Job) void) Executor {
+        const Ptr = @TypeOf(pointer);
+        std.debug.assert(@typeInfo(Ptr) == .Pointer);
+        std.debug.assert(@typeInfo(Ptr).Pointer.size == .One);
+
+        const gen = struct {
+            fn submit(ptr: *anyopaque, job: *Job) void {
+                const alignment = @typeInfo(Ptr).Pointer.alignment;
+                const self = @ptrCast(Ptr, @alignCast(alignment, ptr));
+                submitFn(self, job);
+            }
+        };
+        return .{ .ptr = pointer, .submitFn = gen.submit };
+    }
+
+    /// Submit the given job for execution.
+    pub fn submit(self: Executor, job: *Job) void {
+        self.submitFn(self.ptr, job);
+    }
+};
+
+fn helloHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    _ = req;
+    resp.payloadWriter().writeAll("hello") catch {
+        return codes.INTERNAL_ERR;
+    };
+    return codes.CONTENT;
+}
+
+const TestExecutor = struct {
+    submitted: ?*Job = null,
+
+    fn submit(self: *TestExecutor, job: *Job) void {
+        self.submitted = job;
+    }
+};
+
+test "test job execution" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = helloHandler },
+    };
+
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x3a}, 0x0e01);
+    try msg.addURIPath("hello");
+
+    var response_buf: [32]u8 = undefined;
+    var job = Job{
+        .dispatcher = .{ .resources = &resources, .buf = &response_buf },
+        .request = msg.marshal(),
+    };
+
+    var test_executor = TestExecutor{};
+    const executor = Executor.init(&test_executor, TestExecutor.submit);
+    executor.submit(&job);
+    try testing.expect(test_executor.submitted.? == &job);
+    try testing.expect(!job.completed());
+
+    job.run();
+    try testing.expect(job.completed());
+    var reply = try pkt.Request.init(job.response.?.marshal());
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expectEqualStrings("hello", (try reply.extractPayload()).?);
+}
@@ -0,0 +1,190 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+const ContentFormat = @import("contentformat.zig").ContentFormat;
+const opts = @import("opts.zig");
+
+// Mapping between HTTP and CoAP for HTTP-CoAP cross-proxies (RFC 8075),
+// which forward requests of HTTP clients to CoAP servers. Since the
+// library does not implement HTTP, receiving HTTP requests and sending
+// HTTP responses is left to the proxy, only the messages are mapped.
+
+/// HTTP request to be mapped to a CoAP request, see request.
+pub const Request = struct {
+    /// HTTP method, method names are case-sensitive.
+    method: []const u8,
+    /// Absolute coap or coaps URI of the target, see targetURI.
+    uri: []const u8,
+    /// Media type of the body given by the Content-Type header field.
+    content_type: ?[]const u8 = null,
+    /// Media type given by the Accept header field. Only a single media
+    /// type without quality values is supported.
+    accept: ?[]const u8 = null,
+    body: []const u8 = &[_]u8{},
+};
+
+/// Returns the CoAP method for the given HTTP method or null if the
+/// method has no CoAP equivalent (see RFC 8075 Section 6), in which case
+/// the proxy should respond with 501 (Not Implemented).
+pub fn method(name: []const u8) ?codes.Code {
+    for (methods) |entry| {
+        if (std.mem.eql(u8, entry.name, name))
+            return entry.code;
+    }
+    return null;
+}
+
+const Method = struct {
+    name: []const u8,
+    code: codes.Code,
+};
+
+// The iPATCH method has no HTTP equivalent.
+const methods = [_]Method{
+    .{ .name = "GET", .code = codes.GET },
+    .{ .name = "POST", .code = codes.POST },
+    .{ .name = "PUT", .code = codes.PUT },
+    .{ .name = "DELETE", .code = codes.DELETE },
+    .{ .name = "FETCH", .code = codes.FETCH },
+    .{ .name = "PATCH", .code = codes.PATCH },
+};
+
+/// Returns the CoAP URI contained in the given HTTP request target if it
+/// starts with the given prefix, e.g. "coap://sensor.example/temp" for
+/// the target "/hc/coap://sensor.example/temp" and the prefix "/hc/"
+/// (see RFC 8075 Section 5). Returns null if the target does not start
+/// with the prefix.
+pub fn targetURI(target: []const u8, prefix: []const u8) ?[]const u8 {
+    if (!std.mem.startsWith(u8, target, prefix))
+        return null;
+    return target[prefix.len..];
+}
+
+/// Create the CoAP request for the given HTTP request in the given
+/// buffer, the Content-Type and Accept header fields are mapped to the
+/// Content-Format and Accept options. If the HTTP method cannot be
+/// mapped, error.UnsupportedMethod is returned. If a media type is
+/// not registered as Content-Format, error.UnsupportedMediaType or
+/// error.NotAcceptable is returned, which should be answered with 415
+/// (Unsupported Media Type) or 406 (Not Acceptable) respectively.
+pub fn request(buf: []u8, mt: pkt.Msg, token: []const u8, id: u16, req: Request) !pkt.Response {
+    const code = method(req.method) orelse return error.UnsupportedMethod;
+    var msg = try pkt.Response.init(buf, mt, code, token, id);
+    try msg.addURI(req.uri);
+
+    if (req.content_type) |media_type| {
+        const format = ContentFormat.fromMediaType(media_type) orelse return error.UnsupportedMediaType;
+        try msg.addContentFormat(format);
+    }
+    if (req.accept) |media_type| {
+        const format = ContentFormat.fromMediaType(media_type) orelse return error.NotAcceptable;
+        try msg.addAccept(format);
+    }
+
+    if (req.body.len > 0)
+        try msg.payloadWriter().writeAll(req.body);
+    return msg;
+}
+
+/// Returns the HTTP status code for the given CoAP response code (see
+/// RFC 8075 Section 7). Since an HTTP response without content is
+/// indicated by a dedicated status code, the presence of a payload must
+/// be given. Unknown codes are mapped to the generic status code of
+/// their class. Returns null for codes which must not be forwarded,
+/// e.g. 2.31 (Continue) used by block-wise transfers.
+///
+/// Responses with 2.03 (Valid) are mapped to 304 (Not Modified), which
+/// is only correct if the HTTP request was conditional. Otherwise, the
+/// proxy must respond with 200 (OK) and its cached representation.
+pub fn status(code: codes.Code, payload: bool) ?u16 {
+    const number = @as(u16, code.class) * 100 + code.detail;
+    const value: u16 = switch (number) {
+        201 => 201,
+        202, 204 => if (payload) 200 else 204,
+        203 => 304,
+        205 => 200,
+        231 => return null,
+        // HTTP requires a WWW-Authenticate header field for 401
+        // (Unauthorized) and an Allow header field for 405 (Method Not
+        // Allowed), which cannot be derived from the CoAP response.
+        401 => 403,
+        405 => 400,
+        403, 404, 406, 409, 412, 413, 415, 422, 429 => number,
+        500, 501, 502, 503, 504 => number,
+        505 => 502,
+        else => switch (code.class) {
+            2 => 200,
+            4 => 400,
+            5 => 500,
+            else => return null,
+        },
+    };
+    return value;
+}
+
+/// Returns the HTTP Content-Type for a payload with the given CoAP
+/// Content-Format, i.e. the media type (including parameters) registered
+/// for it. Returns null if no media type is known for the Content-Format,
+/// the proxy may then fall back to application/octet-stream.
+pub fn contentType(format: ContentFormat) ?[]const u8 {
+    return format.mediaType();
+}
+
+/// Returns the HTTP Content-Type for the payload of the given CoAP
+/// response, see contentType. Returns null if the response does not
+/// include a Content-Format option or its Content-Format is not known.
+pub fn responseContentType(resp: *const pkt.Request) !?[]const u8 {
+    const format = (try resp.contentFormat()) orelse return null;
+    return contentType(format);
+}
+
+test "test HTTP-CoAP cross-proxy mapping" {
+    try testing.expect(method("PUT").?.equal(codes.PUT));
+    try testing.expect(method("put") == null);
+    try testing.expectEqualStrings("coap://192.0.2.1/led", targetURI("/hc/coap://192.0.2.1/led", "/hc/").?);
+    try testing.expect(targetURI("/index.html", "/hc/") == null);
+
+    var buf: [64]u8 = undefined;
+    var msg = try request(&buf, pkt.Msg.con, &[_]u8{0x2a}, 0x2501, .{
+        .method = "PUT",
+        .uri = "coap://192.0.2.1/led?state=on",
+        .content_type = "text/plain; charset=utf-8",
+        .accept = "application/json",
+        .body = "1",
+    });
+    var req = try pkt.Request.init(msg.marshal());
+    try testing.expect(req.header.code.equal(codes.PUT));
+    try testing.expectEqualStrings("led", (try req.getString(opts.URIPath)).?);
+    try testing.expectEqualStrings("state=on", (try req.getString(opts.URIQuery)).?);
+    try testing.expect((try req.contentFormat()).? == ContentFormat.text_plain);
+    try testing.expect((try req.accept()).? == ContentFormat.json);
+    try testing.expectEqualStrings("1", (try req.extractPayload()).?);
+
+    try testing.expectError(error.UnsupportedMethod, request(&buf, pkt.Msg.con, &[_]u8{}, 0x2502, .{ .method = "OPTIONS", .uri = "coap://192.0.2.1/" }));
+    try testing.expectError(error.UnsupportedMediaType, request(&buf, pkt.Msg.con, &[_]u8{}, 0x2503, .{ .method = "POST", .uri = "coap://192.0.2.1/", .content_type = "text/html" }));
+
+    try testing.expect(status(codes.CONTENT, true).? == 200);
+    try testing.expect(status(codes.CHANGED, true).? == 200);
+    try testing.expect(status(codes.CHANGED, false).? == 204);
+    try testing.expect(status(codes.UNAUTH, false).? == 403);
+    try testing.expect(status(codes.NOT_FOUND, false).? == 404);
+    try testing.expect(status(codes.NO_PROXY, false).? == 502);
+    try testing.expect(status(codes.Code{ .class = 4, .detail = 30 }, false).? == 400);
+    try testing.expect(status(codes.CONTINUE, false) == null);
+
+    try testing.expectEqualStrings("text/plain; charset=utf-8", contentType(ContentFormat.text_plain).?);
+    try testing.expectEqualStrings("application/link-format", contentType(ContentFormat.link_format).?);
+    try testing.expect(contentType(@intToEnum(ContentFormat, 65000)) == null);
+
+    var resp = try pkt.Response.init(&buf, pkt.Msg.ack, codes.CONTENT, &[_]u8{0x2a}, 0x2501);
+    try resp.addContentFormat(ContentFormat.cbor);
+    try resp.payloadWriter().writeByte(0xf5);
+    var reply = try pkt.Request.init(resp.marshal());
+    try testing.expectEqualStrings("application/cbor", (try responseContentType(&reply)).?);
+
+    resp = try pkt.Response.init(&buf, pkt.Msg.ack, codes.DELETED, &[_]u8{0x2a}, 0x2502);
+    reply = try pkt.Request.init(resp.marshal());
+    try testing.expect((try responseContentType(&reply)) == null);
+}
@@ -0,0 +1,260 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+
+/// Parameter of a link. The value of a quoted parameter is returned
+/// without the surrounding quotes but with escape sequences retained.
+/// Parameters without a value (e.g. obs) have a null value.
+pub const Param = struct {
+    name: []const u8,
+    value: ?[]const u8,
+};
+
+/// Attributes of a link commonly used for resource discovery (see
+/// RFC 6690 Section 3 and RFC 7252 Section 7.2).
+pub const Attributes = struct {
+    /// Resource type, a space-separated list of resource types.
+    rt: ?[]const u8 = null,
+    /// Interface description, a space-separated list of interfaces.
+    interface: ?[]const u8 = null,
+    /// Content-Format of the resource.
+    ct: ?u16 = null,
+    /// Whether the resource is observable (see RFC 7641 Section 6).
+    obs: bool = false,
+    /// Estimated maximum size of the resource representation in bytes.
+    sz: ?u32 = null,
+};
+
+/// A link to be written, see writeLinks.
+pub const Description = struct {
+    target: []const u8,
+    attributes: Attributes = Attributes{},
+};
+
+/// Iterator over the parameters of a link.
+pub const ParamIterator = struct {
+    data: []const u8,
+    pos: usize = 0,
+
+    pub fn next(self: *ParamIterator) !?Param {
+        if (self.pos == self.data.len)
+            return null;
+        if (self.data[self.pos] != ';')
+            return error.FormatError;
+        self.pos += 1;
+
+        const start = self.pos;
+        while (self.pos < self.data.len and self.data[self.pos] != '=' and self.data[self.pos] != ';')
+            self.pos += 1;
+        const name = self.data[start..self.pos];
+        if (name.len == 0)
+            return error.FormatError;
+
+        if (self.pos == self.data.len or self.data[self.pos] == ';')
+            return Param{ .name = name, .value = null };
+
+        self.pos += 1; // skip '='
+        if (self.pos < self.data.len and self.data[self.pos] == '"') {
+            const end = try skipQuoted(self.data, self.pos);
+            const value = self.data[self.pos + 1 .. end - 1];
+            self.pos = end;
+            return Param{ .name = name, .value = value };
+        }
+
+        const value_start = self.pos;
+        while (self.pos < self.data.len and self.data[self.pos] != ';')
+            self.pos += 1;
+        return Param{ .name = name, .value = self.data[value_start..self.pos] };
+    }
+};
+
+/// A link parsed from a document in the CoRE Link Format.
+pub const Link = struct {
+    /// URI reference of the link target, without angle brackets.
+    target: []const u8,
+    /// Unparsed parameters of the link, see params.
+    raw_params: []const u8,
+
+    /// Returns an iterator over the parameters of the link.
+    pub fn params(self: Link) ParamIterator {
+        return ParamIterator{ .data = self.raw_params };
+    }
+
+    /// Returns the first parameter with the given name or null if the
+    /// link has no such parameter.
+    pub fn get(self: Link, name: []const u8) !?Param {
+        var iter = self.params();
+        while (try iter.next()) |param| {
+            if (std.mem.eql(u8, param.name, name))
+                return param;
+        }
+        return null;
+    }
+
+    /// Returns the common attributes of the link. If a parameter occurs
+    /// more than once, the first occurrence is used. If multiple
+    /// Content-Formats are given, only the first one is returned.
+    pub fn attributes(self: Link) !Attributes {
+        var attrs = Attributes{};
+
+        if (try self.get("rt")) |param|
+            attrs.rt = param.value;
+        if (try self.get("if")) |param|
+            attrs.interface = param.value;
+        if (try self.get("obs")) |_|
+            attrs.obs = true;
+        if (try self.get("ct")) |param| {
+            const value = param.value orelse return error.FormatError;
+            const first = value[0 .. std.mem.indexOfScalar(u8, value, ' ') orelse value.len];
+            attrs.ct = std.fmt.parseUnsigned(u16, first, 10) catch {
+                return error.FormatError;
+            };
+        }
+        if (try self.get("sz")) |param| {
+            const value = param.value orelse return error.FormatError;
+            attrs.sz = std.fmt.parseUnsigned(u32, value, 10) catch {
+                return error.FormatError;
+            };
+        }
+
+        return attrs;
+    }
+};
+
+/// Parser for documents in the CoRE Link Format (RFC 6690), links are
+/// returned in the order they are contained in the document. No memory
+/// is allocated, all returned slices reference the document.
+pub const Parser = struct {
+    data: []const u8,
+    pos: usize = 0,
+
+    /// Returns the next link or null if the end of the document has
+    /// been reached.
+    pub fn next(self: *Parser) !?Link {
+        if (self.pos == self.data.len)
+            return null;
+        if (self.pos > 0) {
+            // Links are separated by a comma.
+            if (self.data[self.pos] != ',')
+                return error.FormatError;
+            self.pos += 1;
+        }
+
+        if (self.pos == self.data.len or self.data[self.pos] != '<')
+            return error.FormatError;
+        const end = std.mem.indexOfScalarPos(u8, self.data, self.pos, '>') orelse {
+            return error.FormatError;
+        };
+        const target = self.data[self.pos + 1 .. end];
+
+        // Parameters extend to the next comma outside of a quoted string.
+        self.pos = end + 1;
+        const start = self.pos;
+        while (self.pos < self.data.len and self.data[self.pos] != ',') {
+            if (self.data[self.pos] == '"') {
+                self.pos = try skipQuoted(self.data, self.pos);
+            } else {
+                self.pos += 1;
+            }
+        }
+
+        return Link{ .target = target, .raw_params = self.data[start..self.pos] };
+    }
+};
+
+// Returns the position after the quoted string starting at the given
+// position, backslash escapes are skipped.
+fn skipQuoted(data: []const u8, pos: usize) !usize {
+    std.debug.assert(data[pos] == '"');
+
+    var i = pos + 1;
+    while (i < data.len) : (i += 1) {
+        switch (data[i]) {
+            '\\' => i += 1,
+            '"' => return i + 1,
+            else => {},
+        }
+    }
+
+    return error.FormatError;
+}
+
+/// Write the given links as document in the CoRE Link Format to the
+/// given writer. The values of rt and if are written as quoted strings
+/// and must not contain quotes.
+pub fn writeLinks(writer: anytype, links: []const Description) !void {
+    for (links) |link, i| {
+        if (i > 0)
+            try writer.writeByte(',');
+        try writer.print("<{s}>", .{link.target});
+        try writeAttributes(writer, link.attributes);
+    }
+}
+
+/// Write the given attributes of a link, each preceded by a semicolon,
+/// see writeLinks.
+pub fn writeAttributes(writer: anytype, attrs: Attributes) !void {
+    if (attrs.rt) |rt|
+        try writer.print(";rt=\"{s}\"", .{rt});
+    if (attrs.interface) |interface|
+        try writer.print(";if=\"{s}\"", .{interface});
+    if (attrs.ct) |ct|
+        try writer.print(";ct={d}", .{ct});
+    if (attrs.sz) |sz|
+        try writer.print(";sz={d}", .{sz});
+    if (attrs.obs)
+        try writer.writeAll(";obs");
+}
+
+test "test link format parsing" {
+    const req = try pkt.Request.init(@embedFile("../testvectors/multicast-discovery-1.bin"));
+    var parser = try req.linkParser();
+
+    const link = (try parser.next()).?;
+    try testing.expectEqualStrings("/sensors/temp", link.target);
+    const attrs = try link.attributes();
+    try testing.expectEqualStrings("temperature-c", attrs.rt.?);
+    try testing.expectEqualStrings("sensor", attrs.interface.?);
+    try testing.expect(attrs.ct == null and !attrs.obs);
+    try testing.expect((try parser.next()) == null);
+
+    // Example from RFC 6690 Section 5, with quoted commas and semicolons.
+    parser = Parser{ .data = "</sensors>;ct=40;title=\"Sensor Index\",</sensors/temp>;rt=\"temperature-c\";if=\"sensor\";obs,</t>;anchor=\"/sensors/temp\";rel=\"alternate;x,y\";sz=128" };
+
+    const index = (try parser.next()).?;
+    try testing.expectEqualStrings("/sensors", index.target);
+    try testing.expect((try index.attributes()).ct.? == 40);
+    try testing.expectEqualStrings("Sensor Index", (try index.get("title")).?.value.?);
+
+    const temp = (try parser.next()).?;
+    try testing.expect((try temp.attributes()).obs);
+
+    const alternate = (try parser.next()).?;
+    try testing.expectEqualStrings("alternate;x,y", (try alternate.get("rel")).?.value.?);
+    try testing.expect((try alternate.attributes()).sz.? == 128);
+    try testing.expect((try parser.next()) == null);
+
+    var invalid = Parser{ .data = "</a>;rt=\"unterminated" };
+    try testing.expectError(error.FormatError, invalid.next());
+}
+
+test "test link format serialization" {
+    const exp = @embedFile("../testvectors/multicast-discovery-1.bin");
+
+    var buf: [exp.len]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.non, codes.CONTENT, &[_]u8{}, 6657);
+    try resp.writeLinks(&[_]Description{
+        .{ .target = "/sensors/temp", .attributes = .{ .rt = "temperature-c", .interface = "sensor" } },
+    });
+    try testing.expectEqualSlices(u8, exp, resp.marshal());
+
+    var out: [64]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&out);
+    try writeLinks(fbs.writer(), &[_]Description{
+        .{ .target = "/a", .attributes = .{ .ct = 50, .obs = true, .sz = 512 } },
+        .{ .target = "/b" },
+    });
+    try testing.expectEqualStrings("</a>;ct=50;sz=512;obs,</b>", fbs.getWritten());
+}
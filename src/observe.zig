@@ -0,0 +1,132 @@
+const std = @import("std");
+const testing = std.testing;
+const pkt = @import("packet.zig");
+const opts = @import("opts.zig");
+
+// Sequence numbers of notifications are 24-bit unsigned integers which
+// wrap around, a notification is fresher than the latest notification
+// if its sequence number is less than 2^23 ahead (see RFC 7641
+// Section 3.4).
+const HALF_SEQUENCE_SPACE = 1 << 23;
+
+// Amount of seconds after which a notification is always considered
+// fresh, regardless of its sequence number. This assumes that fewer
+// than 2^23 notifications are sent within this time frame.
+pub const FRESHNESS_TIMEOUT = 128;
+
+/// Whether the notification with sequence number v2 received at time t2
+/// is fresher than the latest notification with sequence number v1
+/// received at time t1. Times are given in seconds using an arbitrary
+/// monotonic clock provided by the caller.
+///
+/// From RFC 7641:
+///
+///  (V1 < V2 and V2 - V1 < 2^23) or
+///  (V1 > V2 and V1 - V2 > 2^23) or
+///  (T2 > T1 + (128 seconds))
+///
+pub fn isFresher(v1: u24, t1: u64, v2: u24, t2: u64) bool {
+    return (v1 < v2 and v2 - v1 < HALF_SEQUENCE_SPACE) or
+        (v1 > v2 and v1 - v2 > HALF_SEQUENCE_SPACE) or
+        (t2 > t1 + FRESHNESS_TIMEOUT);
+}
+
+/// Tracks the latest notification received for an observed resource,
+/// allowing reordered notifications to be detected.
+pub const Observation = struct {
+    value: u24 = 0,
+    time: u64 = 0,
+    received: bool = false,
+
+    /// Record the notification with the given sequence number received
+    /// at the given time if it is fresher than the latest notification.
+    /// Returns false if the notification is stale and must be ignored.
+    pub fn update(self: *Observation, value: u24, time: u64) bool {
+        if (self.received and !isFresher(self.value, self.time, value, time))
+            return false;
+
+        self.* = Observation{ .value = value, .time = time, .received = true };
+        return true;
+    }
+};
+
+/// Notification periods of an observation in seconds, corresponding to
+/// the pmin and pmax attributes defined by LwM2M. If null, no period is
+/// enforced.
+pub const Periods = struct {
+    /// Minimum period between two notifications, state changes within
+    /// this period are coalesced into a single notification.
+    pmin: ?u32 = null,
+    /// Maximum period between two notifications, a notification is sent
+    /// if none was sent within this period.
+    pmax: ?u32 = null,
+
+    /// Parse the pmin and pmax query parameters of the given request
+    /// (e.g. "?pmin=10&pmax=60"), other query parameters are ignored.
+    /// Returns error.InvalidPeriod if a value is not an unsigned
+    /// integer. As in LwM2M, a maximum period which is less than the
+    /// minimum period is ignored. Options of the request are not
+    /// consumed.
+    pub fn fromRequest(req: *const pkt.Request) !Periods {
+        var periods = Periods{};
+        var queries = req.getAll(opts.URIQuery);
+        while (try queries.next()) |query| {
+            if (std.mem.startsWith(u8, query, "pmin=")) {
+                periods.pmin = try parsePeriod(query["pmin=".len..]);
+            } else if (std.mem.startsWith(u8, query, "pmax=")) {
+                periods.pmax = try parsePeriod(query["pmax=".len..]);
+            }
+        }
+
+        if (periods.pmin != null and periods.pmax != null and periods.pmax.? < periods.pmin.?)
+            periods.pmax = null;
+        return periods;
+    }
+};
+
+fn parsePeriod(value: []const u8) !u32 {
+    return std.fmt.parseUnsigned(u32, value, 10) catch return error.InvalidPeriod;
+}
+
+fn expectNotifications(vectors: []const []const u8, fresh: []const bool) !void {
+    var obs = Observation{};
+    for (vectors) |buf, i| {
+        const req = try pkt.Request.init(buf);
+        const value = (try req.getObserve()).?;
+        try testing.expect(obs.update(value, 0) == fresh[i]);
+    }
+}
+
+test "test observe notification freshness" {
+    const wrap = [_][]const u8{
+        @embedFile("../testvectors/observe-wrap-1.bin"),
+        @embedFile("../testvectors/observe-wrap-2.bin"),
+        @embedFile("../testvectors/observe-wrap-3.bin"),
+        @embedFile("../testvectors/observe-wrap-4.bin"),
+        @embedFile("../testvectors/observe-wrap-5.bin"),
+    };
+    try expectNotifications(&wrap, &[_]bool{ true, true, true, true, true });
+
+    const reordered = [_][]const u8{
+        @embedFile("../testvectors/observe-reordered-1.bin"),
+        @embedFile("../testvectors/observe-reordered-2.bin"),
+        @embedFile("../testvectors/observe-reordered-3.bin"),
+        @embedFile("../testvectors/observe-reordered-4.bin"),
+        @embedFile("../testvectors/observe-reordered-5.bin"),
+        @embedFile("../testvectors/observe-reordered-6.bin"),
+    };
+    try expectNotifications(&reordered, &[_]bool{ true, true, false, true, false, true });
+}
+
+test "test observe notification freshness after timeout" {
+    // Stale according to the sequence number but received after the
+    // freshness timeout.
+    try testing.expect(!isFresher(12, 1000, 11, 1000 + FRESHNESS_TIMEOUT));
+    try testing.expect(isFresher(12, 1000, 11, 1001 + FRESHNESS_TIMEOUT));
+
+    var obs = Observation{};
+    try testing.expect(obs.update(12, 1000));
+    try testing.expect(!obs.update(11, 1010));
+    try testing.expect(obs.update(11, 1200));
+    try testing.expect(obs.value == 11);
+}
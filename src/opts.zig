@@ -6,4 +6,164 @@ pub const Option = struct {
 // https://datatracker.ietf.org/doc/html/rfc7252#section-5.10
 pub const IfMatch: u32 = 1;
 pub const URIHost: u32 = 3;
+pub const ETag: u32 = 4;
+pub const IfNoneMatch: u32 = 5;
+pub const URIPort: u32 = 7;
+pub const LocationPath: u32 = 8;
 pub const URIPath: u32 = 11;
+pub const ContentFormat: u32 = 12;
+pub const MaxAge: u32 = 14;
+pub const URIQuery: u32 = 15;
+pub const Accept: u32 = 17;
+pub const LocationQuery: u32 = 20;
+pub const ProxyURI: u32 = 35;
+pub const ProxyScheme: u32 = 39;
+pub const Size1: u32 = 60;
+
+// https://datatracker.ietf.org/doc/html/rfc7641#section-2
+pub const Observe: u32 = 6;
+
+// https://datatracker.ietf.org/doc/html/rfc7959#section-6
+pub const Block2: u32 = 23;
+pub const Block1: u32 = 27;
+pub const Size2: u32 = 28;
+
+// https://datatracker.ietf.org/doc/html/rfc8613#section-2
+pub const OSCORE: u32 = 9;
+
+// https://datatracker.ietf.org/doc/html/rfc8768#section-3
+pub const HopLimit: u32 = 16;
+
+// https://datatracker.ietf.org/doc/html/rfc9177#section-4
+pub const QBlock1: u32 = 19;
+pub const QBlock2: u32 = 31;
+
+// https://datatracker.ietf.org/doc/html/rfc9175#section-2
+pub const Echo: u32 = 252;
+pub const RequestTag: u32 = 292;
+
+// https://datatracker.ietf.org/doc/html/rfc7967#section-2
+pub const NoResponse: u32 = 258;
+
+// Properties of an option encoded in its Option Number.
+//
+// From RFC 7252:
+//
+//    Critical = (onum & 1);
+//    UnSafe = (onum & 2);
+//    NoCacheKey = ((onum & 0x1e) == 0x1c);
+//
+
+/// Whether the option must be understood by the receiver, critical
+/// options are odd, elective options are even.
+pub fn isCritical(number: u32) bool {
+    return (number & 1) != 0;
+}
+
+/// Whether a proxy must understand the option to forward the message.
+pub fn isUnsafe(number: u32) bool {
+    return (number & 2) != 0;
+}
+
+/// Whether the option is not part of the cache key. Only meaningful for
+/// options which are safe to forward.
+pub fn isNoCacheKey(number: u32) bool {
+    return (number & 0x1e) == 0x1c;
+}
+
+/// Whether the option may occur more than once in a message. Options
+/// which are not registered are assumed to be repeatable.
+pub fn isRepeatable(number: u32) bool {
+    // https://datatracker.ietf.org/doc/html/rfc7252#section-5.10
+    return switch (number) {
+        IfMatch, ETag, LocationPath, URIPath, URIQuery, LocationQuery => true,
+        // https://datatracker.ietf.org/doc/html/rfc9175#section-3.2
+        RequestTag => true,
+        else => definition(number) == null,
+    };
+}
+
+/// Returns the name of the option with the given Option Number as
+/// registered in the CoAP Option Numbers registry (e.g. "Uri-Path") or
+/// null if the option is not known.
+pub fn name(number: u32) ?[]const u8 {
+    return switch (number) {
+        IfMatch => "If-Match",
+        URIHost => "Uri-Host",
+        ETag => "ETag",
+        IfNoneMatch => "If-None-Match",
+        Observe => "Observe",
+        URIPort => "Uri-Port",
+        LocationPath => "Location-Path",
+        URIPath => "Uri-Path",
+        ContentFormat => "Content-Format",
+        MaxAge => "Max-Age",
+        URIQuery => "Uri-Query",
+        Accept => "Accept",
+        LocationQuery => "Location-Query",
+        Block2 => "Block2",
+        Block1 => "Block1",
+        Size2 => "Size2",
+        ProxyURI => "Proxy-Uri",
+        ProxyScheme => "Proxy-Scheme",
+        Size1 => "Size1",
+        OSCORE => "OSCORE",
+        HopLimit => "Hop-Limit",
+        QBlock1 => "Q-Block1",
+        QBlock2 => "Q-Block2",
+        Echo => "Echo",
+        RequestTag => "Request-Tag",
+        NoResponse => "No-Response",
+        else => null,
+    };
+}
+
+/// Format of an option value (RFC 7252 Section 3.2).
+pub const Format = enum {
+    empty,
+    @"opaque",
+    uint,
+    string,
+};
+
+/// Registered format and permitted length of an option value.
+pub const Definition = struct {
+    format: Format,
+    min_len: usize,
+    max_len: usize,
+};
+
+/// Returns the definition of the option with the given Option Number
+/// or null if the option is not registered.
+pub fn definition(number: u32) ?Definition {
+    // https://datatracker.ietf.org/doc/html/rfc7252#section-5.10
+    return switch (number) {
+        IfMatch => Definition{ .format = .@"opaque", .min_len = 0, .max_len = 8 },
+        URIHost => Definition{ .format = .string, .min_len = 1, .max_len = 255 },
+        ETag => Definition{ .format = .@"opaque", .min_len = 1, .max_len = 8 },
+        IfNoneMatch => Definition{ .format = .empty, .min_len = 0, .max_len = 0 },
+        URIPort => Definition{ .format = .uint, .min_len = 0, .max_len = 2 },
+        LocationPath => Definition{ .format = .string, .min_len = 0, .max_len = 255 },
+        URIPath => Definition{ .format = .string, .min_len = 0, .max_len = 255 },
+        ContentFormat => Definition{ .format = .uint, .min_len = 0, .max_len = 2 },
+        MaxAge => Definition{ .format = .uint, .min_len = 0, .max_len = 4 },
+        URIQuery => Definition{ .format = .string, .min_len = 0, .max_len = 255 },
+        Accept => Definition{ .format = .uint, .min_len = 0, .max_len = 2 },
+        LocationQuery => Definition{ .format = .string, .min_len = 0, .max_len = 255 },
+        ProxyURI => Definition{ .format = .string, .min_len = 1, .max_len = 1034 },
+        ProxyScheme => Definition{ .format = .string, .min_len = 1, .max_len = 255 },
+        Size1 => Definition{ .format = .uint, .min_len = 0, .max_len = 4 },
+        Observe => Definition{ .format = .uint, .min_len = 0, .max_len = 3 },
+        Block2 => Definition{ .format = .uint, .min_len = 0, .max_len = 3 },
+        Block1 => Definition{ .format = .uint, .min_len = 0, .max_len = 3 },
+        Size2 => Definition{ .format = .uint, .min_len = 0, .max_len = 4 },
+        OSCORE => Definition{ .format = .@"opaque", .min_len = 0, .max_len = 255 },
+        HopLimit => Definition{ .format = .uint, .min_len = 1, .max_len = 1 },
+        QBlock1 => Definition{ .format = .uint, .min_len = 0, .max_len = 3 },
+        QBlock2 => Definition{ .format = .uint, .min_len = 0, .max_len = 3 },
+        Echo => Definition{ .format = .@"opaque", .min_len = 1, .max_len = 40 },
+        RequestTag => Definition{ .format = .@"opaque", .min_len = 0, .max_len = 8 },
+        NoResponse => Definition{ .format = .uint, .min_len = 0, .max_len = 1 },
+        else => null,
+    };
+}
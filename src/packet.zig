@@ -4,6 +4,16 @@ const testing = std.testing;
 const buffer = @import("buffer.zig");
 const codes = @import("codes.zig");
 const opts = @import("opts.zig");
+const uri = @import("uri.zig");
+const stream = @import("stream.zig");
+const ContentFormat = @import("contentformat.zig").ContentFormat;
+const BlockValue = @import("block.zig").BlockValue;
+const cache = @import("cache.zig");
+const validation = @import("validate.zig");
+const cbor = @import("cbor.zig");
+const senml = @import("senml.zig");
+const linkformat = @import("linkformat.zig");
+const pretty = @import("pretty.zig");
 
 // CoAP version implemented by this library.
 //
@@ -22,7 +32,11 @@ const VERSION: u2 = 1;
 //  Lengths 9-15 are reserved, MUST NOT be sent, and MUST be processed
 //  as a message format error.
 //
-const MAX_TOKEN_LEN = 8;
+pub const MAX_TOKEN_LEN = 8;
+
+// Maximum length of an extended CoAP token (see RFC 8974 Section 2.1),
+// i.e. the largest length encoded using a 2-byte extension.
+pub const MAX_EXTENDED_TOKEN_LEN = 65804;
 
 // CoAP Payload marker.
 //
@@ -34,6 +48,40 @@ const MAX_TOKEN_LEN = 8;
 //
 const OPTION_END = 0xff;
 
+// Largest valid Option Number, Option Numbers are 16-bit unsigned
+// integers (see RFC 7252 Section 12.2).
+const MAX_OPTION_NUMBER = 65535;
+
+// Maximum size of an option header: the initial byte followed by the
+// extended option delta and the extended option length (2 bytes each).
+const MAX_OPTION_HEADER = 5;
+
+/// Limits enforced when parsing a message, e.g. to bound the amount of
+/// work a server performs for a single message received from the
+/// network. By default, only the limits imposed by the message format
+/// apply. Exceeding a limit results in error.LimitExceeded, regardless
+/// of the parse mode.
+pub const Limits = struct {
+    /// Maximum size of the entire message in bytes.
+    max_message_size: usize = std.math.maxInt(usize),
+    /// Maximum length of the token in bytes.
+    max_token_len: usize = MAX_EXTENDED_TOKEN_LEN,
+    /// Maximum number of options, including skipped options.
+    max_options: usize = std.math.maxInt(usize),
+    /// Maximum length of a single option value in bytes.
+    max_option_len: usize = std.math.maxInt(usize),
+};
+
+/// Parse mode of a Request. In strict mode, all message format errors
+/// are reported. In lenient mode, the parser attempts to make sense of
+/// malformed messages (e.g. for gateways forwarding messages from
+/// non-conforming peers): unknown elective options are skipped and
+/// malformed trailing data is ignored.
+pub const Mode = enum {
+    strict,
+    lenient,
+};
+
 // CoAP message type.
 //
 // From RFC 7252:
@@ -128,19 +176,56 @@ pub const Response = struct {
     last_option: u32 = 0,
     zero_payload: bool = true,
 
+    /// Offset of the first option in the buffer.
+    options_start: usize = 0,
+
     const WriteError = error{BufTooSmall};
     const PayloadWriter = std.io.Writer(*Response, WriteError, write);
 
     pub fn init(buf: []u8, mt: Msg, code: codes.Code, token: []const u8, id: u16) !Response {
-        if (buf.len < @sizeOf(Header) + token.len)
-            return error.BufTooSmall;
         if (token.len > MAX_TOKEN_LEN)
             return error.InvalidTokenLength;
+        return initExtended(buf, mt, code, token, id);
+    }
+
+    /// Create a message in the given buffer with the header, token, and
+    /// remaining options of the given parsed message, e.g. to forward a
+    /// modified copy of it. Options are copied verbatim and in their
+    /// original order, including repeated options and options with
+    /// unregistered Option Numbers. The latter are copied even if the
+    /// message was parsed in lenient mode. Options added afterwards are
+    /// inserted after copied options with the same Option Number. The
+    /// payload is not copied.
+    pub fn initFrom(buf: []u8, req: *const Request) !Response {
+        var resp = try initExtended(buf, req.header.type, req.header.code, req.token, req.header.message_id);
+
+        var iter = req.options();
+        iter.mode = Mode.strict;
+        iter.enforce_rules = false;
+        while (try iter.next()) |opt|
+            try resp.addOption(&opt);
+
+        return resp;
+    }
+
+    /// Like init but also supports tokens longer than 8 bytes using the
+    /// extended token length specified in RFC 8974. Since peers not
+    /// implementing RFC 8974 reject such messages, this must only be
+    /// used if the peer is known to support extended tokens.
+    pub fn initExtended(buf: []u8, mt: Msg, code: codes.Code, token: []const u8, id: u16) !Response {
+        if (token.len > MAX_EXTENDED_TOKEN_LEN)
+            return error.InvalidTokenLength;
+
+        // The extended token length uses the same encoding as the
+        // option delta (see RFC 8974 Section 2.1).
+        const tkl = DeltaEncoding.encode(@intCast(u32, token.len));
+        if (buf.len < @sizeOf(Header) + tkl.size() + token.len)
+            return error.BufTooSmall;
 
         var hdr = Header{
             .version = VERSION,
             .type = mt,
-            .token_len = @intCast(u4, token.len),
+            .token_len = tkl.id(),
             .code = code,
             .message_id = id,
         };
@@ -155,43 +240,255 @@ pub const Response = struct {
         const serialized = @bitCast(u32, hdr);
 
         r.buffer.word(serialized);
+        tkl.writeExtend(&r.buffer);
         r.buffer.bytes(token);
+        r.options_start = r.buffer.pos;
 
         return r;
     }
 
     pub fn reply(buf: []u8, req: *const Request, mt: Msg, code: codes.Code) !Response {
         const hdr = req.header;
-        return init(buf, mt, code, req.token, hdr.message_id);
+
+        // An extended token in the request implies that the peer
+        // supports extended tokens, thus it can be echoed.
+        return initExtended(buf, mt, code, req.token, hdr.message_id);
     }
 
-    /// Add an option to the CoAP response. Options must be added in the
-    /// in order of their Option Numbers. After data has been written to
-    /// the payload, no additional options can be added. Both invariants
-    /// are enforced using assertions in Debug and ReleaseSafe modes.
+    /// Add an option to the CoAP response. Options can be added in any
+    /// order, each option is inserted according to its Option Number.
+    /// Options with the same Option Number retain the order in which
+    /// they were added. Adding options in order is cheapest, since
+    /// inserting an option requires moving all following options.
+    /// After data has been written to the payload, no additional options
+    /// can be added. This invariant is enforced using assertions in
+    /// Debug and ReleaseSafe modes.
     pub fn addOption(self: *Response, opt: *const opts.Option) !void {
         // This function cannot be called after payload has been written.
         std.debug.assert(self.zero_payload);
 
-        std.debug.assert(self.last_option <= opt.number);
-        const delta = opt.number - self.last_option;
+        if (opt.number >= self.last_option) {
+            try self.appendOption(opt);
+        } else {
+            try self.insertOption(opt);
+        }
+    }
 
-        const odelta = DeltaEncoding.encode(delta);
-        const olen = DeltaEncoding.encode(@intCast(u32, opt.value.len));
+    fn appendOption(self: *Response, opt: *const opts.Option) !void {
+        var hdr: [MAX_OPTION_HEADER]u8 = undefined;
+        const header = encodeOptionHeader(&hdr, opt.number - self.last_option, opt.value.len);
 
-        const reqcap = 1 + odelta.size() + olen.size() + opt.value.len;
-        if (self.buffer.capacity() < reqcap)
+        if (self.buffer.capacity() < header.len + opt.value.len)
             return error.BufTooSmall;
-
-        // See https://datatracker.ietf.org/doc/html/rfc7252#section-3.1
-        self.buffer.byte(@as(u8, odelta.id()) << 4 | olen.id());
-        odelta.writeExtend(&self.buffer);
-        olen.writeExtend(&self.buffer);
+        self.buffer.bytes(header);
         self.buffer.bytes(opt.value);
 
         self.last_option = opt.number;
     }
 
+    fn insertOption(self: *Response, opt: *const opts.Option) !void {
+        const end = self.buffer.pos;
+        // Options copied from other messages (e.g. a relayed response)
+        // may violate the rules of their definition or the limits of the
+        // original message, they only need to be decoded here.
+        var iter = OptionIterator{
+            .slice = buffer.ReadBuffer{ .slice = self.buffer.slice[self.options_start..end] },
+            .limits = Limits{},
+            .enforce_rules = false,
+        };
+
+        // Find the first option with a larger Option Number, the new
+        // option is inserted in front of it. Since the delta of that
+        // option changes, its header needs to be encoded again.
+        var prev: u32 = 0;
+        var at: usize = undefined;
+        var value_start: usize = undefined;
+        var next: opts.Option = undefined;
+        while (true) {
+            const pos = end - iter.slice.length();
+            const o = (try iter.next()) orelse unreachable;
+            if (o.number > opt.number) {
+                at = pos;
+                value_start = end - iter.slice.length() - o.value.len;
+                next = o;
+                break;
+            }
+            prev = o.number;
+        }
+
+        var opt_hdr: [MAX_OPTION_HEADER]u8 = undefined;
+        var next_hdr: [MAX_OPTION_HEADER]u8 = undefined;
+        const opt_header = encodeOptionHeader(&opt_hdr, opt.number - prev, opt.value.len);
+        const next_header = encodeOptionHeader(&next_hdr, next.number - opt.number, next.value.len);
+
+        const old_len = value_start - at;
+        const new_len = opt_header.len + opt.value.len + next_header.len;
+        if (new_len > old_len and new_len - old_len > self.buffer.capacity())
+            return error.BufTooSmall;
+
+        // Move the following options, including the value of the option
+        // whose header is replaced, and write the new option in between.
+        const buf = self.buffer.slice;
+        const tail_len = end - value_start;
+        const dest = at + new_len;
+        if (new_len > old_len) {
+            std.mem.copyBackwards(u8, buf[dest .. dest + tail_len], buf[value_start..end]);
+        } else {
+            std.mem.copy(u8, buf[dest .. dest + tail_len], buf[value_start..end]);
+        }
+
+        std.mem.copy(u8, buf[at..], opt_header);
+        std.mem.copy(u8, buf[at + opt_header.len ..], opt.value);
+        std.mem.copy(u8, buf[at + opt_header.len + opt.value.len ..], next_header);
+        self.buffer.pos = dest + tail_len;
+    }
+
+    /// Encode the header of an option with the given delta and value
+    /// length into the given buffer, returns the encoded header.
+    fn encodeOptionHeader(buf: *[MAX_OPTION_HEADER]u8, delta: u32, len: usize) []const u8 {
+        const odelta = DeltaEncoding.encode(delta);
+        const olen = DeltaEncoding.encode(@intCast(u32, len));
+
+        // See https://datatracker.ietf.org/doc/html/rfc7252#section-3.1
+        var wb = buffer.WriteBuffer{ .slice = buf };
+        wb.byte(@as(u8, odelta.id()) << 4 | olen.id());
+        odelta.writeExtend(&wb);
+        olen.writeExtend(&wb);
+        return wb.serialized();
+    }
+
+    /// Add an option with a value in the uint format, see addOption.
+    pub fn addUint(self: *Response, number: u32, value: u32) !void {
+        var buf: [@sizeOf(u32)]u8 = undefined;
+        std.mem.writeIntBig(u32, &buf, value);
+
+        // From RFC 7252:
+        //
+        //  A sender SHOULD represent the integer with as few bytes as
+        //  possible, i.e., without leading zero bytes.
+        //
+        var i: usize = 0;
+        while (i < buf.len and buf[i] == 0) : (i += 1) {}
+
+        try self.addOption(&opts.Option{ .number = number, .value = buf[i..] });
+    }
+
+    /// Add a Content-Format option, see addOption.
+    pub fn addContentFormat(self: *Response, format: ContentFormat) !void {
+        try self.addUint(opts.ContentFormat, @enumToInt(format));
+    }
+
+    /// Add an Accept option, see addOption.
+    pub fn addAccept(self: *Response, format: ContentFormat) !void {
+        try self.addUint(opts.Accept, @enumToInt(format));
+    }
+
+    /// Add a Max-Age option, see addOption.
+    pub fn addMaxAge(self: *Response, seconds: u32) !void {
+        try self.addUint(opts.MaxAge, seconds);
+    }
+
+    /// Add a Block1 or Block2 option, see addOption.
+    pub fn addBlock(self: *Response, number: u32, block: BlockValue) !void {
+        var buf: [3]u8 = undefined;
+        try self.addOption(&opts.Option{ .number = number, .value = block.encode(&buf) });
+    }
+
+    /// Add Uri-Path options for the given percent-encoded absolute path,
+    /// one option per path segment. Empty segments (e.g. from a trailing
+    /// slash) are retained, the paths "" and "/" result in no options.
+    pub fn addURIPath(self: *Response, path: []const u8) !void {
+        if (path.len == 0 or std.mem.eql(u8, path, "/"))
+            return;
+
+        var segments = path;
+        if (segments[0] == '/')
+            segments = segments[1..];
+        try self.addComponents(opts.URIPath, segments, "/");
+    }
+
+    /// Add Uri-Host, Uri-Port, Uri-Path, and Uri-Query options for the
+    /// given absolute coap or coaps URI, as described in RFC 7252
+    /// Section 6.4. The Uri-Host option is omitted if the host is an IP
+    /// address, the Uri-Port option if the port is the default port of
+    /// the scheme. The request must be sent to the host and port given
+    /// in the URI, selecting the transport for the scheme is up to the
+    /// caller.
+    pub fn addURI(self: *Response, str: []const u8) !void {
+        const components = try uri.parse(str);
+
+        if (!uri.isIPAddress(components.host)) {
+            // From RFC 7252:
+            //
+            //  The value of this option is the |host| component of
+            //  |url| after converting it to lowercase and
+            //  percent-decoding.
+            //
+            var buf: [uri.MAX_SEGMENT_LEN]u8 = undefined;
+            const len = (try uri.decode(&buf, components.host)).len;
+            for (buf[0..len]) |*c|
+                c.* = std.ascii.toLower(c.*);
+            try self.addOption(&opts.Option{ .number = opts.URIHost, .value = buf[0..len] });
+        }
+
+        const port = components.port orelse components.scheme.defaultPort();
+        if (port != components.scheme.defaultPort())
+            try self.addUint(opts.URIPort, port);
+
+        try self.addURIPath(components.path);
+        try self.addURIQuery(components.query);
+    }
+
+    /// Add Uri-Query options for the given percent-encoded query (without
+    /// the leading "?"), one option per "&"-separated argument.
+    pub fn addURIQuery(self: *Response, query: []const u8) !void {
+        if (query.len == 0)
+            return;
+        try self.addComponents(opts.URIQuery, query, "&");
+    }
+
+    /// Add Location-Path and Location-Query options for the given
+    /// percent-encoded relative URI reference consisting of an absolute
+    /// path, a query, or both (e.g. "/items/42?rev=1"), as used to
+    /// indicate the resource created by a 2.01 (Created) response to a
+    /// POST request. Segments are split like in addURIPath and
+    /// addURIQuery, the segments "." and ".." are rejected since they
+    /// must not appear in Location-Path options.
+    pub fn addLocation(self: *Response, ref: []const u8) !void {
+        if (std.mem.indexOfScalar(u8, ref, '#') != null)
+            return error.InvalidURI;
+
+        const query_start = std.mem.indexOfScalar(u8, ref, '?') orelse ref.len;
+        const path = ref[0..query_start];
+        if (path.len > 0 and path[0] != '/')
+            return error.InvalidURI;
+
+        if (path.len > 1) {
+            var buf: [uri.MAX_SEGMENT_LEN]u8 = undefined;
+
+            var iter = std.mem.split(u8, path[1..], "/");
+            while (iter.next()) |segment| {
+                const value = try uri.decode(&buf, segment);
+                if (std.mem.eql(u8, value, ".") or std.mem.eql(u8, value, ".."))
+                    return error.InvalidURI;
+                try self.addOption(&opts.Option{ .number = opts.LocationPath, .value = value });
+            }
+        }
+
+        if (query_start + 1 < ref.len)
+            try self.addComponents(opts.LocationQuery, ref[query_start + 1 ..], "&");
+    }
+
+    fn addComponents(self: *Response, number: u32, components: []const u8, sep: []const u8) !void {
+        var buf: [uri.MAX_SEGMENT_LEN]u8 = undefined;
+
+        var iter = std.mem.split(u8, components, sep);
+        while (iter.next()) |component| {
+            const value = try uri.decode(&buf, component);
+            try self.addOption(&opts.Option{ .number = number, .value = value });
+        }
+    }
+
     /// Write data to the payload of the CoAP response. If the given data
     /// exceeds the available space in the buffer, an error is returned.
     fn write(self: *Response, data: []const u8) WriteError!usize {
@@ -217,15 +514,117 @@ pub const Response = struct {
     pub fn setCode(self: *Response, code: codes.Code) void {
         // Code is *always* the second byte in the buffer.
         self.buffer.slice[1] = @bitCast(u8, code);
+        self.header.code = code;
+    }
+
+    /// Update the message type after creating the packet.
+    pub fn setType(self: *Response, mt: Msg) void {
+        // Type is stored in bits 4 and 5 of the first byte.
+        const first = self.buffer.slice[0] & 0xcf;
+        self.buffer.slice[0] = first | @as(u8, @enumToInt(mt)) << 4;
+        self.header.type = mt;
+    }
+
+    /// Update the Message ID after creating the packet.
+    pub fn setMessageID(self: *Response, id: u16) void {
+        std.mem.writeIntSliceBig(u16, self.buffer.slice[2..4], id);
+        self.header.message_id = id;
     }
 
     pub fn payloadWriter(self: *Response) PayloadWriter {
         return PayloadWriter{ .context = self };
     }
 
+    /// Add a Content-Format option for application/cbor and return an
+    /// encoder writing CBOR data items to the payload. Since no options
+    /// can be added after the payload, this must be called after adding
+    /// all other options.
+    pub fn cborEncoder(self: *Response) !cbor.Encoder(PayloadWriter) {
+        try self.addContentFormat(ContentFormat.cbor);
+        return cbor.encoder(self.payloadWriter());
+    }
+
+    /// Add a Content-Format option for application/link-format and write
+    /// the given links to the payload. Since no options can be added
+    /// after the payload, this must be called after adding all other
+    /// options.
+    pub fn writeLinks(self: *Response, links: []const linkformat.Description) !void {
+        try self.addContentFormat(ContentFormat.link_format);
+        try linkformat.writeLinks(self.payloadWriter(), links);
+    }
+
+    /// Add a Content-Format option for the given SenML representation
+    /// (application/senml+cbor or application/senml+json) and write the
+    /// given records as SenML Pack to the payload. Since no options can
+    /// be added after the payload, this must be called after adding all
+    /// other options.
+    pub fn writeSenML(self: *Response, format: ContentFormat, records: []const senml.Record) !void {
+        switch (format) {
+            ContentFormat.senml_cbor => {
+                try self.addContentFormat(format);
+                try senml.writeCbor(self.payloadWriter(), records);
+            },
+            ContentFormat.senml_json => {
+                try self.addContentFormat(format);
+                try senml.writeJson(self.payloadWriter(), records);
+            },
+            else => return error.InvalidArgument,
+        }
+    }
+
     pub fn marshal(self: *Response) []u8 {
         return self.buffer.serialized();
     }
+
+    /// Discard all options and the payload, retaining the header and the
+    /// token. This allows reusing the response, and its buffer, for
+    /// another message to the same peer (e.g. after an error occurred).
+    pub fn reset(self: *Response) void {
+        self.buffer.pos = self.options_start;
+        self.last_option = 0;
+        self.zero_payload = true;
+    }
+
+    /// Returns the size of the encoded message in bytes.
+    pub fn size(self: *const Response) usize {
+        return self.buffer.pos;
+    }
+
+    /// Copy the encoded message to the given buffer (e.g. a transmit
+    /// buffer used for DMA), returns the amount of bytes written. If the
+    /// buffer is smaller than size, an error is returned and nothing is
+    /// written.
+    pub fn marshalTo(self: *Response, buf: []u8) !usize {
+        const msg = self.marshal();
+        if (buf.len < msg.len)
+            return error.BufTooSmall;
+
+        std.mem.copy(u8, buf, msg);
+        return msg.len;
+    }
+
+    /// Like marshalTo but encodes the message in the CoAP over TCP
+    /// message format (see RFC 8323 Section 3.2), which does not include
+    /// the type and Message ID of the message. Extended tokens are not
+    /// supported by this format.
+    pub fn marshalStream(self: *Response, buf: []u8) !usize {
+        const msg = self.marshal();
+        return stream.encode(buf, self.header.code, self.token, msg[self.options_start..]);
+    }
+
+    /// Like marshalStream but encodes the message in the CoAP over
+    /// WebSockets message format (see RFC 8323 Section 4.2), the result
+    /// must be sent as a single binary WebSocket frame.
+    pub fn marshalWebSocket(self: *Response, buf: []u8) !usize {
+        const msg = self.marshal();
+        return stream.encodeWebSocket(buf, self.header.code, self.token, msg[self.options_start..]);
+    }
+
+    /// Check the constructed message for violations, see Request.validate.
+    pub fn validate(self: *Response) !validation.Violations {
+        const req = try Request.init(self.marshal());
+        return req.validate();
+    }
 };
 
 test "test header serialization" {
@@ -312,50 +711,24 @@ test "test option serialization" {
     try testing.expect(std.mem.eql(u8, serialized, exp));
 }
 
-pub const Request = struct {
-    header: Header,
+/// Iterator over the options of a CoAP packet. The option block is
+/// decoded in place, that is, option values are slices of the underlying
+/// packet buffer and no intermediate option list is created.
+pub const OptionIterator = struct {
     slice: buffer.ReadBuffer,
-    token: []const u8,
-    payload: ?([]const u8),
-    last_option: ?opts.Option,
-
-    pub fn init(buf: []const u8) !Request {
-        var slice = buffer.ReadBuffer{ .slice = buf };
-        if (buf.len < @sizeOf(Header))
-            return error.FormatError;
-
-        // Cast first four bytes to u32 and convert them to header struct
-        const serialized: u32 = try slice.word();
-        var hdr = @bitCast(Header, serialized);
-
-        // Convert message_id to a integer in host byteorder
-        hdr.message_id = std.mem.bigToNative(u16, hdr.message_id);
-
-        var token: []const u8 = &[_]u8{};
-        if (hdr.token_len > 0) {
-            if (hdr.token_len > MAX_TOKEN_LEN)
-                return error.FormatError;
-
-            token = slice.bytes(hdr.token_len) catch {
-                return error.FormatError;
-            };
-        }
-
-        // For the first instance in a message, a preceding
-        // option instance with Option Number zero is assumed.
-        const init_option = opts.Option{ .number = 0, .value = &[_]u8{} };
-
-        return Request{
-            .header = hdr,
-            .token = token,
-            .slice = slice,
-            .payload = null,
-            .last_option = init_option,
-        };
-    }
+    number: u32 = 0,
+    done: bool = false,
+    mode: Mode = Mode.strict,
+    limits: Limits = Limits{},
+    /// Number of options returned or skipped so far.
+    count: usize = 0,
+    /// Whether options violating the length or repetition rules of
+    /// registered options (see opts.definition and opts.isRepeatable)
+    /// are rejected in strict mode.
+    enforce_rules: bool = true,
 
     // https://datatracker.ietf.org/doc/html/rfc7252#section-3.1
-    fn decodeValue(self: *Request, val: u4) !u16 {
+    fn decodeValue(self: *OptionIterator, val: u4) !u32 {
         switch (val) {
             13 => {
                 // From RFC 7252:
@@ -366,7 +739,7 @@ pub const Request = struct {
                 const result = self.slice.byte() catch {
                     return error.FormatError;
                 };
-                return @as(u16, result + 13);
+                return @as(u32, result) + 13;
             },
             14 => {
                 // From RFC 7252:
@@ -377,7 +750,7 @@ pub const Request = struct {
                 const result = self.slice.half() catch {
                     return error.FormatError;
                 };
-                return std.mem.bigToNative(u16, result) + 269;
+                return @as(u32, std.mem.bigToNative(u16, result)) + 269;
             },
             15 => {
                 // From RFC 7252:
@@ -385,7 +758,7 @@ pub const Request = struct {
                 //  15: Reserved for future use. If the field is set to this value,
                 //  it MUST be processed as a message format error.
                 //
-                return error.PayloadMarker;
+                return error.FormatError;
             },
             else => {
                 return val;
@@ -393,44 +766,324 @@ pub const Request = struct {
         }
     }
 
-    /// Returns the next option or null if the packet contains a payload
-    /// and the option end has been reached. If the packet does not
-    /// contain a payload an error is returned.
+    /// Returns the next option or null if the end of the options has
+    /// been reached. Options are returned in the order of their Option
+    /// Numbers. The absence of the Payload Marker denotes the end of the
+    /// options and a zero-length payload.
     ///
-    /// Options are returned in the order of their Option Numbers.
-    fn nextOption(self: *Request) !?opts.Option {
-        if (self.last_option == null)
+    /// In lenient mode, unknown elective options are skipped and
+    /// malformed options are treated as the end of the options, any
+    /// data following them is discarded.
+    ///
+    /// In strict mode, an error is also returned for options with a value
+    /// length outside the registered range and for repeated options which
+    /// are not repeatable, unless enforce_rules is disabled.
+    ///
+    /// If the options exceed the limits of the iterator, an error is
+    /// returned in both modes.
+    pub fn next(self: *OptionIterator) !?opts.Option {
+        while (true) {
+            const prev = self.number;
+            const next_opt = self.decodeOption() catch |err| {
+                if (self.mode == Mode.strict)
+                    return err;
+
+                self.done = true;
+                self.slice.slice = &[_]u8{};
+                return null;
+            };
+
+            const opt = next_opt orelse return null;
+            const repeated = self.count > 0 and opt.number == prev;
+            self.count += 1;
+            if (self.count > self.limits.max_options or opt.value.len > self.limits.max_option_len)
+                return error.LimitExceeded;
+
+            if (self.mode == Mode.strict and self.enforce_rules) {
+                if (repeated and !opts.isRepeatable(opt.number))
+                    return error.RepeatedOption;
+                try checkLength(opt);
+            }
+
+            if (self.mode == Mode.lenient and isUnknownElective(opt.number))
+                continue;
+            return opt;
+        }
+    }
+
+    fn decodeOption(self: *OptionIterator) !?opts.Option {
+        if (self.done)
             return null;
 
         const option = self.slice.byte() catch {
-            return error.EndOfStream;
+            self.done = true;
+            return null;
         };
         if (option == OPTION_END) {
-            self.last_option = null;
-            if (self.slice.length() < 1) {
-                // For zero-length payload OPTION_END should not be set.
+            // For zero-length payload OPTION_END should not be set.
+            if (self.slice.length() < 1)
                 return error.InvalidPayload;
-            } else {
-                self.payload = self.slice.remaining();
-            }
+
+            self.done = true;
             return null;
         }
 
         const delta = try self.decodeValue(@intCast(u4, option >> 4));
         const len = try self.decodeValue(@intCast(u4, option & 0xf));
 
-        var optnum = self.last_option.?.number + delta;
-        var optval = self.slice.bytes(len) catch {
+        const optnum = self.number + delta;
+        if (optnum > MAX_OPTION_NUMBER)
+            return error.FormatError;
+        const optval = self.slice.bytes(len) catch {
             return error.FormatError;
         };
 
-        const ret = opts.Option{
+        self.number = optnum;
+        return opts.Option{
             .number = optnum,
             .value = optval,
         };
+    }
+
+    /// Returns the payload following the options (if any). This function
+    /// must only be called after next returned null.
+    pub fn payload(self: *OptionIterator) ?[]const u8 {
+        std.debug.assert(self.done);
+        if (self.slice.length() == 0)
+            return null;
+        return self.slice.remaining();
+    }
+};
+
+/// Iterator over the values of all options with a given Option Number,
+/// e.g. all Uri-Path segments. See Request.getAll.
+pub const OptionValues = struct {
+    iter: OptionIterator,
+    number: u32,
+
+    /// Returns the next option value or null if no further options
+    /// with the Option Number exist.
+    pub fn next(self: *OptionValues) !?[]const u8 {
+        while (try self.iter.next()) |opt| {
+            if (opt.number > self.number)
+                break;
+            if (opt.number == self.number) {
+                try checkLength(opt);
+                return opt.value;
+            }
+        }
+
+        self.iter.done = true;
+        return null;
+    }
+};
+
+/// Whether the given option is elective and not defined in opts.zig.
+fn isUnknownElective(number: u32) bool {
+    return !opts.isCritical(number) and opts.definition(number) == null;
+}
+
+/// Iterator over critical options which are not recognized, see
+/// Request.unrecognizedOptions.
+pub const UnrecognizedOptions = struct {
+    iter: OptionIterator,
+    known: []const u32,
+
+    /// Returns the next unrecognized critical option or null if no
+    /// further unrecognized critical options exist.
+    pub fn next(self: *UnrecognizedOptions) !?opts.Option {
+        while (try self.iter.next()) |opt| {
+            if (!opts.isCritical(opt.number) or opts.definition(opt.number) != null)
+                continue;
+            if (std.mem.indexOfScalar(u32, self.known, opt.number) == null)
+                return opt;
+        }
+        return null;
+    }
+};
+
+/// Returns the slice of new at the offset of the given slice in old.
+fn rebase(slice: []const u8, old: []const u8, new: []const u8) []const u8 {
+    // Empty slices do not necessarily point into the message.
+    if (slice.len == 0)
+        return new[0..0];
+
+    const offset = @ptrToInt(slice.ptr) - @ptrToInt(old.ptr);
+    return new[offset .. offset + slice.len];
+}
+
+/// Check that the length of the given option is within the range
+/// registered for its Option Number (if any).
+fn checkLength(opt: opts.Option) !void {
+    const def = opts.definition(opt.number) orelse return;
+    if (opt.value.len < def.min_len or opt.value.len > def.max_len)
+        return error.InvalidLength;
+}
+
+/// A parsed CoAP message. The request does not own the parsed message,
+/// the token, options, and payload are views into the buffer passed to
+/// init and are only valid as long as this buffer is. To retain the
+/// message beyond the lifetime of the buffer (e.g. a receive buffer
+/// reused for the next datagram), the message must be copied to a
+/// buffer owned by the caller using clone.
+pub const Request = struct {
+    header: Header,
+    /// The complete message.
+    data: []const u8,
+    slice: buffer.ReadBuffer,
+    token: []const u8,
+    payload: ?([]const u8),
+    last_option: ?opts.Option,
+    mode: Mode,
+    limits: Limits,
+    /// Number of options consumed using nextOption.
+    option_count: usize = 0,
+
+    pub fn init(buf: []const u8) !Request {
+        return initWithMode(buf, Mode.strict);
+    }
+
+    /// Parse the header of the given CoAP message using the given parse
+    /// mode, options and payload are parsed on demand.
+    pub fn initWithMode(buf: []const u8, mode: Mode) !Request {
+        return initWithLimits(buf, mode, Limits{});
+    }
+
+    /// Like initWithMode but additionally enforces the given limits. The
+    /// limits on the options are enforced as options are parsed.
+    pub fn initWithLimits(buf: []const u8, mode: Mode, limits: Limits) !Request {
+        if (buf.len > limits.max_message_size)
+            return error.LimitExceeded;
+
+        var slice = buffer.ReadBuffer{ .slice = buf };
+        if (buf.len < @sizeOf(Header))
+            return error.FormatError;
+
+        // Cast first four bytes to u32 and convert them to header struct
+        const serialized: u32 = try slice.word();
+        var hdr = @bitCast(Header, serialized);
+
+        // Convert message_id to a integer in host byteorder
+        hdr.message_id = std.mem.bigToNative(u16, hdr.message_id);
+
+        // From RFC 7252:
+        //
+        //  Messages with unknown version numbers MUST be silently ignored.
+        //
+        if (hdr.version != VERSION)
+            return error.UnsupportedVersion;
+
+        // From RFC 8974:
+        //
+        //  13:  An 8-bit unsigned integer directly precedes the Token field
+        //       and indicates the length of the Token minus 13.
+        //
+        //  14:  A 16-bit unsigned integer in network byte order directly
+        //       precedes the Token field and indicates the length of the
+        //       Token minus 269.
+        //
+        const token_len: usize = switch (hdr.token_len) {
+            0...MAX_TOKEN_LEN => hdr.token_len,
+            13 => @as(usize, slice.byte() catch return error.FormatError) + 13,
+            14 => @as(usize, std.mem.bigToNative(u16, slice.half() catch return error.FormatError)) + 269,
+            else => return error.FormatError,
+        };
+        if (token_len > limits.max_token_len)
+            return error.LimitExceeded;
+        var token = slice.bytes(token_len) catch {
+            return error.FormatError;
+        };
+
+        // From RFC 7252:
+        //
+        //  An Empty message has the Code field set to 0.00. The Token
+        //  Length field MUST be set to 0 and bytes of data MUST NOT be
+        //  present after the Message ID field. If there are any bytes,
+        //  they MUST be processed as a message format error.
+        //
+        if (hdr.code.isEmpty() and (token.len > 0 or slice.length() > 0)) {
+            if (mode == Mode.strict)
+                return error.FormatError;
+
+            hdr.token_len = 0;
+            token = &[_]u8{};
+            slice.slice = &[_]u8{};
+        }
+
+        // For the first instance in a message, a preceding
+        // option instance with Option Number zero is assumed.
+        const init_option = opts.Option{ .number = 0, .value = &[_]u8{} };
+
+        return Request{
+            .header = hdr,
+            .data = buf,
+            .token = token,
+            .slice = slice,
+            .payload = null,
+            .last_option = init_option,
+            .mode = mode,
+            .limits = limits,
+        };
+    }
+
+    /// Copy the message to the given buffer and return a request which
+    /// references the copy instead of the original buffer. The state of
+    /// the request is retained, i.e. options already consumed from this
+    /// request are also consumed from the returned one.
+    pub fn clone(self: *const Request, buf: []u8) !Request {
+        if (buf.len < self.data.len)
+            return error.BufTooSmall;
+        std.mem.copy(u8, buf, self.data);
+
+        const data = buf[0..self.data.len];
+        var r = self.*;
+        r.data = data;
+        r.token = rebase(self.token, self.data, data);
+        r.slice = buffer.ReadBuffer{ .slice = rebase(self.slice.slice, self.data, data) };
+        if (self.payload) |p|
+            r.payload = rebase(p, self.data, data);
+        if (self.last_option) |opt|
+            r.last_option = opts.Option{ .number = opt.number, .value = rebase(opt.value, self.data, data) };
+
+        return r;
+    }
+
+    /// Returns the next option or null if the packet contains a payload
+    /// and the option end has been reached. If the packet does not
+    /// contain a payload an error is returned.
+    ///
+    /// Options are returned in the order of their Option Numbers.
+    fn nextOption(self: *Request) !?opts.Option {
+        if (self.last_option == null)
+            return null;
+        if (self.slice.length() < 1)
+            return error.EndOfStream;
 
-        self.last_option = ret;
-        return ret;
+        var iter = self.options();
+        const next = try iter.next();
+
+        self.slice = iter.slice;
+        self.option_count = iter.count;
+        if (next) |opt| {
+            self.last_option = opt;
+        } else {
+            self.last_option = null;
+            self.payload = iter.payload();
+        }
+
+        return next;
+    }
+
+    /// Returns an iterator over the remaining options in the CoAP packet.
+    /// Contrary to nextOption, the iterator does not modify the request.
+    /// Hence, options can be iterated multiple times, without allocating
+    /// memory, and the request can be used afterwards.
+    pub fn options(self: *const Request) OptionIterator {
+        if (self.last_option) |last| {
+            return OptionIterator{ .slice = self.slice, .number = last.number, .mode = self.mode, .limits = self.limits, .count = self.option_count };
+        } else {
+            return OptionIterator{ .slice = self.slice, .done = true, .mode = self.mode, .limits = self.limits, .count = self.option_count };
+        }
     }
 
     /// Find an option with the given Option Number in the CoAP packet.
@@ -464,15 +1117,276 @@ pub const Request = struct {
         }
     }
 
-    /// Skip all remaining options in the CoAP packet and return a pointer
-    /// to the packet payload (if any). After this function has been
-    /// called it is no longer possible to extract options from the packet.
-    pub fn extractPayload(self: *Request) !(?[]const u8) {
-        while (true) {
-            var opt = self.nextOption() catch |err| {
-                // The absence of the Payload Marker denotes a zero-length payload.
-                if (err == error.EndOfStream)
-                    return error.ZeroLengthPayload;
+    /// Returns the first option with the given Option Number, without
+    /// consuming any options. If the option is registered, its format
+    /// must match the given format and its length must be within the
+    /// registered range.
+    fn getOption(self: *const Request, number: u32, format: opts.Format) !?opts.Option {
+        if (opts.definition(number)) |def| {
+            if (def.format != format)
+                return error.InvalidArgument;
+        }
+
+        var iter = self.options();
+        while (try iter.next()) |opt| {
+            if (opt.number > number)
+                break;
+            if (opt.number == number) {
+                try checkLength(opt);
+                return opt;
+            }
+        }
+
+        return null;
+    }
+
+    /// Returns the value of the first option with the given Option
+    /// Number decoded as an unsigned integer or null if no such option
+    /// exists.
+    pub fn getUint(self: *const Request, number: u32) !?u32 {
+        const opt = (try self.getOption(number, .uint)) orelse return null;
+        if (opt.value.len > @sizeOf(u32))
+            return error.InvalidLength;
+
+        // From RFC 7252:
+        //
+        //  A non-negative integer that is represented in network byte
+        //  order using the number of bytes given by the Option Length
+        //  field.
+        //
+        var result: u32 = 0;
+        for (opt.value) |b| {
+            result = (result << 8) | b;
+        }
+        return result;
+    }
+
+    /// Returns the value of the first option with the given Option
+    /// Number, which must be valid UTF-8, or null if no such option
+    /// exists.
+    pub fn getString(self: *const Request, number: u32) !?[]const u8 {
+        const opt = (try self.getOption(number, .string)) orelse return null;
+        if (!std.unicode.utf8ValidateSlice(opt.value))
+            return error.InvalidString;
+        return opt.value;
+    }
+
+    /// Returns the value of the first option with the given Option
+    /// Number as an opaque sequence of bytes or null if no such option
+    /// exists.
+    pub fn getOpaque(self: *const Request, number: u32) !?[]const u8 {
+        const opt = (try self.getOption(number, .@"opaque")) orelse return null;
+        return opt.value;
+    }
+
+    /// Returns an iterator over the values of all options with the given
+    /// Option Number, without consuming any options.
+    pub fn getAll(self: *const Request, number: u32) OptionValues {
+        return OptionValues{ .iter = self.options(), .number = number };
+    }
+
+    /// Returns the Content-Format of the payload or null if the request
+    /// does not contain a Content-Format option.
+    pub fn contentFormat(self: *const Request) !?ContentFormat {
+        const value = (try self.getUint(opts.ContentFormat)) orelse return null;
+        return @intToEnum(ContentFormat, @intCast(u16, value));
+    }
+
+    /// Returns the payload without consuming any options, an empty slice
+    /// is returned if the message has no payload.
+    fn peekPayload(self: *const Request) ![]const u8 {
+        var iter = self.options();
+        while (try iter.next()) |_| {}
+        return iter.payload() orelse &[_]u8{};
+    }
+
+    /// Returns a decoder for the CBOR data items in the payload. An
+    /// error is returned if the Content-Format of the message is not
+    /// application/cbor.
+    pub fn cborDecoder(self: *const Request) !cbor.Decoder {
+        const format = (try self.contentFormat()) orelse return error.InvalidContentFormat;
+        if (format != ContentFormat.cbor)
+            return error.InvalidContentFormat;
+        return cbor.Decoder{ .slice = try self.peekPayload() };
+    }
+
+    /// Returns a parser for the links in the payload. An error is
+    /// returned if the Content-Format of the message is not
+    /// application/link-format.
+    pub fn linkParser(self: *const Request) !linkformat.Parser {
+        const format = (try self.contentFormat()) orelse return error.InvalidContentFormat;
+        if (format != ContentFormat.link_format)
+            return error.InvalidContentFormat;
+        return linkformat.Parser{ .data = try self.peekPayload() };
+    }
+
+    /// Returns a reader for the records of the SenML Pack in the payload.
+    /// An error is returned if the Content-Format of the message is
+    /// neither application/senml+cbor nor application/senml+json.
+    pub fn senMLReader(self: *const Request) !senml.Reader {
+        const format = (try self.contentFormat()) orelse return error.InvalidContentFormat;
+        return switch (format) {
+            ContentFormat.senml_cbor => senml.Reader{ .cbor = try senml.CborReader.init(try self.peekPayload()) },
+            ContentFormat.senml_json => senml.Reader{ .json = try senml.JsonReader.init(try self.peekPayload()) },
+            else => error.InvalidContentFormat,
+        };
+    }
+
+    /// Returns the Content-Format requested using the Accept option or
+    /// null if the request does not contain an Accept option.
+    pub fn accept(self: *const Request) !?ContentFormat {
+        const value = (try self.getUint(opts.Accept)) orelse return null;
+        return @intToEnum(ContentFormat, @intCast(u16, value));
+    }
+
+    /// Returns the error corresponding to the code of the message if it
+    /// is a Client Error (4.xx) or Server Error (5.xx) response, see
+    /// codes.Code.check. In this case, the diagnostic payload of the
+    /// response is stored in the given slice, it is empty if the
+    /// response has no payload and should be valid UTF-8 but is not
+    /// validated.
+    pub fn checkResponse(self: *const Request, diagnostic: *[]const u8) !void {
+        self.header.code.check() catch |err| {
+            diagnostic.* = try self.peekPayload();
+            return err;
+        };
+    }
+
+    /// Returns the value of the Max-Age option in seconds or the default
+    /// value if the response does not contain a Max-Age option.
+    pub fn getMaxAge(self: *const Request) !u32 {
+        return (try self.getUint(opts.MaxAge)) orelse cache.DEFAULT_MAX_AGE;
+    }
+
+    /// Returns the value of the Block1 or Block2 option or null if the
+    /// request does not contain the given option.
+    pub fn getBlock(self: *const Request, number: u32) !?BlockValue {
+        const opt = (try self.getOption(number, .uint)) orelse return null;
+        return try BlockValue.decode(opt.value);
+    }
+
+    /// Returns the sequence number of the Observe option or null if the
+    /// request does not contain an Observe option.
+    pub fn getObserve(self: *const Request) !?u24 {
+        const value = (try self.getUint(opts.Observe)) orelse return null;
+        return @intCast(u24, value);
+    }
+
+    /// Check the message for violations of the requirements on message
+    /// types, codes, options, and the payload which are not enforced by
+    /// the parser, see validate.zig.
+    pub fn validate(self: *const Request) validation.Violations {
+        return validation.validate(self);
+    }
+
+    /// Returns an iterator over all critical options which are neither
+    /// defined in opts.zig nor contained in the given list of additional
+    /// Option Numbers known to the caller.
+    ///
+    /// From RFC 7252:
+    ///
+    ///  Unrecognized options of class "critical" that occur in a
+    ///  Confirmable request MUST cause the return of a 4.02 (Bad Option)
+    ///  response.
+    ///
+    pub fn unrecognizedOptions(self: *const Request, known: []const u32) UnrecognizedOptions {
+        return UnrecognizedOptions{ .iter = self.options(), .known = known };
+    }
+
+    /// Write the path of the request URI to the given writer. Each
+    /// Uri-Path option is percent-encoded and prefixed with a "/". If
+    /// the request contains no Uri-Path option, "/" is written.
+    pub fn writeURIPath(self: *const Request, writer: anytype) !void {
+        var values = self.getAll(opts.URIPath);
+
+        var empty = true;
+        while (try values.next()) |value| {
+            try writer.writeByte('/');
+            try uri.writeEncoded(writer, value, uri.isPathChar);
+            empty = false;
+        }
+
+        if (empty)
+            try writer.writeByte('/');
+    }
+
+    /// Write the query of the request URI to the given writer. If the
+    /// request contains Uri-Query options, a "?" followed by the
+    /// percent-encoded options separated by "&" is written.
+    pub fn writeURIQuery(self: *const Request, writer: anytype) !void {
+        var values = self.getAll(opts.URIQuery);
+
+        var sep: u8 = '?';
+        while (try values.next()) |value| {
+            try writer.writeByte(sep);
+            try uri.writeEncoded(writer, value, uri.isQueryChar);
+            sep = '&';
+        }
+    }
+
+    /// Formats the message in a human-readable form, see pretty.write.
+    pub fn format(self: Request, comptime fmt: []const u8, fmt_options: std.fmt.FormatOptions, writer: anytype) !void {
+        _ = fmt;
+        _ = fmt_options;
+        try pretty.write(writer, &self);
+    }
+
+    /// Write the relative URI given by the Location-Path and
+    /// Location-Query options of a 2.01 (Created) response to the given
+    /// writer, e.g. "/items/42?rev=1". Nothing is written if the message
+    /// contains neither option.
+    pub fn writeLocation(self: *const Request, writer: anytype) !void {
+        var paths = self.getAll(opts.LocationPath);
+        while (try paths.next()) |value| {
+            try writer.writeByte('/');
+            try uri.writeEncoded(writer, value, uri.isPathChar);
+        }
+
+        var queries = self.getAll(opts.LocationQuery);
+        var sep: u8 = '?';
+        while (try queries.next()) |value| {
+            try writer.writeByte(sep);
+            try uri.writeEncoded(writer, value, uri.isQueryChar);
+            sep = '&';
+        }
+    }
+
+    /// Write the URI of the requested resource to the given writer, as
+    /// described in RFC 7252 Section 6.5. The given host and port of the
+    /// destination endpoint are used if the request contains no Uri-Host
+    /// or Uri-Port option respectively, IPv6 addresses must be given as
+    /// IP literal (i.e. enclosed in brackets).
+    pub fn writeURI(self: *const Request, writer: anytype, scheme: uri.Scheme, host: []const u8, port: u16) !void {
+        try writer.print("{s}://", .{@tagName(scheme)});
+
+        if (try self.getString(opts.URIHost)) |value| {
+            try uri.writeEncoded(writer, value, uri.isHostChar);
+        } else {
+            try writer.writeAll(host);
+        }
+
+        var uri_port = port;
+        if (try self.getUint(opts.URIPort)) |value| {
+            uri_port = std.math.cast(u16, value) catch {
+                return error.InvalidLength;
+            };
+        }
+        if (uri_port != scheme.defaultPort())
+            try writer.print(":{d}", .{uri_port});
+
+        try self.writeURIPath(writer);
+        try self.writeURIQuery(writer);
+    }
+
+    /// Skip all remaining options in the CoAP packet and return a pointer
+    /// to the packet payload (if any). After this function has been
+    /// called it is no longer possible to extract options from the packet.
+    pub fn extractPayload(self: *Request) !(?[]const u8) {
+        while (true) {
+            var opt = self.nextOption() catch |err| {
+                // The absence of the Payload Marker denotes a zero-length payload.
+                if (err == error.EndOfStream)
+                    return error.ZeroLengthPayload;
                 return err;
             };
             if (opt == null)
@@ -506,6 +1420,14 @@ test "test payload parsing" {
     try testing.expect(std.mem.eql(u8, payload.?, "Hello"));
 }
 
+test "test payload parsing with large payload" {
+    const buf = @embedFile("../testvectors/large-payload-16k.bin");
+    var req = try Request.init(buf);
+
+    const payload = try req.extractPayload();
+    try testing.expect(payload.?.len == 16 * 1024);
+}
+
 test "test nextOption without payload" {
     const buf = @embedFile("../testvectors/with-options.bin");
     var req = try Request.init(buf);
@@ -578,3 +1500,1110 @@ test "test findOption without payload" {
     // but return an error since this packet has no payload.
     try testing.expectError(error.ZeroLengthPayload, req.extractPayload());
 }
+
+test "test header parser with unsupported version" {
+    const vectors = [_][]const u8{
+        @embedFile("../testvectors/version-0.bin"),
+        @embedFile("../testvectors/bad-version.bin"),
+        @embedFile("../testvectors/version-3.bin"),
+    };
+
+    for (vectors) |buf| {
+        try testing.expectError(error.UnsupportedVersion, Request.init(buf));
+    }
+}
+
+test "test header parser with truncated header" {
+    const buf = @embedFile("../testvectors/truncated-header.bin");
+    try testing.expectError(error.FormatError, Request.init(buf));
+}
+
+test "test header parser with invalid token length" {
+    const buf = @embedFile("../testvectors/invalid-token-length.bin");
+    try testing.expectError(error.FormatError, Request.init(buf));
+}
+
+test "test header parser with reserved token lengths" {
+    const vectors = [_][]const u8{
+        @embedFile("../testvectors/token-length-9.bin"),
+        @embedFile("../testvectors/token-length-10.bin"),
+        @embedFile("../testvectors/token-length-11.bin"),
+        @embedFile("../testvectors/token-length-12.bin"),
+    };
+
+    for (vectors) |buf| {
+        try testing.expectError(error.FormatError, Request.init(buf));
+    }
+}
+
+test "test option parser with reserved option delta" {
+    const buf = @embedFile("../testvectors/option-delta-15.bin");
+    var req = try Request.init(buf);
+
+    try testing.expectError(error.FormatError, req.nextOption());
+}
+
+test "test payload parser with payload marker but without payload" {
+    const buf = @embedFile("../testvectors/payload-marker-only.bin");
+    var req = try Request.init(buf);
+
+    try testing.expectError(error.InvalidPayload, req.extractPayload());
+}
+
+test "test nextOption with maximum option number" {
+    const buf = @embedFile("../testvectors/option-number-65535.bin");
+    var req = try Request.init(buf);
+
+    const numbers = [_]u32{ 1000, 30000, 65535 };
+    for (numbers) |number| {
+        const opt = (try req.nextOption()).?;
+        try testing.expect(opt.number == number);
+    }
+}
+
+test "test nextOption with option number overflow" {
+    const buf = @embedFile("../testvectors/option-number-overflow.bin");
+    var req = try Request.init(buf);
+
+    const opt = (try req.nextOption()).?;
+    try testing.expect(opt.number == 65000);
+    try testing.expectError(error.FormatError, req.nextOption());
+}
+
+test "test nextOption with option delta overflow" {
+    const buf = @embedFile("../testvectors/option-delta-overflow.bin");
+    var req = try Request.init(buf);
+
+    try testing.expectError(error.FormatError, req.nextOption());
+}
+
+test "test nextOption with repeated options" {
+    const buf = @embedFile("../testvectors/repeated-uri-path-and-query.bin");
+    var req = try Request.init(buf);
+
+    const exp = [_]opts.Option{
+        .{ .number = opts.URIPath, .value = "a" },
+        .{ .number = opts.URIPath, .value = "" },
+        .{ .number = opts.URIPath, .value = "b" },
+        .{ .number = opts.URIPath, .value = "c" },
+        .{ .number = opts.URIPath, .value = "" },
+        .{ .number = opts.URIPath, .value = "d" },
+        .{ .number = opts.URIPath, .value = "e" },
+        .{ .number = opts.URIPath, .value = "f" },
+        .{ .number = opts.URIPath, .value = "g" },
+        .{ .number = opts.URIPath, .value = "h" },
+        .{ .number = opts.URIPath, .value = "i" },
+        .{ .number = opts.URIPath, .value = "" },
+        .{ .number = opts.URIQuery, .value = "x=1" },
+        .{ .number = opts.URIQuery, .value = "y=2" },
+        .{ .number = opts.URIQuery, .value = "x=3" },
+        .{ .number = opts.URIQuery, .value = "" },
+        .{ .number = opts.URIQuery, .value = "flag" },
+        .{ .number = opts.URIQuery, .value = "x=4" },
+    };
+    for (exp) |e| {
+        const opt = (try req.nextOption()).?;
+        try testing.expect(opt.number == e.number);
+        try testing.expect(std.mem.eql(u8, opt.value, e.value));
+    }
+
+    try testing.expectError(error.EndOfStream, req.nextOption());
+}
+
+fn expectOption(buf: []const u8, number: u32, len: usize) !void {
+    var req = try Request.init(buf);
+
+    const opt = (try req.nextOption()).?;
+    try testing.expect(opt.number == number);
+    try testing.expect(opt.value.len == len);
+}
+
+test "test nextOption with extended option delta" {
+    try expectOption(@embedFile("../testvectors/option-delta-12.bin"), 12, 0);
+    try expectOption(@embedFile("../testvectors/option-delta-13.bin"), 13, 0);
+    try expectOption(@embedFile("../testvectors/option-delta-268.bin"), 268, 0);
+    try expectOption(@embedFile("../testvectors/option-delta-269.bin"), 269, 0);
+}
+
+test "test nextOption with extended option length" {
+    try expectOption(@embedFile("../testvectors/option-length-12.bin"), 2, 12);
+    try expectOption(@embedFile("../testvectors/option-length-13.bin"), 2, 13);
+    try expectOption(@embedFile("../testvectors/option-length-268.bin"), 2, 268);
+    try expectOption(@embedFile("../testvectors/option-length-269.bin"), 2, 269);
+}
+
+test "test nextOption with malformed option encoding" {
+    const vectors = [_][]const u8{
+        @embedFile("../testvectors/option-length-15.bin"),
+        @embedFile("../testvectors/truncated-option-delta.bin"),
+        @embedFile("../testvectors/truncated-option-length.bin"),
+        @embedFile("../testvectors/truncated-option-value.bin"),
+    };
+
+    for (vectors) |buf| {
+        var req = try Request.init(buf);
+        try testing.expectError(error.FormatError, req.nextOption());
+    }
+}
+
+test "test option iterator" {
+    const buf = @embedFile("../testvectors/with-options.bin");
+    const req = try Request.init(buf);
+
+    const exp = [_]opts.Option{
+        .{ .number = 2, .value = &[_]u8{0xff} },
+        .{ .number = 23, .value = &[_]u8{ 13, 37 } },
+        .{ .number = 65535, .value = &[_]u8{} },
+    };
+
+    // The iterator does not modify the request, thus options can be
+    // iterated multiple times.
+    var n: usize = 0;
+    while (n < 2) : (n += 1) {
+        var iter = req.options();
+        for (exp) |e| {
+            const opt = (try iter.next()).?;
+            try testing.expect(opt.number == e.number);
+            try testing.expect(std.mem.eql(u8, opt.value, e.value));
+        }
+
+        try testing.expect((try iter.next()) == null);
+        try testing.expect(iter.payload() == null);
+    }
+}
+
+test "test option iterator with payload" {
+    const buf = @embedFile("../testvectors/payload-and-options.bin");
+    var req = try Request.init(buf);
+
+    var iter = req.options();
+    const opt = (try iter.next()).?;
+    try testing.expect(opt.number == 0);
+    try testing.expect(std.mem.eql(u8, opt.value, "test"));
+
+    try testing.expect((try iter.next()) == null);
+    try testing.expect((try iter.next()) == null);
+    try testing.expect(std.mem.eql(u8, iter.payload().?, "foobar"));
+
+    // Consuming options via the request must still be possible.
+    const payload = try req.extractPayload();
+    try testing.expect(std.mem.eql(u8, payload.?, "foobar"));
+}
+
+test "test option iterator with malformed options" {
+    const vectors = [_][]const u8{
+        @embedFile("../testvectors/option-delta-15.bin"),
+        @embedFile("../testvectors/option-delta-overflow.bin"),
+        @embedFile("../testvectors/truncated-option-value.bin"),
+    };
+
+    for (vectors) |buf| {
+        const req = try Request.init(buf);
+        var iter = req.options();
+        try testing.expectError(error.FormatError, iter.next());
+    }
+
+    // Payload marker without payload.
+    const req = try Request.init(@embedFile("../testvectors/payload-marker-only.bin"));
+    var iter = req.options();
+    _ = (try iter.next()).?;
+    try testing.expectError(error.InvalidPayload, iter.next());
+}
+
+test "test typed option accessors" {
+    const buf = @embedFile("../testvectors/uri-host-port.bin");
+    const req = try Request.init(buf);
+
+    try testing.expect(std.mem.eql(u8, (try req.getString(opts.URIHost)).?, "example.org"));
+    try testing.expect((try req.getUint(opts.URIPort)).? == 61616);
+    try testing.expect(std.mem.eql(u8, (try req.getString(opts.URIPath)).?, "res"));
+
+    // Options are not consumed by accessors.
+    try testing.expect((try req.getUint(opts.URIPort)).? == 61616);
+
+    // Absent options.
+    try testing.expect((try req.getUint(opts.MaxAge)) == null);
+    try testing.expect((try req.getString(opts.URIQuery)) == null);
+
+    // Accessor does not match the registered format.
+    try testing.expectError(error.InvalidArgument, req.getUint(opts.URIHost));
+    try testing.expectError(error.InvalidArgument, req.getOpaque(opts.URIPort));
+}
+
+test "test typed option accessors with uint values" {
+    const text = try Request.init(@embedFile("../testvectors/content-format-text-plain.bin"));
+    try testing.expect((try text.getUint(opts.ContentFormat)).? == 0);
+
+    const xml = try Request.init(@embedFile("../testvectors/content-format-senml-xml.bin"));
+    try testing.expect((try xml.getUint(opts.ContentFormat)).? == 310);
+
+    const size = try Request.init(@embedFile("../testvectors/size1-request-4.bin"));
+    try testing.expect((try size.getUint(opts.Size1)).? == 16777216);
+
+    // Content-Format value exceeds the registered maximum length.
+    const long = try Request.init(@embedFile("../testvectors/option-value-too-long.bin"));
+    try testing.expectError(error.InvalidLength, long.getUint(opts.ContentFormat));
+}
+
+test "test typed option accessors with string values" {
+    const buf = @embedFile("../testvectors/uri-path-utf8.bin");
+    const req = try Request.init(buf);
+
+    try testing.expect(std.mem.eql(u8, (try req.getString(opts.URIPath)).?, "äpfel"));
+}
+
+test "test typed option accessors with repeated options" {
+    const etags = try Request.init(@embedFile("../testvectors/etag-get-multiple.bin"));
+    const etag1 = [_]u8{ 0x5c, 0x1d };
+    const etag2 = [_]u8{ 0x33, 0xa6, 0x2b, 0xf0, 0x97, 0x01, 0x42, 0x8e };
+
+    try testing.expect(std.mem.eql(u8, (try etags.getOpaque(opts.ETag)).?, &etag1));
+
+    var values = etags.getAll(opts.ETag);
+    try testing.expect(std.mem.eql(u8, (try values.next()).?, &etag1));
+    try testing.expect(std.mem.eql(u8, (try values.next()).?, &etag2));
+    try testing.expect((try values.next()) == null);
+
+    const queries = try Request.init(@embedFile("../testvectors/repeated-uri-path-and-query.bin"));
+    const exp = [_][]const u8{ "x=1", "y=2", "x=3", "", "flag", "x=4" };
+
+    var query = queries.getAll(opts.URIQuery);
+    for (exp) |e| {
+        try testing.expect(std.mem.eql(u8, (try query.next()).?, e));
+    }
+    try testing.expect((try query.next()) == null);
+}
+
+test "test URI path serialization" {
+    const exp = @embedFile("../testvectors/repeated-uri-path.bin");
+
+    var buf = [_]u8{0} ** exp.len;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{}, 0x0d19);
+    try resp.addURIPath("/a//b/c//d/e/f/g/h/i/");
+
+    try testing.expect(std.mem.eql(u8, resp.marshal(), exp));
+}
+
+test "test URI path and query serialization" {
+    const exp = @embedFile("../testvectors/repeated-uri-path-and-query.bin");
+
+    var buf = [_]u8{0} ** exp.len;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{}, 0x0d19);
+    try resp.addURIPath("/a//b/c//d/e/f/g/h/i/");
+    try resp.addURIQuery("x=1&y=2&x=3&&flag&x=4");
+
+    try testing.expect(std.mem.eql(u8, resp.marshal(), exp));
+}
+
+test "test URI path serialization with percent-encoding" {
+    const exp = @embedFile("../testvectors/uri-path-utf8.bin");
+
+    var buf = [_]u8{0} ** exp.len;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{ 0x0a, 0x34 }, 0x0d34);
+    try resp.addOption(&opts.Option{ .number = opts.URIHost, .value = "example.org" });
+    try resp.addURIPath("/%C3%A4pfel");
+
+    try testing.expect(std.mem.eql(u8, resp.marshal(), exp));
+}
+
+test "test URI path serialization with invalid paths" {
+    var buf = [_]u8{0} ** 512;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{}, 0);
+
+    try testing.expectError(error.InvalidEncoding, resp.addURIPath("/%4"));
+    try testing.expectError(error.InvalidEncoding, resp.addURIPath("/%G0"));
+
+    // Segments are limited to 255 bytes after decoding.
+    try resp.addURIPath("/" ++ "a" ** 255);
+    try testing.expectError(error.SegmentTooLong, resp.addURIPath("/" ++ "a" ** 256));
+}
+
+fn expectURI(buf: []const u8, path: []const u8, query: []const u8) !void {
+    const req = try Request.init(buf);
+
+    var out: [256]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&out);
+    try req.writeURIPath(fbs.writer());
+    try testing.expect(std.mem.eql(u8, fbs.getWritten(), path));
+
+    fbs.reset();
+    try req.writeURIQuery(fbs.writer());
+    try testing.expect(std.mem.eql(u8, fbs.getWritten(), query));
+}
+
+test "test URI path and query parsing" {
+    try expectURI(@embedFile("../testvectors/basic-header.bin"), "/", "");
+    try expectURI(@embedFile("../testvectors/repeated-uri-path.bin"), "/a//b/c//d/e/f/g/h/i/", "");
+    try expectURI(@embedFile("../testvectors/repeated-uri-query.bin"), "/", "?x=1&y=2&x=3&&flag&x=4");
+    try expectURI(@embedFile("../testvectors/uri-path-utf8.bin"), "/%C3%A4pfel", "");
+    try expectURI(@embedFile("../testvectors/uri-path-slash.bin"), "/a%2Fb", "");
+    try expectURI(@embedFile("../testvectors/uri-query-utf8.bin"), "/users", "?name=J%C3%BCrgen");
+}
+
+fn expectContentFormat(exp: []const u8, format: ContentFormat) !void {
+    var buf = [_]u8{0} ** 16;
+    var resp = try Response.init(&buf, Msg.ack, codes.CONTENT, &[_]u8{0xcf}, 0x0d20);
+    try resp.addContentFormat(format);
+    try resp.payloadWriter().writeByte(0x00);
+    try testing.expect(std.mem.eql(u8, resp.marshal(), exp));
+
+    const req = try Request.init(exp);
+    try testing.expect((try req.contentFormat()).? == format);
+    try testing.expect((try req.accept()) == null);
+}
+
+test "test Content-Format serialization" {
+    try expectContentFormat(@embedFile("../testvectors/content-format-text-plain.bin"), .text_plain);
+    try expectContentFormat(@embedFile("../testvectors/content-format-cbor.bin"), .cbor);
+    try expectContentFormat(@embedFile("../testvectors/content-format-senml-cbor.bin"), .senml_cbor);
+    try expectContentFormat(@embedFile("../testvectors/content-format-senml-xml.bin"), .senml_xml);
+}
+
+test "test Accept serialization" {
+    var buf = [_]u8{0} ** 16;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{}, 1);
+    try resp.addAccept(.link_format);
+
+    const req = try Request.init(resp.marshal());
+    try testing.expect((try req.accept()).? == .link_format);
+}
+
+test "test code classification and formatting" {
+    const Vector = struct {
+        buf: []const u8,
+        dotted: []const u8,
+    };
+    const vectors = [_]Vector{
+        .{ .buf = @embedFile("../testvectors/code-0-00.bin"), .dotted = "0.00" },
+        .{ .buf = @embedFile("../testvectors/code-0-01.bin"), .dotted = "0.01" },
+        .{ .buf = @embedFile("../testvectors/code-2-05.bin"), .dotted = "2.05" },
+        .{ .buf = @embedFile("../testvectors/code-2-31.bin"), .dotted = "2.31" },
+        .{ .buf = @embedFile("../testvectors/code-4-04.bin"), .dotted = "4.04" },
+        .{ .buf = @embedFile("../testvectors/code-4-29.bin"), .dotted = "4.29" },
+        .{ .buf = @embedFile("../testvectors/code-5-03.bin"), .dotted = "5.03" },
+    };
+
+    for (vectors) |v| {
+        const req = try Request.init(v.buf);
+        const code = req.header.code;
+
+        var out: [4]u8 = undefined;
+        const str = try std.fmt.bufPrint(&out, "{}", .{code});
+        try testing.expect(std.mem.eql(u8, str, v.dotted));
+        try testing.expect(code.equal(try codes.Code.fromDotted(v.dotted)));
+
+        try testing.expect(code.isEmpty() == (v.dotted[0] == '0' and code.detail == 0));
+        try testing.expect(code.isRequest() == (v.dotted[0] == '0' and code.detail != 0));
+        try testing.expect(code.isSuccess() == (v.dotted[0] == '2'));
+        try testing.expect(code.isClientError() == (v.dotted[0] == '4'));
+        try testing.expect(code.isServerError() == (v.dotted[0] == '5'));
+    }
+
+    try testing.expectError(error.InvalidCode, codes.Code.fromDotted("4.4"));
+    try testing.expectError(error.InvalidCode, codes.Code.fromDotted("4-04"));
+    try testing.expectError(error.InvalidCode, codes.Code.fromDotted("8.00"));
+    try testing.expectError(error.InvalidCode, codes.Code.fromDotted("2.32"));
+}
+
+test "test empty message parsing" {
+    const req = try Request.init(@embedFile("../testvectors/empty-ack.bin"));
+    try testing.expect(req.header.code.isEmpty());
+    try testing.expect(req.token.len == 0);
+
+    const vectors = [_][]const u8{
+        @embedFile("../testvectors/empty-with-token.bin"),
+        @embedFile("../testvectors/empty-with-payload.bin"),
+    };
+
+    for (vectors) |buf| {
+        try testing.expectError(error.FormatError, Request.initWithMode(buf, Mode.strict));
+
+        // Trailing bytes are ignored in lenient mode.
+        var lenient = try Request.initWithMode(buf, Mode.lenient);
+        try testing.expect(lenient.token.len == 0);
+        try testing.expectError(error.ZeroLengthPayload, lenient.extractPayload());
+    }
+}
+
+test "test lenient option parsing with unknown elective options" {
+    const buf = @embedFile("../testvectors/with-options.bin");
+    const req = try Request.initWithMode(buf, Mode.lenient);
+
+    // Option 2 is elective and unknown, option 65535 is critical and
+    // thus retained even though it is unknown.
+    var iter = req.options();
+    try testing.expect((try iter.next()).?.number == 23);
+    try testing.expect((try iter.next()).?.number == 65535);
+    try testing.expect((try iter.next()) == null);
+}
+
+test "test lenient option parsing with malformed options" {
+    const vectors = [_][]const u8{
+        @embedFile("../testvectors/option-delta-15.bin"),
+        @embedFile("../testvectors/option-delta-overflow.bin"),
+        @embedFile("../testvectors/truncated-option-value.bin"),
+        @embedFile("../testvectors/payload-marker-only.bin"),
+    };
+
+    for (vectors) |buf| {
+        var req = try Request.initWithMode(buf, Mode.lenient);
+        try testing.expect((try req.extractPayload()) == null);
+    }
+
+    // Well-formed options preceding the malformed data are retained.
+    const marker_only = @embedFile("../testvectors/payload-marker-only.bin");
+    const req = try Request.initWithMode(marker_only, Mode.lenient);
+    try testing.expect(std.mem.eql(u8, (try req.getString(opts.URIPath)).?, "test"));
+}
+
+test "test header parser with extended token length" {
+    const Vector = struct {
+        buf: []const u8,
+        len: usize,
+    };
+    const vectors = [_]Vector{
+        .{ .buf = @embedFile("../testvectors/extended-token-13.bin"), .len = 13 },
+        .{ .buf = @embedFile("../testvectors/extended-token-268.bin"), .len = 268 },
+        .{ .buf = @embedFile("../testvectors/extended-token-269.bin"), .len = 269 },
+        .{ .buf = @embedFile("../testvectors/extended-token-1024.bin"), .len = 1024 },
+    };
+
+    for (vectors) |v| {
+        const req = try Request.init(v.buf);
+        try testing.expect(req.token.len == v.len);
+        for (req.token) |b, i| {
+            try testing.expect(b == @truncate(u8, i));
+        }
+
+        // Serializing the token again must yield the same message.
+        var buf: [1100]u8 = undefined;
+        var resp = try Response.initExtended(buf[0..v.buf.len], Msg.con, codes.GET, req.token, 42);
+        try testing.expect(std.mem.eql(u8, resp.marshal(), v.buf));
+
+        // Extended tokens are only sent if explicitly requested.
+        try testing.expectError(error.InvalidTokenLength, Response.init(&buf, Msg.con, codes.GET, req.token, 42));
+    }
+}
+
+test "test header parser with truncated extended token length" {
+    const buf = @embedFile("../testvectors/truncated-extended-token.bin");
+    try testing.expectError(error.FormatError, Request.init(buf));
+}
+
+test "test option classification" {
+    try testing.expect(opts.isCritical(opts.URIHost) and opts.isUnsafe(opts.URIHost));
+    try testing.expect(!opts.isCritical(opts.ETag) and !opts.isUnsafe(opts.ETag));
+    try testing.expect(!opts.isCritical(opts.MaxAge) and opts.isUnsafe(opts.MaxAge));
+    try testing.expect(opts.isCritical(opts.ProxyURI) and opts.isUnsafe(opts.ProxyURI));
+    try testing.expect(opts.isNoCacheKey(opts.Size1));
+    try testing.expect(!opts.isNoCacheKey(opts.ContentFormat));
+}
+
+test "test unrecognized critical options" {
+    const bad = try Request.init(@embedFile("../testvectors/exchange-bad-option-request.bin"));
+    var bad_iter = bad.unrecognizedOptions(&[_]u32{});
+    try testing.expect((try bad_iter.next()).?.number == 65001);
+    try testing.expect((try bad_iter.next()) == null);
+
+    // Option 2 is elective, option 23 (Block2) is recognized.
+    const req = try Request.init(@embedFile("../testvectors/with-options.bin"));
+    var iter = req.unrecognizedOptions(&[_]u32{});
+    try testing.expect((try iter.next()).?.number == 65535);
+    try testing.expect((try iter.next()) == null);
+
+    // Options known to the caller are recognized.
+    var known = req.unrecognizedOptions(&[_]u32{65535});
+    try testing.expect((try known.next()) == null);
+
+    const get = try Request.init(@embedFile("../testvectors/exchange-get-request.bin"));
+    var none = get.unrecognizedOptions(&[_]u32{});
+    try testing.expect((try none.next()) == null);
+}
+
+test "test block option parsing" {
+    // Block2 transfer of a 40 byte body using 16 byte blocks.
+    const vectors = [_][]const u8{
+        @embedFile("../testvectors/block2-szx0-0.bin"),
+        @embedFile("../testvectors/block2-szx0-1.bin"),
+        @embedFile("../testvectors/block2-szx0-2.bin"),
+        @embedFile("../testvectors/block2-szx0-3.bin"),
+        @embedFile("../testvectors/block2-szx0-4.bin"),
+        @embedFile("../testvectors/block2-szx0-5.bin"),
+    };
+
+    var i: usize = 0;
+    while (i < vectors.len) : (i += 2) {
+        var req = try Request.init(vectors[i]);
+        var resp = try Request.init(vectors[i + 1]);
+
+        const req_block = (try req.getBlock(opts.Block2)).?;
+        const resp_block = (try resp.getBlock(opts.Block2)).?;
+        try testing.expect(req_block.num == i / 2 and !req_block.more);
+        try testing.expect(resp_block.num == req_block.num);
+        try testing.expect(resp_block.size() == 16);
+        try testing.expect(resp_block.offset() == i / 2 * 16);
+
+        const payload = (try resp.extractPayload()).?;
+        try testing.expect(resp_block.more == (payload.len == resp_block.size()));
+
+        // The following request asks for the next block.
+        if (resp_block.more) {
+            var next_req = try Request.init(vectors[i + 2]);
+            const next_block = (try next_req.getBlock(opts.Block2)).?;
+            try testing.expect(std.meta.eql(next_block, try resp_block.next()));
+        }
+    }
+}
+
+test "test block option serialization" {
+    const exp = @embedFile("../testvectors/block2-szx0-3.bin");
+    var req = try Request.init(exp);
+    const block = (try req.getBlock(opts.Block2)).?;
+
+    var buf: [3]u8 = undefined;
+    var values = req.getAll(opts.Block2);
+    try testing.expect(std.mem.eql(u8, block.encode(&buf), (try values.next()).?));
+
+    // Empty, 1-byte, and 3-byte values.
+    try testing.expect(BlockValue.encode(.{ .num = 0, .more = false, .szx = 0 }, &buf).len == 0);
+    const max = BlockValue{ .num = 0xfffff, .more = true, .szx = 6 };
+    try testing.expect(std.mem.eql(u8, max.encode(&buf), &[_]u8{ 0xff, 0xff, 0xfe }));
+    try testing.expect(std.meta.eql(try BlockValue.decode(max.encode(&buf)), max));
+    try testing.expectError(error.Overflow, max.next());
+
+    // Late block size negotiation.
+    const late = try (BlockValue{ .num = 1, .more = true, .szx = 3 }).resize(2);
+    try testing.expect(late.num == 2 and late.offset() == 128);
+
+    try testing.expectError(error.ReservedSize, BlockValue.decode(&[_]u8{0x07}));
+    try testing.expectError(error.InvalidLength, BlockValue.decode(&[_]u8{ 0, 0, 0, 0 }));
+}
+
+test "test message validation" {
+    const valid = [_][]const u8{
+        @embedFile("../testvectors/matrix-con-request.bin"),
+        @embedFile("../testvectors/matrix-con-response.bin"),
+        @embedFile("../testvectors/matrix-con-empty.bin"),
+        @embedFile("../testvectors/matrix-non-request.bin"),
+        @embedFile("../testvectors/matrix-non-response.bin"),
+        @embedFile("../testvectors/matrix-ack-response.bin"),
+        @embedFile("../testvectors/matrix-ack-empty.bin"),
+        @embedFile("../testvectors/matrix-rst-empty.bin"),
+        @embedFile("../testvectors/repeated-uri-path-and-query.bin"),
+    };
+    for (valid) |buf| {
+        const req = try Request.init(buf);
+        try testing.expect(req.validate().len == 0);
+    }
+
+    const invalid = [_][]const u8{
+        @embedFile("../testvectors/matrix-non-empty.bin"),
+        @embedFile("../testvectors/matrix-ack-request.bin"),
+        @embedFile("../testvectors/matrix-rst-response.bin"),
+        @embedFile("../testvectors/option-value-too-long.bin"),
+        @embedFile("../testvectors/payload-marker-only.bin"),
+        @embedFile("../testvectors/extended-token-13.bin"),
+    };
+    const expected = [_]validation.Violation{
+        .empty_non,
+        .request_ack,
+        .reset_not_empty,
+        .invalid_length,
+        .empty_payload,
+        .extended_token,
+    };
+    for (invalid) |buf, i| {
+        const req = try Request.init(buf);
+        const violations = req.validate();
+        try testing.expectEqualSlices(validation.Violation, expected[i .. i + 1], violations.slice());
+    }
+}
+
+test "test validation of constructed messages" {
+    var buf: [32]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.con, codes.CONTENT, &[_]u8{}, 1);
+    try resp.addContentFormat(ContentFormat.json);
+    try resp.addContentFormat(ContentFormat.cbor);
+
+    const violations = try resp.validate();
+    try testing.expectEqualSlices(validation.Violation, &[_]validation.Violation{.repeated_option}, violations.slice());
+}
+
+test "test option serialization out of order" {
+    const exp = @embedFile("../testvectors/repeated-uri-path-and-query.bin");
+
+    var buf = [_]u8{0} ** exp.len;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{}, 0x0d19);
+    try resp.addURIQuery("x=1&y=2&x=3&&flag&x=4");
+    try resp.addURIPath("/a//b/c//d/e/f/g/h/i/");
+    try testing.expect(std.mem.eql(u8, resp.marshal(), exp));
+
+    // Insertion changing the size of extended option deltas.
+    var value: [300]u8 = undefined;
+    std.mem.set(u8, &value, 'x');
+
+    var ordered_buf: [1024]u8 = undefined;
+    var ordered = try Response.init(&ordered_buf, Msg.con, codes.GET, &[_]u8{}, 1);
+    try ordered.addOption(&opts.Option{ .number = 1, .value = "" });
+    try ordered.addOption(&opts.Option{ .number = 20, .value = &value });
+    try ordered.addOption(&opts.Option{ .number = 400, .value = &value });
+    try ordered.addOption(&opts.Option{ .number = 400, .value = "a" });
+    try ordered.addOption(&opts.Option{ .number = 2000, .value = "" });
+
+    var shuffled_buf: [1024]u8 = undefined;
+    var shuffled = try Response.init(&shuffled_buf, Msg.con, codes.GET, &[_]u8{}, 1);
+    try shuffled.addOption(&opts.Option{ .number = 2000, .value = "" });
+    try shuffled.addOption(&opts.Option{ .number = 400, .value = &value });
+    try shuffled.addOption(&opts.Option{ .number = 20, .value = &value });
+    try shuffled.addOption(&opts.Option{ .number = 400, .value = "a" });
+    try shuffled.addOption(&opts.Option{ .number = 1, .value = "" });
+    try testing.expectEqualSlices(u8, ordered.marshal(), shuffled.marshal());
+
+    var small_buf: [8]u8 = undefined;
+    var small = try Response.init(&small_buf, Msg.con, codes.GET, &[_]u8{}, 1);
+    try small.addOption(&opts.Option{ .number = 20, .value = "ab" });
+    try testing.expectError(error.BufTooSmall, small.addOption(&opts.Option{ .number = 1, .value = "c" }));
+
+    // Options violating their definition (a repeated Content-Format and
+    // an oversized Size1) do not prevent insertion.
+    var copied_buf: [32]u8 = undefined;
+    var copied = try Response.init(&copied_buf, Msg.con, codes.CONTENT, &[_]u8{}, 1);
+    try copied.addOption(&opts.Option{ .number = opts.ContentFormat, .value = "" });
+    try copied.addOption(&opts.Option{ .number = opts.ContentFormat, .value = &[_]u8{50} });
+    try copied.addOption(&opts.Option{ .number = opts.Size1, .value = "abcde" });
+    try copied.addUint(opts.MaxAge, 30);
+
+    const msg = try Request.init(copied.marshal());
+    var iter = msg.options();
+    iter.enforce_rules = false;
+    for ([_]u32{ opts.ContentFormat, opts.ContentFormat, opts.MaxAge, opts.Size1 }) |number|
+        try testing.expect((try iter.next()).?.number == number);
+    try testing.expect((try iter.next()) == null);
+}
+
+// Compare the given messages field by field, including all options not
+// consumed yet and the payload.
+fn expectEqualMessages(a: *const Request, b: *const Request) !void {
+    try testing.expect(a.header.type == b.header.type);
+    try testing.expect(a.header.code.equal(b.header.code));
+    try testing.expect(a.header.message_id == b.header.message_id);
+    try testing.expectEqualSlices(u8, a.token, b.token);
+
+    var iter_a = a.options();
+    iter_a.enforce_rules = false;
+    var iter_b = b.options();
+    iter_b.enforce_rules = false;
+    while (try iter_a.next()) |opt| {
+        const other = (try iter_b.next()) orelse return error.TestUnexpectedResult;
+        try testing.expect(opt.number == other.number);
+        try testing.expectEqualSlices(u8, opt.value, other.value);
+    }
+    try testing.expect((try iter_b.next()) == null);
+    try testing.expectEqualSlices(u8, iter_a.payload() orelse &[_]u8{}, iter_b.payload() orelse &[_]u8{});
+}
+
+test "test message cloning" {
+    var recv = [_]u8{0} ** 64;
+    const exp = @embedFile("../testvectors/payload-and-options.bin");
+    std.mem.copy(u8, &recv, exp);
+
+    var req = try Request.init(recv[0..exp.len]);
+    const first = (try req.nextOption()).?;
+
+    var owned: [64]u8 = undefined;
+    var copy = try req.clone(&owned);
+
+    // Reuse the receive buffer for the next message.
+    std.mem.set(u8, &recv, 0);
+
+    try testing.expect(std.mem.eql(u8, copy.data, exp));
+    try testing.expect(copy.header.message_id == req.header.message_id);
+    try testing.expect(copy.last_option.?.number == first.number);
+
+    var orig = try Request.init(exp);
+    _ = try orig.nextOption();
+    try expectEqualMessages(&orig, &copy);
+    try testing.expectEqualSlices(u8, (try orig.extractPayload()).?, (try copy.extractPayload()).?);
+
+    var small: [4]u8 = undefined;
+    try testing.expectError(error.BufTooSmall, copy.clone(&small));
+}
+
+test "test decode limits" {
+    const buf = @embedFile("../testvectors/repeated-uri-path-and-query.bin");
+
+    try testing.expectError(error.LimitExceeded, Request.initWithLimits(buf, Mode.strict, .{ .max_message_size = buf.len - 1 }));
+    _ = try Request.initWithLimits(buf, Mode.strict, .{ .max_message_size = buf.len });
+
+    const token = @embedFile("../testvectors/with-token.bin");
+    try testing.expectError(error.LimitExceeded, Request.initWithLimits(token, Mode.strict, .{ .max_token_len = 0 }));
+
+    // The message contains 12 Uri-Path and 6 Uri-Query options.
+    var req = try Request.initWithLimits(buf, Mode.lenient, .{ .max_options = 17 });
+    var values = req.getAll(opts.URIQuery);
+    var i: usize = 0;
+    while (i < 5) : (i += 1) {
+        _ = (try values.next()).?;
+    }
+    try testing.expectError(error.LimitExceeded, values.next());
+
+    // Options consumed using nextOption count towards the limit.
+    req = try Request.initWithLimits(buf, Mode.strict, .{ .max_options = 17 });
+    i = 0;
+    while (i < 17) : (i += 1) {
+        _ = try req.nextOption();
+    }
+    try testing.expectError(error.LimitExceeded, req.nextOption());
+
+    req = try Request.initWithLimits(buf, Mode.strict, .{ .max_option_len = 0 });
+    try testing.expectError(error.LimitExceeded, req.nextOption());
+}
+
+test "test marshal into caller-provided buffer" {
+    const exp = @embedFile("../testvectors/payload-and-options.bin");
+
+    var buf: [32]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.non, codes.DELETE, &[_]u8{}, 255);
+    try resp.addOption(&opts.Option{ .number = 0, .value = "test" });
+    try resp.payloadWriter().writeAll("foobar");
+    try testing.expect(resp.size() == exp.len);
+
+    var tx: [exp.len]u8 = undefined;
+    const n = try resp.marshalTo(&tx);
+    try testing.expectEqualSlices(u8, exp, tx[0..n]);
+
+    var small: [exp.len - 1]u8 = undefined;
+    try testing.expectError(error.BufTooSmall, resp.marshalTo(&small));
+}
+
+test "test response reset" {
+    const exp = @embedFile("../testvectors/payload-and-options.bin");
+
+    var buf: [32]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.non, codes.DELETE, &[_]u8{}, 255);
+    try resp.addOption(&opts.Option{ .number = 42, .value = "discarded" });
+    try resp.payloadWriter().writeAll("discarded");
+
+    resp.reset();
+    try resp.addOption(&opts.Option{ .number = 0, .value = "test" });
+    try resp.payloadWriter().writeAll("foobar");
+    try testing.expectEqualSlices(u8, exp, resp.marshal());
+}
+
+test "test URI decomposition" {
+    const exp = @embedFile("../testvectors/uri-host-port.bin");
+
+    var buf: [exp.len]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{ 0x0a, 0x34 }, 0x0d34);
+    try resp.addURI("coap://Example.ORG:61616/res");
+    try testing.expectEqualSlices(u8, exp, resp.marshal());
+
+    // Default ports and IP addresses do not result in options.
+    const empty = @embedFile("../testvectors/basic-header.bin");
+
+    var empty_buf: [empty.len]u8 = undefined;
+    resp = try Response.init(&empty_buf, Msg.con, codes.GET, &[_]u8{}, 0x0926);
+    try resp.addURI("coaps://192.0.2.1:5684");
+    try resp.addURI("coap://[2001:db8::1]/");
+    try testing.expectEqualSlices(u8, empty, resp.marshal());
+
+    try testing.expectError(error.UnsupportedScheme, resp.addURI("http://example.org/"));
+    try testing.expectError(error.InvalidURI, resp.addURI("/res"));
+    try testing.expectError(error.InvalidURI, resp.addURI("coap://example.org/res#frag"));
+    try testing.expectError(error.InvalidURI, resp.addURI("coap://:5683/"));
+    try testing.expectError(error.InvalidURI, resp.addURI("coap://example.org:http/"));
+}
+
+test "test URI composition" {
+    var out: [64]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&out);
+
+    const req = try Request.init(@embedFile("../testvectors/uri-host-port.bin"));
+    try req.writeURI(fbs.writer(), uri.Scheme.coap, "192.0.2.1", uri.DEFAULT_PORT);
+    try testing.expectEqualStrings("coap://example.org:61616/res", fbs.getWritten());
+
+    fbs.reset();
+    const empty = try Request.init(@embedFile("../testvectors/basic-header.bin"));
+    try empty.writeURI(fbs.writer(), uri.Scheme.coaps, "[2001:db8::1]", uri.DEFAULT_SECURE_PORT);
+    try testing.expectEqualStrings("coaps://[2001:db8::1]/", fbs.getWritten());
+
+    fbs.reset();
+    try empty.writeURI(fbs.writer(), uri.Scheme.coap, "192.0.2.1", 61616);
+    try testing.expectEqualStrings("coap://192.0.2.1:61616/", fbs.getWritten());
+}
+
+test "test response code errors" {
+    const Vector = struct {
+        buf: []const u8,
+        err: codes.ResponseError,
+    };
+    const vectors = [_]Vector{
+        .{ .buf = @embedFile("../testvectors/code-4-00.bin"), .err = error.BadRequest },
+        .{ .buf = @embedFile("../testvectors/code-4-04.bin"), .err = error.NotFound },
+        .{ .buf = @embedFile("../testvectors/code-4-08.bin"), .err = error.RequestEntityIncomplete },
+        .{ .buf = @embedFile("../testvectors/code-4-09.bin"), .err = error.Conflict },
+        .{ .buf = @embedFile("../testvectors/code-4-15.bin"), .err = error.UnsupportedContentFormat },
+        .{ .buf = @embedFile("../testvectors/code-4-22.bin"), .err = error.UnprocessableEntity },
+        .{ .buf = @embedFile("../testvectors/code-4-29.bin"), .err = error.TooManyRequests },
+        .{ .buf = @embedFile("../testvectors/code-5-03.bin"), .err = error.ServiceUnavailable },
+        .{ .buf = @embedFile("../testvectors/code-5-08.bin"), .err = error.InternalServerError },
+    };
+
+    var diagnostic: []const u8 = undefined;
+    for (vectors) |v| {
+        const req = try Request.init(v.buf);
+        try testing.expectError(v.err, req.checkResponse(&diagnostic));
+        try testing.expect(diagnostic.len == 0);
+    }
+
+    var buf: [32]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.ack, codes.NOT_FOUND, &[_]u8{}, 1);
+    try resp.payloadWriter().writeAll("no such sensor");
+    var req = try Request.init(resp.marshal());
+    try testing.expectError(error.NotFound, req.checkResponse(&diagnostic));
+    try testing.expectEqualStrings("no such sensor", diagnostic);
+
+    resp = try Response.init(&buf, Msg.ack, codes.CONTENT, &[_]u8{}, 1);
+    try resp.payloadWriter().writeAll("22.5");
+    req = try Request.init(resp.marshal());
+    try req.checkResponse(&diagnostic);
+}
+
+fn expectLocation(exp: []const u8, ref: []const u8) !void {
+    var buf: [64]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.ack, codes.CREATED, &[_]u8{ 0x0a, 0x34 }, 0x0d34);
+    try resp.addLocation(ref);
+    try testing.expectEqualSlices(u8, exp, resp.marshal());
+
+    var out: [64]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&out);
+    const req = try Request.init(exp);
+    try req.writeLocation(fbs.writer());
+    try testing.expectEqualStrings(ref, fbs.getWritten());
+}
+
+test "test Location-Path and Location-Query serialization" {
+    try expectLocation(@embedFile("../testvectors/location-path.bin"), "/items/42");
+    try expectLocation(@embedFile("../testvectors/location-path-query.bin"), "/items/42?rev=1&a%26b");
+    try expectLocation(@embedFile("../testvectors/location-path-utf8.bin"), "/prices/%E2%82%AC");
+
+    var buf: [64]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.ack, codes.CREATED, &[_]u8{}, 1);
+    try testing.expectError(error.InvalidURI, resp.addLocation("items/42"));
+    try testing.expectError(error.InvalidURI, resp.addLocation("/items/../42"));
+    try testing.expectError(error.InvalidURI, resp.addLocation("/items/%2E"));
+    try testing.expectError(error.InvalidURI, resp.addLocation("/items#42"));
+}
+
+// Parse the given message and serialize it again using Response.
+fn remarshal(buf: []u8, data: []const u8) ![]u8 {
+    const req = try Request.init(data);
+    var resp = try Response.initExtended(buf, req.header.type, req.header.code, req.token, req.header.message_id);
+
+    var iter = req.options();
+    while (try iter.next()) |opt|
+        try resp.addOption(&opt);
+    if (iter.payload()) |payload|
+        try resp.payloadWriter().writeAll(payload);
+
+    return resp.marshal();
+}
+
+// Whether the given message, including all options, can be parsed.
+fn parses(data: []const u8) bool {
+    const req = Request.init(data) catch return false;
+    var iter = req.options();
+    while (iter.next() catch return false) |_| {}
+    return true;
+}
+
+test "test round-trip of mutated messages" {
+    const seeds = [_][]const u8{
+        @embedFile("../testvectors/basic-header.bin"),
+        @embedFile("../testvectors/with-token.bin"),
+        @embedFile("../testvectors/payload-and-options.bin"),
+        @embedFile("../testvectors/uri-host-port.bin"),
+        @embedFile("../testvectors/repeated-uri-path-and-query.bin"),
+        @embedFile("../testvectors/location-path-query.bin"),
+        @embedFile("../testvectors/content-format-cbor.bin"),
+        @embedFile("../testvectors/block2-szx0-0.bin"),
+        @embedFile("../testvectors/multicast-discovery-1.bin"),
+    };
+
+    var prng = std.rand.DefaultPrng.init(0);
+    const random = prng.random();
+
+    var buf: [512]u8 = undefined;
+    var out: [512]u8 = undefined;
+    for (seeds) |seed| {
+        try testing.expectEqualSlices(u8, seed, try remarshal(&out, seed));
+
+        var i: usize = 0;
+        while (i < 256) : (i += 1) {
+            std.mem.copy(u8, &buf, seed);
+            buf[random.uintLessThan(usize, seed.len)] ^= random.int(u8);
+            const mutated = buf[0..random.intRangeAtMost(usize, 0, seed.len)];
+
+            // Mutated messages that are rejected by the parser are fine,
+            // all others must be serialized to an equivalent message.
+            if (!parses(mutated))
+                continue;
+
+            const orig = try Request.init(mutated);
+            const copy = try Request.init(try remarshal(&out, mutated));
+            try expectEqualMessages(&orig, &copy);
+        }
+    }
+}
+
+fn expectTCPResponse(exp: []const u8, payload_len: usize) !void {
+    var buf: [512]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.con, codes.CONTENT, &[_]u8{ 0x7c, 0xb0 }, 0);
+    try resp.addOption(&opts.Option{ .number = opts.ContentFormat, .value = "" });
+    var i: usize = 0;
+    while (i < payload_len) : (i += 1)
+        try resp.payloadWriter().writeByte('x');
+
+    var out: [512]u8 = undefined;
+    const n = try resp.marshalStream(&out);
+    try testing.expectEqualSlices(u8, exp, out[0..n]);
+
+    const msg = try stream.StreamMessage.init(exp);
+    try testing.expect(msg.code.equal(codes.CONTENT));
+    try testing.expectEqualSlices(u8, resp.token, msg.token);
+
+    var iter = msg.options();
+    try testing.expect((try iter.next()).?.number == opts.ContentFormat);
+    try testing.expect((try iter.next()) == null);
+    try testing.expect(iter.payload().?.len == payload_len);
+}
+
+test "test TCP message serialization" {
+    try expectTCPResponse(@embedFile("../testvectors/tcp-ext-length-1.bin"), 11);
+    try expectTCPResponse(@embedFile("../testvectors/tcp-ext-length-2.bin"), 267);
+
+    const ping = @embedFile("../testvectors/tcp-ping.bin");
+    var buf: [16]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.con, .{ .class = 7, .detail = 2 }, &[_]u8{0x91}, 0);
+    try resp.addOption(&opts.Option{ .number = 2, .value = "" });
+
+    var out: [16]u8 = undefined;
+    try testing.expectEqualSlices(u8, ping, out[0..try resp.marshalStream(&out)]);
+    try testing.expectError(error.BufTooSmall, resp.marshalStream(out[0 .. ping.len - 1]));
+
+    try testing.expectError(error.FormatError, stream.StreamMessage.init(ping[0 .. ping.len - 1]));
+    try testing.expectError(error.FormatError, stream.StreamMessage.init(&[_]u8{}));
+
+    const long = ping ++ "\x00";
+    try testing.expectError(error.FormatError, stream.StreamMessage.init(long));
+}
+
+test "test WebSocket message serialization" {
+    const token = [_]u8{ 0x3b, 0x50, 0xc4 };
+    var buf: [64]u8 = undefined;
+    var out: [64]u8 = undefined;
+
+    const request = @embedFile("../testvectors/ws-request.bin");
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &token, 0);
+    try resp.addURIPath("/ws");
+    try testing.expectEqualSlices(u8, request, out[0..try resp.marshalWebSocket(&out)]);
+
+    const response = @embedFile("../testvectors/ws-response.bin");
+    resp = try Response.init(&buf, Msg.con, codes.CONTENT, &token, 0);
+    try resp.addContentFormat(ContentFormat.text_plain);
+    try resp.payloadWriter().writeAll("Hello, WebSocket!");
+    try testing.expectEqualSlices(u8, response, out[0..try resp.marshalWebSocket(&out)]);
+
+    const msg = try stream.StreamMessage.initWebSocket(response);
+    try testing.expect(msg.code.equal(codes.CONTENT));
+    try testing.expectEqualSlices(u8, &token, msg.token);
+
+    var iter = msg.options();
+    try testing.expect((try iter.next()).?.number == opts.ContentFormat);
+    try testing.expect((try iter.next()) == null);
+    try testing.expectEqualStrings("Hello, WebSocket!", iter.payload().?);
+
+    const empty = try stream.StreamMessage.initWebSocket(@embedFile("../testvectors/ws-no-token.bin"));
+    try testing.expect(empty.code.equal(codes.DELETE));
+    try testing.expect(empty.token.len == 0 and empty.body.len == 0);
+
+    // Messages in the TCP format have a non-zero length.
+    try testing.expectError(error.FormatError, stream.StreamMessage.initWebSocket(@embedFile("../testvectors/tcp-ping.bin")));
+    try testing.expectError(error.FormatError, stream.StreamMessage.initWebSocket(request[0..3]));
+}
+
+test "test round-trip of unrecognized options" {
+    const exp = @embedFile("../testvectors/with-options.bin");
+
+    var buf: [64]u8 = undefined;
+    const lenient = try Request.initWithMode(exp, Mode.lenient);
+    var resp = try Response.initFrom(&buf, &lenient);
+    try testing.expectEqualSlices(u8, exp, resp.marshal());
+
+    // Modified copies retain the order of repeated options.
+    resp = try Response.initFrom(&buf, &lenient);
+    try resp.addOption(&opts.Option{ .number = opts.URIPath, .value = "x" });
+    try resp.addOption(&opts.Option{ .number = 2, .value = "ab" });
+
+    var exp_buf: [64]u8 = undefined;
+    var modified = try Response.init(&exp_buf, Msg.con, codes.GET, &[_]u8{}, 0x0926);
+    try modified.addOption(&opts.Option{ .number = 2, .value = &[_]u8{0xff} });
+    try modified.addOption(&opts.Option{ .number = 2, .value = "ab" });
+    try modified.addOption(&opts.Option{ .number = opts.URIPath, .value = "x" });
+    try modified.addOption(&opts.Option{ .number = opts.Block2, .value = &[_]u8{ 0x0d, 0x25 } });
+    try modified.addOption(&opts.Option{ .number = 65535, .value = "" });
+    try testing.expectEqualSlices(u8, modified.marshal(), resp.marshal());
+
+    const payload = @embedFile("../testvectors/payload-and-options.bin");
+    var req = try Request.init(payload);
+    resp = try Response.initFrom(&buf, &req);
+    try resp.payloadWriter().writeAll((try req.extractPayload()).?);
+    try testing.expectEqualSlices(u8, payload, resp.marshal());
+}
+
+// Iterate over all remaining options of the given message.
+fn skipOptions(req: *const Request) !void {
+    var iter = req.options();
+    while (try iter.next()) |_| {}
+}
+
+fn expectRuleViolation(exp: anyerror, violation: validation.Violation, list: []const opts.Option) !void {
+    var buf: [64]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{}, 1);
+    for (list) |opt|
+        try resp.addOption(&opt);
+
+    const req = try Request.init(resp.marshal());
+    try testing.expectError(exp, skipOptions(&req));
+    try testing.expectEqualSlices(validation.Violation, &[_]validation.Violation{violation}, req.validate().slice());
+
+    const lenient = try Request.initWithMode(resp.marshal(), Mode.lenient);
+    try skipOptions(&lenient);
+}
+
+test "test option repetition and length rules" {
+    try expectRuleViolation(error.RepeatedOption, .repeated_option, &[_]opts.Option{
+        .{ .number = opts.ContentFormat, .value = "" },
+        .{ .number = opts.ContentFormat, .value = &[_]u8{60} },
+    });
+    try expectRuleViolation(error.InvalidLength, .invalid_length, &[_]opts.Option{
+        .{ .number = opts.URIPort, .value = "0123456789" },
+    });
+    try expectRuleViolation(error.InvalidLength, .invalid_length, &[_]opts.Option{
+        .{ .number = opts.HopLimit, .value = &[_]u8{ 0, 16 } },
+    });
+    try expectRuleViolation(error.RepeatedOption, .repeated_option, &[_]opts.Option{
+        .{ .number = opts.Echo, .value = "a" },
+        .{ .number = opts.Echo, .value = "b" },
+    });
+
+    // Repeatable and unregistered options may occur more than once.
+    var buf: [64]u8 = undefined;
+    var resp = try Response.init(&buf, Msg.con, codes.GET, &[_]u8{}, 1);
+    try resp.addOption(&opts.Option{ .number = opts.RequestTag, .value = "a" });
+    try resp.addOption(&opts.Option{ .number = opts.RequestTag, .value = "b" });
+    try resp.addOption(&opts.Option{ .number = 65000, .value = "" });
+    try resp.addOption(&opts.Option{ .number = 65000, .value = "" });
+    const req = try Request.init(resp.marshal());
+    try skipOptions(&req);
+}
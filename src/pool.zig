@@ -0,0 +1,67 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+
+/// Statically allocated pool of fixed-size buffers for encoding and
+/// decoding messages, e.g. to keep buffers of messages awaiting an
+/// acknowledgement without dedicating a buffer to each exchange. The
+/// pool is not thread-safe, concurrent access must be synchronized by
+/// the caller (e.g. by disabling interrupts).
+pub fn BufferPool(comptime count: usize, comptime size: usize) type {
+    return struct {
+        buffers: [count][size]u8 = undefined,
+        used: [count]bool = [_]bool{false} ** count,
+
+        const Self = @This();
+
+        /// Returns an unused buffer or null if all buffers are in use.
+        pub fn acquire(self: *Self) ?*[size]u8 {
+            for (self.used) |*used, i| {
+                if (!used.*) {
+                    used.* = true;
+                    return &self.buffers[i];
+                }
+            }
+            return null;
+        }
+
+        /// Return a buffer obtained using acquire to the pool.
+        pub fn release(self: *Self, buf: *[size]u8) void {
+            const offset = @ptrToInt(buf) - @ptrToInt(&self.buffers);
+            std.debug.assert(offset % size == 0);
+
+            const i = offset / size;
+            std.debug.assert(self.used[i]);
+            self.used[i] = false;
+        }
+
+        /// Returns the number of unused buffers.
+        pub fn available(self: *const Self) usize {
+            var n: usize = 0;
+            for (self.used) |used| {
+                if (!used)
+                    n += 1;
+            }
+            return n;
+        }
+    };
+}
+
+test "test buffer pool" {
+    var pool = BufferPool(2, 64){};
+    try testing.expect(pool.available() == 2);
+
+    const first = pool.acquire().?;
+    const second = pool.acquire().?;
+    try testing.expect(first != second);
+    try testing.expect(pool.acquire() == null);
+
+    pool.release(first);
+    try testing.expect(pool.available() == 1);
+    try testing.expect(pool.acquire().? == first);
+
+    var resp = try pkt.Response.init(second, pkt.Msg.con, codes.GET, &[_]u8{}, 1);
+    try testing.expect(resp.size() == @sizeOf(pkt.Header));
+}
@@ -0,0 +1,163 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const opts = @import("opts.zig");
+const BlockValue = @import("block.zig").BlockValue;
+const ContentFormat = @import("contentformat.zig").ContentFormat;
+
+/// Maximum number of payload bytes included in the output.
+pub const PREVIEW_LEN = 32;
+
+fn typeName(mt: pkt.Msg) []const u8 {
+    return switch (mt) {
+        pkt.Msg.con => "CON",
+        pkt.Msg.non => "NON",
+        pkt.Msg.ack => "ACK",
+        pkt.Msg.rst => "RST",
+    };
+}
+
+// Whether the given value is valid UTF-8 without control characters.
+fn isPrintable(value: []const u8) bool {
+    for (value) |c| {
+        if (c < 0x20 or c == 0x7f)
+            return false;
+    }
+    return std.unicode.utf8ValidateSlice(value);
+}
+
+fn writeHex(writer: anytype, value: []const u8) !void {
+    if (value.len == 0) {
+        try writer.writeAll("(empty)");
+    } else {
+        try writer.print("{}", .{std.fmt.fmtSliceHexLower(value)});
+    }
+}
+
+// Write the value as quoted string if it is printable and as hex string
+// otherwise.
+fn writeBytes(writer: anytype, value: []const u8) !void {
+    if (value.len > 0 and isPrintable(value)) {
+        try writer.print("\"{s}\"", .{value});
+    } else {
+        try writeHex(writer, value);
+    }
+}
+
+// Write the value of the given option decoded according to its
+// registered format, values which cannot be decoded are written as hex.
+fn writeValue(writer: anytype, opt: opts.Option) !void {
+    const def = opts.definition(opt.number) orelse return writeBytes(writer, opt.value);
+    switch (def.format) {
+        .empty => try writeBytes(writer, opt.value),
+        .@"opaque" => try writeHex(writer, opt.value),
+        .string => try writeBytes(writer, opt.value),
+        .uint => {
+            if (opt.value.len > @sizeOf(u32))
+                return writeHex(writer, opt.value);
+
+            const is_block = switch (opt.number) {
+                opts.Block1, opts.Block2, opts.QBlock1, opts.QBlock2 => true,
+                else => false,
+            };
+            if (is_block) {
+                const block = BlockValue.decode(opt.value) catch {
+                    return writeHex(writer, opt.value);
+                };
+                // Notation used in RFC 7959, i.e. NUM/M/size.
+                try writer.print("{d}/{d}/{d}", .{ block.num, @boolToInt(block.more), block.size() });
+                return;
+            }
+
+            var value: u32 = 0;
+            for (opt.value) |b| {
+                value = (value << 8) | b;
+            }
+            try writer.print("{d}", .{value});
+
+            if (opt.number == opts.ContentFormat or opt.number == opts.Accept) {
+                const format = @intToEnum(ContentFormat, @truncate(u16, value));
+                if (format.mediaType()) |media_type|
+                    try writer.print(" ({s})", .{media_type});
+            }
+        },
+    }
+}
+
+/// Write a human-readable representation of the given message to the
+/// given writer, intended for logging and debugging. The first line
+/// contains the type, code, Message ID, and token of the message,
+/// followed by one line per option with its name and decoded value and
+/// an excerpt of the payload (if any). Options already consumed using
+/// nextOption are not included.
+pub fn write(writer: anytype, req: *const pkt.Request) !void {
+    const hdr = req.header;
+    try writer.print("{s} {} ({s}), MID {d}, token ", .{
+        typeName(hdr.type),
+        hdr.code,
+        hdr.code.name() orelse "Unknown",
+        hdr.message_id,
+    });
+    try writeHex(writer, req.token);
+    try writer.writeByte('\n');
+
+    var iter = req.options();
+    iter.enforce_rules = false;
+    while (true) {
+        const next = iter.next() catch {
+            try writer.writeAll("  (malformed options)\n");
+            return;
+        };
+        const opt = next orelse break;
+
+        try writer.print("  {s} ({d}): ", .{ opts.name(opt.number) orelse "Unknown", opt.number });
+        try writeValue(writer, opt);
+        try writer.writeByte('\n');
+    }
+
+    const payload = iter.payload() orelse return;
+    const preview = payload[0..std.math.min(payload.len, PREVIEW_LEN)];
+    try writer.print("Payload ({d} bytes): ", .{payload.len});
+    try writeBytes(writer, preview);
+    if (preview.len < payload.len)
+        try writer.writeAll("...");
+    try writer.writeByte('\n');
+}
+
+test "test message pretty-printing" {
+    var out: [256]u8 = undefined;
+
+    const host = try pkt.Request.init(@embedFile("../testvectors/uri-host-port.bin"));
+    try testing.expectEqualStrings(
+        \\CON 0.01 (GET), MID 3380, token 0a34
+        \\  Uri-Host (3): "example.org"
+        \\  Uri-Port (7): 61616
+        \\  Uri-Path (11): "res"
+        \\
+    , try std.fmt.bufPrint(&out, "{}", .{host}));
+
+    const cf = try pkt.Request.init(@embedFile("../testvectors/content-format-cbor.bin"));
+    try testing.expectEqualStrings(
+        \\ACK 2.05 (Content), MID 3360, token cf
+        \\  Content-Format (12): 60 (application/cbor)
+        \\Payload (1 bytes): 00
+        \\
+    , try std.fmt.bufPrint(&out, "{}", .{cf}));
+
+    var buf: [128]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.non, .{ .class = 2, .detail = 6 }, &[_]u8{}, 7);
+    try resp.addOption(&opts.Option{ .number = opts.ETag, .value = &[_]u8{ 0x5c, 0x1d } });
+    try resp.addBlock(opts.Block2, BlockValue{ .num = 2, .more = true, .szx = 2 });
+    try resp.addOption(&opts.Option{ .number = 65000, .value = &[_]u8{0x01} });
+    try resp.payloadWriter().writeAll("a" ** 40);
+
+    const req = try pkt.Request.init(resp.marshal());
+    try testing.expectEqualStrings(
+        \\NON 2.06 (Unknown), MID 7, token (empty)
+        \\  ETag (4): 5c1d
+        \\  Block2 (23): 2/1/64
+        \\  Unknown (65000): 01
+        \\Payload (40 bytes): "
+    ++ "a" ** PREVIEW_LEN ++ "\"...\n", try std.fmt.bufPrint(&out, "{}", .{req}));
+}
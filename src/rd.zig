@@ -0,0 +1,750 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const opts = @import("opts.zig");
+const codes = @import("codes.zig");
+const res = @import("resource.zig");
+const linkformat = @import("linkformat.zig");
+const ContentFormat = @import("contentformat.zig").ContentFormat;
+
+// Lifetime of a registration without an lt parameter in seconds (see
+// RFC 9176 Section 5).
+pub const DEFAULT_LIFETIME = 90000;
+
+pub const Config = struct {
+    /// Maximum number of registrations stored at the same time.
+    registrations: usize = 8,
+    /// Maximum size of the links of a registration in bytes.
+    links_size: usize = 256,
+    /// Maximum length of the ep, d, and base parameters of a
+    /// registration in bytes.
+    param_size: usize = 64,
+    /// Maximum number of simple registrations whose links are fetched
+    /// at the same time.
+    simple_registrations: usize = 2,
+};
+
+/// Returns the resources of a Resource Directory served by the given
+/// handler, which must invoke Directory.handle: the registration
+/// interface at /rd (including the registration resources below it),
+/// the simple registration interface at /.well-known/rd, and the
+/// endpoint and resource lookup interfaces at /rd-lookup/ep and
+/// /rd-lookup/res (see RFC 9176 Section 5 and Section 6).
+pub fn resources(comptime handler: res.ResourceHandler) [4]res.Resource {
+    const link_format = @enumToInt(ContentFormat.link_format);
+    return [_]res.Resource{
+        .{
+            .path = "rd",
+            .handler = handler,
+            .prefix = true,
+            .methods = &[_]codes.Code{ codes.GET, codes.POST, codes.DELETE },
+            .attributes = .{ .rt = "core.rd", .ct = link_format },
+        },
+        .{
+            .path = ".well-known/rd",
+            .handler = handler,
+            .methods = &[_]codes.Code{codes.POST},
+        },
+        .{
+            .path = "rd-lookup/ep",
+            .handler = handler,
+            .methods = &[_]codes.Code{codes.GET},
+            .attributes = .{ .rt = "core.rd-lookup-ep", .ct = link_format },
+        },
+        .{
+            .path = "rd-lookup/res",
+            .handler = handler,
+            .methods = &[_]codes.Code{codes.GET},
+            .attributes = .{ .rt = "core.rd-lookup-res", .ct = link_format },
+        },
+    };
+}
+
+/// Resource Directory (RFC 9176) storing the links registered by
+/// endpoints, which can be found by clients using the lookup
+/// interfaces. Memory is statically allocated for the configured
+/// number of registrations. Times are given in seconds using an
+/// arbitrary monotonic clock provided by the caller, registrations
+/// expire once their lifetime has passed without an update.
+///
+/// Registrations without a base parameter use the source address of the
+/// registering endpoint as base URI (see RFC 9176 Section 5), which is
+/// passed to handle. Endpoints can also register using simple
+/// registration (see RFC 9176 Section 5.1) by sending an empty POST
+/// request to /.well-known/rd. The links of the endpoint are then
+/// fetched by the caller, see startFetch.
+pub fn Directory(comptime config: Config) type {
+    return struct {
+        registrations: [config.registrations]?Registration = [_]?Registration{null} ** config.registrations,
+        simple: [config.simple_registrations]?Simple = [_]?Simple{null} ** config.simple_registrations,
+        next_id: u16 = 1,
+
+        const Self = @This();
+        const Registration = struct {
+            id: u16,
+            ep: Value(config.param_size) = .{},
+            d: Value(config.param_size) = .{},
+            base: Value(config.param_size) = .{},
+            lifetime: u32,
+            expires: u64,
+            links: [config.links_size]u8 = undefined,
+            links_len: usize = 0,
+
+            fn parser(self: *const Registration) linkformat.Parser {
+                return linkformat.Parser{ .data = self.links[0..self.links_len] };
+            }
+
+            // Handle a registration update (see RFC 9176 Section
+            // 5.3.1), which restarts the lifetime of the registration
+            // and may change its lifetime and base URI.
+            fn update(self: *Registration, req: *const pkt.Request, now: u64) !codes.Code {
+                const lifetime = (try getLifetime(req)) orelse self.lifetime;
+                if (try getQuery(req, "base")) |base|
+                    try self.base.set(base);
+
+                self.lifetime = lifetime;
+                self.expires = now + lifetime;
+                return codes.CHANGED;
+            }
+        };
+
+        // Simple registration whose links have not been fetched yet.
+        const Simple = struct {
+            // Base URI derived from the source address of the endpoint,
+            // identifies the endpoint when its links are received.
+            source: Value(config.param_size) = .{},
+            ep: Value(config.param_size) = .{},
+            d: Value(config.param_size) = .{},
+            base: Value(config.param_size) = .{},
+            lifetime: u32,
+            // Whether the links are being fetched, see startFetch.
+            fetching: bool = false,
+        };
+
+        /// Handle the given request for one of the resources returned
+        /// by the resources function received from the given endpoint at
+        /// the given time (e.g. using Server.sender). The endpoint must
+        /// be formattable as host and port (e.g. std.net.Address), it is
+        /// used as default base URI of registrations. Requests which
+        /// cannot be processed are answered with 4.00 (Bad Request), a
+        /// response exceeding the buffer with 5.00 (Internal Server
+        /// Error).
+        pub fn handle(self: *Self, resp: *pkt.Response, req: *pkt.Request, from: anytype, now: u64) codes.Code {
+            self.expire(now);
+
+            var buf: [config.param_size]u8 = undefined;
+            const source = sourceBase(&buf, from);
+            return self.route(resp, req, source, now) catch |err| {
+                resp.reset();
+                if (err == error.BufTooSmall)
+                    return codes.INTERNAL_ERR;
+                return codes.BAD_REQ;
+            };
+        }
+
+        fn route(self: *Self, resp: *pkt.Response, req: *pkt.Request, source: []const u8, now: u64) !codes.Code {
+            var segments = req.getAll(opts.URIPath);
+            const first = (try segments.next()) orelse return codes.NOT_FOUND;
+            const second = try segments.next();
+            if ((try segments.next()) != null)
+                return codes.NOT_FOUND;
+
+            const method = req.header.code;
+            if (std.mem.eql(u8, first, "rd")) {
+                const id = second orelse {
+                    if (!method.equal(codes.POST))
+                        return codes.BAD_METHOD;
+                    return self.register(resp, req, source, now);
+                };
+
+                const reg = self.find(id) orelse return codes.NOT_FOUND;
+                if (method.equal(codes.GET)) {
+                    try resp.addContentFormat(ContentFormat.link_format);
+                    try resp.payloadWriter().writeAll(reg.links[0..reg.links_len]);
+                    return codes.CONTENT;
+                } else if (method.equal(codes.POST)) {
+                    return reg.update(req, now);
+                } else if (method.equal(codes.DELETE)) {
+                    self.remove(reg.id);
+                    return codes.DELETED;
+                }
+                return codes.BAD_METHOD;
+            } else if (std.mem.eql(u8, first, "rd-lookup")) {
+                const name = second orelse return codes.NOT_FOUND;
+                if (!method.equal(codes.GET))
+                    return codes.BAD_METHOD;
+                if (std.mem.eql(u8, name, "ep"))
+                    return self.lookupEndpoints(resp, req);
+                if (std.mem.eql(u8, name, "res"))
+                    return self.lookupResources(resp, req);
+            } else if (std.mem.eql(u8, first, ".well-known")) {
+                const name = second orelse return codes.NOT_FOUND;
+                if (!std.mem.eql(u8, name, "rd"))
+                    return codes.NOT_FOUND;
+                if (!method.equal(codes.POST))
+                    return codes.BAD_METHOD;
+                return self.registerSimple(req, source);
+            }
+
+            return codes.NOT_FOUND;
+        }
+
+        // Handle a registration request (see RFC 9176 Section 5) from
+        // the endpoint with the given base URI.
+        fn register(self: *Self, resp: *pkt.Response, req: *pkt.Request, source: []const u8, now: u64) !codes.Code {
+            const ep = (try getQuery(req, "ep")) orelse return codes.BAD_REQ;
+            if (ep.len == 0)
+                return codes.BAD_REQ;
+            const d = (try getQuery(req, "d")) orelse "";
+
+            var parser = req.linkParser() catch |err| {
+                if (err == error.InvalidContentFormat)
+                    return codes.UNSUPPORTED_FORMAT;
+                return err;
+            };
+            while (try parser.next()) |_| {}
+            if (parser.data.len > config.links_size)
+                return codes.TOO_LARGE;
+
+            const base = (try getQuery(req, "base")) orelse source;
+            const lifetime = (try getLifetime(req)) orelse DEFAULT_LIFETIME;
+            const id = (try self.store(ep, d, base, lifetime, parser.data, now)) orelse return codes.UNAVAILABLE;
+
+            var buf: [16]u8 = undefined;
+            try resp.addLocation(try std.fmt.bufPrint(&buf, "/rd/{d}", .{id}));
+            return codes.CREATED;
+        }
+
+        // Store a registration with the given parameters and links and
+        // return its identifier, or null if no slot is free. A
+        // registration with the same ep and d parameters as an existing
+        // one replaces it, keeping its location.
+        fn store(self: *Self, ep: []const u8, d: []const u8, base: []const u8, lifetime: u32, links: []const u8, now: u64) !?u16 {
+            if (links.len > config.links_size)
+                return error.BufTooSmall;
+
+            const slot = self.findEndpoint(ep, d) orelse self.freeSlot() orelse return null;
+            const id = if (self.registrations[slot]) |previous| previous.id else self.newID();
+
+            var reg = Registration{ .id = id, .lifetime = lifetime, .expires = now + lifetime };
+            try reg.ep.set(ep);
+            try reg.d.set(d);
+            try reg.base.set(base);
+            std.mem.copy(u8, &reg.links, links);
+            reg.links_len = links.len;
+
+            if (self.registrations[slot] == null)
+                self.next_id = id +% 1;
+            self.registrations[slot] = reg;
+            return id;
+        }
+
+        // Handle a simple registration request (see RFC 9176 Section
+        // 5.1) from the endpoint with the given base URI. The request
+        // is answered right away, the registration is created once the
+        // links of the endpoint have been fetched (see complete).
+        fn registerSimple(self: *Self, req: *const pkt.Request, source: []const u8) !codes.Code {
+            const ep = (try getQuery(req, "ep")) orelse return codes.BAD_REQ;
+            if (ep.len == 0 or source.len == 0)
+                return codes.BAD_REQ;
+
+            const slot = self.findSimple(source) orelse self.freeSimple() orelse {
+                return codes.UNAVAILABLE;
+            };
+
+            var simple = Simple{ .lifetime = (try getLifetime(req)) orelse DEFAULT_LIFETIME };
+            try simple.source.set(source);
+            try simple.ep.set(ep);
+            try simple.d.set((try getQuery(req, "d")) orelse "");
+            try simple.base.set((try getQuery(req, "base")) orelse source);
+            slot.* = simple;
+            return codes.CHANGED;
+        }
+
+        /// Returns true once for each simple registration of the given
+        /// endpoint whose links must be fetched, i.e. after handle
+        /// answered a simple registration request. The caller must then
+        /// send a GET request for /.well-known/core to the endpoint
+        /// (e.g. using Server.request) and pass the response to complete.
+        pub fn startFetch(self: *Self, from: anytype) bool {
+            var buf: [config.param_size]u8 = undefined;
+            const entry = self.findSimple(sourceBase(&buf, from)) orelse return false;
+            if (entry.*.?.fetching)
+                return false;
+            entry.*.?.fetching = true;
+            return true;
+        }
+
+        /// Complete the simple registration of the given endpoint using
+        /// the given response to the request for /.well-known/core. If
+        /// the response is null (e.g. if the request timed out) or not a
+        /// 2.05 (Content) response with links, the registration is
+        /// discarded.
+        pub fn complete(self: *Self, from: anytype, resp: ?*const pkt.Request, now: u64) void {
+            var buf: [config.param_size]u8 = undefined;
+            const entry = self.findSimple(sourceBase(&buf, from)) orelse return;
+            const simple = entry.*.?;
+            entry.* = null;
+
+            const links = resp orelse return;
+            if (!links.header.code.equal(codes.CONTENT))
+                return;
+            self.expire(now);
+
+            var parser = links.linkParser() catch return;
+            while (parser.next() catch return) |_| {}
+            _ = self.store(simple.ep.slice(), simple.d.slice(), simple.base.slice(), simple.lifetime, parser.data, now) catch return;
+        }
+
+        fn findSimple(self: *Self, source: []const u8) ?*?Simple {
+            if (source.len == 0)
+                return null;
+            for (self.simple) |*entry| {
+                if (entry.*) |*simple| {
+                    if (std.mem.eql(u8, simple.source.slice(), source))
+                        return entry;
+                }
+            }
+            return null;
+        }
+
+        fn freeSimple(self: *Self) ?*?Simple {
+            for (self.simple) |*entry| {
+                if (entry.* == null)
+                    return entry;
+            }
+            return null;
+        }
+
+        // Handle an endpoint lookup request (see RFC 9176 Section 6),
+        // endpoints can be filtered by their ep, d, and base parameters
+        // and by the href of their registration resource.
+        fn lookupEndpoints(self: *Self, resp: *pkt.Response, req: *pkt.Request) !codes.Code {
+            var paging = try Paging.fromRequest(req);
+            try resp.addContentFormat(ContentFormat.link_format);
+
+            var links: usize = 0;
+            const writer = resp.payloadWriter();
+            for (self.registrations) |*entry| {
+                if (entry.*) |*reg| {
+                    if (!(try matchEndpoint(req, reg, true)) or !paging.take())
+                        continue;
+
+                    if (links > 0)
+                        try writer.writeByte(',');
+                    try writer.print("</rd/{d}>;ep=\"{s}\"", .{ reg.id, reg.ep.slice() });
+                    if (reg.d.len > 0)
+                        try writer.print(";d=\"{s}\"", .{reg.d.slice()});
+                    if (reg.base.len > 0)
+                        try writer.print(";base=\"{s}\"", .{reg.base.slice()});
+                    try writer.print(";lt={d}", .{reg.lifetime});
+                    links += 1;
+                }
+            }
+
+            return codes.CONTENT;
+        }
+
+        // Handle a resource lookup request (see RFC 9176 Section 6).
+        // Links are filtered by the parameters of their endpoint (see
+        // lookupEndpoints) and by their own parameters. Relative link
+        // targets are resolved against the base URI of the endpoint.
+        fn lookupResources(self: *Self, resp: *pkt.Response, req: *pkt.Request) !codes.Code {
+            var paging = try Paging.fromRequest(req);
+            try resp.addContentFormat(ContentFormat.link_format);
+
+            var links: usize = 0;
+            const writer = resp.payloadWriter();
+            for (self.registrations) |*entry| {
+                if (entry.*) |*reg| {
+                    if (!(try matchEndpoint(req, reg, false)))
+                        continue;
+
+                    var parser = reg.parser();
+                    while (try parser.next()) |link| {
+                        if (!(try matchLink(req, link)) or !paging.take())
+                            continue;
+
+                        if (links > 0)
+                            try writer.writeByte(',');
+                        try writeResource(writer, reg.base.slice(), link);
+                        links += 1;
+                    }
+                }
+            }
+
+            return codes.CONTENT;
+        }
+
+        // Returns the registration with the given registration resource
+        // path segment or null if no such registration exists.
+        fn find(self: *Self, segment: []const u8) ?*Registration {
+            const id = std.fmt.parseUnsigned(u16, segment, 10) catch return null;
+            return self.findID(id);
+        }
+
+        fn findID(self: *Self, id: u16) ?*Registration {
+            for (self.registrations) |*entry| {
+                if (entry.*) |*reg| {
+                    if (reg.id == id)
+                        return reg;
+                }
+            }
+            return null;
+        }
+
+        fn findEndpoint(self: *const Self, ep: []const u8, d: []const u8) ?usize {
+            for (self.registrations) |*entry, i| {
+                if (entry.*) |*reg| {
+                    if (std.mem.eql(u8, reg.ep.slice(), ep) and std.mem.eql(u8, reg.d.slice(), d))
+                        return i;
+                }
+            }
+            return null;
+        }
+
+        fn freeSlot(self: *const Self) ?usize {
+            for (self.registrations) |*entry, i| {
+                if (entry.* == null)
+                    return i;
+            }
+            return null;
+        }
+
+        // Returns an identifier not used by any registration.
+        fn newID(self: *Self) u16 {
+            var id = self.next_id;
+            while (self.findID(id) != null)
+                id +%= 1;
+            return id;
+        }
+
+        /// Remove the registration with the given identifier.
+        pub fn remove(self: *Self, id: u16) void {
+            for (self.registrations) |*entry| {
+                if (entry.*) |*reg| {
+                    if (reg.id == id)
+                        entry.* = null;
+                }
+            }
+        }
+
+        /// Remove all registrations whose lifetime has passed at the
+        /// given time, invoked by handle for each request.
+        pub fn expire(self: *Self, now: u64) void {
+            for (self.registrations) |*entry| {
+                if (entry.*) |*reg| {
+                    if (reg.expires <= now)
+                        entry.* = null;
+                }
+            }
+        }
+
+        /// Returns the number of registrations, including expired ones
+        /// which have not been removed yet.
+        pub fn count(self: *const Self) usize {
+            var n: usize = 0;
+            for (self.registrations) |entry| {
+                if (entry != null)
+                    n += 1;
+            }
+            return n;
+        }
+    };
+}
+
+// Write the base URI of the endpoint with the given address to the
+// given buffer, an empty base URI is returned if it does not fit.
+fn sourceBase(buf: []u8, from: anytype) []const u8 {
+    return std.fmt.bufPrint(buf, "coap://{}", .{from}) catch "";
+}
+
+// Parameter value of a registration with statically allocated memory.
+// Since values are written as quoted strings, they must not contain
+// quotes or backslashes.
+fn Value(comptime size: usize) type {
+    return struct {
+        buf: [size]u8 = undefined,
+        len: usize = 0,
+
+        fn set(self: *@This(), value: []const u8) !void {
+            if (value.len > size or std.mem.indexOfAny(u8, value, "\"\\") != null)
+                return error.InvalidParameter;
+            std.mem.copy(u8, &self.buf, value);
+            self.len = value.len;
+        }
+
+        fn slice(self: *const @This()) []const u8 {
+            return self.buf[0..self.len];
+        }
+    };
+}
+
+// Paging of lookup results using the page and count parameters (see
+// RFC 9176 Section 6.2).
+const Paging = struct {
+    skip: usize = 0,
+    limit: ?usize = null,
+
+    fn fromRequest(req: *const pkt.Request) !Paging {
+        const limit = (try getNumber(req, "count")) orelse return Paging{};
+        const page = (try getNumber(req, "page")) orelse 0;
+        const skip = std.math.mul(usize, page, limit) catch return error.InvalidParameter;
+        return Paging{ .skip = skip, .limit = limit };
+    }
+
+    // Whether the next matching result is part of the requested page.
+    fn take(self: *Paging) bool {
+        if (self.skip > 0) {
+            self.skip -= 1;
+            return false;
+        }
+        if (self.limit) |*n| {
+            if (n.* == 0)
+                return false;
+            n.* -= 1;
+        }
+        return true;
+    }
+};
+
+// Splits the given Uri-Query value into the name and value of a filter.
+fn parseQuery(query: []const u8) linkformat.Param {
+    const sep = std.mem.indexOfScalar(u8, query, '=') orelse {
+        return linkformat.Param{ .name = query, .value = null };
+    };
+    return linkformat.Param{ .name = query[0..sep], .value = query[sep + 1 ..] };
+}
+
+// Returns the value of the first Uri-Query option with the given name
+// or null if the request has no such option.
+fn getQuery(req: *const pkt.Request, name: []const u8) !?[]const u8 {
+    var queries = req.getAll(opts.URIQuery);
+    while (try queries.next()) |query| {
+        const param = parseQuery(query);
+        if (std.mem.eql(u8, param.name, name))
+            return param.value orelse "";
+    }
+    return null;
+}
+
+fn getNumber(req: *const pkt.Request, name: []const u8) !?u32 {
+    const value = (try getQuery(req, name)) orelse return null;
+    return std.fmt.parseUnsigned(u32, value, 10) catch return error.InvalidParameter;
+}
+
+// Returns the lifetime given by the lt parameter of the request, which
+// must not be zero.
+fn getLifetime(req: *const pkt.Request) !?u32 {
+    const lifetime = (try getNumber(req, "lt")) orelse return null;
+    if (lifetime == 0)
+        return error.InvalidParameter;
+    return lifetime;
+}
+
+// Filters of a lookup request which do not apply to registrations or
+// links but control the lookup itself.
+fn isPagingFilter(name: []const u8) bool {
+    return std.mem.eql(u8, name, "page") or std.mem.eql(u8, name, "count");
+}
+
+fn isEndpointFilter(name: []const u8) bool {
+    return std.mem.eql(u8, name, "ep") or std.mem.eql(u8, name, "d") or std.mem.eql(u8, name, "base");
+}
+
+// Whether the given registration matches all endpoint filters of the
+// given lookup request. For endpoint lookups, all other filters must
+// match as well, only the href filter is supported.
+fn matchEndpoint(req: *const pkt.Request, reg: anytype, endpoint_lookup: bool) !bool {
+    var queries = req.getAll(opts.URIQuery);
+    while (try queries.next()) |query| {
+        const filter = parseQuery(query);
+        const value = filter.value orelse "";
+        if (isPagingFilter(filter.name))
+            continue;
+
+        if (std.mem.eql(u8, filter.name, "ep")) {
+            if (!res.matchValue(value, reg.ep.slice()))
+                return false;
+        } else if (std.mem.eql(u8, filter.name, "d")) {
+            if (!res.matchValue(value, reg.d.slice()))
+                return false;
+        } else if (std.mem.eql(u8, filter.name, "base")) {
+            if (!res.matchValue(value, reg.base.slice()))
+                return false;
+        } else if (endpoint_lookup) {
+            var buf: [16]u8 = undefined;
+            const href = try std.fmt.bufPrint(&buf, "/rd/{d}", .{reg.id});
+            if (!std.mem.eql(u8, filter.name, "href") or !res.matchValue(value, href))
+                return false;
+        }
+    }
+    return true;
+}
+
+// Whether the given link matches all link filters of the given resource
+// lookup request. The href filter matches the link target, all other
+// filters match a parameter with the same name, a filter without a
+// value matches any link with the parameter.
+fn matchLink(req: *const pkt.Request, link: linkformat.Link) !bool {
+    var queries = req.getAll(opts.URIQuery);
+    outer: while (try queries.next()) |query| {
+        const filter = parseQuery(query);
+        if (isPagingFilter(filter.name) or isEndpointFilter(filter.name))
+            continue;
+
+        if (std.mem.eql(u8, filter.name, "href")) {
+            if (!res.matchValue(filter.value orelse "", link.target))
+                return false;
+            continue;
+        }
+
+        var params = link.params();
+        while (try params.next()) |param| {
+            if (!std.mem.eql(u8, param.name, filter.name))
+                continue;
+            const value = filter.value orelse continue :outer;
+            if (res.matchAny(value, param.value))
+                continue :outer;
+        }
+        return false;
+    }
+    return true;
+}
+
+// Write the given link of an endpoint with the given base URI, a
+// relative target is resolved against the base URI which is also used
+// as anchor of the link unless it has one already.
+fn writeResource(writer: anytype, base: []const u8, link: linkformat.Link) !void {
+    if (base.len == 0 or link.target.len == 0 or link.target[0] != '/') {
+        try writer.print("<{s}>{s}", .{ link.target, link.raw_params });
+        return;
+    }
+
+    try writer.print("<{s}{s}>{s}", .{ std.mem.trimRight(u8, base, "/"), link.target, link.raw_params });
+    if ((try link.get("anchor")) == null)
+        try writer.print(";anchor=\"{s}\"", .{base});
+}
+
+var directory = Directory(.{ .registrations = 2 }){};
+var directory_time: u64 = 0;
+var directory_peer = TestEndpoint{ .port = 5683 };
+
+// Endpoint of a node registering with the directory, identified by its
+// port.
+const TestEndpoint = struct {
+    port: u16,
+
+    pub fn format(self: TestEndpoint, comptime fmt: []const u8, options: std.fmt.FormatOptions, writer: anytype) !void {
+        _ = fmt;
+        _ = options;
+        try writer.print("[2001:db8::2]:{d}", .{self.port});
+    }
+};
+
+fn directoryHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    return directory.handle(resp, req, directory_peer, directory_time);
+}
+
+// Send a request for the given path and query to the Resource Directory
+// served by the given dispatcher and return the reply. If a payload is
+// given, it is sent as application/link-format.
+fn directoryRequest(dispatcher: *res.Dispatcher, method: codes.Code, path: []const u8, query: []const u8, payload: ?[]const u8) !pkt.Request {
+    var buf: [128]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, method, &[_]u8{}, 0x2401);
+    try msg.addURIPath(path);
+    if (payload != null)
+        try msg.addContentFormat(ContentFormat.link_format);
+    try msg.addURIQuery(query);
+    if (payload) |links|
+        try msg.payloadWriter().writeAll(links);
+
+    var req = try pkt.Request.init(msg.marshal());
+    var resp = try dispatcher.dispatch(&req);
+    return pkt.Request.init(resp.marshal());
+}
+
+test "test resource directory" {
+    const directory_resources = resources(directoryHandler);
+    var dispatcher = res.Dispatcher{ .resources = &directory_resources };
+
+    const links = "</temp>;rt=\"temperature-c\",</led>;if=\"core.a\"";
+    var reply = try directoryRequest(&dispatcher, codes.POST, "rd", "ep=node1&base=coap://[2001:db8::1]&lt=60", links);
+    try testing.expect(reply.header.code.equal(codes.CREATED));
+    var location = reply.getAll(opts.LocationPath);
+    try testing.expectEqualStrings("rd", (try location.next()).?);
+    try testing.expectEqualStrings("1", (try location.next()).?);
+    try testing.expect((try location.next()) == null);
+
+    // Registrations require an endpoint name and a link-format payload.
+    reply = try directoryRequest(&dispatcher, codes.POST, "rd", "lt=60", links);
+    try testing.expect(reply.header.code.equal(codes.BAD_REQ));
+    reply = try directoryRequest(&dispatcher, codes.POST, "rd", "ep=node2", null);
+    try testing.expect(reply.header.code.equal(codes.UNSUPPORTED_FORMAT));
+
+    reply = try directoryRequest(&dispatcher, codes.GET, "rd/1", "", null);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expectEqualStrings(links, (try reply.extractPayload()).?);
+
+    reply = try directoryRequest(&dispatcher, codes.GET, "rd-lookup/ep", "ep=node*", null);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expectEqualStrings("</rd/1>;ep=\"node1\";base=\"coap://[2001:db8::1]\";lt=60", (try reply.extractPayload()).?);
+
+    // Relative link targets are resolved against the base URI.
+    reply = try directoryRequest(&dispatcher, codes.GET, "rd-lookup/res", "rt=temperature*", null);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expectEqualStrings("<coap://[2001:db8::1]/temp>;rt=\"temperature-c\";anchor=\"coap://[2001:db8::1]\"", (try reply.extractPayload()).?);
+
+    // The second registration of an endpoint replaces the first one.
+    // Without a base parameter, the source address is the base URI.
+    reply = try directoryRequest(&dispatcher, codes.POST, "rd", "ep=node1&lt=60", "</temp>");
+    try testing.expect(reply.header.code.equal(codes.CREATED));
+    try testing.expect(directory.count() == 1);
+    reply = try directoryRequest(&dispatcher, codes.GET, "rd-lookup/res", "ep=node1", null);
+    try testing.expectEqualStrings("<coap://[2001:db8::2]:5683/temp>;anchor=\"coap://[2001:db8::2]:5683\"", (try reply.extractPayload()).?);
+
+    // Updates restart the lifetime, registrations expire afterwards.
+    directory_time = 30;
+    reply = try directoryRequest(&dispatcher, codes.POST, "rd/1", "lt=120", null);
+    try testing.expect(reply.header.code.equal(codes.CHANGED));
+    directory_time = 100;
+    reply = try directoryRequest(&dispatcher, codes.GET, "rd-lookup/ep", "", null);
+    try testing.expectEqualStrings("</rd/1>;ep=\"node1\";base=\"coap://[2001:db8::2]:5683\";lt=120", (try reply.extractPayload()).?);
+    directory_time = 150;
+    reply = try directoryRequest(&dispatcher, codes.GET, "rd/1", "", null);
+    try testing.expect(reply.header.code.equal(codes.NOT_FOUND));
+    try testing.expect(directory.count() == 0);
+
+    reply = try directoryRequest(&dispatcher, codes.POST, "rd", "ep=node2", "</light>");
+    try testing.expect(reply.header.code.equal(codes.CREATED));
+    reply = try directoryRequest(&dispatcher, codes.DELETE, "rd/2", "", null);
+    try testing.expect(reply.header.code.equal(codes.DELETED));
+    try testing.expect(directory.count() == 0);
+
+    // Simple registrations are created once the links of the endpoint
+    // have been fetched.
+    directory_peer.port = 61616;
+    reply = try directoryRequest(&dispatcher, codes.POST, ".well-known/rd", "ep=node3", null);
+    try testing.expect(reply.header.code.equal(codes.CHANGED));
+    try testing.expect(directory.startFetch(directory_peer));
+    try testing.expect(!directory.startFetch(directory_peer));
+    try testing.expect(directory.count() == 0);
+
+    var buf: [64]u8 = undefined;
+    var core = try pkt.Response.init(&buf, pkt.Msg.ack, codes.CONTENT, &[_]u8{}, 0x2402);
+    try core.addContentFormat(ContentFormat.link_format);
+    try core.payloadWriter().writeAll("</light>;rt=\"light-lux\"");
+    const fetched = try pkt.Request.init(core.marshal());
+    directory.complete(directory_peer, &fetched, directory_time);
+    try testing.expect(directory.count() == 1);
+    reply = try directoryRequest(&dispatcher, codes.GET, "rd-lookup/res", "ep=node3", null);
+    try testing.expectEqualStrings("<coap://[2001:db8::2]:61616/light>;rt=\"light-lux\";anchor=\"coap://[2001:db8::2]:61616\"", (try reply.extractPayload()).?);
+
+    // Without a response, the simple registration is discarded.
+    reply = try directoryRequest(&dispatcher, codes.POST, ".well-known/rd", "ep=node4", null);
+    try testing.expect(directory.startFetch(directory_peer));
+    directory.complete(directory_peer, null, directory_time);
+    try testing.expect(!directory.startFetch(directory_peer));
+    try testing.expect(directory.count() == 1);
+}
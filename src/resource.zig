@@ -1,51 +1,801 @@
 const std = @import("std");
+const testing = std.testing;
 const pkt = @import("packet.zig");
 const opts = @import("opts.zig");
 const codes = @import("codes.zig");
+const contentformat = @import("contentformat.zig");
+const linkformat = @import("linkformat.zig");
+const ContentFormat = contentformat.ContentFormat;
 
 pub const ResourceHandler = fn (resp: *pkt.Response, req: *pkt.Request) codes.Code;
 
+/// Function returning the current entity-tag (see RFC 7252 Section
+/// 5.10.6) of the representation of a resource for the given request,
+/// written to the given buffer. The entity-tag must change whenever
+/// the representation changes, e.g. it may be a version counter.
+pub const ETagProvider = fn (req: *const pkt.Request, buf: *[8]u8) []const u8;
+
+/// Identity of the peer which sent a request. Unless the transport
+/// authenticates its peers, only the address of the peer is known.
+pub const Peer = union(enum) {
+    /// Address of an unauthenticated peer, e.g. the bytes of the
+    /// Endpoint of the Server.
+    address: []const u8,
+    /// PSK identity of a peer authenticated using DTLS (see RFC 7252
+    /// Section 9.1.3.1).
+    psk_identity: []const u8,
+    /// Sender ID of the security context of a request protected using
+    /// OSCORE (see RFC 8613 Section 3.1).
+    oscore_sender_id: []const u8,
+};
+
+/// Decision of an access-control callback, see AccessControl.
+pub const Access = enum {
+    allow,
+    /// The peer must authenticate (or use different credentials) for
+    /// the request to be allowed, answered with 4.01 (Unauthorized).
+    unauthorized,
+    /// The request is not allowed for the peer, answered with 4.03
+    /// (Forbidden).
+    forbidden,
+};
+
+/// Function deciding whether the given peer may perform the given
+/// request on a resource. Options of the request must not be consumed.
+pub const AccessControl = fn (peer: *const Peer, req: *const pkt.Request) Access;
+
+/// Wrap the given handler in the given tuple of middleware functions,
+/// e.g. for logging or authorization. Each middleware function has the
+/// signature `fn (comptime next: ResourceHandler) ResourceHandler` and
+/// returns a handler which may invoke the next handler. The first
+/// middleware in the tuple is the outermost one, i.e. it is invoked
+/// first. Since handlers are composed at compile-time, no memory needs
+/// to be allocated for the chain. Note that panics cannot be recovered
+/// from by a middleware.
+pub fn chain(comptime handler: ResourceHandler, comptime middleware: anytype) ResourceHandler {
+    comptime var wrapped = handler;
+    comptime var i = middleware.len;
+    inline while (i > 0) : (i -= 1) {
+        wrapped = comptime middleware[i - 1](wrapped);
+    }
+    return wrapped;
+}
+
+/// Code returned by a handler to indicate that the response is sent
+/// later on as a separate response (see RFC 7252 Section 5.2.2), e.g.
+/// because obtaining the representation takes a long time. For
+/// confirmable requests, the Dispatcher returns an empty acknowledgement
+/// which must be sent immediately. For non-confirmable requests, it
+/// returns an empty non-confirmable message which must not be sent.
+pub const SEPARATE = codes.Code{ .class = 0, .detail = 0 };
+
 // Size for reply buffer
 const REPLY_BUFSIZ = 256;
 
+// Path of the resource discovery document (see RFC 6690 Section 4).
+const WELL_KNOWN_CORE = ".well-known/core";
+
 pub const Resource = struct {
     path: []const u8,
     handler: ResourceHandler,
+    /// Request methods supported by the handler. If non-empty, requests
+    /// using a different method are answered with 4.05 (Method Not
+    /// Allowed) by the Dispatcher.
+    methods: []const codes.Code = &[_]codes.Code{},
+    /// Whether clients can register as observers of the resource (see
+    /// RFC 7641). Notifications are sent by the Server, the handler is
+    /// invoked again for each of them.
+    observable: bool = false,
+    /// Content-Formats the handler is able to produce. If non-empty,
+    /// requests with an Accept option for a different Content-Format
+    /// are answered with 4.06 (Not Acceptable) by the Dispatcher.
+    formats: []const ContentFormat = &[_]ContentFormat{},
+    /// Content-Formats of request bodies the handler is able to process,
+    /// e.g. the query of a FETCH request or the patch document of a
+    /// PATCH request (see RFC 8132). If non-empty, requests with a
+    /// payload of a different or without a Content-Format are answered
+    /// with 4.15 (Unsupported Content-Format) by the Dispatcher.
+    body_formats: []const ContentFormat = &[_]ContentFormat{},
+    /// If not null, the ETag option is added to responses automatically
+    /// and GET or FETCH requests whose ETag options include the current
+    /// entity-tag are answered with 2.03 (Valid) by the Dispatcher,
+    /// without invoking the handler.
+    etag: ?ETagProvider = null,
+    /// If not null, the callback is invoked by the Dispatcher with the
+    /// identity of the peer before any other processing of a request.
+    /// Requests which are not allowed are answered with 4.01
+    /// (Unauthorized) or 4.03 (Forbidden), see Access. If the identity
+    /// of the peer is unknown, 4.01 is returned without invoking it.
+    access: ?AccessControl = null,
+    /// Attributes of the resource included in the discovery document
+    /// served by the Dispatcher at /.well-known/core. The obs attribute
+    /// is included automatically for observable resources.
+    attributes: linkformat.Attributes = .{},
+    /// Whether the resource also matches requests for paths below its
+    /// path, e.g. "rd/4" for the path "rd". The handler is responsible
+    /// for the remaining Uri-Path options.
+    prefix: bool = false,
 
     pub fn matchPath(self: Resource, path: []const u8) bool {
         return std.mem.eql(u8, self.path, path);
     }
+
+    /// Whether the Uri-Path options of the given request match the path
+    /// of the resource. Path segments are separated by '/' (e.g.
+    /// "sensors/temp"), an empty path only matches requests without any
+    /// Uri-Path option. Options of the request are not consumed.
+    pub fn matchRequest(self: Resource, req: *const pkt.Request) !bool {
+        return matchSegments(self.path, req, self.prefix);
+    }
+
+    // Whether the resource matches the given query filter of a
+    // discovery request (see RFC 6690 Section 4.1). Only the href, rt,
+    // and if attributes are supported, other filters are ignored.
+    fn matchFilter(self: Resource, filter: linkformat.Param) bool {
+        const value = filter.value orelse "";
+        if (std.mem.eql(u8, filter.name, "href")) {
+            if (value.len == 0 or value[0] != '/')
+                return std.mem.eql(u8, value, "*");
+            return matchValue(value[1..], self.path);
+        } else if (std.mem.eql(u8, filter.name, "rt")) {
+            return matchAny(value, self.attributes.rt);
+        } else if (std.mem.eql(u8, filter.name, "if")) {
+            return matchAny(value, self.attributes.interface);
+        }
+        return true;
+    }
+
+    /// Whether the handler supports the method of the given request, see
+    /// methods.
+    pub fn allowed(self: Resource, req: *const pkt.Request) bool {
+        if (self.methods.len == 0)
+            return true;
+
+        for (self.methods) |method| {
+            if (method.equal(req.header.code))
+                return true;
+        }
+        return false;
+    }
+
+    /// Whether the handler is able to process the request body of the
+    /// given request, see body_formats. Requests without a payload are
+    /// always supported. Options of the request are not consumed.
+    pub fn supported(self: Resource, req: *const pkt.Request) !bool {
+        if (self.body_formats.len == 0)
+            return true;
+
+        var iter = req.options();
+        while (try iter.next()) |_| {}
+        if (iter.payload() == null)
+            return true;
+
+        const format = (try req.contentFormat()) orelse return false;
+        for (self.body_formats) |f| {
+            if (f == format)
+                return true;
+        }
+        return false;
+    }
+
+    /// Whether the resource can produce a representation acceptable to
+    /// the client, as indicated by the Accept option of the request.
+    pub fn acceptable(self: Resource, req: *const pkt.Request) !bool {
+        if (self.formats.len == 0)
+            return true;
+
+        const accept = (try req.accept()) orelse return true;
+        return contentformat.negotiate(&[_]ContentFormat{accept}, self.formats) != null;
+    }
+};
+
+/// Resources of a virtual host, see Dispatcher.hosts.
+pub const Host = struct {
+    /// Value of the Uri-Host option addressing the host, compared
+    /// case-insensitively (e.g. "sensors.example.org").
+    name: []const u8,
+    resources: []const Resource,
+};
+
+/// Iterator over the resources of a Dispatcher, see Dispatcher.iterator.
+pub const ResourceIterator = struct {
+    dispatcher: *const Dispatcher,
+    /// If not null, only the resources of the virtual host are returned.
+    host: ?*const Host = null,
+    pos: usize = 0,
+
+    pub fn next(self: *ResourceIterator) ?*const Resource {
+        if (self.host) |host| {
+            if (self.pos >= host.resources.len)
+                return null;
+            self.pos += 1;
+            return &host.resources[self.pos - 1];
+        }
+
+        const initial = self.dispatcher.resources;
+        const slots: []const ?Resource = self.dispatcher.dynamic orelse &[_]?Resource{};
+        while (self.pos < initial.len + slots.len) {
+            const i = self.pos;
+            self.pos += 1;
+
+            if (i < initial.len)
+                return &initial[i];
+            if (slots[i - initial.len]) |*res|
+                return res;
+        }
+        return null;
+    }
 };
 
 pub const Dispatcher = struct {
+    /// Resources of the default host, used for requests without a
+    /// Uri-Host option or for unknown hosts.
     resources: []const Resource,
+    /// Virtual hosts with separate resources, selected using the
+    /// Uri-Host option of requests. This allows serving multiple
+    /// logical endpoints (e.g. behind a NAT or a reverse proxy) using a
+    /// single Dispatcher.
+    hosts: []const Host = &[_]Host{},
+    /// Slots for resources added at runtime using add, e.g. for object
+    /// instances created by an LwM2M server. Removed resources free
+    /// their slot again. If null, no resources can be added.
+    dynamic: ?[]?Resource = null,
+    /// Buffer for responses, if null a buffer of REPLY_BUFSIZ bytes
+    /// embedded in the Dispatcher is used.
+    buf: ?[]u8 = null,
+    /// Identity of the peer which sent the request being dispatched,
+    /// passed to the access-control callbacks of resources. Set by the
+    /// Server for each request, must be set by the caller otherwise.
+    peer: ?Peer = null,
     rbuf: [REPLY_BUFSIZ]u8 = undefined,
 
     pub fn reply(self: *Dispatcher, req: *const pkt.Request, mt: pkt.Msg, code: codes.Code) !pkt.Response {
-        return pkt.Response.reply(&self.rbuf, req, mt, code);
+        return pkt.Response.reply(self.buffer(), req, mt, code);
+    }
+
+    fn buffer(self: *Dispatcher) []u8 {
+        return self.buf orelse &self.rbuf;
+    }
+
+    /// Add the given resource at runtime, the path of the resource must
+    /// remain valid until the resource is removed. Returns
+    /// error.PathExists if a resource with the same path exists and
+    /// error.LimitExceeded if no slot is free.
+    pub fn add(self: *Dispatcher, resource: Resource) !void {
+        var iter = self.iterator();
+        while (iter.next()) |res| {
+            if (res.matchPath(resource.path))
+                return error.PathExists;
+        }
+
+        for (self.dynamic orelse return error.LimitExceeded) |*entry| {
+            if (entry.* == null) {
+                entry.* = resource;
+                return;
+            }
+        }
+        return error.LimitExceeded;
+    }
+
+    /// Remove the resource with the given path which was added using
+    /// add, returns false if no such resource exists. When used by a
+    /// Server, resources must be removed using Server.remove instead.
+    pub fn remove(self: *Dispatcher, path: []const u8) bool {
+        const entry = self.findDynamic(path) orelse return false;
+        entry.* = null;
+        return true;
+    }
+
+    /// Returns the slot of the resource with the given path which was
+    /// added using add or null if no such resource exists.
+    pub fn findDynamic(self: *Dispatcher, path: []const u8) ?*?Resource {
+        for (self.dynamic orelse return null) |*entry| {
+            const res = entry.* orelse continue;
+            if (res.matchPath(path))
+                return entry;
+        }
+        return null;
+    }
+
+    /// Returns an iterator over all resources of the default host, i.e.
+    /// the resources given on initialization followed by the resources
+    /// added at runtime.
+    pub fn iterator(self: *const Dispatcher) ResourceIterator {
+        return ResourceIterator{ .dispatcher = self };
+    }
+
+    /// Returns the virtual host addressed by the Uri-Host option of the
+    /// given request or null if the default host is addressed.
+    pub fn findHost(self: *const Dispatcher, req: *const pkt.Request) !?*const Host {
+        const name = (try req.getString(opts.URIHost)) orelse return null;
+        for (self.hosts) |*host| {
+            if (std.ascii.eqlIgnoreCase(host.name, name))
+                return host;
+        }
+        return null;
+    }
+
+    // Returns an iterator over the resources of the host addressed by
+    // the given request.
+    fn hostIterator(self: *const Dispatcher, req: *const pkt.Request) !ResourceIterator {
+        return ResourceIterator{ .dispatcher = self, .host = try self.findHost(req) };
     }
 
+    /// Returns the resource of the addressed host matching the given
+    /// request or null if no resource matches.
+    pub fn find(self: *const Dispatcher, req: *const pkt.Request) !?*const Resource {
+        var iter = try self.hostIterator(req);
+        while (iter.next()) |res| {
+            if (try res.matchRequest(req))
+                return res;
+        }
+        return null;
+    }
+
+    /// Forward the given request to the matching resource and return
+    /// the response. Responses to confirmable requests are piggybacked
+    /// in the acknowledgement (see RFC 7252 Section 5.2.1). If the
+    /// handler returns SEPARATE, an empty message is returned instead.
+    ///
+    /// Requests for /.well-known/core are answered with the resource
+    /// discovery document (see discover), unless a resource with this
+    /// path exists.
     pub fn dispatch(self: *Dispatcher, req: *pkt.Request) !pkt.Response {
-        const hdr = req.header;
-        if (hdr.type == pkt.Msg.con) {
-            // We are not able to process confirmable message presently
-            // thus *always* answer those with a reset with NOT_IMPL.
-            return self.reply(req, pkt.Msg.rst, codes.NOT_IMPL);
+        const res = (try self.find(req)) orelse {
+            if (try matchSegments(WELL_KNOWN_CORE, req, false))
+                return self.discover(req);
+            return self.reply(req, replyType(req), codes.NOT_FOUND);
+        };
+        return self.invoke(res, req, null);
+    }
+
+    /// Create the response to the given request for the resource
+    /// discovery document in the CoRE Link Format (see RFC 6690), which
+    /// describes all resources of the addressed host. The document can be
+    /// filtered by clients using a query (e.g. ?rt=temperature), a
+    /// trailing '*' in the value of the query matches any suffix. If no
+    /// resource matches the filter, 4.04 (Not Found) is returned.
+    pub fn discover(self: *Dispatcher, req: *const pkt.Request) !pkt.Response {
+        const mt = replyType(req);
+        if (!req.header.code.equal(codes.GET))
+            return self.reply(req, mt, codes.BAD_METHOD);
+        if (try req.accept()) |format| {
+            if (format != ContentFormat.link_format)
+                return self.reply(req, mt, codes.NOT_ACCEPT);
+        }
+
+        // Only a single query filter is supported, further queries are
+        // ignored (see RFC 6690 Section 4.1).
+        var filter: ?linkformat.Param = null;
+        var queries = req.getAll(opts.URIQuery);
+        if (try queries.next()) |query| {
+            if (std.mem.indexOfScalar(u8, query, '=')) |sep| {
+                filter = linkformat.Param{ .name = query[0..sep], .value = query[sep + 1 ..] };
+            } else {
+                filter = linkformat.Param{ .name = query, .value = null };
+            }
+        }
+
+        var resp = try self.reply(req, mt, codes.CONTENT);
+        try resp.addContentFormat(ContentFormat.link_format);
+
+        var links: usize = 0;
+        const writer = resp.payloadWriter();
+        var iter = try self.hostIterator(req);
+        while (iter.next()) |res| {
+            if (filter) |f| {
+                if (!res.matchFilter(f))
+                    continue;
+            }
+
+            var attrs = res.attributes;
+            attrs.obs = attrs.obs or res.observable;
+
+            if (links > 0)
+                writer.writeByte(',') catch return self.reply(req, mt, codes.INTERNAL_ERR);
+            writer.print("</{s}>", .{res.path}) catch return self.reply(req, mt, codes.INTERNAL_ERR);
+            linkformat.writeAttributes(writer, attrs) catch return self.reply(req, mt, codes.INTERNAL_ERR);
+            links += 1;
         }
 
-        const path_opt = try req.findOption(opts.URIPath);
-        const path = path_opt.value;
+        if (links == 0)
+            return self.reply(req, mt, codes.NOT_FOUND);
+        return resp;
+    }
 
-        for (self.resources) |res| {
-            if (!res.matchPath(path))
-                continue;
+    /// Invoke the handler of the given resource for the given request,
+    /// see dispatch. If observe is not null, an Observe option with the
+    /// given value is added to the response before invoking the handler
+    /// (see RFC 7641 Section 4.2). Since only successful responses are
+    /// notifications, all options and the payload are discarded if the
+    /// handler does not return a 2.xx code.
+    pub fn invoke(self: *Dispatcher, res: *const Resource, req: *pkt.Request, observe: ?u24) !pkt.Response {
+        const mt = replyType(req);
+        if (res.access) |check| {
+            const peer = self.peer orelse return self.reply(req, mt, codes.UNAUTH);
+            switch (check(&peer, req)) {
+                .allow => {},
+                .unauthorized => return self.reply(req, mt, codes.UNAUTH),
+                .forbidden => return self.reply(req, mt, codes.FORBIDDEN),
+            }
+        }
+        if (!res.allowed(req))
+            return self.reply(req, mt, codes.BAD_METHOD);
+        if (!(try res.acceptable(req)))
+            return self.reply(req, mt, codes.NOT_ACCEPT);
+        if (!(try res.supported(req)))
+            return self.reply(req, mt, codes.UNSUPPORTED_FORMAT);
+
+        var etag_buf: [8]u8 = undefined;
+        const etag = if (res.etag) |provider| provider(req, &etag_buf) else null;
 
-            var resp = try self.reply(req, pkt.Msg.non, .{ .class = 0, .detail = 0 });
-            resp.setCode(res.handler(&resp, req));
+        var resp = try self.reply(req, mt, .{ .class = 0, .detail = 0 });
+        if (observe) |value|
+            try resp.addUint(opts.Observe, value);
+        if (etag) |tag| {
+            try resp.addOption(&opts.Option{ .number = opts.ETag, .value = tag });
+
+            // The 2.03 (Valid) response must include the ETag option
+            // but no payload (see RFC 7252 Section 5.9.1.3).
+            if (req.header.code.isSafe() and try validated(req, tag)) {
+                resp.setCode(codes.VALID);
+                return resp;
+            }
+        }
 
-            return resp;
+        const code = res.handler(&resp, req);
+        if (code.equal(SEPARATE)) {
+            // Empty messages must not contain a token, options, or
+            // a payload, thus anything written by the handler is
+            // discarded.
+            return pkt.Response.init(self.buffer(), mt, code, &[_]u8{}, req.header.message_id);
         }
 
-        return self.reply(req, pkt.Msg.non, codes.NOT_FOUND);
+        if (observe != null and !code.isSuccess())
+            resp.reset();
+        resp.setCode(code);
+        return resp;
     }
 };
+
+// Whether the Uri-Path options of the given request match the given
+// path, see Resource.matchRequest. If prefix is true, further
+// segments are allowed.
+fn matchSegments(path: []const u8, req: *const pkt.Request, prefix: bool) !bool {
+    var segments = req.getAll(opts.URIPath);
+    if (path.len > 0) {
+        var iter = std.mem.split(u8, path, "/");
+        while (iter.next()) |segment| {
+            const value = (try segments.next()) orelse return false;
+            if (!std.mem.eql(u8, segment, value))
+                return false;
+        }
+    }
+
+    return prefix or (try segments.next()) == null;
+}
+
+/// Whether the given value matches the value of a query filter, a
+/// trailing '*' in the filter matches any suffix.
+pub fn matchValue(filter: []const u8, value: []const u8) bool {
+    if (filter.len > 0 and filter[filter.len - 1] == '*')
+        return std.mem.startsWith(u8, value, filter[0 .. filter.len - 1]);
+    return std.mem.eql(u8, filter, value);
+}
+
+/// Whether any of the given space-separated values matches the filter.
+pub fn matchAny(filter: []const u8, values: ?[]const u8) bool {
+    var iter = std.mem.tokenize(u8, values orelse return false, " ");
+    while (iter.next()) |value| {
+        if (matchValue(filter, value))
+            return true;
+    }
+    return false;
+}
+
+// Whether any ETag option of the given request contains the given
+// entity-tag.
+fn validated(req: *const pkt.Request, etag: []const u8) !bool {
+    var values = req.getAll(opts.ETag);
+    while (try values.next()) |value| {
+        if (std.mem.eql(u8, value, etag))
+            return true;
+    }
+    return false;
+}
+
+fn replyType(req: *const pkt.Request) pkt.Msg {
+    return if (req.header.type == pkt.Msg.con) pkt.Msg.ack else pkt.Msg.non;
+}
+
+test "test dispatcher with Accept option" {
+    const handler = struct {
+        fn handle(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+            _ = resp;
+            _ = req;
+            return codes.CONTENT;
+        }
+    }.handle;
+
+    const resources = [_]Resource{
+        .{ .path = "sensor", .handler = handler, .formats = &[_]ContentFormat{ContentFormat.cbor} },
+    };
+    var dispatcher = Dispatcher{ .resources = &resources };
+
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{}, 1);
+    try msg.addURIPath("sensor");
+    try msg.addAccept(ContentFormat.cbor);
+
+    var req = try pkt.Request.init(msg.marshal());
+    var resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.CONTENT));
+
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{}, 2);
+    try msg.addURIPath("sensor");
+    try msg.addAccept(ContentFormat.json);
+
+    req = try pkt.Request.init(msg.marshal());
+    resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.NOT_ACCEPT));
+}
+
+fn testHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    if (!req.header.code.equal(codes.GET))
+        return codes.BAD_METHOD;
+
+    resp.addContentFormat(ContentFormat.text_plain) catch {
+        return codes.INTERNAL_ERR;
+    };
+    resp.payloadWriter().writeAll("Hello, World!") catch {
+        return codes.INTERNAL_ERR;
+    };
+    return codes.CONTENT;
+}
+
+// Middleware answering requests without a token with 4.01 (Unauthorized).
+fn requireToken(comptime next: ResourceHandler) ResourceHandler {
+    return struct {
+        fn handle(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+            if (req.token.len == 0)
+                return codes.UNAUTH;
+            return next(resp, req);
+        }
+    }.handle;
+}
+
+// Middleware counting the requests passed to the next handler.
+fn countRequests(comptime next: ResourceHandler) ResourceHandler {
+    return struct {
+        fn handle(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+            handled_requests += 1;
+            return next(resp, req);
+        }
+    }.handle;
+}
+
+var handled_requests: usize = 0;
+
+test "test handler middleware chain" {
+    const resources = [_]Resource{
+        .{ .path = "hello", .handler = chain(testHandler, .{ requireToken, countRequests }) },
+    };
+    var dispatcher = Dispatcher{ .resources = &resources };
+
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{}, 1);
+    try msg.addURIPath("hello");
+    var req = try pkt.Request.init(msg.marshal());
+    var resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.UNAUTH));
+    try testing.expect(handled_requests == 0);
+
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{0x42}, 2);
+    try msg.addURIPath("hello");
+    req = try pkt.Request.init(msg.marshal());
+    resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.CONTENT));
+    try testing.expect(handled_requests == 1);
+}
+
+// Request the discovery document with the given query from the given
+// dispatcher and compare it with the expected links.
+fn expectDiscovery(dispatcher: *Dispatcher, query: []const u8, exp: []const u8) !void {
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x2a}, 0x0d90);
+    try msg.addURIPath(".well-known/core");
+    try msg.addURIQuery(query);
+
+    var req = try pkt.Request.init(msg.marshal());
+    var resp = try dispatcher.dispatch(&req);
+    var reply = try pkt.Request.init(resp.marshal());
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expect((try reply.contentFormat()).? == ContentFormat.link_format);
+    try testing.expectEqualStrings(exp, (try reply.extractPayload()).?);
+}
+
+test "test resource discovery" {
+    const resources = [_]Resource{
+        .{ .path = "sensors/temp", .handler = testHandler, .observable = true, .attributes = .{ .rt = "temperature-c", .interface = "sensor" } },
+        .{ .path = "light", .handler = testHandler, .attributes = .{ .rt = "light-lux", .interface = "sensor", .ct = 0 } },
+        .{ .path = "actuators/led", .handler = testHandler, .attributes = .{ .interface = "core.a" } },
+    };
+    var dispatcher = Dispatcher{ .resources = &resources };
+
+    var req = try pkt.Request.init(@embedFile("../testvectors/multicast-discovery-0.bin"));
+    var resp = try dispatcher.dispatch(&req);
+    var reply = try pkt.Request.init(resp.marshal());
+    try testing.expect(reply.header.type == pkt.Msg.non);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expectEqualStrings("</sensors/temp>;rt=\"temperature-c\";if=\"sensor\";obs,</light>;rt=\"light-lux\";if=\"sensor\";ct=0,</actuators/led>;if=\"core.a\"", (try reply.extractPayload()).?);
+
+    try expectDiscovery(&dispatcher, "rt=light-lux", "</light>;rt=\"light-lux\";if=\"sensor\";ct=0");
+    try expectDiscovery(&dispatcher, "rt=temp*", "</sensors/temp>;rt=\"temperature-c\";if=\"sensor\";obs");
+    try expectDiscovery(&dispatcher, "if=sensor", "</sensors/temp>;rt=\"temperature-c\";if=\"sensor\";obs,</light>;rt=\"light-lux\";if=\"sensor\";ct=0");
+    try expectDiscovery(&dispatcher, "href=/actuators/*", "</actuators/led>;if=\"core.a\"");
+    try expectDiscovery(&dispatcher, "href=/light", "</light>;rt=\"light-lux\";if=\"sensor\";ct=0");
+
+    // Requests not matching any resource are answered with 4.04.
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x0d91);
+    try msg.addURIPath(".well-known/core");
+    try msg.addURIQuery("rt=humidity");
+    req = try pkt.Request.init(msg.marshal());
+    resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.NOT_FOUND));
+}
+
+fn queryHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    const code = req.header.code;
+    if (code.equal(codes.FETCH)) {
+        resp.addContentFormat(ContentFormat.text_plain) catch {
+            return codes.INTERNAL_ERR;
+        };
+        resp.payloadWriter().writeAll("21.5 C") catch {
+            return codes.INTERNAL_ERR;
+        };
+        return codes.CONTENT;
+    } else if (code.equal(codes.PATCH) or code.equal(codes.IPATCH)) {
+        return codes.CHANGED;
+    }
+    return codes.BAD_METHOD;
+}
+
+// Dispatch a request with the given method and JSON body (if any) to
+// the given dispatcher and return the response code.
+fn dispatchMethod(dispatcher: *Dispatcher, method: codes.Code, format: ContentFormat, body: []const u8) !codes.Code {
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, method, &[_]u8{0x86}, 0x0db0);
+    try msg.addURIPath("sensors");
+    if (body.len > 0) {
+        try msg.addContentFormat(format);
+        try msg.payloadWriter().writeAll(body);
+    }
+
+    var req = try pkt.Request.init(msg.marshal());
+    var resp = try dispatcher.dispatch(&req);
+    return resp.header.code;
+}
+
+test "test FETCH, PATCH, and iPATCH requests" {
+    try testing.expect(codes.FETCH.isSafe() and codes.FETCH.isIdempotent());
+    try testing.expect(!codes.PATCH.isSafe() and !codes.PATCH.isIdempotent());
+    try testing.expect(!codes.IPATCH.isSafe() and codes.IPATCH.isIdempotent());
+    try testing.expectEqualStrings("iPATCH", codes.IPATCH.name().?);
+
+    const resources = [_]Resource{
+        .{ .path = "sensors", .handler = queryHandler, .body_formats = &[_]ContentFormat{ContentFormat.json} },
+    };
+    var dispatcher = Dispatcher{ .resources = &resources };
+
+    try testing.expect((try dispatchMethod(&dispatcher, codes.FETCH, ContentFormat.json, "[\"temp\"]")).equal(codes.CONTENT));
+    try testing.expect((try dispatchMethod(&dispatcher, codes.PATCH, ContentFormat.json, "{\"unit\":\"C\"}")).equal(codes.CHANGED));
+    try testing.expect((try dispatchMethod(&dispatcher, codes.IPATCH, ContentFormat.json, "{\"unit\":\"C\"}")).equal(codes.CHANGED));
+
+    // Request bodies with an unsupported Content-Format are rejected.
+    try testing.expect((try dispatchMethod(&dispatcher, codes.FETCH, ContentFormat.text_plain, "temp")).equal(codes.UNSUPPORTED_FORMAT));
+    try testing.expect((try dispatchMethod(&dispatcher, codes.FETCH, ContentFormat.json, "")).equal(codes.CONTENT));
+
+}
+
+var config_etag: []const u8 = &[_]u8{ 0x5c, 0x1d };
+
+fn configETag(req: *const pkt.Request, buf: *[8]u8) []const u8 {
+    _ = req;
+    std.mem.copy(u8, buf, config_etag);
+    return buf[0..config_etag.len];
+}
+
+fn configHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    _ = req;
+    resp.addContentFormat(ContentFormat.text_plain) catch {
+        return codes.INTERNAL_ERR;
+    };
+    resp.payloadWriter().writeAll("on") catch {
+        return codes.INTERNAL_ERR;
+    };
+    return codes.CONTENT;
+}
+
+test "test ETag validation by the dispatcher" {
+    const resources = [_]Resource{
+        .{ .path = "config", .handler = configHandler, .etag = configETag },
+    };
+    var dispatcher = Dispatcher{ .resources = &resources };
+
+    // The entity-tag is added to responses automatically.
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{ 0xe7, 0xa9 }, 0x0d33);
+    try msg.addURIPath("config");
+    var req = try pkt.Request.init(msg.marshal());
+    var resp = try dispatcher.dispatch(&req);
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/etag-content.bin"), resp.marshal());
+
+    // Requests including the current entity-tag are answered with 2.03.
+    config_etag = &[_]u8{ 0x33, 0xa6, 0x2b, 0xf0, 0x97, 0x01, 0x42, 0x8e };
+    req = try pkt.Request.init(@embedFile("../testvectors/etag-get-multiple.bin"));
+    resp = try dispatcher.dispatch(&req);
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/etag-valid.bin"), resp.marshal());
+
+    // Requests including an outdated entity-tag receive the current
+    // representation.
+    req = try pkt.Request.init(@embedFile("../testvectors/etag-get.bin"));
+    resp = try dispatcher.dispatch(&req);
+    var reply = try pkt.Request.init(resp.marshal());
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expectEqualSlices(u8, config_etag, (try reply.getOpaque(opts.ETag)).?);
+    try testing.expectEqualStrings("on", (try reply.extractPayload()).?);
+}
+
+test "test virtual hosts" {
+    const common = [_]Resource{
+        .{ .path = "hello", .handler = testHandler },
+    };
+    const sensors = [_]Resource{
+        .{ .path = "temperature", .handler = testHandler, .attributes = .{ .rt = "temperature-c" } },
+    };
+    const hosts = [_]Host{
+        .{ .name = "sensors.example.org", .resources = &sensors },
+    };
+    var dispatcher = Dispatcher{ .resources = &common, .hosts = &hosts };
+
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x2301);
+    try msg.addOption(&opts.Option{ .number = opts.URIHost, .value = "Sensors.Example.org" });
+    try msg.addURIPath("temperature");
+    var req = try pkt.Request.init(msg.marshal());
+    var resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.CONTENT));
+
+    // Resources of other hosts are not found.
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x2302);
+    try msg.addOption(&opts.Option{ .number = opts.URIHost, .value = "sensors.example.org" });
+    try msg.addURIPath("hello");
+    req = try pkt.Request.init(msg.marshal());
+    resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.NOT_FOUND));
+
+    // Without a Uri-Host option or for unknown hosts, the default
+    // resources are used.
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x2303);
+    try msg.addURIPath("hello");
+    req = try pkt.Request.init(msg.marshal());
+    resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.CONTENT));
+
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x2304);
+    try msg.addOption(&opts.Option{ .number = opts.URIHost, .value = "192.0.2.1" });
+    try msg.addURIPath("temperature");
+    req = try pkt.Request.init(msg.marshal());
+    resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.NOT_FOUND));
+
+    // The discovery document only describes resources of the host.
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x2305);
+    try msg.addOption(&opts.Option{ .number = opts.URIHost, .value = "sensors.example.org" });
+    try msg.addURIPath(".well-known/core");
+    req = try pkt.Request.init(msg.marshal());
+    resp = try dispatcher.dispatch(&req);
+    var reply = try pkt.Request.init(resp.marshal());
+    try testing.expectEqualStrings("</temperature>;rt=\"temperature-c\"", (try reply.extractPayload()).?);
+}
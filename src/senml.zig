@@ -0,0 +1,579 @@
+const std = @import("std");
+const testing = std.testing;
+
+const cbor = @import("cbor.zig");
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+const ContentFormat = @import("contentformat.zig").ContentFormat;
+
+// Largest SenML version supported, records with a larger base version
+// must be rejected (see RFC 8428 Section 4.4).
+pub const VERSION = 10;
+
+// Times below 2^28 are relative to the current time.
+//
+// From RFC 8428:
+//
+//  A time of zero indicates that the sensor does not know the absolute
+//  time and the measurement was made roughly "now".
+//
+// See RFC 8428 Section 4.5.3 for details.
+const RELATIVE_TIME_LIMIT = 1 << 28;
+
+/// Value of a SenML record.
+pub const Value = union(enum) {
+    none: void,
+    number: f64,
+    string: []const u8,
+    boolean: bool,
+    /// Data value, in the JSON representation the data is base64url
+    /// encoded without padding (see std.base64.url_safe_no_pad).
+    data: []const u8,
+};
+
+/// A SenML record as contained in a SenML Pack, base fields are not
+/// resolved (see Resolver).
+pub const Record = struct {
+    base_name: ?[]const u8 = null,
+    base_time: ?f64 = null,
+    base_unit: ?[]const u8 = null,
+    base_value: ?f64 = null,
+    base_sum: ?f64 = null,
+    base_version: ?u64 = null,
+    name: ?[]const u8 = null,
+    unit: ?[]const u8 = null,
+    value: Value = Value{ .none = {} },
+    sum: ?f64 = null,
+    time: ?f64 = null,
+    update_time: ?f64 = null,
+};
+
+/// A record with all base fields applied (see RFC 8428 Section 4.6).
+pub const Resolved = struct {
+    base_name: []const u8,
+    name: []const u8,
+    unit: ?[]const u8,
+    value: Value,
+    sum: ?f64,
+    time: f64,
+    update_time: ?f64,
+
+    /// Write the full name, i.e. the concatenation of base name and
+    /// name, to the given writer.
+    pub fn writeName(self: Resolved, writer: anytype) !void {
+        try writer.writeAll(self.base_name);
+        try writer.writeAll(self.name);
+    }
+
+    /// Whether the time is relative to the current time instead of
+    /// being an absolute time in seconds since the Unix epoch.
+    pub fn isRelativeTime(self: Resolved) bool {
+        return self.time < RELATIVE_TIME_LIMIT;
+    }
+};
+
+/// Resolves the records of a SenML Pack, base fields apply to all
+/// following records until they are overwritten. Hence, records must
+/// be resolved in the order they are contained in the pack.
+pub const Resolver = struct {
+    base_name: []const u8 = "",
+    base_time: f64 = 0,
+    base_unit: ?[]const u8 = null,
+    base_value: f64 = 0,
+    base_sum: f64 = 0,
+
+    pub fn resolve(self: *Resolver, rec: Record) !Resolved {
+        if (rec.base_version) |version| {
+            if (version > VERSION)
+                return error.UnsupportedVersion;
+        }
+
+        if (rec.base_name) |bn| self.base_name = bn;
+        if (rec.base_time) |bt| self.base_time = bt;
+        if (rec.base_unit) |bu| self.base_unit = bu;
+        if (rec.base_value) |bv| self.base_value = bv;
+        if (rec.base_sum) |bs| self.base_sum = bs;
+
+        const name = rec.name orelse "";
+        if (self.base_name.len + name.len == 0)
+            return error.MissingName;
+
+        var value = rec.value;
+        switch (value) {
+            .number => |v| value = Value{ .number = v + self.base_value },
+            else => {},
+        }
+
+        var sum: ?f64 = null;
+        if (rec.sum) |s|
+            sum = s + self.base_sum;
+
+        return Resolved{
+            .base_name = self.base_name,
+            .name = name,
+            .unit = rec.unit orelse self.base_unit,
+            .value = value,
+            .sum = sum,
+            .time = self.base_time + (rec.time orelse 0),
+            .update_time = rec.update_time,
+        };
+    }
+};
+
+const Field = enum {
+    base_version,
+    base_name,
+    base_time,
+    base_unit,
+    base_value,
+    base_sum,
+    name,
+    unit,
+    value,
+    string_value,
+    boolean_value,
+    sum,
+    time,
+    update_time,
+    data_value,
+};
+
+const Label = struct {
+    field: Field,
+    json: []const u8,
+    cbor: i64,
+};
+
+// https://datatracker.ietf.org/doc/html/rfc8428#section-12.2
+const labels = [_]Label{
+    .{ .field = .base_version, .json = "bver", .cbor = -1 },
+    .{ .field = .base_name, .json = "bn", .cbor = -2 },
+    .{ .field = .base_time, .json = "bt", .cbor = -3 },
+    .{ .field = .base_unit, .json = "bu", .cbor = -4 },
+    .{ .field = .base_value, .json = "bv", .cbor = -5 },
+    .{ .field = .base_sum, .json = "bs", .cbor = -6 },
+    .{ .field = .name, .json = "n", .cbor = 0 },
+    .{ .field = .unit, .json = "u", .cbor = 1 },
+    .{ .field = .value, .json = "v", .cbor = 2 },
+    .{ .field = .string_value, .json = "vs", .cbor = 3 },
+    .{ .field = .boolean_value, .json = "vb", .cbor = 4 },
+    .{ .field = .sum, .json = "s", .cbor = 5 },
+    .{ .field = .time, .json = "t", .cbor = 6 },
+    .{ .field = .update_time, .json = "ut", .cbor = 7 },
+    .{ .field = .data_value, .json = "vd", .cbor = 8 },
+};
+
+fn label(field: Field) Label {
+    return labels[@enumToInt(field)];
+}
+
+// Value of a single field independent of the representation.
+const Raw = union(enum) {
+    number: f64,
+    text: []const u8,
+    bytes: []const u8,
+    boolean: bool,
+};
+
+const Entry = struct {
+    field: Field,
+    raw: Raw,
+};
+
+// Returns all fields present in the given record.
+fn entries(rec: Record, buf: *[labels.len]Entry) []const Entry {
+    var n: usize = 0;
+    for (labels) |l| {
+        const raw: ?Raw = switch (l.field) {
+            .base_version => if (rec.base_version) |v| Raw{ .number = @intToFloat(f64, v) } else null,
+            .base_name => if (rec.base_name) |v| Raw{ .text = v } else null,
+            .base_time => if (rec.base_time) |v| Raw{ .number = v } else null,
+            .base_unit => if (rec.base_unit) |v| Raw{ .text = v } else null,
+            .base_value => if (rec.base_value) |v| Raw{ .number = v } else null,
+            .base_sum => if (rec.base_sum) |v| Raw{ .number = v } else null,
+            .name => if (rec.name) |v| Raw{ .text = v } else null,
+            .unit => if (rec.unit) |v| Raw{ .text = v } else null,
+            .value => if (rec.value == .number) Raw{ .number = rec.value.number } else null,
+            .string_value => if (rec.value == .string) Raw{ .text = rec.value.string } else null,
+            .boolean_value => if (rec.value == .boolean) Raw{ .boolean = rec.value.boolean } else null,
+            .sum => if (rec.sum) |v| Raw{ .number = v } else null,
+            .time => if (rec.time) |v| Raw{ .number = v } else null,
+            .update_time => if (rec.update_time) |v| Raw{ .number = v } else null,
+            .data_value => if (rec.value == .data) Raw{ .bytes = rec.value.data } else null,
+        };
+
+        if (raw) |r| {
+            buf[n] = Entry{ .field = l.field, .raw = r };
+            n += 1;
+        }
+    }
+
+    return buf[0..n];
+}
+
+fn number(raw: Raw) !f64 {
+    return switch (raw) {
+        .number => |v| v,
+        else => error.FormatError,
+    };
+}
+
+fn text(raw: Raw) ![]const u8 {
+    return switch (raw) {
+        .text => |v| v,
+        else => error.FormatError,
+    };
+}
+
+// Set the given field of the record, data values may be given as text
+// since they are base64url encoded in the JSON representation.
+fn setField(rec: *Record, field: Field, raw: Raw) !void {
+    switch (field) {
+        .base_version => {
+            const v = try number(raw);
+            if (v < 0 or v > std.math.maxInt(u32) or @floor(v) != v)
+                return error.FormatError;
+            rec.base_version = @floatToInt(u64, v);
+        },
+        .base_name => rec.base_name = try text(raw),
+        .base_time => rec.base_time = try number(raw),
+        .base_unit => rec.base_unit = try text(raw),
+        .base_value => rec.base_value = try number(raw),
+        .base_sum => rec.base_sum = try number(raw),
+        .name => rec.name = try text(raw),
+        .unit => rec.unit = try text(raw),
+        .value => rec.value = Value{ .number = try number(raw) },
+        .string_value => rec.value = Value{ .string = try text(raw) },
+        .boolean_value => switch (raw) {
+            .boolean => |v| rec.value = Value{ .boolean = v },
+            else => return error.FormatError,
+        },
+        .sum => rec.sum = try number(raw),
+        .time => rec.time = try number(raw),
+        .update_time => rec.update_time = try number(raw),
+        .data_value => switch (raw) {
+            .bytes => |v| rec.value = Value{ .data = v },
+            .text => |v| rec.value = Value{ .data = v },
+            else => return error.FormatError,
+        },
+    }
+}
+
+/// Reader for the records of a SenML Pack in the CBOR representation
+/// (RFC 8428 Section 6).
+pub const CborReader = struct {
+    dec: cbor.Decoder,
+    remaining: u64,
+
+    pub fn init(data: []const u8) !CborReader {
+        var dec = cbor.Decoder{ .slice = data };
+        const item = (try dec.next()) orelse return error.FormatError;
+        switch (item) {
+            .array => |n| return CborReader{ .dec = dec, .remaining = n },
+            else => return error.FormatError,
+        }
+    }
+
+    /// Returns the next record or null if all records have been read.
+    /// Fields with unknown labels are skipped.
+    pub fn next(self: *CborReader) !?Record {
+        if (self.remaining == 0)
+            return null;
+        self.remaining -= 1;
+
+        const item = (try self.dec.next()) orelse return error.FormatError;
+        const pairs = switch (item) {
+            .map => |n| n,
+            else => return error.FormatError,
+        };
+
+        var rec = Record{};
+        var i: u64 = 0;
+        while (i < pairs) : (i += 1) {
+            const key = (try self.dec.next()) orelse return error.FormatError;
+            const field = fromCborLabel(try key.int()) orelse {
+                try self.dec.skip();
+                continue;
+            };
+
+            const value = (try self.dec.next()) orelse return error.FormatError;
+            const raw = switch (value) {
+                .uint, .negint => Raw{ .number = @intToFloat(f64, try value.int()) },
+                .float => |v| Raw{ .number = v },
+                .text => |v| Raw{ .text = v },
+                .bytes => |v| Raw{ .bytes = v },
+                .boolean => |v| Raw{ .boolean = v },
+                else => return error.FormatError,
+            };
+            try setField(&rec, field, raw);
+        }
+
+        return rec;
+    }
+};
+
+fn fromCborLabel(key: i64) ?Field {
+    for (labels) |l| {
+        if (l.cbor == key)
+            return l.field;
+    }
+    return null;
+}
+
+/// Reader for the records of a SenML Pack in the JSON representation
+/// (RFC 8428 Section 5). Strings containing escape sequences are not
+/// supported, since they cannot be returned without allocating memory.
+pub const JsonReader = struct {
+    stream: std.json.TokenStream,
+
+    pub fn init(data: []const u8) !JsonReader {
+        var reader = JsonReader{ .stream = std.json.TokenStream.init(data) };
+        const begin = (try reader.stream.next()) orelse return error.FormatError;
+        if (begin != .ArrayBegin)
+            return error.FormatError;
+        return reader;
+    }
+
+    fn nextToken(self: *JsonReader) !std.json.Token {
+        return (try self.stream.next()) orelse return error.FormatError;
+    }
+
+    // Returns the raw input of the token returned last.
+    fn source(self: *JsonReader, tok: std.json.Token) ![]const u8 {
+        return switch (tok) {
+            .String => |s| blk: {
+                if (s.escapes != .None)
+                    return error.Unsupported;
+                break :blk s.slice(self.stream.slice, self.stream.i - 1);
+            },
+            .Number => |n| n.slice(self.stream.slice, self.stream.i - 1),
+            else => error.FormatError,
+        };
+    }
+
+    /// Returns the next record or null if all records have been read.
+    /// Fields with unknown names are skipped, unless they are marked as
+    /// must-understand (see RFC 8428 Section 4.4).
+    pub fn next(self: *JsonReader) !?Record {
+        const begin = try self.nextToken();
+        switch (begin) {
+            .ArrayEnd => return null,
+            .ObjectBegin => {},
+            else => return error.FormatError,
+        }
+
+        var rec = Record{};
+        while (true) {
+            const key = try self.nextToken();
+            if (key == .ObjectEnd)
+                break;
+            if (key != .String)
+                return error.FormatError;
+
+            const name = try self.source(key);
+            const value = try self.nextToken();
+            const field = fromJsonLabel(name) orelse {
+                if (std.mem.endsWith(u8, name, "_"))
+                    return error.UnsupportedField;
+                if (value == .ObjectBegin or value == .ArrayBegin)
+                    return error.FormatError;
+                continue;
+            };
+
+            const raw = switch (value) {
+                .Number => blk: {
+                    const v = std.fmt.parseFloat(f64, try self.source(value)) catch {
+                        return error.FormatError;
+                    };
+                    break :blk Raw{ .number = v };
+                },
+                .String => Raw{ .text = try self.source(value) },
+                .True => Raw{ .boolean = true },
+                .False => Raw{ .boolean = false },
+                else => return error.FormatError,
+            };
+            try setField(&rec, field, raw);
+        }
+
+        return rec;
+    }
+};
+
+fn fromJsonLabel(name: []const u8) ?Field {
+    for (labels) |l| {
+        if (std.mem.eql(u8, l.json, name))
+            return l.field;
+    }
+    return null;
+}
+
+/// Reader for a SenML Pack in either representation.
+pub const Reader = union(enum) {
+    cbor: CborReader,
+    json: JsonReader,
+
+    pub fn next(self: *Reader) !?Record {
+        switch (self.*) {
+            .cbor => |*r| return try r.next(),
+            .json => |*r| return try r.next(),
+        }
+    }
+};
+
+// Whether the value is written as integer, which is more compact than
+// a floating point value.
+fn isIntegral(v: f64) bool {
+    return @floor(v) == v and std.math.fabs(v) <= std.math.maxInt(u32);
+}
+
+/// Write the given records as SenML Pack in the CBOR representation.
+pub fn writeCbor(writer: anytype, records: []const Record) !void {
+    const enc = cbor.encoder(writer);
+    try enc.array(records.len);
+
+    var buf: [labels.len]Entry = undefined;
+    for (records) |rec| {
+        const fields = entries(rec, &buf);
+        try enc.map(fields.len);
+
+        for (fields) |entry| {
+            try enc.int(label(entry.field).cbor);
+            switch (entry.raw) {
+                .number => |v| {
+                    if (isIntegral(v)) {
+                        try enc.int(@floatToInt(i64, v));
+                    } else {
+                        try enc.float(v);
+                    }
+                },
+                .text => |v| try enc.text(v),
+                .bytes => |v| try enc.bytes(v),
+                .boolean => |v| try enc.boolean(v),
+            }
+        }
+    }
+}
+
+/// Write the given records as SenML Pack in the JSON representation.
+pub fn writeJson(writer: anytype, records: []const Record) !void {
+    try writer.writeByte('[');
+
+    var buf: [labels.len]Entry = undefined;
+    for (records) |rec, i| {
+        if (i > 0)
+            try writer.writeByte(',');
+        try writer.writeByte('{');
+
+        for (entries(rec, &buf)) |entry, j| {
+            if (j > 0)
+                try writer.writeByte(',');
+            try writer.print("\"{s}\":", .{label(entry.field).json});
+
+            switch (entry.raw) {
+                .number => |v| {
+                    if (std.math.isNan(v) or std.math.isInf(v))
+                        return error.InvalidValue;
+                    if (isIntegral(v)) {
+                        try writer.print("{d}", .{@floatToInt(i64, v)});
+                    } else {
+                        try writer.print("{d}", .{v});
+                    }
+                },
+                .text => |v| try std.json.stringify(v, .{}, writer),
+                .bytes => |v| try writeBase64(writer, v),
+                .boolean => |v| try writer.writeAll(if (v) "true" else "false"),
+            }
+        }
+
+        try writer.writeByte('}');
+    }
+
+    try writer.writeByte(']');
+}
+
+fn writeBase64(writer: anytype, data: []const u8) !void {
+    const encoder = std.base64.url_safe_no_pad.Encoder;
+
+    try writer.writeByte('"');
+    var i: usize = 0;
+    while (i < data.len) : (i += 3) {
+        var out: [4]u8 = undefined;
+        const chunk = data[i..std.math.min(i + 3, data.len)];
+        try writer.writeAll(encoder.encode(&out, chunk));
+    }
+    try writer.writeByte('"');
+}
+
+test "test SenML JSON parsing and resolution" {
+    // Example from RFC 8428 Section 5.1.2.
+    const pack =
+        \\[
+        \\  {"bn":"urn:dev:ow:10e2073a01080063:","n":"voltage","u":"V","v":120.1},
+        \\  {"n":"current","u":"A","v":1.2}
+        \\]
+    ;
+
+    var reader = try JsonReader.init(pack);
+    var resolver = Resolver{};
+
+    var buf: [64]u8 = undefined;
+    var fbs = std.io.fixedBufferStream(&buf);
+    const voltage = try resolver.resolve((try reader.next()).?);
+    try voltage.writeName(fbs.writer());
+    try testing.expectEqualStrings("urn:dev:ow:10e2073a01080063:voltage", fbs.getWritten());
+    try testing.expectEqualStrings("V", voltage.unit.?);
+    try testing.expect(std.math.approxEqAbs(f64, voltage.value.number, 120.1, 1e-9));
+    try testing.expect(voltage.isRelativeTime());
+
+    fbs.reset();
+    const current = try resolver.resolve((try reader.next()).?);
+    try current.writeName(fbs.writer());
+    try testing.expectEqualStrings("urn:dev:ow:10e2073a01080063:current", fbs.getWritten());
+    try testing.expect(std.math.approxEqAbs(f64, current.value.number, 1.2, 1e-9));
+
+    try testing.expect((try reader.next()) == null);
+
+    var unknown = try JsonReader.init("[{\"n\":\"x\",\"foo_\":1}]");
+    try testing.expectError(error.UnsupportedField, unknown.next());
+}
+
+test "test SenML payload serialization" {
+    const records = [_]Record{
+        .{ .base_name = "urn:dev:mac:0024befffe804ff1/", .base_time = 1276020076, .base_unit = "Cel", .name = "temp", .value = .{ .number = 23.5 } },
+        .{ .name = "temp", .time = -5, .value = .{ .number = 23.6 } },
+        .{ .name = "open", .value = .{ .boolean = true } },
+        .{ .name = "raw", .value = .{ .data = &[_]u8{ 0xde, 0xad, 0xbe, 0xef } } },
+    };
+
+    var buf: [256]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.ack, codes.CONTENT, &[_]u8{}, 1);
+    try resp.writeSenML(ContentFormat.senml_json, &records);
+
+    var req = try pkt.Request.init(resp.marshal());
+    const payload = try req.extractPayload();
+    try testing.expectEqualStrings(
+        \\[{"bn":"urn:dev:mac:0024befffe804ff1/","bt":1276020076,"bu":"Cel","n":"temp","v":23.5},{"n":"temp","v":23.6,"t":-5},{"n":"open","vb":true},{"n":"raw","vd":"3q2-7w"}]
+    , payload.?);
+
+    for ([_]ContentFormat{ ContentFormat.senml_cbor, ContentFormat.senml_json }) |format| {
+        resp = try pkt.Response.init(&buf, pkt.Msg.ack, codes.CONTENT, &[_]u8{}, 1);
+        try resp.writeSenML(format, &records);
+
+        req = try pkt.Request.init(resp.marshal());
+        var reader = try req.senMLReader();
+        var resolver = Resolver{};
+
+        _ = try resolver.resolve((try reader.next()).?);
+        const second = try resolver.resolve((try reader.next()).?);
+        try testing.expectEqualStrings("Cel", second.unit.?);
+        try testing.expect(second.time == 1276020071);
+        try testing.expect(std.math.approxEqAbs(f64, second.value.number, 23.6, 1e-9));
+
+        const open = try resolver.resolve((try reader.next()).?);
+        try testing.expect(open.value.boolean);
+        _ = try reader.next();
+        try testing.expect((try reader.next()) == null);
+    }
+
+    try testing.expectError(error.InvalidArgument, resp.writeSenML(ContentFormat.cbor, &records));
+}
@@ -0,0 +1,3220 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+const opts = @import("opts.zig");
+const res = @import("resource.zig");
+const dedup = @import("dedup.zig");
+const cache = @import("cache.zig");
+const observe = @import("observe.zig");
+const uri = @import("uri.zig");
+const Job = @import("executor.zig").Job;
+const Executor = @import("executor.zig").Executor;
+const Token = @import("token.zig").Token;
+const DEFAULT_TOKEN_LEN = @import("token.zig").DEFAULT_LEN;
+const BlockValue = @import("block.zig").BlockValue;
+const ContentFormat = @import("contentformat.zig").ContentFormat;
+
+// From RFC 7252:
+//
+//  If the Path MTU is not known for a destination, an IP MTU of 1280
+//  bytes SHOULD be assumed; if nothing is known about the size of the
+//  headers, good upper bounds are 1152 bytes for the message size and
+//  1024 bytes for the payload size.
+//
+pub const MAX_MESSAGE_SIZE = 1152;
+
+// From RFC 7252:
+//
+//  For a new Confirmable message, the initial timeout is set to a
+//  random duration (often not an integral number of seconds) between
+//  ACK_TIMEOUT and (ACK_TIMEOUT * ACK_RANDOM_FACTOR), and the
+//  retransmission counter is set to 0.
+//
+// Default transmission parameters (see RFC 7252 Section 4.8), times
+// are given in milliseconds.
+pub const ACK_TIMEOUT = 2000;
+pub const MAX_ACK_TIMEOUT = ACK_TIMEOUT * 3 / 2; // ACK_RANDOM_FACTOR = 1.5
+pub const MAX_RETRANSMIT = 4;
+
+// Maximum time from the first transmission of a confirmable message to
+// the time the sender gives up on receiving an acknowledgement (see
+// RFC 7252 Section 4.8.2), used as timeout of forwarded requests.
+pub const MAX_TRANSMIT_WAIT = MAX_ACK_TIMEOUT * ((1 << (MAX_RETRANSMIT + 1)) - 1);
+
+// From RFC 7252:
+//
+//  In order not to cause congestion, clients (including proxies) MUST
+//  strictly limit the number of simultaneous outstanding interactions
+//  that they maintain to a given server (including proxies) to NSTART.
+//
+// The server applies the same limit to the confirmable messages it
+// sends to each endpoint, e.g. notifications.
+pub const NSTART = 1;
+
+// Maximum time in milliseconds between two confirmable notifications to
+// an observer. Non-confirmable notifications are sent as confirmable
+// ones after this time, allowing the server to detect observers which
+// lost interest (see RFC 7641 Section 4.5).
+pub const CON_NOTIFICATION_INTERVAL = 24 * 60 * 60 * 1000;
+
+// From RFC 7252:
+//
+//  PROCESSING_DELAY is the time a node takes to turn around a
+//  Confirmable message into an acknowledgement.  We assume the node
+//  will attempt to send an ACK before having the sender time out, so as
+//  a conservative assumption we set it equal to ACK_TIMEOUT.
+//
+pub const PROCESSING_DELAY = ACK_TIMEOUT;
+
+// From RFC 7252:
+//
+//  If no estimate for G or R can be determined, a default value of
+//  DEFAULT_LEISURE should be used.
+//
+// The DEFAULT_LEISURE is 5 seconds (see RFC 7252 Section 4.8).
+pub const DEFAULT_LEISURE = 5000;
+
+/// The "All CoAP Nodes" IPv4 multicast address 224.0.1.187, which must
+/// be joined by the transport for receiving multicast requests (see RFC
+/// 7252 Section 12.8).
+pub const ALL_COAP_NODES_IPV4 = [4]u8{ 224, 0, 1, 187 };
+/// The link-local "All CoAP Nodes" IPv6 multicast address FF02::FD.
+pub const ALL_COAP_NODES_IPV6_LINK_LOCAL = [16]u8{ 0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xfd };
+/// The site-local "All CoAP Nodes" IPv6 multicast address FF05::FD.
+pub const ALL_COAP_NODES_IPV6_SITE_LOCAL = [16]u8{ 0xff, 0x05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xfd };
+
+/// Configuration of the memory statically allocated by a server.
+pub const Config = struct {
+    /// Number of received messages remembered for the detection of
+    /// duplicates, see dedup.Deduplicator.
+    dedup_entries: usize = 8,
+    /// Maximum size of a reply stored for answering duplicates. Larger
+    /// replies are not stored, retransmissions of the request are then
+    /// ignored instead of being processed again. The default is the
+    /// size of the largest message sent by the server, e.g. a Block2
+    /// response carrying 1024 bytes of payload.
+    dedup_reply_size: usize = MAX_MESSAGE_SIZE,
+    /// Number of requests which may await a separate response at the
+    /// same time.
+    deferred_requests: usize = 4,
+    /// Number of messages queued for transmission by the server at the
+    /// same time, i.e. confirmable messages awaiting an acknowledgement
+    /// and delayed responses to multicast requests.
+    pending_messages: usize = 4,
+    /// Maximum size of a message queued for transmission.
+    pending_size: usize = 256,
+    /// Number of observers (see RFC 7641) which can be registered at
+    /// the same time.
+    observers: usize = 4,
+    /// Maximum size of the registration request of an observer, which
+    /// is retained for creating notifications. Clients sending larger
+    /// requests are not registered as observers.
+    observe_request_size: usize = 64,
+    /// Maximum size of a response created by a handler. Responses with
+    /// payloads larger than the block size are sent block-wise.
+    response_size: usize = 2048,
+    /// Number of request bodies which can be received block-wise (see
+    /// RFC 7959 Section 2.5) at the same time.
+    transfers: usize = 1,
+    /// Maximum size of a request body received block-wise, larger
+    /// bodies are rejected with 4.13 (Request Entity Too Large).
+    request_size: usize = 2048,
+    /// Number of responses to GET and FETCH requests cached by the
+    /// server, see cache.ResponseCache. Disabled by default.
+    cache_entries: usize = 0,
+    /// Maximum size of a cached response including the cache key of
+    /// its request, larger responses are not cached.
+    cache_size: usize = 256,
+    /// Number of requests which can be forwarded to origin servers at
+    /// the same time, see Server. Disabled by default, i.e. requests
+    /// with a Proxy-Uri or Proxy-Scheme option are answered with 5.05
+    /// (Proxying Not Supported).
+    proxy_exchanges: usize = 0,
+    /// Maximum size of a forwarded request whose response is cached,
+    /// see cache_entries.
+    proxy_request_size: usize = 128,
+    /// Number of requests which can be handed to the executor of the
+    /// server at the same time, including requests waiting for an
+    /// earlier request with the same endpoint and token to complete.
+    /// Disabled by default, i.e. all handlers are invoked by the thread
+    /// calling handle.
+    jobs: usize = 0,
+    /// Maximum size of a request handed to the executor, larger
+    /// requests are handled by the thread calling handle.
+    job_request_size: usize = 256,
+    /// Number of requests sent by the server itself which may await a
+    /// response at the same time, see Server.request. Disabled by
+    /// default.
+    client_requests: usize = 0,
+};
+
+/// CoAP server receiving requests from the given datagram transport
+/// and answering them using a Dispatcher. Since this library does not
+/// use any OS-specific code, the transport (e.g. a UDP socket in a POSIX
+/// environment) must be provided by the caller. The Transport type must
+/// declare the following members:
+///
+///  - `Endpoint`, the type used to identify the sender of a datagram
+///    (e.g. `std.net.Address` for UDP sockets), endpoints are compared
+///    using its `eql` method if declared (see dedup.equalEndpoint),
+///  - `fn recv(self: *Transport, buf: []u8, from: *Endpoint) !?usize`
+///    which waits for a datagram, copies it to the given buffer, and
+///    returns its length. If no datagram is received within a timeout
+///    chosen by the transport, null is returned. This allows the server
+///    to retransmit confirmable messages in the meantime.
+///  - `fn send(self: *Transport, to: Endpoint, data: []const u8) !void`
+///    which sends the given datagram to the given endpoint,
+///  - `fn now(self: *Transport) u64` which returns the current time in
+///    milliseconds using an arbitrary monotonic clock.
+///
+/// Transports which joined the "All CoAP Nodes" multicast groups (e.g.
+/// ALL_COAP_NODES_IPV4) may additionally declare a method `fn
+/// multicast(self: *Transport) bool` which returns whether the datagram
+/// received last was addressed to a multicast group, see
+/// handleMulticast.
+///
+/// Transports which authenticate their peers (e.g. using DTLS or OSCORE)
+/// may declare a method `fn identity(self: *Transport, from: Endpoint)
+/// res.Peer` which returns the identity of the given endpoint, used for
+/// the access control of resources. Otherwise, the bytes of the
+/// Endpoint are used as its address.
+///
+/// Duplicate requests (see RFC 7252 Section 4.5) are detected using the
+/// Message ID, retransmitted confirmable requests are answered with the
+/// original reply and duplicate non-confirmable requests are ignored.
+/// Responses to confirmable requests are piggybacked in the
+/// acknowledgement if the handler returns within the processing delay.
+/// Otherwise, the request is acknowledged by an empty message and the
+/// response is sent as separate confirmable message. The handler may
+/// also defer the response explicitly by returning res.SEPARATE, in
+/// which case the response is sent later on using sendSeparate.
+/// Clients can observe resources which are marked as observable, see
+/// notify. Responses whose payload exceeds the block size are
+/// transparently sliced into Block2 responses (see RFC 7959), the
+/// handler is invoked again for each block requested by the client.
+/// Similarly, request bodies sent using Block1 are reassembled by the
+/// server and the handler is invoked once with the complete body.
+/// Requests including unrecognized critical options are rejected with
+/// 4.02 (Bad Option), see known_options. Optionally, responses to GET
+/// and FETCH requests are cached while they are fresh according to
+/// their Max-Age option (see Config.cache_entries), i.e. the handler is
+/// not invoked for repeated requests. State changes must be announced
+/// using notify, which discards all cached responses, or by successful
+/// requests with unsafe methods (e.g. PUT).
+///
+/// Transports may declare a method `fn resolve(self: *Transport, scheme:
+/// uri.Scheme, host: []const u8, port: u16) ?Endpoint` which returns the
+/// endpoint of the given origin server or null if it is unknown. If
+/// Config.proxy_exchanges is not zero, the server then acts as forward
+/// proxy (see RFC 7252 Section 5.7.2): requests with a Proxy-Uri or
+/// Proxy-Scheme option are forwarded to the origin server using a new
+/// Message ID and token, its response is relayed to the client as
+/// separate response. Responses are cached like responses of local
+/// resources. Observations are not forwarded, i.e. the Observe option
+/// is removed from forwarded requests. If the origin server does not
+/// respond within MAX_TRANSMIT_WAIT, the client is sent 5.04 (Gateway
+/// Timeout).
+///
+/// If Server.executor is set and Config.jobs is not zero, handlers are
+/// invoked concurrently by the executor (e.g. on a pool of worker
+/// threads) instead of the thread calling handle. Requests handed to
+/// the executor are acknowledged right away and answered by a separate
+/// response once the handler has returned, see poll. Requests from the
+/// same endpoint with the same token are executed one after another in
+/// the order they were received, while requests from other endpoints or
+/// with other tokens are executed concurrently. Observe registrations,
+/// block-wise transfers, and requests for the discovery document are
+/// always handled by the thread calling handle.
+///
+/// If the Transport declares a method `fn close(self: *Transport) void`,
+/// it is invoked once the server has been shut down, see shutdown.
+pub fn Server(comptime Transport: type) type {
+    return ServerWithConfig(Transport, Config{});
+}
+
+/// Like Server but with the given configuration.
+pub fn ServerWithConfig(comptime Transport: type, comptime config: Config) type {
+    return struct {
+        transport: Transport,
+        dispatcher: res.Dispatcher,
+        /// Message ID of the next confirmable message sent by the server,
+        /// should be initialized randomly (see RFC 7252 Section 4.4).
+        message_id: u16 = 0,
+        /// Source of randomness for the initial retransmission timeout
+        /// and the delay of responses to multicast requests, if null
+        /// neither is randomized.
+        random: ?std.rand.Random = null,
+        /// Maximum time in milliseconds a handler may take for its
+        /// response to be piggybacked in the acknowledgement. Since the
+        /// client retransmits the request after ACK_TIMEOUT, slower
+        /// responses are sent as separate confirmable message which is
+        /// retransmitted by the server until it is acknowledged.
+        processing_delay: u64 = PROCESSING_DELAY,
+        /// Period in milliseconds within which responses to multicast
+        /// requests are sent, the response is delayed randomly within
+        /// this period to avoid congestion (see RFC 7252 Section 8.2).
+        leisure: u64 = DEFAULT_LEISURE,
+        /// Executor invoking handlers concurrently, see Server. Handlers
+        /// must then be thread-safe and resources must not be added or
+        /// removed at runtime while jobs are running. The server must
+        /// not be moved while jobs are running.
+        executor: ?Executor = null,
+        /// Observe value of the next notification.
+        sequence: u24 = 0,
+        /// Size exponent of the largest block sent by the server, larger
+        /// blocks requested by clients are reduced to this size. Clients
+        /// sending larger blocks are asked to use this size too. The
+        /// default corresponds to 1024 bytes, i.e. the maximum payload
+        /// size recommended by RFC 7252.
+        block_szx: u3 = 6,
+        /// Numbers of critical options which are not defined in opts.zig
+        /// but processed by the handlers. Requests including other
+        /// unrecognized critical options are rejected by the server.
+        known_options: []const u32 = &[_]u32{},
+        // Time by which the server must be closed, set by shutdown.
+        deadline: ?u64 = null,
+        closed: bool = false,
+        duplicates: dedup.Deduplicator(Endpoint, config.dedup_entries, config.dedup_reply_size) = .{},
+        deferred: [config.deferred_requests]?Deferred = [_]?Deferred{null} ** config.deferred_requests,
+        pending: [config.pending_messages]?Transmission = [_]?Transmission{null} ** config.pending_messages,
+        observers: [config.observers]?Observer = [_]?Observer{null} ** config.observers,
+        transfers: [config.transfers]?Transfer = [_]?Transfer{null} ** config.transfers,
+        exchanges: [config.proxy_exchanges]?Exchange = [_]?Exchange{null} ** config.proxy_exchanges,
+        works: [config.jobs]?Work = [_]?Work{null} ** config.jobs,
+        queries: [config.client_requests]?Query = [_]?Query{null} ** config.client_requests,
+        // Endpoint which sent the request being dispatched, see sender.
+        source: ?Endpoint = null,
+        // Number of requests handed to the executor so far, used for
+        // executing requests with the same endpoint and token in order.
+        submitted: u64 = 0,
+        responses: cache.ResponseCache(config.cache_entries, config.cache_size) = .{},
+        rbuf: [MAX_MESSAGE_SIZE]u8 = undefined,
+        response_buf: [config.response_size]u8 = undefined,
+        bbuf: [MAX_MESSAGE_SIZE]u8 = undefined,
+        request_buf: [MAX_MESSAGE_SIZE + config.request_size]u8 = undefined,
+
+        const Self = @This();
+        pub const Endpoint = Transport.Endpoint;
+
+        // Request awaiting a separate response.
+        const Deferred = struct {
+            endpoint: Endpoint,
+            token: Token,
+        };
+
+        // Message queued for transmission, confirmable messages are
+        // retransmitted until they are acknowledged.
+        const Transmission = struct {
+            endpoint: Endpoint,
+            message_id: u16,
+            buf: [config.pending_size]u8,
+            len: usize,
+            // Time of the next (re)transmission.
+            timeout: u64,
+            // Current timeout, doubled on each retransmission.
+            interval: u64,
+            // Number of times the message has been sent.
+            transmissions: u8 = 0,
+            confirmable: bool,
+        };
+
+        // Client registered as observer of a resource.
+        const Observer = struct {
+            endpoint: Endpoint,
+            resource: *const res.Resource,
+            token: Token,
+            // Copy of the registration request, used for invoking the
+            // handler of the resource for each notification.
+            request: [config.observe_request_size]u8,
+            len: usize,
+            // Message ID of the latest notification.
+            message_id: ?u16 = null,
+            periods: observe.Periods = .{},
+            // Time of the latest notification or the registration.
+            sent: u64,
+            // Time of the latest confirmable notification or the
+            // registration.
+            confirmed: u64,
+            // Type of the latest notification.
+            last: pkt.Msg = pkt.Msg.non,
+            // Type of a coalesced notification, see notify.
+            pending: ?pkt.Msg = null,
+        };
+
+        // Request body received block-wise.
+        const Transfer = struct {
+            endpoint: Endpoint,
+            // Hash of the method and URI of the request.
+            key: u64,
+            body: [config.request_size]u8,
+            len: usize,
+            // Time the latest block was received.
+            updated: u64,
+        };
+
+        // Request forwarded to an origin server.
+        const Exchange = struct {
+            client: Endpoint,
+            // Token of the request received from the client.
+            token: Token,
+            confirmable: bool,
+            origin: Endpoint,
+            // Message ID and token of the forwarded request.
+            message_id: u16,
+            origin_token: Token,
+            // Time at which the client is sent 5.04 (Gateway Timeout).
+            expires: u64,
+            // Copy of the request received from the client, used as
+            // cache key of the response. Empty if the response is not
+            // cached.
+            request: [config.proxy_request_size]u8,
+            len: usize,
+
+            // Type of the response relayed to the client.
+            fn responseType(self: *const Exchange) pkt.Msg {
+                return if (self.confirmable) pkt.Msg.con else pkt.Msg.non;
+            }
+        };
+
+        // Request handed to the executor.
+        const Work = struct {
+            endpoint: Endpoint,
+            token: Token,
+            // Position of the request in the order requests were handed
+            // to the executor.
+            sequence: u64,
+            // Whether the job has been submitted to the executor, it is
+            // only submitted once all earlier requests with the same
+            // endpoint and token are completed.
+            running: bool = false,
+            // Whether the request may change the state of a resource.
+            unsafe: bool,
+            job: Job,
+            request: [config.job_request_size]u8,
+            len: usize,
+            response: [config.pending_size]u8,
+        };
+
+        // Request sent by the server, see request.
+        const Query = struct {
+            endpoint: Endpoint,
+            message_id: u16,
+            token: Token,
+            handler: ResponseHandler,
+            // Time at which the handler is invoked without a response.
+            expires: u64,
+        };
+
+        /// Handler for the response to a request sent using request. It
+        /// is invoked with null if no response was received within
+        /// MAX_TRANSMIT_WAIT.
+        pub const ResponseHandler = fn (from: Endpoint, resp: ?*const pkt.Request) void;
+
+        /// Handle the given message received from the given endpoint at
+        /// the given time. Returns the reply which must be sent to the
+        /// endpoint or null if no reply must be sent.
+        pub fn handle(self: *Self, data: []const u8, from: Endpoint, now: u64) !?[]const u8 {
+            // Messages with a malformed header are silently ignored, at
+            // least for now. Rejecting them would require parsing the
+            // Message ID. Options are parsed on demand while processing
+            // the message, messages with malformed options are rejected.
+            var req = pkt.Request.init(data) catch return null;
+            return self.process(&req, from, now) catch |err| {
+                if (!isMalformed(err))
+                    return err;
+                return self.reject(&req, err, from, now);
+            };
+        }
+
+        // Reply to the given message whose options or payload are
+        // malformed, see isMalformed. Confirmable requests with options
+        // violating their definition (e.g. a repeated Content-Format) are
+        // answered with 4.02 (Bad Option) and those exceeding limits with
+        // 4.00 (Bad Request). Other confirmable messages are rejected
+        // with a reset (see RFC 7252 Section 4.2), non-confirmable
+        // messages are ignored.
+        fn reject(self: *Self, req: *const pkt.Request, err: anyerror, from: Endpoint, now: u64) !?[]const u8 {
+            const hdr = req.header;
+            if (hdr.type != pkt.Msg.con) {
+                self.duplicates.insert(from, hdr.message_id, dedup.NON_LIFETIME, &[_]u8{}, now);
+                return null;
+            }
+
+            const code: ?codes.Code = if (!hdr.code.isRequest()) null else switch (err) {
+                error.RepeatedOption, error.InvalidLength => codes.BAD_OPT,
+                error.InvalidString, error.LimitExceeded => codes.BAD_REQ,
+                else => null,
+            };
+            var resp = if (code) |c|
+                try self.dispatcher.reply(req, pkt.Msg.ack, c)
+            else
+                try pkt.Response.init(&self.dispatcher.rbuf, pkt.Msg.rst, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, hdr.message_id);
+
+            const reply = resp.marshal();
+            self.duplicates.insert(from, hdr.message_id, dedup.EXCHANGE_LIFETIME, reply, now);
+            return reply;
+        }
+
+        // Process the given message received from the given endpoint,
+        // see handle.
+        fn process(self: *Self, req: *pkt.Request, from: Endpoint, now: u64) !?[]const u8 {
+            const hdr = req.header;
+            if (!hdr.code.isRequest()) {
+                // Confirmable responses are processed only once, but each
+                // retransmission is acknowledged (see RFC 7252 Section 4.5).
+                // Otherwise, a retransmitted response received after the
+                // request was completed would be rejected with a reset.
+                if (hdr.type == pkt.Msg.con and !hdr.code.isEmpty()) {
+                    if (self.duplicates.lookup(from, hdr.message_id, now)) |prev| {
+                        if (prev.len == 0)
+                            return null;
+                        return prev;
+                    }
+                }
+                if (!hdr.code.isEmpty() and hdr.type != pkt.Msg.rst) {
+                    // Responses of origin servers which cannot be relayed
+                    // yet are not acknowledged, thus the origin server
+                    // retransmits them (see relay).
+                    const relayed = self.relay(req, from, now) catch |err| {
+                        if (err == error.LimitExceeded)
+                            return null;
+                        return err;
+                    };
+                    if (relayed or self.deliver(req, from)) {
+                        if (hdr.type != pkt.Msg.con)
+                            return null;
+                        var ack = try pkt.Response.init(&self.dispatcher.rbuf, pkt.Msg.ack, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, hdr.message_id);
+                        const reply = ack.marshal();
+                        self.duplicates.insert(from, hdr.message_id, dedup.EXCHANGE_LIFETIME, reply, now);
+                        return reply;
+                    }
+                }
+
+                switch (hdr.type) {
+                    pkt.Msg.ack => self.acknowledge(from, hdr.message_id),
+                    // A reset indicates that the peer is unable to
+                    // process the message, thus it is not retransmitted
+                    // either. If the message is a notification, the
+                    // observer is removed (see RFC 7641 Section 3.6).
+                    pkt.Msg.rst => {
+                        self.acknowledge(from, hdr.message_id);
+                        self.forget(from, hdr.message_id);
+                    },
+                    // Empty confirmable messages (i.e. CoAP pings) and
+                    // confirmable responses are rejected with a reset.
+                    pkt.Msg.con => {
+                        var rst = try pkt.Response.init(&self.dispatcher.rbuf, pkt.Msg.rst, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, hdr.message_id);
+                        return rst.marshal();
+                    },
+                    pkt.Msg.non => {},
+                }
+                return null;
+            }
+
+            if (self.duplicates.lookup(from, hdr.message_id, now)) |prev| {
+                if (prev.len == 0)
+                    return null;
+                return prev;
+            }
+            if (self.deadline != null) {
+                var unavailable = try self.dispatcher.reply(req, replyType(req), codes.UNAVAILABLE);
+                return unavailable.marshal();
+            }
+
+            const proxied = try isProxyRequest(req);
+            var resp = if (proxied) try self.forward(req, from, now) else try self.dispatch(req, from, now);
+            if (resp.header.code.isEmpty()) {
+                // Forwarded requests are answered once the response of
+                // the origin server is received, see relay. If no further
+                // request can be deferred, the request is answered with
+                // 5.03 (Service Unavailable) instead.
+                if (!proxied) {
+                    self.postpone(from, req.token) catch {
+                        resp = try self.dispatcher.reply(req, resp.header.type, codes.UNAVAILABLE);
+                    };
+                }
+            } else if (hdr.type == pkt.Msg.con) {
+                const end = self.transport.now();
+                const elapsed = if (end > now) end - now else 0;
+                if (elapsed > self.processing_delay and self.separate(from, &resp, end))
+                    resp = try pkt.Response.init(&self.dispatcher.rbuf, pkt.Msg.ack, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, hdr.message_id);
+            }
+
+            // From RFC 7252:
+            //
+            //  The recipient SHOULD acknowledge each duplicate copy of a
+            //  Confirmable message using the same Acknowledgement or
+            //  Reset message but SHOULD process any request or response
+            //  in the message only once.
+            //
+            // For Non-confirmable messages, the recipient SHOULD silently
+            // ignore any duplicated message.
+            if (hdr.type == pkt.Msg.con) {
+                const reply = resp.marshal();
+                self.duplicates.insert(from, hdr.message_id, dedup.EXCHANGE_LIFETIME, reply, now);
+                return reply;
+            }
+
+            self.duplicates.insert(from, hdr.message_id, dedup.NON_LIFETIME, &[_]u8{}, now);
+            if (resp.header.code.isEmpty())
+                return null;
+            return resp.marshal();
+        }
+
+        /// Handle the given message received from the given endpoint via
+        /// multicast (see RFC 7252 Section 8). Confirmable messages and
+        /// requests with non-idempotent methods (e.g. POST) are ignored.
+        /// Only successful responses are sent, they are queued and sent
+        /// by poll after a random delay within the leisure period.
+        pub fn handleMulticast(self: *Self, data: []const u8, from: Endpoint, now: u64) !void {
+            var req = pkt.Request.init(data) catch return;
+
+            const hdr = req.header;
+            if (hdr.type != pkt.Msg.non or !hdr.code.isIdempotent() or self.deadline != null)
+                return;
+            if (self.duplicates.lookup(from, hdr.message_id, now) != null)
+                return;
+            self.duplicates.insert(from, hdr.message_id, dedup.NON_LIFETIME, &[_]u8{}, now);
+
+            // From RFC 7252:
+            //
+            //  When a server is aware that a request arrived via
+            //  multicast, the server MAY always ignore the request, in
+            //  particular if it doesn't have anything useful to respond
+            //  (e.g., if it only has an empty payload or an error
+            //  response).
+            //
+            // Since only non-confirmable requests are processed, requests
+            // with malformed options are ignored (see handle).
+            var resp = self.dispatch(&req, from, now) catch |err| {
+                if (isMalformed(err))
+                    return;
+                return err;
+            };
+            if (!resp.header.code.isSuccess())
+                return;
+
+            // Responses which cannot be queued are dropped, as if they
+            // were lost in the network.
+            self.enqueue(from, resp.marshal(), resp.header.message_id, now + self.leisureDelay(), false) catch {};
+        }
+
+        /// Send a separate response for a request whose handler returned
+        /// res.SEPARATE. The request is identified by the token of the
+        /// given response, which must be created by the caller (e.g.
+        /// using Response.init with a Token copied from the request). The
+        /// type and Message ID of the response are set by the server, it
+        /// is sent as confirmable message and retransmitted until it is
+        /// acknowledged (see poll).
+        pub fn sendSeparate(self: *Self, resp: *pkt.Response, now: u64) !void {
+            try self.respond(null, resp, now);
+            return self.poll(now);
+        }
+
+        // Queue the given separate response for the deferred request with
+        // the token of the response, see sendSeparate. If to is not null,
+        // the request must have been received from the given endpoint.
+        fn respond(self: *Self, to: ?Endpoint, resp: *pkt.Response, now: u64) !void {
+            for (self.deferred) |*entry| {
+                const request = entry.* orelse continue;
+                if (!request.token.equal(resp.token))
+                    continue;
+                if (to) |endpoint| {
+                    if (!dedup.equalEndpoint(Endpoint, request.endpoint, endpoint))
+                        continue;
+                }
+
+                resp.setType(pkt.Msg.con);
+                resp.setMessageID(self.nextMessageID());
+                try self.enqueue(request.endpoint, resp.marshal(), resp.header.message_id, now, true);
+
+                entry.* = null;
+                return;
+            }
+
+            return error.UnknownToken;
+        }
+
+        /// Send a confirmable request with the given method for the given
+        /// path to the given endpoint, e.g. for fetching the links of an
+        /// endpoint using simple registration with a Resource Directory
+        /// (see rd.Directory). The request is sent by the next invocation
+        /// of poll and retransmitted until it is acknowledged, thus this
+        /// function may be called by resource handlers. Once the response
+        /// is received, the given handler is invoked by handle. Returns
+        /// error.LimitExceeded if Config.client_requests requests already
+        /// await a response.
+        pub fn request(self: *Self, to: Endpoint, method: codes.Code, path: []const u8, handler: ResponseHandler, now: u64) !void {
+            std.debug.assert(method.isRequest());
+            const slot = self.freeQuery() orelse return error.LimitExceeded;
+
+            const message_id = self.nextMessageID();
+            const token = try self.newToken(message_id);
+            var msg = try pkt.Response.init(&self.bbuf, pkt.Msg.con, method, token.slice(), message_id);
+            try msg.addURIPath(path);
+            try self.enqueue(to, msg.marshal(), message_id, now, true);
+
+            slot.* = Query{
+                .endpoint = to,
+                .message_id = message_id,
+                .token = token,
+                .handler = handler,
+                .expires = now + MAX_TRANSMIT_WAIT,
+            };
+        }
+
+        /// Returns the endpoint which sent the request being dispatched
+        /// or null if no request is dispatched. This allows handlers to
+        /// obtain the source address of a request, e.g. as base URI of a
+        /// registration with a Resource Directory. Since the endpoint is
+        /// only known to the thread calling handle, it is always null
+        /// for handlers invoked by the executor.
+        pub fn sender(self: *const Self) ?Endpoint {
+            return self.source;
+        }
+
+        /// Send a notification to all observers of the resource with the
+        /// given path as message of the given type, e.g. after the state
+        /// of the resource changed (see RFC 7641 Section 4.2). For each
+        /// observer, the handler of the resource is invoked to create the
+        /// notification. If the handler does not return a 2.xx code, the
+        /// observer is removed after sending the response. Confirmable
+        /// notifications are retransmitted until they are acknowledged,
+        /// the observer is removed if they are not acknowledged at all.
+        ///
+        /// Observers may request minimum and maximum notification periods
+        /// using the pmin and pmax query parameters of their registration
+        /// (see observe.Periods). If the minimum period has not passed
+        /// since the latest notification to an observer, state changes
+        /// are coalesced into a single notification which is sent by poll
+        /// once the period has passed. Similarly, poll sends a
+        /// notification if none was sent within the maximum period.
+        ///
+        /// To avoid congestion, only one confirmable message is
+        /// outstanding per endpoint (see NSTART) and a notification
+        /// replaces the previous one if it has not been acknowledged yet.
+        /// Non-confirmable notifications are sent as confirmable ones if
+        /// no confirmable notification was sent to the observer within
+        /// CON_NOTIFICATION_INTERVAL.
+        ///
+        /// If a notification cannot be sent, the remaining observers are
+        /// still notified and the first error is returned afterwards.
+        pub fn notify(self: *Self, path: []const u8, mt: pkt.Msg, now: u64) !void {
+            std.debug.assert(mt == pkt.Msg.con or mt == pkt.Msg.non);
+
+            var failure: ?anyerror = null;
+            for (self.observers) |*entry| {
+                if (entry.*) |*observer| {
+                    if (!observer.resource.matchPath(path))
+                        continue;
+
+                    if (observer.periods.pmin) |pmin| {
+                        if (now < observer.sent + seconds(pmin)) {
+                            // Confirmable notifications are not turned
+                            // into non-confirmable ones by coalescing.
+                            if (observer.pending != pkt.Msg.con)
+                                observer.pending = mt;
+                            continue;
+                        }
+                    }
+                    self.sendNotification(entry, mt, now) catch |err| {
+                        if (failure == null)
+                            failure = err;
+                    };
+                }
+            }
+
+            // Since the state of the resource changed, cached responses
+            // are outdated.
+            self.responses.clear();
+            self.sequence +%= 1;
+            const polled = self.poll(now);
+            if (failure) |err|
+                return err;
+            return polled;
+        }
+
+        /// Remove the resource with the given path, which was added at
+        /// runtime using Dispatcher.add. Observers of the resource are
+        /// sent a non-confirmable 4.04 (Not Found) notification and
+        /// removed (see RFC 7641 Section 4.2). Returns false if no such
+        /// resource exists.
+        pub fn remove(self: *Self, path: []const u8) !bool {
+            const entry = self.dispatcher.findDynamic(path) orelse return false;
+            const resource: *const res.Resource = &entry.*.?;
+
+            for (self.observers) |*slot| {
+                if (slot.*) |*observer| {
+                    if (observer.resource != resource)
+                        continue;
+
+                    var resp = try pkt.Response.init(&self.bbuf, pkt.Msg.non, codes.NOT_FOUND, observer.token.slice(), self.nextMessageID());
+                    try self.transport.send(observer.endpoint, resp.marshal());
+                    slot.* = null;
+                }
+            }
+
+            entry.* = null;
+            self.responses.clear();
+            return true;
+        }
+
+        /// Send all queued messages which are due for transmission at the
+        /// given time. Unacknowledged confirmable messages are
+        /// retransmitted using exponential back-off and discarded after
+        /// MAX_RETRANSMIT retransmissions (see RFC 7252 Section 4.2).
+        /// Invoked by serveOnce, even if no datagram was received. Due
+        /// notifications, timeouts of forwarded requests, and responses
+        /// of completed jobs are sent as well, see notify and Server.
+        /// Handlers of requests sent using request which timed out are
+        /// invoked.
+        pub fn poll(self: *Self, now: u64) !void {
+            self.collectJobs(now);
+            self.notifyDue(now);
+            self.expireQueries(now);
+            self.expireExchanges(now);
+
+            for (self.pending) |*entry| {
+                if (entry.*) |*msg| {
+                    if (now < msg.timeout)
+                        continue;
+                    // Further confirmable messages to the endpoint are
+                    // sent once outstanding ones are acknowledged or
+                    // given up.
+                    if (msg.confirmable and msg.transmissions == 0 and self.outstanding(msg.endpoint) >= NSTART)
+                        continue;
+                    if (msg.transmissions > MAX_RETRANSMIT) {
+                        self.forget(msg.endpoint, msg.message_id);
+                        entry.* = null;
+                        continue;
+                    }
+
+                    if (msg.transmissions > 0)
+                        msg.interval *= 2;
+                    msg.transmissions += 1;
+                    msg.timeout = now + msg.interval;
+
+                    try self.transport.send(msg.endpoint, msg.buf[0..msg.len]);
+                    if (!msg.confirmable)
+                        entry.* = null;
+                }
+            }
+        }
+
+        /// Shut down the server gracefully. Afterwards, new requests are
+        /// answered with 5.03 (Service Unavailable) while exchanges in
+        /// flight are completed: duplicates are still answered, deferred
+        /// requests can still be answered using sendSeparate, and queued
+        /// messages are still retransmitted until they are acknowledged
+        /// or discarded. Once all exchanges are completed or the given
+        /// deadline has passed, the transport is closed by serveOnce and
+        /// serve returns. Remaining exchanges are abandoned.
+        pub fn shutdown(self: *Self, deadline: u64) void {
+            self.deadline = deadline;
+        }
+
+        /// Whether the server has been shut down and closed the
+        /// transport, see shutdown.
+        pub fn isClosed(self: *const Self) bool {
+            return self.closed;
+        }
+
+        /// Receive a single datagram from the transport, send the reply
+        /// to it (if any), and send due retransmissions afterwards.
+        /// Returns error.ServerClosed if the server has been closed.
+        pub fn serveOnce(self: *Self) !void {
+            if (self.closed)
+                return error.ServerClosed;
+
+            var from: Endpoint = undefined;
+            if (try self.transport.recv(&self.rbuf, &from)) |len| {
+                if (self.receivedMulticast()) {
+                    try self.handleMulticast(self.rbuf[0..len], from, self.transport.now());
+                } else if (try self.handle(self.rbuf[0..len], from, self.transport.now())) |reply| {
+                    try self.transport.send(from, reply);
+                }
+            }
+
+            const now = self.transport.now();
+            try self.poll(now);
+
+            const deadline = self.deadline orelse return;
+            if (self.idle() or now >= deadline) {
+                if (comptime @hasDecl(Transport, "close"))
+                    self.transport.close();
+                self.closed = true;
+            }
+        }
+
+        /// Serve requests until the transport returns an error or the
+        /// server has been closed, see shutdown.
+        pub fn serve(self: *Self) !void {
+            while (!self.closed)
+                try self.serveOnce();
+        }
+
+        // Dispatch the given request, once its body has been received
+        // completely, and return the requested block of the response.
+        fn dispatch(self: *Self, req: *pkt.Request, from: Endpoint, now: u64) !pkt.Response {
+            self.dispatcher.buf = &self.response_buf;
+            self.dispatcher.peer = self.identity(&from);
+            self.source = from;
+            defer {
+                self.dispatcher.peer = null;
+                self.source = null;
+            }
+
+            if (try self.rejectOptions(req)) |resp|
+                return resp;
+
+            const mt = replyType(req);
+            const block1 = req.getBlock(opts.Block1) catch return self.dispatcher.reply(req, mt, codes.BAD_REQ);
+            const block2 = req.getBlock(opts.Block2) catch return self.dispatcher.reply(req, mt, codes.BAD_REQ);
+            const size2 = (try req.getUint(opts.Size2)) != null;
+
+            const use_cache = block1 == null and try self.cacheable(req);
+            if (use_cache) {
+                if (try self.responses.lookup(req, now / 1000)) |hit|
+                    return self.fromCache(req, hit, now / 1000);
+            }
+
+            // Request bodies sent in a single message are subject to the
+            // same limit as bodies received block-wise.
+            if (block1 == null and (try payloadLength(req)) > config.request_size)
+                return self.tooLarge(req);
+
+            if (block1 == null and block2 == null) {
+                if (try self.offload(req, from)) |resp|
+                    return resp;
+            }
+
+            var full: pkt.Request = undefined;
+            var target = req;
+            if (block1) |value| {
+                if (try self.reassemble(req, from, value, now, &full)) |resp|
+                    return resp;
+                target = &full;
+            }
+
+            var resp = try self.register(target, from, now);
+            if (resp.header.code.isEmpty())
+                return resp;
+
+            var result = (try self.blockwise(&resp, block1, block2, size2)) orelse resp;
+            if (use_cache and result.header.code.equal(codes.CONTENT)) {
+                try self.responses.insert(req, result.marshal(), now / 1000);
+            } else if (!req.header.code.isSafe() and result.header.code.isSuccess()) {
+                // The request may have changed the state of any resource,
+                // thus all cached responses are considered outdated.
+                self.responses.clear();
+            }
+            return result;
+        }
+
+        // Whether responses to the given request may be cached, i.e.
+        // whether it is a GET or FETCH request which does not register
+        // an observer and is not subject to access control.
+        fn cacheable(self: *Self, req: *const pkt.Request) !bool {
+            if (config.cache_entries == 0 or !req.header.code.isSafe())
+                return false;
+            if ((try req.getObserve()) != null)
+                return false;
+
+            const resource = (try self.dispatcher.find(req)) orelse return true;
+            return resource.access == null;
+        }
+
+        // Create the response to the given request from the given cached
+        // response. The Max-Age option is set to the remaining freshness
+        // lifetime at the given time in seconds (see RFC 7252 Section
+        // 5.6.1).
+        fn fromCache(self: *Self, req: *const pkt.Request, hit: cache.CachedResponse, now: u64) !pkt.Response {
+            const msg = try pkt.Request.init(hit.response);
+            var resp = try self.dispatcher.reply(req, replyType(req), msg.header.code);
+
+            var iter = msg.options();
+            iter.mode = pkt.Mode.strict;
+            iter.enforce_rules = false;
+            while (try iter.next()) |opt| {
+                if (opt.number != opts.MaxAge)
+                    try resp.addOption(&opt);
+            }
+            try resp.addUint(opts.MaxAge, hit.freshness.remaining(now));
+            if (iter.payload()) |payload|
+                try resp.payloadWriter().writeAll(payload);
+            return resp;
+        }
+
+        // Returns a 4.02 (Bad Option) response to the given request if it
+        // includes unrecognized critical options (see RFC 7252 Section
+        // 5.4.1), their numbers are listed in the diagnostic payload.
+        // Returns null if all critical options are recognized.
+        fn rejectOptions(self: *Self, req: *const pkt.Request) !?pkt.Response {
+            var iter = req.unrecognizedOptions(self.known_options);
+            const first = (try iter.next()) orelse return null;
+
+            var resp = try self.dispatcher.reply(req, replyType(req), codes.BAD_OPT);
+            const writer = resp.payloadWriter();
+            try writer.print("unrecognized critical options: {d}", .{first.number});
+
+            var last = first.number;
+            while (try iter.next()) |opt| {
+                // Repeated options are only listed once.
+                if (opt.number == last)
+                    continue;
+                try writer.print(", {d}", .{opt.number});
+                last = opt.number;
+            }
+            return resp;
+        }
+
+        // Forward the given request with a Proxy-Uri or Proxy-Scheme
+        // option to its origin server. Returns an empty message if the
+        // request has been forwarded, the response of the origin server
+        // is relayed later on (see relay). Otherwise, the response to
+        // the request is returned, e.g. a cached response.
+        fn forward(self: *Self, req: *const pkt.Request, from: Endpoint, now: u64) !pkt.Response {
+            const mt = replyType(req);
+            if (config.proxy_exchanges == 0 or !@hasDecl(Transport, "resolve"))
+                return self.dispatcher.reply(req, mt, codes.NO_PROXY);
+
+            // Options which are unsafe to forward must be recognized by
+            // the proxy (see RFC 7252 Section 5.7.1).
+            var iter = req.options();
+            while (try iter.next()) |opt| {
+                if (!opts.isUnsafe(opt.number) or opts.definition(opt.number) != null)
+                    continue;
+                if (std.mem.indexOfScalar(u32, self.known_options, opt.number) == null)
+                    return self.dispatcher.reply(req, mt, codes.BAD_GATEWAY);
+            }
+
+            const use_cache = config.cache_entries > 0 and req.header.code.isSafe() and (try req.getObserve()) == null;
+            if (use_cache) {
+                if (try self.responses.lookup(req, now / 1000)) |hit|
+                    return self.fromCache(req, hit, now / 1000);
+            }
+
+            const slot = self.freeExchange() orelse return self.dispatcher.reply(req, mt, codes.UNAVAILABLE);
+            const client_token = Token.fromSlice(req.token) catch return self.dispatcher.reply(req, mt, codes.BAD_REQ);
+            const message_id = self.nextMessageID();
+            const token = try self.newToken(message_id);
+
+            const confirmable = req.header.type == pkt.Msg.con;
+            var msg = try pkt.Response.init(&self.bbuf, if (confirmable) pkt.Msg.con else pkt.Msg.non, req.header.code, token.slice(), message_id);
+            const origin = self.addTarget(&msg, req) catch |err| {
+                if (err == error.UnsupportedScheme)
+                    return self.dispatcher.reply(req, mt, codes.NO_PROXY);
+                if (err == error.UnknownHost)
+                    return self.dispatcher.reply(req, mt, codes.BAD_GATEWAY);
+                return self.dispatcher.reply(req, mt, codes.BAD_REQ);
+            };
+
+            const proxy_uri = (try req.getString(opts.ProxyURI)) != null;
+            iter = req.options();
+            while (try iter.next()) |opt| {
+                switch (opt.number) {
+                    // Notifications of the origin server would not be
+                    // relayed, thus observations are not forwarded.
+                    opts.ProxyURI, opts.ProxyScheme, opts.Observe => continue,
+                    // The Proxy-Uri option takes precedence over these.
+                    opts.URIHost, opts.URIPort, opts.URIPath, opts.URIQuery => if (proxy_uri) continue,
+                    else => {},
+                }
+                try msg.addOption(&opt);
+            }
+            if (iter.payload()) |payload|
+                try msg.payloadWriter().writeAll(payload);
+
+            self.enqueue(origin, msg.marshal(), message_id, now, confirmable) catch {
+                return self.dispatcher.reply(req, mt, codes.UNAVAILABLE);
+            };
+
+            slot.* = Exchange{
+                .client = from,
+                .token = client_token,
+                .confirmable = confirmable,
+                .origin = origin,
+                .message_id = message_id,
+                .origin_token = token,
+                .expires = now + MAX_TRANSMIT_WAIT,
+                .request = undefined,
+                .len = 0,
+            };
+            if (use_cache and req.data.len <= config.proxy_request_size) {
+                std.mem.copy(u8, &slot.*.?.request, req.data);
+                slot.*.?.len = req.data.len;
+            }
+
+            return pkt.Response.init(&self.dispatcher.rbuf, mt, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, req.header.message_id);
+        }
+
+        // Add the URI options of the given forwarded request to the given
+        // message and return the endpoint of the origin server. The
+        // target is given by the Proxy-Uri option or, for requests with
+        // a Proxy-Scheme option, by the Uri-Host, Uri-Port, Uri-Path, and
+        // Uri-Query options of the request, which are forwarded as is.
+        fn addTarget(self: *Self, msg: *pkt.Response, req: *const pkt.Request) !Endpoint {
+            if (try req.getString(opts.ProxyURI)) |str| {
+                const components = try uri.parse(str);
+                try msg.addURI(str);
+
+                var buf: [uri.MAX_SEGMENT_LEN]u8 = undefined;
+                const host = try uri.decode(&buf, components.host);
+                const port = components.port orelse components.scheme.defaultPort();
+                return self.resolve(components.scheme, host, port) orelse return error.UnknownHost;
+            }
+
+            const name = (try req.getString(opts.ProxyScheme)) orelse return error.InvalidURI;
+            const scheme = std.meta.stringToEnum(uri.Scheme, name) orelse return error.UnsupportedScheme;
+            // Without a Uri-Host option, the target would be the proxy
+            // itself (see RFC 7252 Section 6.5).
+            const host = (try req.getString(opts.URIHost)) orelse return error.InvalidURI;
+            const port = (try req.getUint(opts.URIPort)) orelse scheme.defaultPort();
+            return self.resolve(scheme, host, @intCast(u16, port)) orelse return error.UnknownHost;
+        }
+
+        // Relay the given response of an origin server to the client of
+        // the matching forwarded request. Returns false if the response
+        // does not match any forwarded request. If the response cannot
+        // be queued for transmission (error.LimitExceeded), the exchange
+        // is retained: the response is relayed if the origin server
+        // retransmits it, the client is sent 5.04 (Gateway Timeout) when
+        // the exchange expires otherwise.
+        fn relay(self: *Self, resp: *const pkt.Request, from: Endpoint, now: u64) !bool {
+            for (self.exchanges) |*entry| {
+                if (entry.*) |*exchange| {
+                    if (!exchange.origin_token.equal(resp.token) or !dedup.equalEndpoint(Endpoint, exchange.origin, from))
+                        continue;
+                    if (resp.header.type == pkt.Msg.ack)
+                        self.acknowledge(from, resp.header.message_id);
+
+                    // Responses which cannot be relayed as is, e.g. since
+                    // they are malformed or exceed Config.pending_size,
+                    // are relayed as 5.02 (Bad Gateway).
+                    const message_id = self.nextMessageID();
+                    var msg = self.copyResponse(exchange, resp, message_id) catch
+                        try pkt.Response.init(&self.bbuf, exchange.responseType(), codes.BAD_GATEWAY, exchange.token.slice(), message_id);
+                    try self.enqueue(exchange.client, msg.marshal(), message_id, now, exchange.confirmable);
+
+                    // Responses which cannot be cached are relayed only.
+                    if (exchange.len > 0 and msg.header.code.equal(codes.CONTENT))
+                        self.cacheResponse(exchange, msg.marshal(), now) catch {};
+                    entry.* = null;
+                    return true;
+                }
+            }
+            return false;
+        }
+
+        // Copy the given response of an origin server to the transmission
+        // buffer as response to the client of the given exchange.
+        fn copyResponse(self: *Self, exchange: *const Exchange, resp: *const pkt.Request, message_id: u16) !pkt.Response {
+            var msg = try pkt.Response.init(&self.bbuf, exchange.responseType(), resp.header.code, exchange.token.slice(), message_id);
+            var iter = resp.options();
+            iter.mode = pkt.Mode.strict;
+            iter.enforce_rules = false;
+            while (try iter.next()) |opt|
+                try msg.addOption(&opt);
+            if (iter.payload()) |payload|
+                try msg.payloadWriter().writeAll(payload);
+            if (msg.marshal().len > config.pending_size)
+                return error.BufTooSmall;
+            return msg;
+        }
+
+        // Cache the given response relayed for the given exchange, using
+        // the request of the client as cache key.
+        fn cacheResponse(self: *Self, exchange: *const Exchange, msg: []const u8, now: u64) !void {
+            const request = try pkt.Request.init(exchange.request[0..exchange.len]);
+            try self.responses.insert(&request, msg, now / 1000);
+        }
+
+        // Send 5.04 (Gateway Timeout) to the clients of forwarded
+        // requests which have not been answered by the origin server
+        // within MAX_TRANSMIT_WAIT. If the response cannot be queued,
+        // it is retried by the next invocation.
+        fn expireExchanges(self: *Self, now: u64) void {
+            for (self.exchanges) |*entry| {
+                if (entry.*) |*exchange| {
+                    if (now < exchange.expires)
+                        continue;
+
+                    self.acknowledge(exchange.origin, exchange.message_id);
+                    const message_id = self.nextMessageID();
+                    var msg = pkt.Response.init(&self.bbuf, exchange.responseType(), codes.GATEWAY_TIMEOUT, exchange.token.slice(), message_id) catch continue;
+                    self.enqueue(exchange.client, msg.marshal(), message_id, now, exchange.confirmable) catch continue;
+                    entry.* = null;
+                }
+            }
+        }
+
+        // Invoke the handler of the request sent by the server which
+        // matches the given response. Returns false if the response does
+        // not match any request sent by the server.
+        fn deliver(self: *Self, resp: *const pkt.Request, from: Endpoint) bool {
+            for (self.queries) |*entry| {
+                const query = entry.* orelse continue;
+                if (!query.token.equal(resp.token) or !dedup.equalEndpoint(Endpoint, query.endpoint, from))
+                    continue;
+                if (resp.header.type == pkt.Msg.ack)
+                    self.acknowledge(from, resp.header.message_id);
+
+                entry.* = null;
+                query.handler(from, resp);
+                return true;
+            }
+            return false;
+        }
+
+        // Invoke the handlers of requests sent by the server which have
+        // not been answered within MAX_TRANSMIT_WAIT.
+        fn expireQueries(self: *Self, now: u64) void {
+            for (self.queries) |*entry| {
+                const query = entry.* orelse continue;
+                if (now < query.expires)
+                    continue;
+
+                self.acknowledge(query.endpoint, query.message_id);
+                entry.* = null;
+                query.handler(query.endpoint, null);
+            }
+        }
+
+        fn freeQuery(self: *Self) ?*?Query {
+            for (self.queries) |*entry| {
+                if (entry.* == null)
+                    return entry;
+            }
+            return null;
+        }
+
+        fn freeExchange(self: *Self) ?*?Exchange {
+            for (self.exchanges) |*entry| {
+                if (entry.* == null)
+                    return entry;
+            }
+            return null;
+        }
+
+        // Store the given block of a request body sent using Block1.
+        // Returns the response to the block or null if the body is
+        // complete, in which case the request with the complete body
+        // (the options of the last block and the reassembled payload)
+        // is parsed into full. Blocks of a body are associated using the
+        // endpoint, method, and URI of the request.
+        fn reassemble(self: *Self, req: *const pkt.Request, from: Endpoint, value: BlockValue, now: u64, full: *pkt.Request) !?pkt.Response {
+            const mt = replyType(req);
+            const key = try requestKey(req);
+
+            var iter = req.options();
+            while (try iter.next()) |_| {}
+            const payload = iter.payload() orelse &[_]u8{};
+
+            // From RFC 7959:
+            //
+            //  This new client error status code indicates that the
+            //  server has not received the blocks of the request body
+            //  that it needs to proceed.
+            //
+            // Blocks which do not start at the end of the data received
+            // so far are treated as gaps and abort the transfer.
+            const entry = if (value.num == 0) self.startTransfer(from, key, now) else self.findTransfer(from, key, now);
+            if (entry == null or entry.?.*.?.len != value.offset()) {
+                if (entry) |e|
+                    e.* = null;
+                return try self.dispatcher.reply(req, mt, codes.INCOMPLETE);
+            }
+            const transfer = &entry.?.*.?;
+
+            const size1 = if (value.num == 0) try req.getUint(opts.Size1) else null;
+            if (value.offset() + payload.len > config.request_size or (size1 orelse 0) > config.request_size) {
+                entry.?.* = null;
+                return try self.tooLarge(req);
+            }
+            // All blocks except the last one must have the full size.
+            if (value.more and payload.len != value.size()) {
+                entry.?.* = null;
+                return try self.dispatcher.reply(req, mt, codes.BAD_REQ);
+            }
+
+            std.mem.copy(u8, transfer.body[transfer.len..], payload);
+            transfer.len += payload.len;
+            transfer.updated = now;
+
+            if (value.more) {
+                // If the first block is larger than supported, the client
+                // is asked to use smaller blocks for the remaining ones
+                // (see RFC 7959 Section 2.5).
+                var ack = value;
+                if (value.num == 0 and value.szx > self.block_szx)
+                    ack.szx = self.block_szx;
+
+                var resp = try self.dispatcher.reply(req, mt, codes.CONTINUE);
+                try resp.addBlock(opts.Block1, ack);
+                return resp;
+            }
+
+            var msg = try pkt.Response.initExtended(&self.request_buf, req.header.type, req.header.code, req.token, req.header.message_id);
+            var opt_iter = req.options();
+            opt_iter.mode = pkt.Mode.strict;
+            opt_iter.enforce_rules = false;
+            while (try opt_iter.next()) |opt| {
+                if (opt.number != opts.Block1)
+                    try msg.addOption(&opt);
+            }
+            try msg.payloadWriter().writeAll(transfer.body[0..transfer.len]);
+
+            entry.?.* = null;
+            full.* = try pkt.Request.init(msg.marshal());
+            return null;
+        }
+
+        // Returns a 4.13 (Request Entity Too Large) response to the given
+        // request, whose Size1 option indicates the maximum size of a
+        // request body accepted by the server (see RFC 7959 Section 2.9.3).
+        fn tooLarge(self: *Self, req: *const pkt.Request) !pkt.Response {
+            var resp = try self.dispatcher.reply(req, replyType(req), codes.TOO_LARGE);
+            try resp.addUint(opts.Size1, @intCast(u32, config.request_size));
+            return resp;
+        }
+
+        // Returns the transfer for the first block of a request body,
+        // an existing transfer of the same request is restarted. If no
+        // transfer is free, the least recently updated one is replaced.
+        fn startTransfer(self: *Self, from: Endpoint, key: u64, now: u64) ?*?Transfer {
+            const slot = self.findTransfer(from, key, now) orelse blk: {
+                var oldest: ?*?Transfer = null;
+                for (self.transfers) |*entry| {
+                    const transfer = entry.* orelse break :blk entry;
+                    if (oldest == null or transfer.updated < oldest.?.*.?.updated)
+                        oldest = entry;
+                }
+                break :blk oldest orelse return null;
+            };
+
+            slot.* = Transfer{
+                .endpoint = from,
+                .key = key,
+                .body = undefined,
+                .len = 0,
+                .updated = now,
+            };
+            return slot;
+        }
+
+        // Returns the transfer of the given request, transfers which did
+        // not receive a block within EXCHANGE_LIFETIME are released.
+        fn findTransfer(self: *Self, from: Endpoint, key: u64, now: u64) ?*?Transfer {
+            for (self.transfers) |*entry| {
+                const transfer = entry.* orelse continue;
+                if (transfer.updated + dedup.EXCHANGE_LIFETIME <= now) {
+                    entry.* = null;
+                    continue;
+                }
+
+                if (transfer.key == key and dedup.equalEndpoint(Endpoint, transfer.endpoint, from))
+                    return entry;
+            }
+            return null;
+        }
+
+        // Dispatch the given request, registering or deregistering the
+        // sender as observer if the request includes an Observe option.
+        fn register(self: *Self, req: *pkt.Request, from: Endpoint, now: u64) !pkt.Response {
+            const value = (try req.getObserve()) orelse return self.dispatcher.dispatch(req);
+            if (!req.header.code.isSafe())
+                return self.dispatcher.dispatch(req);
+
+            // From RFC 7641:
+            //
+            //  A client MAY explicitly deregister by issuing a GET request
+            //  that has the Token field set to the token of the
+            //  observation to be cancelled and includes an Observe Option
+            //  with the value set to 1 (deregister).
+            //
+            // A registration with the token of an existing observation
+            // replaces the existing one. Besides GET, observations can be
+            // registered using FETCH (see RFC 8132 Section 2).
+            self.cancel(from, req.token);
+            const resource = (try self.dispatcher.find(req)) orelse return self.dispatcher.dispatch(req);
+            if (value != 0 or !resource.observable or req.data.len > config.observe_request_size)
+                return self.dispatcher.dispatch(req);
+
+            // If the observer cannot be registered, the request is
+            // answered with a response without an Observe option.
+            const slot = self.freeObserver() orelse return self.dispatcher.dispatch(req);
+            const token = Token.fromSlice(req.token) catch return self.dispatcher.dispatch(req);
+            const periods = observe.Periods.fromRequest(req) catch {
+                return self.dispatcher.reply(req, replyType(req), codes.BAD_REQ);
+            };
+
+            var resp = try self.dispatcher.invoke(resource, req, self.sequence);
+            if (!resp.header.code.isSuccess())
+                return resp;
+
+            slot.* = Observer{
+                .endpoint = from,
+                .resource = resource,
+                .token = token,
+                .request = undefined,
+                .len = req.data.len,
+                .periods = periods,
+                .sent = now,
+                .confirmed = now,
+            };
+            std.mem.copy(u8, &slot.*.?.request, req.data);
+
+            self.sequence +%= 1;
+            return resp;
+        }
+
+        // Returns the block of the given response requested using the
+        // given Block2 option value, including the given Block1 option
+        // (if any), or null if the response must be sent unmodified. The
+        // response is not sliced if no block was requested explicitly
+        // and the payload fits into a single block or if the handler
+        // already added a Block2 option. If the response does not
+        // include an ETag, an ETag derived from the payload is added to
+        // each block to allow the client to detect changes of the
+        // representation while retrieving the blocks (see RFC 7959
+        // Section 2.4). The Size2 option is included in the first block
+        // if size2 is true.
+        fn blockwise(self: *Self, resp: *pkt.Response, block1: ?BlockValue, requested: ?BlockValue, size2: bool) !?pkt.Response {
+            const msg = try pkt.Request.init(resp.marshal());
+
+            var iter = msg.options();
+            while (try iter.next()) |_| {}
+            const payload = iter.payload() orelse &[_]u8{};
+
+            // Late negotiation of a smaller block size, see RFC 7959
+            // Section 2.4.
+            var value = requested orelse BlockValue{ .num = 0, .more = false, .szx = self.block_szx };
+            if (value.szx > self.block_szx) {
+                value = value.resize(self.block_szx) catch {
+                    return try pkt.Response.initExtended(&self.bbuf, msg.header.type, codes.BAD_OPT, msg.token, msg.header.message_id);
+                };
+            }
+
+            const partial = (try msg.getBlock(opts.Block2)) == null and (value.num != 0 or payload.len > value.size());
+            if (!partial and block1 == null)
+                return null;
+
+            var start: usize = 0;
+            var end = payload.len;
+            if (partial) {
+                start = value.offset();
+                if (start >= payload.len)
+                    return try pkt.Response.initExtended(&self.bbuf, msg.header.type, codes.BAD_OPT, msg.token, msg.header.message_id);
+                end = std.math.min(payload.len, start + value.size());
+                value.more = end < payload.len;
+            }
+
+            var blk = try pkt.Response.initFrom(&self.bbuf, &msg);
+            if (partial and (try msg.getOpaque(opts.ETag)) == null) {
+                var etag: [4]u8 = undefined;
+                std.mem.writeIntBig(u32, &etag, @truncate(u32, std.hash.Wyhash.hash(0, payload)));
+                try blk.addOption(&opts.Option{ .number = opts.ETag, .value = &etag });
+            }
+            if (block1) |ack|
+                try blk.addBlock(opts.Block1, ack);
+            if (partial) {
+                try blk.addBlock(opts.Block2, value);
+                if (size2 and value.num == 0)
+                    try blk.addUint(opts.Size2, @intCast(u32, payload.len));
+            }
+            try blk.payloadWriter().writeAll(payload[start..end]);
+
+            return blk;
+        }
+
+        // Send a notification to the given observer as message of the
+        // given type, see notify.
+        fn sendNotification(self: *Self, entry: *?Observer, mt: pkt.Msg, now: u64) !void {
+            const observer = &entry.*.?;
+            var req = try pkt.Request.init(observer.request[0..observer.len]);
+            // Access is checked for each notification, thus observers
+            // whose access was revoked are removed.
+            self.dispatcher.buf = &self.response_buf;
+            self.dispatcher.peer = self.identity(&observer.endpoint);
+            defer self.dispatcher.peer = null;
+            var resp = try self.dispatcher.invoke(observer.resource, &req, self.sequence);
+            if (resp.header.code.isEmpty())
+                return; // Separate responses are not supported.
+
+            // Large notifications only include the first block, the
+            // client retrieves the remaining ones using GET requests
+            // (see RFC 7959 Section 3.4).
+            if (try self.blockwise(&resp, null, null, false)) |first|
+                resp = first;
+
+            // If the previous notification has not been acknowledged
+            // yet, it is replaced by the new one which retains its
+            // retransmission state (see RFC 7641 Section 4.5). Thus, at
+            // most one confirmable notification is outstanding per
+            // observer and is always the most recent one.
+            const previous = if (observer.message_id) |id| self.findPending(observer.endpoint, id) else null;
+            var kind = mt;
+            if (previous != null or now >= observer.confirmed + CON_NOTIFICATION_INTERVAL)
+                kind = pkt.Msg.con;
+
+            resp.setType(kind);
+            resp.setMessageID(self.nextMessageID());
+            observer.message_id = resp.header.message_id;
+
+            if (previous) |msg| {
+                const data = resp.marshal();
+                if (data.len > config.pending_size)
+                    return error.BufTooSmall;
+                std.mem.copy(u8, &msg.buf, data);
+                msg.len = data.len;
+                msg.message_id = resp.header.message_id;
+            } else if (kind == pkt.Msg.con) {
+                try self.enqueue(observer.endpoint, resp.marshal(), resp.header.message_id, now, true);
+            } else {
+                try self.transport.send(observer.endpoint, resp.marshal());
+            }
+
+            if (kind == pkt.Msg.con)
+                observer.confirmed = now;
+            observer.sent = now;
+            observer.pending = null;
+            observer.last = kind;
+            if (!resp.header.code.isSuccess())
+                entry.* = null;
+        }
+
+        // Send coalesced notifications whose minimum period has passed
+        // and notifications to observers which were not sent one within
+        // their maximum period. Notifications which cannot be sent are
+        // retried by the next invocation.
+        fn notifyDue(self: *Self, now: u64) void {
+            var sent = false;
+            for (self.observers) |*entry| {
+                if (entry.*) |*observer| {
+                    const elapsed = if (now > observer.sent) now - observer.sent else 0;
+                    const coalesced = observer.pending != null and elapsed >= seconds(observer.periods.pmin orelse 0);
+                    const expired = if (observer.periods.pmax) |pmax| elapsed >= seconds(pmax) else false;
+                    if (!coalesced and !expired)
+                        continue;
+
+                    self.sendNotification(entry, observer.pending orelse observer.last, now) catch continue;
+                    sent = true;
+                }
+            }
+
+            if (sent)
+                self.sequence +%= 1;
+        }
+
+        fn freeObserver(self: *Self) ?*?Observer {
+            for (self.observers) |*entry| {
+                if (entry.* == null)
+                    return entry;
+            }
+            return null;
+        }
+
+        // Remove the observation with the given token.
+        fn cancel(self: *Self, from: Endpoint, token: []const u8) void {
+            for (self.observers) |*entry| {
+                if (entry.*) |*observer| {
+                    if (observer.token.equal(token) and dedup.equalEndpoint(Endpoint, observer.endpoint, from))
+                        entry.* = null;
+                }
+            }
+        }
+
+        // Remove the observer which was sent the notification with the
+        // given Message ID.
+        fn forget(self: *Self, to: Endpoint, message_id: u16) void {
+            for (self.observers) |*entry| {
+                if (entry.*) |*observer| {
+                    const id = observer.message_id orelse continue;
+                    if (id == message_id and dedup.equalEndpoint(Endpoint, observer.endpoint, to))
+                        entry.* = null;
+                }
+            }
+        }
+
+        // Queue the given piggybacked response for transmission as
+        // separate response. Returns false if it cannot be queued, in
+        // which case the response remains piggybacked.
+        fn separate(self: *Self, to: Endpoint, resp: *pkt.Response, now: u64) bool {
+            const ack_id = resp.header.message_id;
+            resp.setType(pkt.Msg.con);
+            resp.setMessageID(self.nextMessageID());
+
+            self.enqueue(to, resp.marshal(), resp.header.message_id, now, true) catch {
+                resp.setType(pkt.Msg.ack);
+                resp.setMessageID(ack_id);
+                return false;
+            };
+            return true;
+        }
+
+        // Remember the request with the given token as awaiting a
+        // separate response.
+        fn postpone(self: *Self, from: Endpoint, token: []const u8) !void {
+            const tok = try Token.fromSlice(token);
+            for (self.deferred) |*entry| {
+                if (entry.* == null) {
+                    entry.* = Deferred{ .endpoint = from, .token = tok };
+                    return;
+                }
+            }
+
+            return error.LimitExceeded;
+        }
+
+        // Queue the given message for transmission at the given time, it
+        // is sent by the next invocation of poll after this time.
+        fn enqueue(self: *Self, to: Endpoint, msg: []const u8, message_id: u16, at: u64, confirmable: bool) !void {
+            if (msg.len > config.pending_size)
+                return error.BufTooSmall;
+
+            for (self.pending) |*entry| {
+                if (entry.* != null)
+                    continue;
+
+                entry.* = Transmission{
+                    .endpoint = to,
+                    .message_id = message_id,
+                    .buf = undefined,
+                    .len = msg.len,
+                    .timeout = at,
+                    .interval = self.initialTimeout(),
+                    .confirmable = confirmable,
+                };
+                std.mem.copy(u8, &entry.*.?.buf, msg);
+                return;
+            }
+
+            return error.LimitExceeded;
+        }
+
+        // Returns the queued confirmable message with the given Message
+        // ID to the given endpoint.
+        fn findPending(self: *Self, to: Endpoint, message_id: u16) ?*Transmission {
+            for (self.pending) |*entry| {
+                if (entry.*) |*msg| {
+                    if (msg.confirmable and msg.message_id == message_id and dedup.equalEndpoint(Endpoint, msg.endpoint, to))
+                        return msg;
+                }
+            }
+            return null;
+        }
+
+        // Returns the number of confirmable messages sent to the given
+        // endpoint which have not been acknowledged yet.
+        fn outstanding(self: *const Self, to: Endpoint) usize {
+            var n: usize = 0;
+            for (self.pending) |*entry| {
+                if (entry.*) |*msg| {
+                    if (msg.confirmable and msg.transmissions > 0 and dedup.equalEndpoint(Endpoint, msg.endpoint, to))
+                        n += 1;
+                }
+            }
+            return n;
+        }
+
+        // Stop retransmitting the message with the given Message ID.
+        fn acknowledge(self: *Self, from: Endpoint, message_id: u16) void {
+            for (self.pending) |*entry| {
+                if (entry.*) |*msg| {
+                    if (msg.message_id == message_id and dedup.equalEndpoint(Endpoint, msg.endpoint, from))
+                        entry.* = null;
+                }
+            }
+        }
+
+        // Hand the given request to the executor, see Server. Returns an
+        // empty message if the request has been handed over, it is
+        // answered by a separate response once the job is completed (see
+        // collectJobs). Returns null if the request must be handled by
+        // the calling thread.
+        fn offload(self: *Self, req: *const pkt.Request, from: Endpoint) !?pkt.Response {
+            if (config.jobs == 0 or self.executor == null)
+                return null;
+            if ((try req.getObserve()) != null or req.data.len > config.job_request_size)
+                return null;
+            if ((try self.dispatcher.find(req)) == null)
+                return null;
+            // Extended tokens (see RFC 8974) are not retained, thus such
+            // requests cannot be answered by a separate response and are
+            // handled by the calling thread instead.
+            const token = Token.fromSlice(req.token) catch return null;
+
+            // The request is postponed by handle, thus a slot for
+            // deferred requests must be free as well.
+            const mt = replyType(req);
+            const slot = self.freeWork() orelse return try self.dispatcher.reply(req, mt, codes.UNAVAILABLE);
+            if (!self.canPostpone())
+                return try self.dispatcher.reply(req, mt, codes.UNAVAILABLE);
+
+            const waiting = self.findWork(from, token) != null;
+            slot.* = Work{
+                .endpoint = from,
+                .token = token,
+                .sequence = self.submitted,
+                .unsafe = !req.header.code.isSafe(),
+                .job = undefined,
+                .request = undefined,
+                .len = req.data.len,
+                .response = undefined,
+            };
+            self.submitted += 1;
+
+            const work = &slot.*.?;
+            std.mem.copy(u8, &work.request, req.data);
+            if (!waiting)
+                self.start(work);
+            return try pkt.Response.init(&self.response_buf, mt, res.SEPARATE, &[_]u8{}, req.header.message_id);
+        }
+
+        // Submit the job of the given request to the executor. The job
+        // uses a copy of the Dispatcher with a dedicated buffer.
+        fn start(self: *Self, work: *Work) void {
+            var dispatcher = self.dispatcher;
+            dispatcher.buf = &work.response;
+            dispatcher.peer = self.identity(&work.endpoint);
+
+            work.job = Job{ .dispatcher = dispatcher, .request = work.request[0..work.len] };
+            work.running = true;
+            self.executor.?.submit(&work.job);
+        }
+
+        // Send the responses of completed jobs and submit the jobs of
+        // requests which waited for them. Responses which cannot be
+        // queued are retried by the next invocation.
+        fn collectJobs(self: *Self, now: u64) void {
+            for (self.works) |*entry| {
+                if (entry.*) |*work| {
+                    if (!work.running or !work.job.completed())
+                        continue;
+                    self.complete(work, now) catch |err| switch (err) {
+                        error.LimitExceeded => continue,
+                        else => {},
+                    };
+
+                    const from = work.endpoint;
+                    const token = work.token;
+                    entry.* = null;
+                    if (self.findWork(from, token)) |next|
+                        self.start(next);
+                }
+            }
+        }
+
+        // Send the response of the given completed job as separate
+        // response. If the handler returned res.SEPARATE, the response
+        // is sent later on using sendSeparate.
+        fn complete(self: *Self, work: *Work, now: u64) !void {
+            var resp = work.job.response orelse blk: {
+                const req = try pkt.Request.init(work.request[0..work.len]);
+                break :blk try pkt.Response.reply(&work.response, &req, replyType(&req), codes.INTERNAL_ERR);
+            };
+            if (resp.header.code.isEmpty())
+                return;
+
+            // See dispatch, the job may have changed the state of any
+            // resource.
+            if (work.unsafe and resp.header.code.isSuccess())
+                self.responses.clear();
+            try self.respond(work.endpoint, &resp, now);
+        }
+
+        // Returns the earliest request with the given token from the
+        // given endpoint which has been handed to the executor.
+        fn findWork(self: *Self, from: Endpoint, token: Token) ?*Work {
+            var first: ?*Work = null;
+            for (self.works) |*entry| {
+                if (entry.*) |*work| {
+                    if (!work.token.equal(token.slice()) or !dedup.equalEndpoint(Endpoint, work.endpoint, from))
+                        continue;
+                    if (first == null or work.sequence < first.?.sequence)
+                        first = work;
+                }
+            }
+            return first;
+        }
+
+        fn freeWork(self: *Self) ?*?Work {
+            for (self.works) |*entry| {
+                if (entry.* == null)
+                    return entry;
+            }
+            return null;
+        }
+
+        fn canPostpone(self: *const Self) bool {
+            for (self.deferred) |entry| {
+                if (entry == null)
+                    return true;
+            }
+            return false;
+        }
+
+        // Whether no request awaits a separate response and no message
+        // is queued for transmission.
+        fn idle(self: *const Self) bool {
+            for (self.deferred) |entry| {
+                if (entry != null)
+                    return false;
+            }
+            for (self.pending) |entry| {
+                if (entry != null)
+                    return false;
+            }
+            for (self.exchanges) |entry| {
+                if (entry != null)
+                    return false;
+            }
+            for (self.works) |entry| {
+                if (entry != null)
+                    return false;
+            }
+            for (self.queries) |entry| {
+                if (entry != null)
+                    return false;
+            }
+            return true;
+        }
+
+        fn receivedMulticast(self: *Self) bool {
+            return if (comptime @hasDecl(Transport, "multicast")) self.transport.multicast() else false;
+        }
+
+        // Returns the identity of the given endpoint, which must remain
+        // valid while the identity is used.
+        fn identity(self: *Self, from: *const Endpoint) res.Peer {
+            if (comptime @hasDecl(Transport, "identity"))
+                return self.transport.identity(from.*);
+            return res.Peer{ .address = std.mem.asBytes(from) };
+        }
+
+        // Returns the endpoint of the given origin server, see Server.
+        fn resolve(self: *Self, scheme: uri.Scheme, host: []const u8, port: u16) ?Endpoint {
+            if (comptime @hasDecl(Transport, "resolve"))
+                return self.transport.resolve(scheme, host, port);
+            return null;
+        }
+
+        fn leisureDelay(self: *Self) u64 {
+            const random = self.random orelse return 0;
+            return random.intRangeAtMost(u64, 0, self.leisure);
+        }
+
+        fn initialTimeout(self: *Self) u64 {
+            const random = self.random orelse return ACK_TIMEOUT;
+            return random.intRangeAtMost(u64, ACK_TIMEOUT, MAX_ACK_TIMEOUT);
+        }
+
+        // Returns a token for a request sent by the server with the given
+        // Message ID. Without a source of randomness, the Message ID is
+        // used as token.
+        fn newToken(self: *Self, message_id: u16) !Token {
+            const random = self.random orelse {
+                var id: [2]u8 = undefined;
+                std.mem.writeIntBig(u16, &id, message_id);
+                return Token.fromSlice(&id);
+            };
+            return Token.generate(random, DEFAULT_TOKEN_LEN);
+        }
+
+        fn nextMessageID(self: *Self) u16 {
+            const id = self.message_id;
+            self.message_id +%= 1;
+            return id;
+        }
+    };
+}
+
+// Convert the given period in seconds to milliseconds.
+fn seconds(period: u32) u64 {
+    return @as(u64, period) * 1000;
+}
+
+fn replyType(req: *const pkt.Request) pkt.Msg {
+    return if (req.header.type == pkt.Msg.con) pkt.Msg.ack else pkt.Msg.non;
+}
+
+// Whether the given request must be forwarded by a proxy.
+// Whether the given error results from parsing malformed options or a
+// malformed payload, e.g. a repeated Content-Format option or a
+// zero-length Uri-Host option.
+fn isMalformed(err: anyerror) bool {
+    return switch (err) {
+        error.FormatError, error.InvalidPayload, error.RepeatedOption, error.InvalidLength, error.InvalidString, error.LimitExceeded => true,
+        else => false,
+    };
+}
+
+fn isProxyRequest(req: *const pkt.Request) !bool {
+    var values = req.getAll(opts.ProxyURI);
+    if ((try values.next()) != null)
+        return true;
+    values = req.getAll(opts.ProxyScheme);
+    return (try values.next()) != null;
+}
+
+// Returns the length of the payload of the given request.
+fn payloadLength(req: *const pkt.Request) !usize {
+    var iter = req.options();
+    while (try iter.next()) |_| {}
+    const payload = iter.payload() orelse return 0;
+    return payload.len;
+}
+
+// Returns a hash of the method and the URI options of the given request,
+// used to associate the blocks of a request body.
+fn requestKey(req: *const pkt.Request) !u64 {
+    var hasher = std.hash.Wyhash.init(0);
+    hasher.update(&[_]u8{@bitCast(u8, req.header.code)});
+
+    var iter = req.options();
+    while (try iter.next()) |opt| {
+        switch (opt.number) {
+            opts.URIHost, opts.URIPort, opts.URIPath, opts.URIQuery => {
+                var prefix: [8]u8 = undefined;
+                std.mem.writeIntBig(u32, prefix[0..4], opt.number);
+                std.mem.writeIntBig(u32, prefix[4..8], @intCast(u32, opt.value.len));
+                hasher.update(&prefix);
+                hasher.update(opt.value);
+            },
+            else => {},
+        }
+    }
+    return hasher.final();
+}
+
+// Transport for server tests, which receives a single datagram and
+// records the datagram sent in response. Time only advances manually.
+const TestTransport = struct {
+    pub const Endpoint = u16;
+
+    input: []const u8,
+    output: [64]u8 = undefined,
+    sent: ?[]const u8 = null,
+    peer: Endpoint = 0,
+    time: u64 = 0,
+    // Whether the input is received via multicast.
+    group: bool = false,
+    closed: bool = false,
+    // Endpoint to which datagrams cannot be sent.
+    unreachable_peer: ?Endpoint = null,
+
+    pub fn recv(self: *TestTransport, buf: []u8, from: *Endpoint) !?usize {
+        std.mem.copy(u8, buf, self.input);
+        from.* = 5683;
+        return self.input.len;
+    }
+
+    pub fn send(self: *TestTransport, to: Endpoint, data: []const u8) !void {
+        if (self.unreachable_peer) |blocked| {
+            if (blocked == to)
+                return error.NetworkUnreachable;
+        }
+        std.mem.copy(u8, &self.output, data);
+        self.sent = self.output[0..data.len];
+        self.peer = to;
+    }
+
+    pub fn now(self: *TestTransport) u64 {
+        return self.time;
+    }
+
+    pub fn multicast(self: *TestTransport) bool {
+        return self.group;
+    }
+
+    pub fn close(self: *TestTransport) void {
+        self.closed = true;
+    }
+
+    // Origin servers are identified by their port.
+    pub fn resolve(self: *TestTransport, scheme: uri.Scheme, host: []const u8, port: u16) ?Endpoint {
+        _ = self;
+        _ = scheme;
+        if (!std.mem.eql(u8, host, "origin.example"))
+            return null;
+        return port;
+    }
+};
+
+fn testHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    if (!req.header.code.equal(codes.GET))
+        return codes.BAD_METHOD;
+
+    resp.addContentFormat(ContentFormat.text_plain) catch {
+        return codes.INTERNAL_ERR;
+    };
+    resp.payloadWriter().writeAll("Hello, World!") catch {
+        return codes.INTERNAL_ERR;
+    };
+    return codes.CONTENT;
+}
+
+test "test server request routing" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = testHandler },
+        .{ .path = "sensors/temp", .handler = testHandler },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{ 0xe7, 0x01 }, 0x0d70);
+    try msg.addURIPath("sensors/temp");
+    server.transport.input = msg.marshal();
+    try server.serveOnce();
+
+    try testing.expect(server.transport.peer == 5683);
+    var reply = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(reply.header.type == pkt.Msg.non);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expect(reply.header.message_id == 0x0d70);
+    try testing.expectEqualSlices(u8, &[_]u8{ 0xe7, 0x01 }, reply.token);
+    try testing.expectEqualStrings("Hello, World!", (try reply.extractPayload()).?);
+
+    // Only complete paths match a resource.
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{}, 0x0d71);
+    try msg.addURIPath("sensors");
+    reply = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.NOT_FOUND));
+
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{}, 0x0d72);
+    try msg.addURIPath("hello/world");
+    reply = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.NOT_FOUND));
+
+    // CoAP pings are answered with a reset, other non-requests and
+    // malformed messages are ignored.
+    reply = try pkt.Request.init((try server.handle(@embedFile("../testvectors/matrix-con-empty.bin"), 1, 0)).?);
+    try testing.expect(reply.header.type == pkt.Msg.rst);
+    try testing.expect(reply.header.code.isEmpty());
+    try testing.expect((try server.handle(@embedFile("../testvectors/empty-ack.bin"), 1, 0)) == null);
+    try testing.expect((try server.handle(@embedFile("../testvectors/truncated-header.bin"), 1, 0)) == null);
+}
+
+// Middleware counting the requests passed to the next handler.
+fn countRequests(comptime next: res.ResourceHandler) res.ResourceHandler {
+    return struct {
+        fn handle(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+            handled_requests += 1;
+            return next(resp, req);
+        }
+    }.handle;
+}
+
+var handled_requests: usize = 0;
+
+test "test server with duplicate requests" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = res.chain(testHandler, .{countRequests}) },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+    const handled = handled_requests;
+
+    // Retransmitted confirmable requests are answered with the same reply.
+    var req_buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&req_buf, pkt.Msg.con, codes.GET, &[_]u8{0x42}, 0x0d70);
+    try msg.addURIPath("hello");
+
+    var reply_buf: [64]u8 = undefined;
+    const reply = (try server.handle(msg.marshal(), 1, 0)).?;
+    std.mem.copy(u8, &reply_buf, reply);
+    try testing.expectEqualSlices(u8, reply_buf[0..reply.len], (try server.handle(msg.marshal(), 1, 1000)).?);
+    try testing.expect(handled_requests == handled + 1);
+
+    // Duplicate non-confirmable requests are ignored.
+    msg = try pkt.Response.init(&req_buf, pkt.Msg.non, codes.GET, &[_]u8{0x42}, 0x0d71);
+    try msg.addURIPath("hello");
+    _ = (try server.handle(msg.marshal(), 1, 0)).?;
+    try testing.expect((try server.handle(msg.marshal(), 1, 1000)) == null);
+    try testing.expect(handled_requests == handled + 2);
+
+    // The same Message ID from a different endpoint is not a duplicate.
+    _ = (try server.handle(msg.marshal(), 2, 1000)).?;
+    try testing.expect(handled_requests == handled + 3);
+
+    // After its lifetime, the Message ID may be reused.
+    _ = (try server.handle(msg.marshal(), 1, 145 * 1000)).?;
+    try testing.expect(handled_requests == handled + 4);
+
+    // Retransmissions of requests whose reply is too large to be stored
+    // are ignored instead of being processed again.
+    var small = ServerWithConfig(TestTransport, .{ .dedup_reply_size = 8 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+    msg = try pkt.Response.init(&req_buf, pkt.Msg.con, codes.GET, &[_]u8{0x42}, 0x0d72);
+    try msg.addURIPath("hello");
+    _ = (try small.handle(msg.marshal(), 1, 0)).?;
+    try testing.expect((try small.handle(msg.marshal(), 1, 1000)) == null);
+    try testing.expect(handled_requests == handled + 5);
+}
+
+fn slowHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    _ = resp;
+    _ = req;
+    return res.SEPARATE;
+}
+
+test "test server with separate responses" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = testHandler },
+        .{ .path = "slow", .handler = slowHandler },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+        .message_id = 0x0f71,
+    };
+
+    // Responses to confirmable requests are piggybacked by default.
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/exchange-get-response.bin"), (try server.handle(@embedFile("../testvectors/exchange-get-request.bin"), 1, 0)).?);
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/exchange-not-found-response.bin"), (try server.handle(@embedFile("../testvectors/exchange-not-found-request.bin"), 1, 0)).?);
+
+    // Deferred responses are acknowledged by an empty message.
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/exchange-separate-ack.bin"), (try server.handle(@embedFile("../testvectors/exchange-separate-request.bin"), 1, 0)).?);
+
+    const exp = @embedFile("../testvectors/exchange-separate-response.bin");
+    var buf: [exp.len]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.non, codes.CONTENT, &[_]u8{ 0xe7, 0x01 }, 0);
+    try resp.addContentFormat(ContentFormat.text_plain);
+    try resp.payloadWriter().writeAll("Hello, World!");
+    try server.sendSeparate(&resp, 1000);
+    try testing.expectEqualSlices(u8, exp, server.transport.sent.?);
+    try testing.expect(server.transport.peer == 1);
+
+    // The separate response is retransmitted until it is acknowledged.
+    server.transport.sent = null;
+    try server.poll(2999);
+    try testing.expect(server.transport.sent == null);
+    try server.poll(3000);
+    try testing.expectEqualSlices(u8, exp, server.transport.sent.?);
+
+    try testing.expect((try server.handle(@embedFile("../testvectors/exchange-separate-response-ack.bin"), 1, 3100)) == null);
+    server.transport.sent = null;
+    try server.poll(100000);
+    try testing.expect(server.transport.sent == null);
+
+    // Each deferred request is answered only once.
+    try testing.expectError(error.UnknownToken, server.sendSeparate(&resp, 4000));
+}
+
+test "test server retransmission back-off" {
+    const resources = [_]res.Resource{
+        .{ .path = "slow", .handler = slowHandler },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+    _ = (try server.handle(@embedFile("../testvectors/exchange-separate-request.bin"), 1, 0)).?;
+
+    var buf: [16]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.con, codes.CONTENT, &[_]u8{ 0xe7, 0x01 }, 0);
+    try server.sendSeparate(&resp, 0);
+
+    // Retransmitted after 2, 4, 8, and 16 seconds, then discarded.
+    const times = [_]u64{ 2000, 6000, 14000, 30000 };
+    for (times) |time| {
+        server.transport.sent = null;
+        try server.poll(time - 1);
+        try testing.expect(server.transport.sent == null);
+        try server.poll(time);
+        try testing.expect(server.transport.sent != null);
+    }
+
+    server.transport.sent = null;
+    try server.poll(62000);
+    try server.poll(1000000);
+    try testing.expect(server.transport.sent == null);
+}
+
+test "test server with slow handlers" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = testHandler },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined, .time = 2001 },
+        .dispatcher = .{ .resources = &resources },
+        .message_id = 0x0f71,
+    };
+
+    // Responses exceeding the processing delay are sent separately.
+    const ack = try pkt.Request.init((try server.handle(@embedFile("../testvectors/exchange-get-request.bin"), 1, 0)).?);
+    try testing.expect(ack.header.type == pkt.Msg.ack);
+    try testing.expect(ack.header.code.isEmpty());
+    try testing.expect(ack.header.message_id == 0x0d70);
+    try testing.expect(ack.token.len == 0);
+
+    try server.poll(2001);
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/exchange-separate-response.bin"), server.transport.sent.?);
+
+    // Responses within the processing delay are piggybacked.
+    server.transport.time = 2000;
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/exchange-not-found-response.bin"), (try server.handle(@embedFile("../testvectors/exchange-not-found-request.bin"), 1, 0)).?);
+}
+
+var temperature: []const u8 = "21.0 C";
+
+fn temperatureHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    _ = req;
+    resp.addContentFormat(ContentFormat.text_plain) catch {
+        return codes.INTERNAL_ERR;
+    };
+    resp.payloadWriter().writeAll(temperature) catch {
+        return codes.INTERNAL_ERR;
+    };
+    return codes.CONTENT;
+}
+
+test "test server with observers" {
+    const resources = [_]res.Resource{
+        .{ .path = "temperature", .handler = temperatureHandler, .observable = true },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+        .message_id = 0x0c00,
+        .sequence = 1000,
+    };
+
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/observe-1.bin"), (try server.handle(@embedFile("../testvectors/observe-0.bin"), 1, 0)).?);
+
+    // Notifications use increasing Observe values.
+    temperature = "21.5 C";
+    const notifications = [_][]const u8{
+        @embedFile("../testvectors/observe-2.bin"),
+        @embedFile("../testvectors/observe-3.bin"),
+        @embedFile("../testvectors/observe-4.bin"),
+    };
+    for (notifications) |exp| {
+        try server.notify("temperature", pkt.Msg.non, 0);
+        try testing.expectEqualSlices(u8, exp, server.transport.sent.?);
+    }
+
+    // Observers are removed on explicit deregistration.
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/observe-6.bin"), (try server.handle(@embedFile("../testvectors/observe-5.bin"), 1, 0)).?);
+    server.transport.sent = null;
+    try server.notify("temperature", pkt.Msg.non, 0);
+    try testing.expect(server.transport.sent == null);
+
+    // Observers are removed if they reject a notification.
+    _ = (try server.handle(@embedFile("../testvectors/observe-0.bin"), 2, 0)).?;
+    try server.notify("temperature", pkt.Msg.con, 0);
+    const notification = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(notification.header.type == pkt.Msg.con);
+    try testing.expect(server.transport.peer == 2);
+
+    var buf: [4]u8 = undefined;
+    var rst = try pkt.Response.init(&buf, pkt.Msg.rst, .{ .class = 0, .detail = 0 }, &[_]u8{}, notification.header.message_id);
+    try testing.expect((try server.handle(rst.marshal(), 2, 0)) == null);
+
+    server.transport.sent = null;
+    try server.notify("temperature", pkt.Msg.non, 0);
+    try server.poll(100000);
+    try testing.expect(server.transport.sent == null);
+}
+
+test "test notification of unreachable observers" {
+    const resources = [_]res.Resource{
+        .{ .path = "temperature", .handler = temperatureHandler, .observable = true },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+        .sequence = 1000,
+    };
+    _ = (try server.handle(@embedFile("../testvectors/observe-0.bin"), 1, 0)).?;
+    _ = (try server.handle(@embedFile("../testvectors/observe-0.bin"), 2, 0)).?;
+
+    // Failing to notify one observer does not affect the others.
+    server.transport.unreachable_peer = 1;
+    server.transport.sent = null;
+    try testing.expectError(error.NetworkUnreachable, server.notify("temperature", pkt.Msg.non, 0));
+    try testing.expect(server.transport.peer == 2);
+    try testing.expect(server.sequence == 1001);
+
+    const notification = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect((try notification.getObserve()).? == 1000);
+}
+
+var fetch_responses: usize = 0;
+var fetch_timeouts: usize = 0;
+
+fn fetchHandler(from: u16, resp: ?*const pkt.Request) void {
+    std.debug.assert(from == 7);
+    const msg = resp orelse {
+        fetch_timeouts += 1;
+        return;
+    };
+    if (msg.header.code.equal(codes.CONTENT))
+        fetch_responses += 1;
+}
+
+test "test requests sent by the server" {
+    var server = ServerWithConfig(TestTransport, .{ .client_requests = 1 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &[_]res.Resource{} },
+        .message_id = 0x0a00,
+    };
+
+    try server.request(7, codes.GET, ".well-known/core", fetchHandler, 0);
+    try testing.expectError(error.LimitExceeded, server.request(7, codes.GET, ".well-known/core", fetchHandler, 0));
+    try server.poll(0);
+    const sent = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(server.transport.peer == 7);
+    try testing.expect(sent.header.type == pkt.Msg.con and sent.header.code.equal(codes.GET));
+
+    // The response is passed to the handler.
+    var buf: [16]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.ack, codes.CONTENT, sent.token, sent.header.message_id);
+    try testing.expect((try server.handle(resp.marshal(), 7, 100)) == null);
+    try testing.expect(fetch_responses == 1);
+
+    // Requests which are not answered time out.
+    try server.request(7, codes.GET, ".well-known/core", fetchHandler, 200);
+    try server.poll(200 + MAX_TRANSMIT_WAIT);
+    try testing.expect(fetch_timeouts == 1 and fetch_responses == 1);
+    try testing.expect(server.queries[0] == null);
+}
+
+// Executor running jobs only when requested by the test.
+const TestExecutor = struct {
+    jobs: [4]*Job = undefined,
+    len: usize = 0,
+
+    fn submit(self: *TestExecutor, job: *Job) void {
+        self.jobs[self.len] = job;
+        self.len += 1;
+    }
+};
+
+test "test server with concurrent handlers" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = res.chain(testHandler, .{countRequests}) },
+    };
+    var test_executor = TestExecutor{};
+    var server = ServerWithConfig(TestTransport, .{ .jobs = 4 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+        .executor = Executor.init(&test_executor, TestExecutor.submit),
+        .message_id = 0x0e00,
+    };
+    const handled = handled_requests;
+
+    // Requests are acknowledged right away and handed to the executor.
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x42}, 0x0e10);
+    try msg.addURIPath("hello");
+    const ack = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(ack.header.type == pkt.Msg.ack and ack.header.code.isEmpty());
+    try testing.expect(test_executor.len == 1 and handled_requests == handled);
+
+    // Further requests with the same endpoint and token wait for the
+    // earlier ones, other requests are executed concurrently.
+    msg.setMessageID(0x0e11);
+    _ = (try server.handle(msg.marshal(), 1, 0)).?;
+    try testing.expect(test_executor.len == 1);
+    msg.setMessageID(0x0e12);
+    _ = (try server.handle(msg.marshal(), 2, 0)).?;
+    try testing.expect(test_executor.len == 2);
+
+    // Responses are sent as separate responses once the job completed.
+    server.transport.sent = null;
+    test_executor.jobs[1].run();
+    try server.poll(0);
+    var resp = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(server.transport.peer == 2);
+    try testing.expect(resp.header.type == pkt.Msg.con);
+    try testing.expectEqualStrings("Hello, World!", (try resp.extractPayload()).?);
+
+    // Completing a job submits the next one with the same endpoint and
+    // token.
+    test_executor.jobs[0].run();
+    try server.poll(0);
+    try testing.expect(server.transport.peer == 1);
+    try testing.expect(test_executor.len == 3 and handled_requests == handled + 2);
+
+    test_executor.jobs[2].run();
+    try server.poll(0);
+    try testing.expect(handled_requests == handled + 3);
+    try testing.expect(server.works[0] == null and server.works[1] == null and server.works[2] == null);
+
+    // Requests with extended tokens (see RFC 8974) are handled by the
+    // calling thread, since they cannot be answered separately.
+    msg = try pkt.Response.initExtended(&buf, pkt.Msg.con, codes.GET, &([_]u8{0x42} ** 13), 0x0e13);
+    try msg.addURIPath("hello");
+    resp = try pkt.Request.init((try server.handle(msg.marshal(), 3, 0)).?);
+    try testing.expect(resp.header.type == pkt.Msg.ack);
+    try testing.expect(resp.header.code.equal(codes.CONTENT));
+    try testing.expect(test_executor.len == 3 and handled_requests == handled + 4);
+}
+
+fn largeHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    _ = req;
+    resp.payloadWriter().writeAll("0123456789abcdef0123456789abcdef01234567") catch {
+        return codes.INTERNAL_ERR;
+    };
+    return codes.CONTENT;
+}
+
+// Compare the given block with the expected one, ignoring the ETag
+// added by the server. Returns the ETag of the block.
+fn expectBlock(data: []const u8, exp: []const u8) !u32 {
+    const blk = try pkt.Request.init(data);
+    const want = try pkt.Request.init(exp);
+    try testing.expect(blk.header.type == want.header.type);
+    try testing.expect(blk.header.code.equal(want.header.code));
+    try testing.expectEqual(want.header.message_id, blk.header.message_id);
+    try testing.expectEqualSlices(u8, want.token, blk.token);
+    try testing.expectEqual((try want.getBlock(opts.Block2)).?, (try blk.getBlock(opts.Block2)).?);
+    try testing.expectEqualSlices(u8, try want.peekPayload(), try blk.peekPayload());
+
+    const etag = (try blk.getOpaque(opts.ETag)).?;
+    try testing.expectEqual(@as(usize, 4), etag.len);
+    return std.mem.readIntBig(u32, etag[0..4]);
+}
+
+test "test server with block-wise responses" {
+    const resources = [_]res.Resource{
+        .{ .path = "large", .handler = largeHandler },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    // The body is sliced into blocks of the size requested by the
+    // client, all blocks carry the same ETag.
+    const etag = try expectBlock((try server.handle(@embedFile("../testvectors/block2-szx0-0.bin"), 1, 0)).?, @embedFile("../testvectors/block2-szx0-1.bin"));
+    try testing.expectEqual(etag, try expectBlock((try server.handle(@embedFile("../testvectors/block2-szx0-2.bin"), 1, 0)).?, @embedFile("../testvectors/block2-szx0-3.bin")));
+    try testing.expectEqual(etag, try expectBlock((try server.handle(@embedFile("../testvectors/block2-szx0-4.bin"), 1, 0)).?, @embedFile("../testvectors/block2-szx0-5.bin")));
+
+    // Larger blocks than supported by the server are reduced in size.
+    server.block_szx = 0;
+    try testing.expectEqual(etag, try expectBlock((try server.handle(@embedFile("../testvectors/block2-szx2-0.bin"), 2, 0)).?, @embedFile("../testvectors/block2-szx0-1.bin")));
+
+    // Without a Block2 option, the first block is sent if the body
+    // exceeds the block size, including the Size2 option if requested.
+    var buf: [32]u8 = undefined;
+    var req = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{ 0xb1, 0x0c }, 0x0b30);
+    try req.addURIPath("/large");
+    try req.addUint(opts.Size2, 0);
+
+    const first = try pkt.Request.init((try server.handle(req.marshal(), 1, 0)).?);
+    try testing.expectEqual(BlockValue{ .num = 0, .more = true, .szx = 0 }, (try first.getBlock(opts.Block2)).?);
+    try testing.expectEqual(@as(u32, 40), (try first.getUint(opts.Size2)).?);
+    try testing.expectEqualSlices(u8, "0123456789abcdef", try first.peekPayload());
+
+    // Blocks beyond the end of the body are rejected.
+    req = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{ 0xb1, 0x0c }, 0x0b31);
+    try req.addURIPath("/large");
+    try req.addBlock(opts.Block2, .{ .num = 3, .more = false, .szx = 0 });
+
+    const rejected = try pkt.Request.init((try server.handle(req.marshal(), 1, 0)).?);
+    try testing.expect(rejected.header.code.equal(codes.BAD_OPT));
+}
+
+var stored_body: [4096]u8 = undefined;
+var stored_len: usize = 0;
+
+fn storeHandler(resp: *pkt.Response, req: *pkt.Request) codes.Code {
+    _ = resp;
+    if (!req.header.code.equal(codes.PUT))
+        return codes.BAD_METHOD;
+
+    const body = req.peekPayload() catch {
+        return codes.BAD_REQ;
+    };
+    std.mem.copy(u8, &stored_body, body);
+    stored_len = body.len;
+    return codes.CHANGED;
+}
+
+test "test server with block-wise requests" {
+    const resources = [_]res.Resource{
+        .{ .path = "large", .handler = storeHandler },
+    };
+    var server = ServerWithConfig(TestTransport, .{ .request_size = 2560 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    // Blocks are acknowledged with 2.31 (Continue), the handler is
+    // invoked once with the reassembled body.
+    const blocks = [_][]const u8{
+        @embedFile("../testvectors/block1-szx0-0.bin"),
+        @embedFile("../testvectors/block1-szx0-1.bin"),
+        @embedFile("../testvectors/block1-szx0-2.bin"),
+        @embedFile("../testvectors/block1-szx0-3.bin"),
+        @embedFile("../testvectors/block1-szx0-4.bin"),
+        @embedFile("../testvectors/block1-szx0-5.bin"),
+    };
+    var i: usize = 0;
+    while (i < blocks.len) : (i += 2)
+        try testing.expectEqualSlices(u8, blocks[i + 1], (try server.handle(blocks[i], 1, 0)).?);
+    try testing.expectEqualSlices(u8, "0123456789abcdef0123456789abcdef01234567", stored_body[0..stored_len]);
+
+    // Clients sending blocks larger than block_szx are asked to use
+    // smaller blocks.
+    server.block_szx = 4;
+    const negotiation = [_][]const u8{
+        @embedFile("../testvectors/block1-server-negotiation-0.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-1.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-2.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-3.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-4.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-5.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-6.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-7.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-8.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-9.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-10.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-11.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-12.bin"),
+        @embedFile("../testvectors/block1-server-negotiation-13.bin"),
+    };
+    i = 0;
+    while (i < negotiation.len) : (i += 2)
+        try testing.expectEqualSlices(u8, negotiation[i + 1], (try server.handle(negotiation[i], 2, 0)).?);
+    try testing.expectEqual(@as(usize, 2560), stored_len);
+
+    // Blocks not following the previous one are rejected.
+    const gap = try pkt.Request.init((try server.handle(blocks[2], 3, 0)).?);
+    try testing.expect(gap.header.code.equal(codes.INCOMPLETE));
+
+    // Bodies exceeding the configured size are rejected.
+    var small = ServerWithConfig(TestTransport, .{ .request_size = 32 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+    try testing.expectEqualSlices(u8, blocks[1], (try small.handle(blocks[0], 1, 0)).?);
+    try testing.expectEqualSlices(u8, blocks[3], (try small.handle(blocks[2], 1, 0)).?);
+
+    const rejected = try pkt.Request.init((try small.handle(blocks[4], 1, 0)).?);
+    try testing.expect(rejected.header.code.equal(codes.TOO_LARGE));
+    try testing.expectEqual(@as(u32, 32), (try rejected.getUint(opts.Size1)).?);
+}
+
+test "test server with multicast requests" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = testHandler, .attributes = .{ .rt = "greeting" } },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = @embedFile("../testvectors/multicast-discovery-0.bin"), .group = true },
+        .dispatcher = .{ .resources = &resources },
+        .leisure = 1000,
+    };
+
+    // Responses are queued and sent by poll, they are only delayed
+    // randomly if a source of randomness is configured.
+    try server.serveOnce();
+    try testing.expect(server.transport.sent != null);
+    var reply = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(reply.header.type == pkt.Msg.non);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expectEqualStrings("</hello>;rt=\"greeting\"", (try reply.extractPayload()).?);
+
+    // Responses to multicast requests are not retransmitted.
+    server.transport.sent = null;
+    try server.poll(100000);
+    try testing.expect(server.transport.sent == null);
+
+    var prng = std.rand.DefaultPrng.init(0);
+    server.random = prng.random();
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{}, 0x0da0);
+    try msg.addURIPath("hello");
+    try server.handleMulticast(msg.marshal(), 1, 0);
+    try server.poll(1000);
+    reply = try pkt.Request.init(server.transport.sent.?);
+    try testing.expectEqualStrings("Hello, World!", (try reply.extractPayload()).?);
+
+    // Confirmable messages, non-idempotent methods, and requests which
+    // would be answered with an error are ignored.
+    server.transport.sent = null;
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x0da1);
+    try msg.addURIPath("hello");
+    try server.handleMulticast(msg.marshal(), 1, 0);
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.POST, &[_]u8{}, 0x0da2);
+    try msg.addURIPath("hello");
+    try server.handleMulticast(msg.marshal(), 1, 0);
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{}, 0x0da3);
+    try msg.addURIPath("missing");
+    try server.handleMulticast(msg.marshal(), 1, 0);
+    try server.poll(100000);
+    try testing.expect(server.transport.sent == null);
+}
+
+test "test resources added at runtime" {
+    const resources = [_]res.Resource{
+        .{ .path = "3303", .handler = temperatureHandler, .methods = &[_]codes.Code{codes.GET} },
+    };
+    var slots = [_]?res.Resource{null} ** 2;
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources, .dynamic = &slots },
+    };
+
+    // Methods not declared for a resource are rejected.
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.DELETE, &[_]u8{}, 0x0dc0);
+    try msg.addURIPath("3303");
+    var reply = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.BAD_METHOD));
+
+    try server.dispatcher.add(.{ .path = "3303/0", .handler = temperatureHandler, .observable = true });
+    try server.dispatcher.add(.{ .path = "3303/1", .handler = temperatureHandler });
+    try testing.expectError(error.PathExists, server.dispatcher.add(.{ .path = "3303", .handler = temperatureHandler }));
+    try testing.expectError(error.LimitExceeded, server.dispatcher.add(.{ .path = "3303/2", .handler = temperatureHandler }));
+
+    // Resources added at runtime are routed to and can be observed.
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x07}, 0x0dc1);
+    try msg.addUint(opts.Observe, 0);
+    try msg.addURIPath("3303/0");
+    reply = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expect((try reply.getObserve()) != null);
+
+    // Observers are notified if the resource is removed.
+    try testing.expect(try server.remove("3303/0"));
+    reply = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(reply.header.type == pkt.Msg.non);
+    try testing.expect(reply.header.code.equal(codes.NOT_FOUND));
+    try testing.expectEqualSlices(u8, &[_]u8{0x07}, reply.token);
+    try testing.expect(!(try server.remove("3303/0")));
+    try testing.expect(!(try server.remove("3303")));
+
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x0dc2);
+    try msg.addURIPath("3303/0");
+    reply = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.NOT_FOUND));
+
+    // Freed slots can be reused.
+    try server.dispatcher.add(.{ .path = "3303/2", .handler = temperatureHandler });
+    try testing.expect(server.dispatcher.remove("3303/1"));
+}
+
+// Allows the peer with the PSK identity "admin" and GET requests from
+// the unauthenticated endpoint 1.
+fn adminOnly(peer: *const res.Peer, req: *const pkt.Request) res.Access {
+    const trusted: TestTransport.Endpoint = 1;
+    switch (peer.*) {
+        .psk_identity => |id| {
+            if (std.mem.eql(u8, id, "admin"))
+                return .allow;
+            return .forbidden;
+        },
+        .address => |addr| {
+            if (std.mem.eql(u8, addr, std.mem.asBytes(&trusted)) and req.header.code.equal(codes.GET))
+                return .allow;
+            return .unauthorized;
+        },
+        .oscore_sender_id => return .forbidden,
+    }
+}
+
+test "test access control of resources" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = testHandler, .access = adminOnly },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x0e01);
+    try msg.addURIPath("hello");
+    var reply = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+
+    // The server identifies peers by the bytes of their endpoint.
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x0e02);
+    try msg.addURIPath("hello");
+    reply = try pkt.Request.init((try server.handle(msg.marshal(), 2, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.UNAUTH));
+    try testing.expect(server.dispatcher.peer == null);
+
+    // Without a known identity, requests are rejected.
+    var dispatcher = res.Dispatcher{ .resources = &resources };
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0x0e03);
+    try msg.addURIPath("hello");
+    var req = try pkt.Request.init(msg.marshal());
+    var resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.UNAUTH));
+
+    dispatcher.peer = res.Peer{ .psk_identity = "guest" };
+    resp = try dispatcher.dispatch(&req);
+    try testing.expect(resp.header.code.equal(codes.FORBIDDEN));
+
+    dispatcher.peer = res.Peer{ .psk_identity = "admin" };
+    resp = try dispatcher.dispatch(&req);
+    reply = try pkt.Request.init(resp.marshal());
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expectEqualStrings("Hello, World!", (try reply.extractPayload()).?);
+}
+
+test "test graceful server shutdown" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = testHandler },
+        .{ .path = "slow", .handler = slowHandler },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = @embedFile("../testvectors/exchange-get-request.bin") },
+        .dispatcher = .{ .resources = &resources },
+        .message_id = 0x0f71,
+    };
+    const ack = @embedFile("../testvectors/exchange-separate-ack.bin");
+    try testing.expectEqualSlices(u8, ack, (try server.handle(@embedFile("../testvectors/exchange-separate-request.bin"), 1, 0)).?);
+
+    // New requests are rejected, duplicates are still answered.
+    server.shutdown(60000);
+    var reply = try pkt.Request.init((try server.handle(@embedFile("../testvectors/exchange-get-request.bin"), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.UNAVAILABLE));
+    try testing.expectEqualSlices(u8, ack, (try server.handle(@embedFile("../testvectors/exchange-separate-request.bin"), 1, 0)).?);
+
+    // The server is closed once the deferred request is answered and
+    // the separate response is acknowledged.
+    try server.serveOnce();
+    try testing.expect(!server.isClosed());
+
+    var buf: [32]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.non, codes.CONTENT, &[_]u8{ 0xe7, 0x01 }, 0);
+    try resp.addContentFormat(ContentFormat.text_plain);
+    try resp.payloadWriter().writeAll("Hello, World!");
+    try server.sendSeparate(&resp, 1000);
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/exchange-separate-response.bin"), server.transport.sent.?);
+
+    server.transport.time = 1000;
+    try server.serveOnce();
+    try testing.expect(!server.isClosed());
+
+    try testing.expect((try server.handle(@embedFile("../testvectors/exchange-separate-response-ack.bin"), 1, 1100)) == null);
+    try server.serveOnce();
+    try testing.expect(server.isClosed());
+    try testing.expect(server.transport.closed);
+    try testing.expectError(error.ServerClosed, server.serveOnce());
+
+    // Exchanges which are not completed by the deadline are abandoned.
+    server = Server(TestTransport){
+        .transport = .{ .input = @embedFile("../testvectors/exchange-get-request.bin") },
+        .dispatcher = .{ .resources = &resources },
+    };
+    _ = (try server.handle(@embedFile("../testvectors/exchange-separate-request.bin"), 1, 0)).?;
+    server.shutdown(5000);
+    server.transport.time = 4999;
+    try server.serveOnce();
+    try testing.expect(!server.isClosed());
+    server.transport.time = 5000;
+    try server.serve();
+    try testing.expect(server.transport.closed);
+}
+
+test "test server rejects unrecognized critical options" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = testHandler },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    const req = @embedFile("../testvectors/exchange-bad-option-diagnostic-request.bin");
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/exchange-bad-option-diagnostic-response.bin"), (try server.handle(req, 1, 0)).?);
+    var reply = try pkt.Request.init((try server.handle(@embedFile("../testvectors/exchange-bad-option-request.bin"), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.BAD_OPT));
+    try testing.expectEqualStrings("unrecognized critical options: 65001", (try reply.extractPayload()).?);
+
+    // Options known to the server are passed to the handler.
+    server.known_options = &[_]u32{ 65001, 65003 };
+    reply = try pkt.Request.init((try server.handle(req, 2, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+}
+
+test "test server survives malformed input" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = testHandler },
+    };
+    var server = ServerWithConfig(TestTransport, .{ .cache_entries = 1 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    const Vector = struct {
+        data: []const u8,
+        // Type and code of the reply, if any.
+        reply: ?pkt.Msg,
+        code: codes.Code = codes.Code{ .class = 0, .detail = 0 },
+    };
+    const vectors = [_]Vector{
+        // Message format errors are rejected with a reset.
+        .{ .data = @embedFile("../testvectors/option-delta-15.bin"), .reply = pkt.Msg.rst },
+        .{ .data = @embedFile("../testvectors/option-length-15.bin"), .reply = pkt.Msg.rst },
+        .{ .data = @embedFile("../testvectors/option-delta-overflow.bin"), .reply = pkt.Msg.rst },
+        .{ .data = @embedFile("../testvectors/truncated-option-delta.bin"), .reply = pkt.Msg.rst },
+        .{ .data = @embedFile("../testvectors/truncated-option-length.bin"), .reply = pkt.Msg.rst },
+        .{ .data = @embedFile("../testvectors/truncated-option-value.bin"), .reply = pkt.Msg.rst },
+        .{ .data = @embedFile("../testvectors/payload-marker-only.bin"), .reply = pkt.Msg.rst },
+        // Confirmable response with a reserved option delta.
+        .{ .data = &[_]u8{ 0x40, 0x45, 0x00, 0x2a, 0xf1, 0x00 }, .reply = pkt.Msg.rst },
+        // Options violating their definition are rejected with 4.02 (Bad
+        // Option): a Content-Format with a 3-byte value, a repeated
+        // Content-Format, and a zero-length Uri-Host.
+        .{ .data = @embedFile("../testvectors/option-value-too-long.bin"), .reply = pkt.Msg.ack, .code = codes.BAD_OPT },
+        .{ .data = &[_]u8{ 0x40, 0x01, 0x00, 0x2a, 0xb5, 'h', 'e', 'l', 'l', 'o', 0x10, 0x00 }, .reply = pkt.Msg.ack, .code = codes.BAD_OPT },
+        .{ .data = &[_]u8{ 0x40, 0x01, 0x00, 0x2a, 0x30 }, .reply = pkt.Msg.ack, .code = codes.BAD_OPT },
+        // Non-confirmable messages and messages with a malformed header
+        // are ignored.
+        .{ .data = &[_]u8{ 0x50, 0x01, 0x00, 0x2a, 0xf1, 0x00 }, .reply = null },
+        .{ .data = @embedFile("../testvectors/invalid-token-length.bin"), .reply = null },
+        .{ .data = @embedFile("../testvectors/token-length-9.bin"), .reply = null },
+        .{ .data = @embedFile("../testvectors/truncated-header.bin"), .reply = null },
+    };
+
+    for (vectors) |vector| {
+        // Advance the clock such that messages with the same Message ID
+        // are not considered duplicates.
+        server.transport.time += dedup.EXCHANGE_LIFETIME;
+        server.transport.input = vector.data;
+        server.transport.sent = null;
+        try server.serveOnce();
+
+        const expected = vector.reply orelse {
+            try testing.expect(server.transport.sent == null);
+            continue;
+        };
+        const sent = try pkt.Request.init(server.transport.sent.?);
+        const message_id = try pkt.Request.init(vector.data);
+        try testing.expect(sent.header.type == expected);
+        try testing.expect(sent.header.code.equal(vector.code));
+        try testing.expect(sent.header.message_id == message_id.header.message_id);
+    }
+
+    // Well-formed requests are still served afterwards.
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x01}, 0x002b);
+    try msg.addURIPath("hello");
+    server.transport.input = msg.marshal();
+    try server.serveOnce();
+    var reply = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+}
+
+test "test server response cache" {
+    const resources = [_]res.Resource{
+        .{ .path = "hello", .handler = res.chain(testHandler, .{countRequests}) },
+    };
+    var server = ServerWithConfig(TestTransport, .{ .cache_entries = 2 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    var buf: [32]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{0x01}, 0x1001);
+    try msg.addURIPath("hello");
+    const handled = handled_requests;
+    _ = (try server.handle(msg.marshal(), 1, 0)).?;
+    try testing.expect(handled_requests == handled + 1);
+
+    // Repeated requests are answered from the cache while the response
+    // is fresh, with the remaining lifetime as Max-Age.
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x02}, 0x1002);
+    try msg.addURIPath("hello");
+    var reply = try pkt.Request.init((try server.handle(msg.marshal(), 2, 10 * 1000)).?);
+    try testing.expect(handled_requests == handled + 1);
+    try testing.expect(reply.header.type == pkt.Msg.ack);
+    try testing.expect(reply.header.message_id == 0x1002);
+    try testing.expectEqualSlices(u8, &[_]u8{0x02}, reply.token);
+    try testing.expect((try reply.getMaxAge()) == 50);
+    try testing.expectEqualStrings("Hello, World!", (try reply.extractPayload()).?);
+
+    // Stale responses are not used.
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{0x03}, 0x1003);
+    try msg.addURIPath("hello");
+    _ = (try server.handle(msg.marshal(), 1, 60 * 1000)).?;
+    try testing.expect(handled_requests == handled + 2);
+    try testing.expect(server.responses.count() == 1);
+
+    // Notifications indicate a state change and discard the cache.
+    try server.notify("hello", pkt.Msg.non, 60 * 1000);
+    try testing.expect(server.responses.count() == 0);
+
+    // Requests with a different cache key are not answered from the
+    // cache, e.g. if they include other options.
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{0x04}, 0x1004);
+    try msg.addURIPath("hello");
+    _ = (try server.handle(msg.marshal(), 1, 61 * 1000)).?;
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{0x05}, 0x1005);
+    try msg.addURIPath("hello");
+    try msg.addUint(opts.Accept, @enumToInt(ContentFormat.text_plain));
+    _ = (try server.handle(msg.marshal(), 1, 61 * 1000)).?;
+    try testing.expect(handled_requests == handled + 4);
+    try testing.expect(server.responses.count() == 2);
+}
+
+test "test observer notification periods" {
+    const periods = blk: {
+        var buf: [32]u8 = undefined;
+        var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{}, 0);
+        try msg.addURIQuery("pmin=10&pmax=5&rt=temp");
+        const req = try pkt.Request.init(msg.marshal());
+        break :blk try observe.Periods.fromRequest(&req);
+    };
+    // The maximum period must not be less than the minimum period.
+    try testing.expect(periods.pmin.? == 10 and periods.pmax == null);
+
+    const resources = [_]res.Resource{
+        .{ .path = "temperature", .handler = temperatureHandler, .observable = true },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    var buf: [48]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x4a}, 0x2001);
+    try msg.addUint(opts.Observe, 0);
+    try msg.addURIPath("temperature");
+    try msg.addURIQuery("pmin=10&pmax=60");
+    var reply = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expect((try reply.getObserve()) != null);
+
+    // State changes within the minimum period are coalesced.
+    server.transport.sent = null;
+    try server.notify("temperature", pkt.Msg.non, 5000);
+    try server.notify("temperature", pkt.Msg.con, 6000);
+    try testing.expect(server.transport.sent == null);
+    try server.poll(9999);
+    try testing.expect(server.transport.sent == null);
+    try server.poll(10000);
+    var notification = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(notification.header.type == pkt.Msg.con);
+    try testing.expect((try notification.getObserve()) != null);
+
+    var ack_buf: [4]u8 = undefined;
+    var ack = try pkt.Response.init(&ack_buf, pkt.Msg.ack, .{ .class = 0, .detail = 0 }, &[_]u8{}, notification.header.message_id);
+    try testing.expect((try server.handle(ack.marshal(), 1, 10000)) == null);
+
+    // A notification is sent if none was sent within the maximum period.
+    server.transport.sent = null;
+    try server.poll(69999);
+    try testing.expect(server.transport.sent == null);
+    try server.poll(70000);
+    notification = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(notification.header.code.equal(codes.CONTENT));
+
+    // Registrations with malformed periods are rejected.
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x4b}, 0x2002);
+    try msg.addUint(opts.Observe, 0);
+    try msg.addURIPath("temperature");
+    try msg.addURIQuery("pmin=soon");
+    reply = try pkt.Request.init((try server.handle(msg.marshal(), 2, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.BAD_REQ));
+}
+
+test "test server rejects oversized requests" {
+    const resources = [_]res.Resource{
+        .{ .path = "large", .handler = storeHandler },
+    };
+
+    // Size1 indicates the maximum body size accepted by the server.
+    var server = ServerWithConfig(TestTransport, .{ .request_size = 1024 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+    try testing.expectEqualSlices(u8, @embedFile("../testvectors/size1-too-large.bin"), (try server.handle(@embedFile("../testvectors/size1-request-2.bin"), 1, 0)).?);
+    const accepted = try pkt.Request.init((try server.handle(@embedFile("../testvectors/size1-request-1.bin"), 2, 0)).?);
+    try testing.expect(accepted.header.code.equal(codes.CONTINUE));
+
+    // The limit also applies to bodies sent in a single message.
+    var small = ServerWithConfig(TestTransport, .{ .request_size = 32 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.PUT, &[_]u8{}, 0x2101);
+    try msg.addURIPath("large");
+    try msg.payloadWriter().writeAll("0123456789abcdef0123456789abcdef0");
+    const rejected = try pkt.Request.init((try small.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(rejected.header.code.equal(codes.TOO_LARGE));
+    try testing.expectEqual(@as(u32, 32), (try rejected.getUint(opts.Size1)).?);
+
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.PUT, &[_]u8{}, 0x2102);
+    try msg.addURIPath("large");
+    try msg.payloadWriter().writeAll("0123456789abcdef0123456789abcdef");
+    const stored = try pkt.Request.init((try small.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(stored.header.code.isSuccess());
+}
+
+test "test congestion control of notifications" {
+    const resources = [_]res.Resource{
+        .{ .path = "temperature", .handler = temperatureHandler, .observable = true },
+    };
+    var server = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &resources },
+    };
+
+    var buf: [32]u8 = undefined;
+    for ([_]u8{ 0x5a, 0x5b }) |token, i| {
+        var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{token}, 0x2200 + @intCast(u16, i));
+        try msg.addUint(opts.Observe, 0);
+        try msg.addURIPath("temperature");
+        _ = (try server.handle(msg.marshal(), 1, 0)).?;
+    }
+
+    // Only one confirmable notification is outstanding per endpoint.
+    temperature = "19.0 C";
+    server.transport.sent = null;
+    try server.notify("temperature", pkt.Msg.con, 0);
+    const first = try pkt.Request.init(server.transport.sent.?);
+    try testing.expectEqualSlices(u8, &[_]u8{0x5a}, first.token);
+
+    server.transport.sent = null;
+    try server.poll(1000);
+    try testing.expect(server.transport.sent == null);
+
+    // Newer notifications replace unacknowledged ones.
+    temperature = "19.5 C";
+    try server.notify("temperature", pkt.Msg.non, 1000);
+    try testing.expect(server.transport.sent == null);
+    try server.poll(2000);
+    var retransmission = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(retransmission.header.type == pkt.Msg.con);
+    try testing.expect(retransmission.header.message_id != first.header.message_id);
+    try testing.expectEqualStrings("19.5 C", (try retransmission.extractPayload()).?);
+
+    var ack_buf: [4]u8 = undefined;
+    var ack = try pkt.Response.init(&ack_buf, pkt.Msg.ack, .{ .class = 0, .detail = 0 }, &[_]u8{}, retransmission.header.message_id);
+    try testing.expect((try server.handle(ack.marshal(), 1, 2000)) == null);
+
+    // The notification to the other observer is sent afterwards.
+    server.transport.sent = null;
+    try server.poll(2000);
+    var second = try pkt.Request.init(server.transport.sent.?);
+    try testing.expectEqualSlices(u8, &[_]u8{0x5b}, second.token);
+    try testing.expectEqualStrings("19.5 C", (try second.extractPayload()).?);
+    ack = try pkt.Response.init(&ack_buf, pkt.Msg.ack, .{ .class = 0, .detail = 0 }, &[_]u8{}, second.header.message_id);
+    try testing.expect((try server.handle(ack.marshal(), 1, 2000)) == null);
+
+    // Non-confirmable notifications are sent as confirmable ones once a
+    // day.
+    try server.notify("temperature", pkt.Msg.non, 2000 + 60 * 1000);
+    try testing.expect((try pkt.Request.init(server.transport.sent.?)).header.type == pkt.Msg.non);
+    try server.notify("temperature", pkt.Msg.non, 2000 + CON_NOTIFICATION_INTERVAL);
+    try testing.expect((try pkt.Request.init(server.transport.sent.?)).header.type == pkt.Msg.con);
+}
+
+test "test forward proxy" {
+    var server = ServerWithConfig(TestTransport, .{ .proxy_exchanges = 1, .cache_entries = 1 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &[_]res.Resource{} },
+        .message_id = 0x4000,
+    };
+
+    // Confirmable requests are acknowledged and forwarded using a new
+    // Message ID and token.
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x2a}, 0x2601);
+    try msg.addOption(&opts.Option{ .number = opts.ProxyURI, .value = "coap://origin.example:61616/temp" });
+    var reply = try pkt.Request.init((try server.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.type == pkt.Msg.ack);
+    try testing.expect(reply.header.code.isEmpty());
+
+    try server.poll(0);
+    try testing.expect(server.transport.peer == 61616);
+    var forwarded = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(forwarded.header.type == pkt.Msg.con);
+    try testing.expect(forwarded.header.message_id == 0x4000);
+    try testing.expectEqualSlices(u8, &[_]u8{ 0x40, 0x00 }, forwarded.token);
+    try testing.expectEqualStrings("origin.example", (try forwarded.getString(opts.URIHost)).?);
+    try testing.expect((try forwarded.getUint(opts.URIPort)).? == 61616);
+    try testing.expectEqualStrings("temp", (try forwarded.getString(opts.URIPath)).?);
+    try testing.expect((try forwarded.getString(opts.ProxyURI)) == null);
+
+    // The response is relayed as separate response.
+    var response = try pkt.Response.init(&buf, pkt.Msg.ack, codes.CONTENT, &[_]u8{ 0x40, 0x00 }, 0x4000);
+    try response.addUint(opts.MaxAge, 30);
+    try response.payloadWriter().writeAll("22.5 C");
+    try testing.expect((try server.handle(response.marshal(), 61616, 1000)) == null);
+    try server.poll(1000);
+    try testing.expect(server.transport.peer == 1);
+    var relayed = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(relayed.header.type == pkt.Msg.con);
+    try testing.expect(relayed.header.code.equal(codes.CONTENT));
+    try testing.expect(relayed.header.message_id == 0x4001);
+    try testing.expectEqualSlices(u8, &[_]u8{0x2a}, relayed.token);
+    try testing.expectEqualStrings("22.5 C", (try relayed.extractPayload()).?);
+
+    var ack = try pkt.Response.init(&buf, pkt.Msg.ack, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, 0x4001);
+    try testing.expect((try server.handle(ack.marshal(), 1, 1500)) == null);
+
+    // Repeated requests are answered from the cache.
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{0x2b}, 0x2602);
+    try msg.addOption(&opts.Option{ .number = opts.ProxyURI, .value = "coap://origin.example:61616/temp" });
+    reply = try pkt.Request.init((try server.handle(msg.marshal(), 2, 2000)).?);
+    try testing.expect(reply.header.code.equal(codes.CONTENT));
+    try testing.expect((try reply.getMaxAge()) == 29);
+    try testing.expectEqualStrings("22.5 C", (try reply.extractPayload()).?);
+
+    // Unknown origin servers are answered with 5.02 (Bad Gateway).
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x2c}, 0x2603);
+    try msg.addOption(&opts.Option{ .number = opts.ProxyURI, .value = "coap://unknown.example/temp" });
+    reply = try pkt.Request.init((try server.handle(msg.marshal(), 3, 2500)).?);
+    try testing.expect(reply.header.code.equal(codes.BAD_GATEWAY));
+
+    // Clients are sent 5.04 (Gateway Timeout) if the origin server does
+    // not respond.
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{0x2d}, 0x2604);
+    try msg.addOption(&opts.Option{ .number = opts.ProxyURI, .value = "coap://origin.example/light" });
+    try testing.expect((try server.handle(msg.marshal(), 3, 3000)) == null);
+    try server.poll(3000);
+    try testing.expect(server.transport.peer == 5683);
+    try server.poll(3000 + MAX_TRANSMIT_WAIT);
+    try testing.expect(server.transport.peer == 3);
+    reply = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(reply.header.code.equal(codes.GATEWAY_TIMEOUT));
+    try testing.expectEqualSlices(u8, &[_]u8{0x2d}, reply.token);
+
+    // Servers which are not configured as proxy answer with 5.05
+    // (Proxying Not Supported).
+    var plain = Server(TestTransport){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &[_]res.Resource{} },
+    };
+    msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x2e}, 0x2605);
+    try msg.addOption(&opts.Option{ .number = opts.ProxyURI, .value = "coap://origin.example/temp" });
+    reply = try pkt.Request.init((try plain.handle(msg.marshal(), 1, 0)).?);
+    try testing.expect(reply.header.code.equal(codes.NO_PROXY));
+}
+
+test "test forward proxy with confirmable origin responses" {
+    var server = ServerWithConfig(TestTransport, .{ .proxy_exchanges = 1 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &[_]res.Resource{} },
+        .message_id = 0x4000,
+    };
+
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x2a}, 0x2701);
+    try msg.addOption(&opts.Option{ .number = opts.ProxyURI, .value = "coap://origin.example:61616/temp" });
+    _ = (try server.handle(msg.marshal(), 1, 0)).?;
+    try server.poll(0);
+    try testing.expect(server.transport.peer == 61616);
+
+    // The origin server acknowledges the request and sends a separate
+    // confirmable response, which is acknowledged and relayed.
+    var ack = try pkt.Response.init(&buf, pkt.Msg.ack, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, 0x4000);
+    try testing.expect((try server.handle(ack.marshal(), 61616, 500)) == null);
+
+    var response = try pkt.Response.init(&buf, pkt.Msg.con, codes.CONTENT, &[_]u8{ 0x40, 0x00 }, 0x7001);
+    try response.payloadWriter().writeAll("22.5 C");
+    var reply = try pkt.Request.init((try server.handle(response.marshal(), 61616, 1000)).?);
+    try testing.expect(reply.header.type == pkt.Msg.ack);
+    try testing.expect(reply.header.code.isEmpty());
+    try testing.expect(reply.header.message_id == 0x7001);
+
+    try server.poll(1000);
+    try testing.expect(server.transport.peer == 1);
+    var relayed = try pkt.Request.init(server.transport.sent.?);
+    try testing.expectEqualStrings("22.5 C", (try relayed.extractPayload()).?);
+
+    // Retransmissions of the response, e.g. if the acknowledgement was
+    // lost, are acknowledged again instead of being rejected with a
+    // reset, but are not relayed a second time.
+    server.transport.sent = null;
+    reply = try pkt.Request.init((try server.handle(response.marshal(), 61616, 1200)).?);
+    try testing.expect(reply.header.type == pkt.Msg.ack);
+    try testing.expect(reply.header.code.isEmpty());
+    try testing.expect(reply.header.message_id == 0x7001);
+    try server.poll(1200);
+    try testing.expect(server.transport.sent == null);
+
+    // Unknown confirmable responses are still rejected with a reset.
+    response = try pkt.Response.init(&buf, pkt.Msg.con, codes.CONTENT, &[_]u8{ 0x40, 0x00 }, 0x7002);
+    reply = try pkt.Request.init((try server.handle(response.marshal(), 61616, 1500)).?);
+    try testing.expect(reply.header.type == pkt.Msg.rst);
+    try testing.expect(reply.header.message_id == 0x7002);
+}
+
+test "test forward proxy with misbehaving origin servers" {
+    var server = ServerWithConfig(TestTransport, .{ .proxy_exchanges = 1, .cache_entries = 1, .pending_messages = 1, .client_requests = 1 }){
+        .transport = .{ .input = undefined },
+        .dispatcher = .{ .resources = &[_]res.Resource{} },
+        .message_id = 0x4000,
+    };
+
+    var buf: [64]u8 = undefined;
+    var msg = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, &[_]u8{0x2a}, 0x2801);
+    try msg.addOption(&opts.Option{ .number = opts.ProxyURI, .value = "coap://origin.example:61616/temp" });
+    _ = (try server.handle(msg.marshal(), 1, 0)).?;
+    try server.poll(0);
+    try testing.expect(server.transport.peer == 61616);
+
+    // The response of the origin server is received before the
+    // acknowledgement of the forwarded request, which still occupies
+    // the only transmission slot. The response is neither acknowledged
+    // nor relayed until it is retransmitted.
+    var origin_buf: [64]u8 = undefined;
+    var response = try pkt.Response.init(&origin_buf, pkt.Msg.con, codes.CONTENT, &[_]u8{ 0x40, 0x00 }, 0x7001);
+    try response.addOption(&opts.Option{ .number = opts.ContentFormat, .value = &[_]u8{} });
+    try response.addOption(&opts.Option{ .number = opts.ContentFormat, .value = &[_]u8{50} });
+    try response.payloadWriter().writeAll("22.5 C");
+    server.transport.sent = null;
+    try testing.expect((try server.handle(response.marshal(), 61616, 100)) == null);
+    try server.poll(100);
+    try testing.expect(server.transport.sent == null);
+
+    var ack = try pkt.Response.init(&buf, pkt.Msg.ack, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, 0x4000);
+    try testing.expect((try server.handle(ack.marshal(), 61616, 200)) == null);
+
+    // The repeated Content-Format option is relayed as is, but the
+    // response is not cached since its Max-Age cannot be determined.
+    var reply = try pkt.Request.init((try server.handle(response.marshal(), 61616, 2100)).?);
+    try testing.expect(reply.header.type == pkt.Msg.ack);
+    try testing.expect(reply.header.message_id == 0x7001);
+    try server.poll(2100);
+    try testing.expect(server.transport.peer == 1);
+    var relayed = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(relayed.header.code.equal(codes.CONTENT));
+    try testing.expectEqualStrings("22.5 C", (try relayed.extractPayload()).?);
+    const relayed_id = relayed.header.message_id;
+
+    ack = try pkt.Response.init(&buf, pkt.Msg.ack, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, relayed_id);
+    try testing.expect((try server.handle(ack.marshal(), 1, 2200)) == null);
+
+    // If 5.04 (Gateway Timeout) cannot be queued once the exchange
+    // expired, it is sent by a later invocation of poll.
+    msg = try pkt.Response.init(&buf, pkt.Msg.non, codes.GET, &[_]u8{0x2b}, 0x2802);
+    try msg.addOption(&opts.Option{ .number = opts.ProxyURI, .value = "coap://origin.example:61616/temp" });
+    try testing.expect((try server.handle(msg.marshal(), 2, 3000)) == null);
+    try server.poll(3000);
+    try testing.expect(server.transport.peer == 61616);
+
+    const expiry = 3000 + MAX_TRANSMIT_WAIT;
+    try server.request(7, codes.GET, ".well-known/core", fetchHandler, expiry - 1);
+    try server.poll(expiry);
+    try testing.expect(server.transport.peer == 7);
+    const query = try pkt.Request.init(server.transport.sent.?);
+    ack = try pkt.Response.init(&buf, pkt.Msg.ack, codes.Code{ .class = 0, .detail = 0 }, &[_]u8{}, query.header.message_id);
+    try testing.expect((try server.handle(ack.marshal(), 7, expiry)) == null);
+
+    try server.poll(expiry + 1);
+    try testing.expect(server.transport.peer == 2);
+    reply = try pkt.Request.init(server.transport.sent.?);
+    try testing.expect(reply.header.code.equal(codes.GATEWAY_TIMEOUT));
+    try testing.expectEqualSlices(u8, &[_]u8{0x2b}, reply.token);
+}
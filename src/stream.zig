@@ -0,0 +1,323 @@
+const std = @import("std");
+const testing = std.testing;
+
+const buffer = @import("buffer.zig");
+const codes = @import("codes.zig");
+const pkt = @import("packet.zig");
+const opts = @import("opts.zig");
+
+/// Status of a StreamParser after data has been fed to it.
+pub const Status = union(enum) {
+    /// All given data was consumed and at least the given amount of
+    /// additional bytes is needed to complete the message.
+    incomplete: usize,
+    /// The message is complete, only the given amount of bytes of the
+    /// given data was consumed. The remaining bytes belong to the next
+    /// message and must be fed again after calling reset.
+    complete: usize,
+};
+
+/// A message received using the CoAP over TCP message format.
+pub const StreamMessage = struct {
+    code: codes.Code,
+    token: []const u8,
+    body: []const u8,
+
+    /// Parse a complete message in the CoAP over TCP message format, e.g.
+    /// received using a transport which preserves message boundaries.
+    /// The given buffer must contain exactly one message. The returned
+    /// message references the given buffer.
+    pub fn init(buf: []const u8) !StreamMessage {
+        if (buf.len == 0)
+            return error.FormatError;
+
+        const hdr_len = 1 + extendedSize(@intCast(u4, buf[0] >> 4));
+        if (buf.len < hdr_len)
+            return error.FormatError;
+        if ((try messageLength(buf[0..hdr_len])) != buf.len)
+            return error.FormatError;
+
+        return split(buf);
+    }
+
+    /// Parse the given payload of a WebSocket frame as message in the
+    /// CoAP over WebSockets message format (see RFC 8323 Section 4.2).
+    /// The returned message references the given buffer.
+    pub fn initWebSocket(buf: []const u8) !StreamMessage {
+        // From RFC 8323:
+        //
+        //  The Length (Len) field MUST be set to zero, because the
+        //  WebSocket frame contains the length.
+        //
+        if (buf.len < 2 or buf[0] >> 4 != 0)
+            return error.FormatError;
+
+        const tkl = buf[0] & 0xf;
+        if (tkl > pkt.MAX_TOKEN_LEN or buf.len < 2 + tkl)
+            return error.FormatError;
+
+        return split(buf);
+    }
+
+    /// Returns an iterator over the options of the message. After the
+    /// iterator returned null, the payload can be obtained from it.
+    pub fn options(self: *const StreamMessage) pkt.OptionIterator {
+        return pkt.OptionIterator{ .slice = buffer.ReadBuffer{ .slice = self.body } };
+    }
+};
+
+/// Push-style parser for the CoAP over TCP message format (see RFC 8323
+/// Section 3.2), which is also suitable for other reliable byte-stream
+/// transports (e.g. serial links). Data can be fed in chunks of
+/// arbitrary size as it is received. The message is assembled in the
+/// given buffer, no memory is allocated.
+pub const StreamParser = struct {
+    buf: []u8,
+    pos: usize = 0,
+
+    /// Total length of the message, zero if it is not known yet.
+    total: usize = 0,
+
+    /// Returns the amount of bytes needed to determine the length of the
+    /// header, including the extended length, or the length of the
+    /// entire message if the header has been received.
+    fn needed(self: *StreamParser) usize {
+        if (self.total > 0)
+            return self.total;
+        if (self.pos < 1)
+            return 1;
+        return 1 + extendedSize(@intCast(u4, self.buf[0] >> 4));
+    }
+
+    /// Feed received data to the parser.
+    pub fn feed(self: *StreamParser, data: []const u8) !Status {
+        var consumed: usize = 0;
+
+        while (true) {
+            const n = self.needed();
+            if (self.pos == n and self.total > 0)
+                return Status{ .complete = consumed };
+            if (self.pos == n) {
+                self.total = try messageLength(self.buf[0..self.pos]);
+                continue;
+            }
+
+            if (consumed == data.len)
+                return Status{ .incomplete = n - self.pos };
+            if (n > self.buf.len)
+                return error.BufTooSmall;
+
+            const len = std.math.min(n - self.pos, data.len - consumed);
+            std.mem.copy(u8, self.buf[self.pos..], data[consumed .. consumed + len]);
+            self.pos += len;
+            consumed += len;
+        }
+    }
+
+    /// Returns the received message. This function must only be called
+    /// after feed reported a complete message.
+    pub fn message(self: *StreamParser) StreamMessage {
+        std.debug.assert(self.total > 0 and self.pos == self.total);
+        return split(self.buf[0..self.total]);
+    }
+
+    /// Discard the received message, allowing the next message to be
+    /// parsed.
+    pub fn reset(self: *StreamParser) void {
+        self.pos = 0;
+        self.total = 0;
+    }
+};
+
+// Compute the length of the entire message from the initial byte and
+// the extended length contained in the given header.
+fn messageLength(hdr: []const u8) !usize {
+    const tkl = hdr[0] & 0xf;
+    if (tkl > pkt.MAX_TOKEN_LEN)
+        return error.FormatError;
+
+    const nibble = @intCast(u4, hdr[0] >> 4);
+    const ext = hdr[1 .. 1 + extendedSize(nibble)];
+
+    // From RFC 8323:
+    //
+    //  Length (Len): 4-bit unsigned integer. A value between 0 and 12
+    //  inclusive indicates the length of the message in bytes starting
+    //  with the first bit of the Options field.
+    //
+    const len: usize = switch (nibble) {
+        13 => @as(usize, ext[0]) + 13,
+        14 => @as(usize, std.mem.readIntBig(u16, ext[0..2])) + 269,
+        15 => @as(usize, std.mem.readIntBig(u32, ext[0..4])) + 65805,
+        else => nibble,
+    };
+
+    // Initial byte, extended length, code, and token.
+    return 1 + ext.len + 1 + tkl + len;
+}
+
+// Split the given complete message into its fields.
+fn split(msg: []const u8) StreamMessage {
+    const tkl = msg[0] & 0xf;
+    const hdr = 1 + extendedSize(@intCast(u4, msg[0] >> 4));
+
+    return StreamMessage{
+        .code = @bitCast(codes.Code, msg[hdr]),
+        .token = msg[hdr + 1 .. hdr + 1 + tkl],
+        .body = msg[hdr + 1 + tkl ..],
+    };
+}
+
+/// Encode a message with the given code, token, and body (i.e. options
+/// and payload) in the CoAP over TCP message format into the given
+/// buffer, returns the length of the encoded message. See also
+/// Response.marshalStream.
+pub fn encode(buf: []u8, code: codes.Code, token: []const u8, body: []const u8) !usize {
+    if (token.len > pkt.MAX_TOKEN_LEN)
+        return error.InvalidTokenLength;
+
+    // See RFC 8323 Section 3.2 and messageLength.
+    var ext: [4]u8 = undefined;
+    var ext_len: usize = 0;
+    var nibble: u8 = undefined;
+    if (body.len < 13) {
+        nibble = @intCast(u8, body.len);
+    } else if (body.len < 269) {
+        nibble = 13;
+        ext[0] = @intCast(u8, body.len - 13);
+        ext_len = 1;
+    } else if (body.len < 65805) {
+        nibble = 14;
+        std.mem.writeIntBig(u16, ext[0..2], @intCast(u16, body.len - 269));
+        ext_len = 2;
+    } else {
+        nibble = 15;
+        const len = std.math.cast(u32, body.len - 65805) catch {
+            return error.InvalidLength;
+        };
+        std.mem.writeIntBig(u32, &ext, len);
+        ext_len = 4;
+    }
+
+    return writeMessage(buf, nibble, ext[0..ext_len], code, token, body);
+}
+
+/// Encode a message in the CoAP over WebSockets message format (see RFC
+/// 8323 Section 4.2) into the given buffer, like encode. Since the length
+/// of the message is given by the WebSocket frame, the Len field is
+/// always zero. See also Response.marshalWebSocket.
+pub fn encodeWebSocket(buf: []u8, code: codes.Code, token: []const u8, body: []const u8) !usize {
+    if (token.len > pkt.MAX_TOKEN_LEN)
+        return error.InvalidTokenLength;
+    return writeMessage(buf, 0, &[_]u8{}, code, token, body);
+}
+
+fn writeMessage(buf: []u8, nibble: u8, ext: []const u8, code: codes.Code, token: []const u8, body: []const u8) !usize {
+    const total = 1 + ext.len + 1 + token.len + body.len;
+    if (buf.len < total)
+        return error.BufTooSmall;
+
+    var wb = buffer.WriteBuffer{ .slice = buf };
+    wb.byte(nibble << 4 | @intCast(u8, token.len));
+    wb.bytes(ext);
+    wb.byte(@bitCast(u8, code));
+    wb.bytes(token);
+    wb.bytes(body);
+
+    return total;
+}
+
+/// Amount of extended length bytes following the initial byte.
+fn extendedSize(nibble: u4) usize {
+    return switch (nibble) {
+        13 => 1,
+        14 => 2,
+        15 => 4,
+        else => 0,
+    };
+}
+
+fn expectStream(parser: *StreamParser, data: []const u8, chunk: usize, exp: []const u8) !void {
+    var input = data;
+    while (true) {
+        const n = std.math.min(chunk, input.len);
+        const status = try parser.feed(input[0..n]);
+        switch (status) {
+            Status.incomplete => |needed| {
+                try testing.expect(needed > 0);
+                input = input[n..];
+            },
+            Status.complete => |consumed| {
+                try testing.expect(std.mem.eql(u8, parser.buf[0..parser.pos], exp));
+                input = input[consumed..];
+                break;
+            },
+        }
+    }
+
+    // Bytes of the following message must not have been consumed.
+    try testing.expect(input.len == data.len - exp.len);
+}
+
+test "test stream parser with chunked input" {
+    const csm = @embedFile("../testvectors/tcp-csm.bin");
+    const ping = @embedFile("../testvectors/tcp-ping.bin");
+    const ext0 = @embedFile("../testvectors/tcp-ext-length-0.bin");
+    const ext1 = @embedFile("../testvectors/tcp-ext-length-1.bin");
+    const ext2 = @embedFile("../testvectors/tcp-ext-length-2.bin");
+    const release = @embedFile("../testvectors/tcp-release.bin");
+
+    // Concatenation of all vectors, as received on a stream.
+    const vectors = [_][]const u8{ csm, ping, ext0, ext1, ext2, release };
+    const data = csm ++ ping ++ ext0 ++ ext1 ++ ext2 ++ release;
+
+    const chunks = [_]usize{ 1, 3, 7, 512 };
+    for (chunks) |chunk| {
+        var buf: [512]u8 = undefined;
+        var parser = StreamParser{ .buf = &buf };
+
+        var input: []const u8 = data;
+        for (vectors) |exp| {
+            try expectStream(&parser, input, chunk, exp);
+            input = input[exp.len..];
+            parser.reset();
+        }
+        try testing.expect(input.len == 0);
+    }
+}
+
+test "test stream parser message decoding" {
+    const buf = @embedFile("../testvectors/tcp-ext-length-1.bin");
+
+    var mbuf: [64]u8 = undefined;
+    var parser = StreamParser{ .buf = &mbuf };
+    const status = try parser.feed(buf);
+    try testing.expect(status.complete == buf.len);
+
+    const msg = parser.message();
+    try testing.expect(msg.code.equal(codes.CONTENT));
+    try testing.expect(std.mem.eql(u8, msg.token, &[_]u8{ 0x7c, 0xb0 }));
+
+    var iter = msg.options();
+    const opt = (try iter.next()).?;
+    try testing.expect(opt.number == opts.ContentFormat);
+    try testing.expect((try iter.next()) == null);
+    try testing.expect(std.mem.eql(u8, iter.payload().?, "xxxxxxxxxxx"));
+}
+
+// Buffer for the largest TCP vectors, too large for the stack.
+var large_buf: [65813]u8 = undefined;
+
+test "test stream parser with 4-byte extended length" {
+    const buf = @embedFile("../testvectors/tcp-ext-length-4.bin");
+
+    var parser = StreamParser{ .buf = &large_buf };
+    try testing.expect((try parser.feed(buf[0..1])).incomplete == 4);
+    try testing.expect((try parser.feed(buf[1..5])).incomplete == buf.len - 5);
+    try testing.expect((try parser.feed(buf[5..])).complete == buf.len - 5);
+
+    // Buffer too small for the message.
+    var small: [64]u8 = undefined;
+    var short = StreamParser{ .buf = &small };
+    try testing.expectError(error.BufTooSmall, short.feed(buf));
+}
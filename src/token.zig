@@ -0,0 +1,98 @@
+const std = @import("std");
+const testing = std.testing;
+
+const pkt = @import("packet.zig");
+const codes = @import("codes.zig");
+
+// From RFC 7252:
+//
+//  A client that is connected to the general Internet SHOULD use at
+//  least 32 bits of randomness, keeping in mind that not being directly
+//  connected to the Internet is not necessarily sufficient protection
+//  against spoofing.
+//
+pub const DEFAULT_LEN = 4;
+
+/// Token of a request (see RFC 7252 Section 5.3.1), used to match
+/// responses to requests. Tokens should be generated randomly to make
+/// it harder for an off-path attacker to spoof responses.
+pub const Token = struct {
+    buf: [pkt.MAX_TOKEN_LEN]u8 = undefined,
+    len: usize = 0,
+
+    /// Generate a token with the given length in bytes using the given
+    /// source of randomness, which must be cryptographically secure
+    /// (e.g. std.crypto.random) for the token to protect against
+    /// spoofing.
+    pub fn generate(random: std.rand.Random, len: usize) !Token {
+        if (len > pkt.MAX_TOKEN_LEN)
+            return error.InvalidTokenLength;
+
+        var tok = Token{ .len = len };
+        random.bytes(tok.buf[0..len]);
+        return tok;
+    }
+
+    /// Copy the given token, e.g. the token of a received message.
+    pub fn fromSlice(value: []const u8) !Token {
+        if (value.len > pkt.MAX_TOKEN_LEN)
+            return error.InvalidTokenLength;
+
+        var tok = Token{ .len = value.len };
+        std.mem.copy(u8, &tok.buf, value);
+        return tok;
+    }
+
+    /// Returns the token value, e.g. for passing it to Response.init.
+    pub fn slice(self: *const Token) []const u8 {
+        return self.buf[0..self.len];
+    }
+
+    /// Compare the token with the given token value, e.g. the token of
+    /// a received response. The comparison takes constant time for
+    /// values of the same length, only the length is not kept secret.
+    pub fn equal(self: *const Token, value: []const u8) bool {
+        if (value.len != self.len)
+            return false;
+
+        var acc: u8 = 0;
+        for (value) |b, i| {
+            acc |= b ^ self.buf[i];
+        }
+        return acc == 0;
+    }
+
+    /// Formats the token as hex string.
+    pub fn format(self: Token, comptime fmt: []const u8, options: std.fmt.FormatOptions, writer: anytype) !void {
+        _ = fmt;
+        _ = options;
+        if (self.len == 0) {
+            try writer.writeAll("(empty)");
+        } else {
+            try writer.print("{}", .{std.fmt.fmtSliceHexLower(self.buf[0..self.len])});
+        }
+    }
+};
+
+test "test token generation" {
+    var prng = std.rand.DefaultPrng.init(0);
+    const random = prng.random();
+
+    const tok = try Token.generate(random, 8);
+    const other = try Token.generate(random, 8);
+    try testing.expect(tok.slice().len == 8);
+    try testing.expect(!tok.equal(other.slice()));
+    try testing.expectError(error.InvalidTokenLength, Token.generate(random, 9));
+
+    var buf: [16]u8 = undefined;
+    var resp = try pkt.Response.init(&buf, pkt.Msg.con, codes.GET, tok.slice(), 1);
+    const req = try pkt.Request.init(resp.marshal());
+    try testing.expect(tok.equal(req.token));
+    try testing.expect(!tok.equal(req.token[0..4]));
+
+    var out: [16]u8 = undefined;
+    const copy = try Token.fromSlice(&[_]u8{ 0x0a, 0x34 });
+    try testing.expectEqualStrings("0a34", try std.fmt.bufPrint(&out, "{}", .{copy}));
+    const empty = try Token.fromSlice(&[_]u8{});
+    try testing.expectEqualStrings("(empty)", try std.fmt.bufPrint(&out, "{}", .{empty}));
+}
@@ -0,0 +1,196 @@
+const std = @import("std");
+
+// Maximum length of a single Uri-Path or Uri-Query option value.
+//
+// From RFC 7252:
+//
+//  Each Uri-Path Option specifies one segment of the absolute path to
+//  the resource.
+//
+// Both options are registered with a maximum length of 255 bytes (see
+// RFC 7252 Section 5.10).
+pub const MAX_SEGMENT_LEN = 255;
+
+/// Characters which may appear in a path segment without being
+/// percent-encoded (see RFC 3986 Section 3.3).
+pub fn isPathChar(c: u8) bool {
+    return switch (c) {
+        // unreserved
+        'A'...'Z', 'a'...'z', '0'...'9', '-', '.', '_', '~' => true,
+        // sub-delims
+        '!', '$', '&', '\'', '(', ')', '*', '+', ',', ';', '=' => true,
+        ':', '@' => true,
+        else => false,
+    };
+}
+
+/// Characters which may appear in a query argument without being
+/// percent-encoded (see RFC 3986 Section 3.4). Since query arguments
+/// are separated by "&", this character must be encoded.
+pub fn isQueryChar(c: u8) bool {
+    if (c == '&')
+        return false;
+    return isPathChar(c) or c == '/' or c == '?';
+}
+
+/// Decode a percent-encoded URI component into the given buffer and
+/// return the decoded slice. An error is returned if the encoding is
+/// invalid or if the decoded component does not fit into the buffer.
+pub fn decode(buf: []u8, component: []const u8) ![]const u8 {
+    var n: usize = 0;
+    var i: usize = 0;
+    while (i < component.len) : (n += 1) {
+        if (n >= buf.len)
+            return error.SegmentTooLong;
+
+        if (component[i] != '%') {
+            buf[n] = component[i];
+            i += 1;
+            continue;
+        }
+
+        if (component.len - i < 3)
+            return error.InvalidEncoding;
+        const hi = std.fmt.charToDigit(component[i + 1], 16) catch {
+            return error.InvalidEncoding;
+        };
+        const lo = std.fmt.charToDigit(component[i + 2], 16) catch {
+            return error.InvalidEncoding;
+        };
+
+        buf[n] = hi << 4 | lo;
+        i += 3;
+    }
+
+    return buf[0..n];
+}
+
+/// Write the given URI component to the given writer, all characters
+/// for which the given function returns false are percent-encoded.
+pub fn writeEncoded(writer: anytype, component: []const u8, comptime allowed: fn (u8) bool) !void {
+    for (component) |c| {
+        if (allowed(c)) {
+            try writer.writeByte(c);
+        } else {
+            try writer.print("%{X:0>2}", .{c});
+        }
+    }
+}
+
+/// Default UDP port of the coap URI scheme.
+pub const DEFAULT_PORT = 5683;
+/// Default UDP port of the coaps URI scheme.
+pub const DEFAULT_SECURE_PORT = 5684;
+
+/// URI schemes supported for CoAP over UDP (see RFC 7252 Section 6).
+pub const Scheme = enum {
+    coap,
+    coaps,
+
+    /// Port used if the URI does not contain a port.
+    pub fn defaultPort(self: Scheme) u16 {
+        return switch (self) {
+            Scheme.coap => DEFAULT_PORT,
+            Scheme.coaps => DEFAULT_SECURE_PORT,
+        };
+    }
+};
+
+/// Components of a coap or coaps URI, all components are returned
+/// percent-encoded as contained in the URI.
+pub const Components = struct {
+    scheme: Scheme,
+    host: []const u8,
+    /// Port of the URI or null if the URI does not contain a port.
+    port: ?u16,
+    path: []const u8,
+    query: []const u8,
+};
+
+/// Characters which may appear in the host component of a URI without
+/// being percent-encoded, including the characters of IP literals (see
+/// RFC 3986 Section 3.2.2).
+pub fn isHostChar(c: u8) bool {
+    if (c == '@')
+        return false;
+    return isPathChar(c) or c == '[' or c == ']';
+}
+
+/// Whether the given host component of a URI is an IP literal or an
+/// IPv4 address, as opposed to a registered name.
+pub fn isIPAddress(host: []const u8) bool {
+    if (host.len > 0 and host[0] == '[')
+        return true;
+
+    var octets: usize = 0;
+    var iter = std.mem.split(u8, host, ".");
+    while (iter.next()) |octet| : (octets += 1) {
+        if (octet.len == 0 or octet.len > 3)
+            return false;
+        _ = std.fmt.parseUnsigned(u8, octet, 10) catch {
+            return false;
+        };
+    }
+    return octets == 4;
+}
+
+/// Split an absolute coap or coaps URI into its components.
+///
+/// From RFC 7252:
+///
+///  coap-URI = "coap:" "//" host [ ":" port ] path-abempty [ "?" query ]
+///
+/// URIs with a fragment component are rejected, see RFC 7252
+/// Section 6.4.
+pub fn parse(str: []const u8) !Components {
+    const scheme_end = std.mem.indexOf(u8, str, "://") orelse return error.InvalidURI;
+    const scheme = str[0..scheme_end];
+
+    var result: Components = undefined;
+    if (std.ascii.eqlIgnoreCase(scheme, "coap")) {
+        result.scheme = Scheme.coap;
+    } else if (std.ascii.eqlIgnoreCase(scheme, "coaps")) {
+        result.scheme = Scheme.coaps;
+    } else {
+        return error.UnsupportedScheme;
+    }
+
+    if (std.mem.indexOfScalar(u8, str, '#') != null)
+        return error.InvalidURI;
+
+    const rest = str[scheme_end + 3 ..];
+    const authority_end = std.mem.indexOfAny(u8, rest, "/?") orelse rest.len;
+    const authority = rest[0..authority_end];
+    if (std.mem.indexOfScalar(u8, authority, '@') != null)
+        return error.InvalidURI;
+
+    // The host ends at the last ":" which is not part of an IP literal.
+    var host_end = authority.len;
+    if (std.mem.lastIndexOfScalar(u8, authority, ':')) |i| {
+        const literal_end = std.mem.lastIndexOfScalar(u8, authority, ']') orelse 0;
+        if (i > literal_end)
+            host_end = i;
+    }
+    result.host = authority[0..host_end];
+    if (result.host.len == 0)
+        return error.InvalidURI;
+
+    result.port = null;
+    if (host_end < authority.len and authority.len - host_end > 1) {
+        result.port = std.fmt.parseUnsigned(u16, authority[host_end + 1 ..], 10) catch {
+            return error.InvalidURI;
+        };
+    }
+
+    const target = rest[authority_end..];
+    const query_start = std.mem.indexOfScalar(u8, target, '?');
+    if (query_start) |i| {
+        result.path = target[0..i];
+        result.query = target[i + 1 ..];
+    } else {
+        result.path = target;
+        result.query = target[target.len..];
+    }
+
+    return result;
+}
@@ -0,0 +1,116 @@
+const std = @import("std");
+
+const opts = @import("opts.zig");
+const pkt = @import("packet.zig");
+
+/// Violation of a requirement on the structure of a CoAP message which
+/// is not already enforced by the message parser.
+pub const Violation = enum {
+    /// A Non-confirmable message is empty (RFC 7252 Section 4.3).
+    empty_non,
+    /// An Acknowledgement carries a request (RFC 7252 Section 4.2).
+    request_ack,
+    /// A Reset message is not empty (RFC 7252 Section 4.2).
+    reset_not_empty,
+    /// The code uses one of the reserved classes 1, 6, or 7 (RFC 7252
+    /// Section 12.1).
+    reserved_class,
+    /// The token is longer than 8 bytes, which requires the peer to
+    /// support extended tokens (RFC 8974 Section 2.2).
+    extended_token,
+    /// An option which is not repeatable occurs more than once (RFC 7252
+    /// Section 5.4.5).
+    repeated_option,
+    /// The length of an option value is outside the registered range
+    /// (RFC 7252 Section 5.4.3).
+    invalid_length,
+    /// The Payload Marker is followed by a zero-length payload (RFC 7252
+    /// Section 3).
+    empty_payload,
+    /// The options are malformed and could not be parsed.
+    malformed_options,
+};
+
+/// List of violations found in a message, each violation is contained
+/// in the list at most once.
+pub const Violations = struct {
+    buf: [@typeInfo(Violation).Enum.fields.len]Violation = undefined,
+    len: usize = 0,
+
+    fn add(self: *Violations, violation: Violation) void {
+        if (self.contains(violation))
+            return;
+        self.buf[self.len] = violation;
+        self.len += 1;
+    }
+
+    /// Whether the list contains the given violation.
+    pub fn contains(self: *const Violations, violation: Violation) bool {
+        for (self.slice()) |v| {
+            if (v == violation)
+                return true;
+        }
+        return false;
+    }
+
+    /// Returns the violations in the order they were found.
+    pub fn slice(self: *const Violations) []const Violation {
+        return self.buf[0..self.len];
+    }
+};
+
+/// Check the given message for violations of the requirements on
+/// message types, codes, options, and the payload. Options already
+/// consumed from the message are not checked. Options are always
+/// checked strictly, regardless of the parse mode of the message.
+pub fn validate(req: *const pkt.Request) Violations {
+    var violations = Violations{};
+
+    const code = req.header.code;
+    switch (req.header.type) {
+        pkt.Msg.non => {
+            if (code.isEmpty())
+                violations.add(.empty_non);
+        },
+        pkt.Msg.ack => {
+            if (code.isRequest())
+                violations.add(.request_ack);
+        },
+        pkt.Msg.rst => {
+            if (!code.isEmpty())
+                violations.add(.reset_not_empty);
+        },
+        pkt.Msg.con => {},
+    }
+    if (code.class == 1 or code.class >= 6)
+        violations.add(.reserved_class);
+    if (req.token.len > pkt.MAX_TOKEN_LEN)
+        violations.add(.extended_token);
+
+    var iter = req.options();
+    iter.mode = pkt.Mode.strict;
+    iter.enforce_rules = false;
+
+    var last: ?u32 = null;
+    while (true) {
+        const next = iter.next() catch |err| {
+            if (err == error.InvalidPayload) {
+                violations.add(.empty_payload);
+            } else {
+                violations.add(.malformed_options);
+            }
+            break;
+        };
+        const opt = next orelse break;
+
+        if (last != null and last.? == opt.number and !opts.isRepeatable(opt.number))
+            violations.add(.repeated_option);
+        if (opts.definition(opt.number)) |def| {
+            if (opt.value.len < def.min_len or opt.value.len > def.max_len)
+                violations.add(.invalid_length);
+        }
+        last = opt.number;
+    }
+
+    return violations;
+}
@@ -3,11 +3,77 @@ pub const Msg = pkt.Msg;
 pub const Header = pkt.Header;
 pub const Response = pkt.Response;
 pub const Request = pkt.Request;
+pub const Mode = pkt.Mode;
+pub const Limits = pkt.Limits;
+pub const OptionIterator = pkt.OptionIterator;
+pub const OptionValues = pkt.OptionValues;
+pub const UnrecognizedOptions = pkt.UnrecognizedOptions;
+
+const stream = @import("stream.zig");
+pub const StreamParser = stream.StreamParser;
+pub const StreamMessage = stream.StreamMessage;
 
 const res = @import("resource.zig");
 pub const ResourceHandler = res.ResourceHandler;
+pub const ETagProvider = res.ETagProvider;
+pub const Peer = res.Peer;
+pub const Access = res.Access;
+pub const AccessControl = res.AccessControl;
+pub const chain = res.chain;
 pub const Resource = res.Resource;
 pub const Dispatcher = res.Dispatcher;
+pub const ResourceIterator = res.ResourceIterator;
+pub const Host = res.Host;
+pub const SEPARATE = res.SEPARATE;
+
+const server = @import("server.zig");
+pub const Server = server.Server;
+pub const ServerWithConfig = server.ServerWithConfig;
+pub const ServerConfig = server.Config;
+
+const executor = @import("executor.zig");
+pub const Executor = executor.Executor;
+pub const Job = executor.Job;
 
 pub const codes = @import("codes.zig");
 pub const opts = @import("opts.zig");
+pub const uri = @import("uri.zig");
+pub const ContentFormat = @import("contentformat.zig").ContentFormat;
+pub const negotiate = @import("contentformat.zig").negotiate;
+pub const BlockValue = @import("block.zig").BlockValue;
+pub const observe = @import("observe.zig");
+pub const dedup = @import("dedup.zig");
+pub const BufferPool = @import("pool.zig").BufferPool;
+pub const Token = @import("token.zig").Token;
+pub const pretty = @import("pretty.zig");
+pub const diff = @import("diff.zig");
+pub const cache = @import("cache.zig");
+pub const validation = @import("validate.zig");
+pub const cbor = @import("cbor.zig");
+pub const senml = @import("senml.zig");
+pub const linkformat = @import("linkformat.zig");
+pub const coral = @import("coral.zig");
+pub const rd = @import("rd.zig");
+pub const http = @import("http.zig");
+
+test {
+    _ = @import("packet.zig");
+    _ = @import("stream.zig");
+    _ = @import("contentformat.zig");
+    _ = @import("observe.zig");
+    _ = @import("dedup.zig");
+    _ = @import("pool.zig");
+    _ = @import("token.zig");
+    _ = @import("pretty.zig");
+    _ = @import("diff.zig");
+    _ = @import("cache.zig");
+    _ = @import("cbor.zig");
+    _ = @import("senml.zig");
+    _ = @import("linkformat.zig");
+    _ = @import("coral.zig");
+    _ = @import("resource.zig");
+    _ = @import("executor.zig");
+    _ = @import("server.zig");
+    _ = @import("rd.zig");
+    _ = @import("http.zig");
+}
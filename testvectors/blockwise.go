@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Block-wise transfers as specified in RFC 7959.
+
+var blockToken = []byte{0xb1, 0x0c}
+
+// Encode a Block1 or Block2 option value.
+//
+// From RFC 7959:
+//
+//	The value of the Block option is a variable-size (0 to 3 byte)
+//	unsigned integer [...]. This integer value encodes these three
+//	fields: NUM, M and SZX.
+func blockValue(num uint32, more bool, szx uint32) []byte {
+	v := num<<4 | szx
+	if more {
+		v |= 1 << 3
+	}
+
+	return uintValue(v)
+}
+
+func blockSize(szx uint32) int {
+	return 1 << (szx + 4)
+}
+
+// Deterministic resource representation of the given size.
+func blockBody(size int) []byte {
+	pattern := []byte("0123456789abcdef")
+	body := bytes.Repeat(pattern, size/len(pattern)+1)
+	return body[:size]
+}
+
+// Slice the block with the given NUM and SZX from the body, also
+// returns whether further blocks follow.
+func blockSlice(body []byte, num, szx uint32) ([]byte, bool) {
+	size := blockSize(szx)
+	off := int(num) * size
+	end := off + size
+	if end >= len(body) {
+		return body[off:], false
+	}
+	return body[off:end], true
+}
+
+// Block2 transfer of a representation which is 2.5 times the size of
+// the initial block size. The client requests the first block with the
+// given SZX. If lateSZX differs from the SZX, the client switches to
+// the smaller block size after receiving the first block (late
+// negotiation, see RFC 7959 Section 2.4).
+func block2Sequence(szx, lateSZX uint32) seqFn {
+	return func() ([]vector, error) {
+		body := blockBody(blockSize(szx)*2 + blockSize(szx)/2)
+
+		var msgs []vector
+		var num uint32
+		mid := uint16(0x0b20)
+		for more := true; more; mid++ {
+			reqOpts := coap.Options{
+				{ID: coap.URIPath, Value: []byte("large")},
+				{ID: coap.Block2, Value: blockValue(num, false, szx)},
+			}
+			req, err := marshal(message.Message{
+				Code:      codes.GET,
+				Token:     blockToken,
+				Payload:   []byte{},
+				MessageID: mid,
+				Type:      message.Confirmable,
+				Options:   reqOpts,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			var block []byte
+			block, more = blockSlice(body, num, szx)
+			respOpts := coap.Options{
+				{ID: coap.Block2, Value: blockValue(num, more, szx)},
+			}
+			resp, err := marshal(message.Message{
+				Code:      codes.Content,
+				Token:     blockToken,
+				Payload:   block,
+				MessageID: mid,
+				Type:      message.Acknowledgement,
+				Options:   respOpts,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			msgs = append(msgs, vector{Data: req}, vector{Data: resp})
+			if szx != lateSZX {
+				// Same byte offset, expressed in the smaller block size.
+				num = (num + 1) << (szx - lateSZX)
+				szx = lateSZX
+			} else {
+				num++
+			}
+		}
+
+		return msgs, nil
+	}
+}
+
+// Block1 transfer of a request payload which is 2.5 times the size of
+// the given SZX. The server acknowledges each block with 2.31 Continue
+// and the final block with 2.04 Changed. If serverSZX differs from the
+// SZX, the server requests a smaller block size in its first response
+// (see RFC 7959 Section 2.3).
+func block1Sequence(szx, serverSZX uint32) seqFn {
+	return func() ([]vector, error) {
+		body := blockBody(blockSize(szx)*2 + blockSize(szx)/2)
+
+		var msgs []vector
+		var num uint32
+		mid := uint16(0x0b10)
+		for more := true; more; mid++ {
+			var block []byte
+			block, more = blockSlice(body, num, szx)
+
+			reqOpts := coap.Options{
+				{ID: coap.URIPath, Value: []byte("large")},
+				{ID: coap.Block1, Value: blockValue(num, more, szx)},
+			}
+			req, err := marshal(message.Message{
+				Code:      codes.PUT,
+				Token:     blockToken,
+				Payload:   block,
+				MessageID: mid,
+				Type:      message.Confirmable,
+				Options:   reqOpts,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			code := codes.Continue
+			if !more {
+				code = codes.Changed
+			}
+			respOpts := coap.Options{
+				{ID: coap.Block1, Value: blockValue(num, more, serverSZX)},
+			}
+			resp, err := marshal(message.Message{
+				Code:      code,
+				Token:     blockToken,
+				Payload:   []byte{},
+				MessageID: mid,
+				Type:      message.Acknowledgement,
+				Options:   respOpts,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			msgs = append(msgs, vector{Data: req}, vector{Data: resp})
+			if szx != serverSZX {
+				num = (num + 1) << (szx - serverSZX)
+				szx = serverSZX
+			} else {
+				num++
+			}
+		}
+
+		return msgs, nil
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Builder for messages in the UDP message format. Instead of assembling
+// option slices by hand, options are added one by one. All methods can
+// be chained, the message is only validated when it is built. Contrary
+// to the round-trip check, this reports which option was added out of
+// order instead of a mismatch of the decoded options.
+type builder struct {
+	msg message.Message
+}
+
+func newBuilder(typ message.Type, mid uint16) *builder {
+	return &builder{msg: message.Message{
+		Token:     []byte{},
+		Payload:   []byte{},
+		MessageID: mid,
+		Type:      typ,
+	}}
+}
+
+func (b *builder) SetCode(code codes.Code) *builder {
+	b.msg.Code = code
+	return b
+}
+
+func (b *builder) SetToken(token []byte) *builder {
+	b.msg.Token = token
+	return b
+}
+
+// AddOption appends an option. Options must be added in the order of
+// their Option Numbers, repeated options in the order of their values.
+func (b *builder) AddOption(id coap.OptionID, value []byte) *builder {
+	b.msg.Options = append(b.msg.Options, coap.Option{ID: id, Value: value})
+	return b
+}
+
+// AddUint appends an option with a value in the uint format.
+func (b *builder) AddUint(id coap.OptionID, value uint32) *builder {
+	return b.AddOption(id, uintValue(value))
+}
+
+// AddString appends an option with a value in the string format.
+func (b *builder) AddString(id coap.OptionID, value string) *builder {
+	return b.AddOption(id, []byte(value))
+}
+
+func (b *builder) SetPayload(payload []byte) *builder {
+	b.msg.Payload = payload
+	return b
+}
+
+// Build validates the message and marshals it.
+func (b *builder) Build() ([]byte, error) {
+	if len(b.msg.Token) > coap.MaxTokenSize {
+		return nil, fmt.Errorf("token length %d exceeds %d", len(b.msg.Token), coap.MaxTokenSize)
+	}
+
+	for i := 1; i < len(b.msg.Options); i++ {
+		prev, cur := b.msg.Options[i-1].ID, b.msg.Options[i].ID
+		if cur < prev {
+			return nil, fmt.Errorf("option %v added after option %v", cur, prev)
+		}
+	}
+
+	return marshal(b.msg)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SHA-256 checksums of all generated files, written in the format used
+// by sha256sum(1). This allows verifying that regenerated test vectors
+// did not change unexpectedly and allows downstream users to verify the
+// integrity of the test vectors using:
+//
+//	$ sha256sum -c SHA256SUMS
+const checksumFile = "SHA256SUMS"
+
+type fileChecksum struct {
+	File string
+	Sum  [sha256.Size]byte
+}
+
+type fileChecksums []fileChecksum
+
+func (c *fileChecksums) add(fn string, data []byte) {
+	*c = append(*c, fileChecksum{fn, sha256.Sum256(data)})
+}
+
+// Write the checksum file to the given directory. The given additional
+// files are read from this directory and included in the checksum file.
+func (c fileChecksums) write(dir string, files ...string) error {
+	for _, fn := range files {
+		data, err := os.ReadFile(filepath.Join(dir, fn))
+		if err != nil {
+			return err
+		}
+		c.add(fn, data)
+	}
+
+	sort.Slice(c, func(i, j int) bool {
+		return c[i].File < c[j].File
+	})
+
+	var buf bytes.Buffer
+	for _, sum := range c {
+		fmt.Fprintf(&buf, "%x  %s\n", sum.Sum, sum.File)
+	}
+	return os.WriteFile(filepath.Join(dir, checksumFile), buf.Bytes(), 0644)
+}
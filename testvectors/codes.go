@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// All method and response codes registered in the CoRE parameters
+// registry (RFC 7252 Section 12.1). Codes not defined by go-coap are
+// constructed from their class and detail.
+
+func code(class, detail uint8) codes.Code {
+	return codes.Code(class<<5 | detail)
+}
+
+var definedCodes = []struct {
+	Code codes.Code
+	Desc string
+}{
+	// Empty message and methods
+	{codes.Empty, "Empty"},
+	{codes.GET, "GET"},
+	{codes.POST, "POST"},
+	{codes.PUT, "PUT"},
+	{codes.DELETE, "DELETE"},
+	{code(0, 5), "FETCH"},
+	{code(0, 6), "PATCH"},
+	{code(0, 7), "iPATCH"},
+
+	// Success
+	{codes.Created, "Created"},
+	{codes.Deleted, "Deleted"},
+	{codes.Valid, "Valid"},
+	{codes.Changed, "Changed"},
+	{codes.Content, "Content"},
+	{codes.Continue, "Continue"},
+
+	// Client error
+	{codes.BadRequest, "Bad Request"},
+	{codes.Unauthorized, "Unauthorized"},
+	{codes.BadOption, "Bad Option"},
+	{codes.Forbidden, "Forbidden"},
+	{codes.NotFound, "Not Found"},
+	{codes.MethodNotAllowed, "Method Not Allowed"},
+	{codes.NotAcceptable, "Not Acceptable"},
+	{codes.RequestEntityIncomplete, "Request Entity Incomplete"},
+	{code(4, 9), "Conflict"},
+	{codes.PreconditionFailed, "Precondition Failed"},
+	{codes.RequestEntityTooLarge, "Request Entity Too Large"},
+	{codes.UnsupportedMediaType, "Unsupported Content-Format"},
+	{code(4, 22), "Unprocessable Entity"},
+	{code(4, 29), "Too Many Requests"},
+
+	// Server error
+	{codes.InternalServerError, "Internal Server Error"},
+	{codes.NotImplemented, "Not Implemented"},
+	{codes.BadGateway, "Bad Gateway"},
+	{codes.ServiceUnavailable, "Service Unavailable"},
+	{codes.GatewayTimeout, "Gateway Timeout"},
+	{codes.ProxyingNotSupported, "Proxying Not Supported"},
+	{code(5, 8), "Hop Limit Reached"},
+}
+
+var codeToken = []byte{0xc0, 0xde}
+
+// Message with the given code. The empty message is sent as a CON
+// without token (i.e. a CoAP ping), requests are sent as CON messages
+// and responses are piggybacked on an ACK.
+func codeVector(c codes.Code) genFn {
+	return func() ([]byte, error) {
+		m := message.Message{
+			Code:      c,
+			Token:     codeToken,
+			Payload:   []byte{},
+			MessageID: 0x0c00 + uint16(c),
+			Type:      message.Acknowledgement,
+		}
+
+		switch {
+		case c == codes.Empty:
+			m.Token = []byte{}
+			m.Type = message.Confirmable
+		case c>>5 == 0:
+			m.Type = message.Confirmable
+			m.Options = coap.Options{
+				{ID: coap.URIPath, Value: []byte("code")},
+			}
+		}
+
+		return marshal(m)
+	}
+}
+
+func init() {
+	for _, c := range definedCodes {
+		testCases = append(testCases, testCase{
+			Name: fmt.Sprintf("code-%d-%02d", c.Code>>5, c.Code&0x1f),
+			Func: codeVector(c.Code),
+			Note: c.Desc,
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Comparison of the go-coap encoding with the encoding of the same
+// logical message by other CoAP implementations. Currently supported
+// are libcoap (using coap-client) and aiocoap, implementations which
+// are not installed are skipped.
+
+// A logical CoAP request, independent of its encoding.
+type crossMessage struct {
+	Name        string
+	Code        codes.Code
+	Confirmable bool
+	Token       string
+	Path        []string
+	Query       []string
+	Payload     string
+}
+
+var crossMessages = []crossMessage{
+	{Name: "get", Code: codes.GET, Confirmable: true, Token: "zoap", Path: []string{"hello"}},
+	{Name: "get-nested", Code: codes.GET, Confirmable: true, Token: "zoap", Path: []string{"sensors", "temp"}},
+	{Name: "get-query", Code: codes.GET, Confirmable: true, Token: "zoap", Path: []string{"sensors"}, Query: []string{"unit=c", "precision=2"}},
+	{Name: "non-post", Code: codes.POST, Token: "zoap", Path: []string{"data"}, Payload: "Hello, World!"},
+	{Name: "put-long-path", Code: codes.PUT, Confirmable: true, Token: "zoap", Path: []string{strings.Repeat("x", 20)}, Payload: "1"},
+}
+
+const crossMessageID = 0x0d30
+
+func (m *crossMessage) encode() ([]byte, error) {
+	var opts coap.Options
+	for _, p := range m.Path {
+		opts = append(opts, coap.Option{ID: coap.URIPath, Value: []byte(p)})
+	}
+	for _, q := range m.Query {
+		opts = append(opts, coap.Option{ID: coap.URIQuery, Value: []byte(q)})
+	}
+
+	typ := message.NonConfirmable
+	if m.Confirmable {
+		typ = message.Confirmable
+	}
+	return marshal(message.Message{
+		Code:      m.Code,
+		Token:     []byte(m.Token),
+		Payload:   []byte(m.Payload),
+		MessageID: crossMessageID,
+		Type:      typ,
+		Options:   opts,
+	})
+}
+
+// An implementation encodes a logical message, the message ID of the
+// encoded message may differ from crossMessageID.
+type implementation struct {
+	Name      string
+	Available func() bool
+	Encode    func(m *crossMessage) ([]byte, error)
+}
+
+var implementations = []implementation{
+	{Name: "libcoap", Available: libcoapAvailable, Encode: libcoapEncode},
+	{Name: "aiocoap", Available: aiocoapAvailable, Encode: aiocoapEncode},
+}
+
+func libcoapAvailable() bool {
+	_, err := exec.LookPath("coap-client")
+	return err == nil
+}
+
+// Encode the message using coap-client and capture the request it sends
+// on a local UDP socket. Since coap-client waits for a response, it is
+// killed after the request has been received.
+func libcoapEncode(m *crossMessage) ([]byte, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	u := url.URL{
+		Scheme:   "coap",
+		Host:     conn.LocalAddr().String(),
+		Path:     "/" + strings.Join(m.Path, "/"),
+		RawQuery: strings.Join(m.Query, "&"),
+	}
+	args := []string{"-m", strings.ToLower(m.Code.String()), "-T", m.Token, "-B", "1"}
+	if !m.Confirmable {
+		args = append(args, "-N")
+	}
+	if m.Payload != "" {
+		args = append(args, "-e", m.Payload)
+	}
+
+	cmd := exec.Command("coap-client", append(args, u.String())...)
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	buf := make([]byte, 1500)
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		return nil, err
+	}
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+const aiocoapScript = `
+import json, sys
+from aiocoap import Message
+from aiocoap.numbers import codes, types
+
+m = json.load(sys.stdin)
+msg = Message(
+    code=codes.Code(m["code"]),
+    mtype=types.CON if m["confirmable"] else types.NON,
+    mid=m["mid"],
+    token=bytes.fromhex(m["token"]),
+    payload=bytes.fromhex(m["payload"]),
+)
+msg.opt.uri_path = m["path"]
+msg.opt.uri_query = m["query"]
+sys.stdout.buffer.write(msg.encode())
+`
+
+func aiocoapAvailable() bool {
+	return exec.Command("python3", "-c", "import aiocoap").Run() == nil
+}
+
+func aiocoapEncode(m *crossMessage) ([]byte, error) {
+	input, err := json.Marshal(map[string]interface{}{
+		"code":        int(m.Code),
+		"confirmable": m.Confirmable,
+		"mid":         crossMessageID,
+		"token":       hex.EncodeToString([]byte(m.Token)),
+		"payload":     hex.EncodeToString([]byte(m.Payload)),
+		"path":        append([]string{}, m.Path...),
+		"query":       append([]string{}, m.Query...),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("python3", "-c", aiocoapScript)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	return out, nil
+}
+
+// Compare the encoding of all logical messages by all available
+// implementations with the go-coap encoding. Returns the number of
+// disagreements.
+func compareImplementations() (int, error) {
+	var found bool
+	var disagreements int
+	for _, impl := range implementations {
+		if !impl.Available() {
+			fmt.Printf("%s: not available, skipping\n", impl.Name)
+			continue
+		}
+		found = true
+
+		for i := range crossMessages {
+			m := &crossMessages[i]
+			exp, err := m.encode()
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", m.Name, err)
+			}
+			out, err := impl.Encode(m)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %s: %w", impl.Name, m.Name, err)
+			}
+
+			// The message ID is chosen by the implementation.
+			if len(out) >= 4 {
+				exp[2], exp[3] = out[2], out[3]
+			}
+			if bytes.Equal(exp, out) {
+				fmt.Printf("%s: %s: ok\n", impl.Name, m.Name)
+				continue
+			}
+
+			disagreements++
+			fmt.Printf("%s: %s: encoding differs\n\tgo-coap: %x\n\t%s: %x\n",
+				impl.Name, m.Name, exp, impl.Name, out)
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("no other CoAP implementation available")
+	}
+	return disagreements, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Commonly used values of the CoAP Content-Formats registry (RFC 7252
+// Section 12.3, RFC 8428 Section 12.3). Content-Format values are
+// encoded as uint options, depending on the value the option is
+// therefore 0, 1, or 2 bytes long.
+var contentFormats = []struct {
+	Name  string
+	Type  string
+	Value uint32
+}{
+	{"text-plain", "text/plain; charset=utf-8", 0},
+	{"link-format", "application/link-format", 40},
+	{"xml", "application/xml", 41},
+	{"octet-stream", "application/octet-stream", 42},
+	{"exi", "application/exi", 47},
+	{"json", "application/json", 50},
+	{"cbor", "application/cbor", 60},
+	{"senml-json", "application/senml+json", 110},
+	{"sensml-json", "application/sensml+json", 111},
+	{"senml-cbor", "application/senml+cbor", 112},
+	{"sensml-cbor", "application/sensml+cbor", 113},
+	{"senml-exi", "application/senml-exi", 114},
+	{"sensml-exi", "application/sensml-exi", 115},
+	{"senml-xml", "application/senml+xml", 310},
+	{"sensml-xml", "application/sensml+xml", 311},
+}
+
+func contentFormat(value uint32) genFn {
+	return func() ([]byte, error) {
+		return marshal(message.Message{
+			Code:      codes.Content,
+			Token:     []byte{0xcf},
+			Payload:   []byte{0x00},
+			MessageID: 0x0d20,
+			Type:      message.Acknowledgement,
+			Options: coap.Options{
+				{ID: coap.ContentFormat, Value: uintValue(value)},
+			},
+		})
+	}
+}
+
+func init() {
+	for _, cf := range contentFormats {
+		testCases = append(testCases, testCase{
+			Name: fmt.Sprintf("content-format-%s", cf.Name),
+			Func: contentFormat(cf.Value),
+			Note: cf.Type,
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+var (
+	errTruncated     = errors.New("message is truncated")
+	errTokenLength   = errors.New("invalid token length")
+	errReservedValue = errors.New("reserved option delta or length")
+	errZeroPayload   = errors.New("payload marker without payload")
+	errLength        = errors.New("length does not match message size")
+	errOptionNumber  = errors.New("option number exceeds 65535")
+)
+
+// rawOption is a CoAP option as it appears on the wire.
+type rawOption struct {
+	Number uint32
+	Value  []byte
+}
+
+// rawMessage is a CoAP message decoded without interpreting any of its
+// options. Contrary to go-coap, all options (including unknown ones and
+// options with invalid lengths) are retained in the order they were
+// encoded in. This allows using it for checking the generated vectors.
+type rawMessage struct {
+	Version   uint8
+	Type      uint8
+	Code      codes.Code
+	MessageID uint16
+	Token     []byte
+	Options   []rawOption
+	Payload   []byte
+}
+
+// decodeExtended decodes an option delta or length nibble including its
+// extended bytes. It returns the decoded value and the remaining data.
+func decodeExtended(nibble uint8, data []byte) (uint32, []byte, error) {
+	switch nibble {
+	case 13:
+		if len(data) < 1 {
+			return 0, nil, errTruncated
+		}
+		return uint32(data[0]) + 13, data[1:], nil
+	case 14:
+		if len(data) < 2 {
+			return 0, nil, errTruncated
+		}
+		return uint32(binary.BigEndian.Uint16(data)) + 269, data[2:], nil
+	case 15:
+		return 0, nil, errReservedValue
+	default:
+		return uint32(nibble), data, nil
+	}
+}
+
+// decodeToken decodes the token following the fixed-size header. The
+// token length may be extended as specified in RFC 8974. It returns
+// the token and the remaining data.
+func decodeToken(tkl uint8, data []byte) ([]byte, []byte, error) {
+	length := int(tkl)
+	switch tkl {
+	case 9, 10, 11, 12, 15:
+		return nil, nil, errTokenLength
+	case 13:
+		if len(data) < 1 {
+			return nil, nil, errTruncated
+		}
+		length = int(data[0]) + 13
+		data = data[1:]
+	case 14:
+		if len(data) < 2 {
+			return nil, nil, errTruncated
+		}
+		length = int(binary.BigEndian.Uint16(data)) + 269
+		data = data[2:]
+	}
+
+	if len(data) < length {
+		return nil, nil, errTruncated
+	}
+	return data[:length], data[length:], nil
+}
+
+// decodeOptions decodes options and payload from the given data.
+func decodeOptions(data []byte) ([]rawOption, []byte, error) {
+	var number uint32
+	options := []rawOption{}
+
+	for len(data) > 0 {
+		if data[0] == 0xff {
+			if len(data) == 1 {
+				return nil, nil, errZeroPayload
+			}
+			return options, data[1:], nil
+		}
+
+		delta, rest, err := decodeExtended(data[0]>>4, data[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		length, rest, err := decodeExtended(data[0]&0xf, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		if uint32(len(rest)) < length {
+			return nil, nil, errTruncated
+		}
+
+		number += delta
+		if number > 65535 {
+			return nil, nil, errOptionNumber
+		}
+		options = append(options, rawOption{number, rest[:length]})
+		data = rest[length:]
+	}
+
+	return options, []byte{}, nil
+}
+
+// decode decodes a CoAP message in the UDP message format.
+func decode(data []byte) (*rawMessage, error) {
+	if len(data) < 4 {
+		return nil, errTruncated
+	}
+
+	token, rest, err := decodeToken(data[0]&0xf, data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	options, payload, err := decodeOptions(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawMessage{
+		Version:   data[0] >> 6,
+		Type:      (data[0] >> 4) & 0x3,
+		Code:      codes.Code(data[1]),
+		MessageID: binary.BigEndian.Uint16(data[2:4]),
+		Token:     token,
+		Options:   options,
+		Payload:   payload,
+	}, nil
+}
+
+// decodeTCP decodes a CoAP message in the TCP message format (RFC 8323).
+// The data must contain exactly one message.
+func decodeTCP(data []byte) (*rawMessage, error) {
+	if len(data) < 2 {
+		return nil, errTruncated
+	}
+
+	var length int
+	rest := data[1:]
+	switch n := data[0] >> 4; n {
+	case 13:
+		if len(rest) < 1 {
+			return nil, errTruncated
+		}
+		length = int(rest[0]) + 13
+		rest = rest[1:]
+	case 14:
+		if len(rest) < 2 {
+			return nil, errTruncated
+		}
+		length = int(binary.BigEndian.Uint16(rest)) + 269
+		rest = rest[2:]
+	case 15:
+		if len(rest) < 4 {
+			return nil, errTruncated
+		}
+		length = int(binary.BigEndian.Uint32(rest)) + 65805
+		rest = rest[4:]
+	default:
+		length = int(n)
+	}
+
+	if len(rest) < 1 {
+		return nil, errTruncated
+	}
+	token, body, err := decodeToken(data[0]&0xf, rest[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(body) != length {
+		return nil, errLength
+	}
+
+	options, payload, err := decodeOptions(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawMessage{
+		Code:    codes.Code(rest[0]),
+		Token:   token,
+		Options: options,
+		Payload: payload,
+	}, nil
+}
+
+// decodeWebSocket decodes a CoAP message in the WebSocket message format
+// (RFC 8323). Since the framing is provided by WebSocket, the data must
+// contain exactly one message.
+func decodeWebSocket(data []byte) (*rawMessage, error) {
+	if len(data) < 2 {
+		return nil, errTruncated
+	}
+	if data[0]>>4 != 0 {
+		return nil, errLength
+	}
+
+	token, rest, err := decodeToken(data[0]&0xf, data[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	options, payload, err := decodeOptions(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawMessage{
+		Code:    codes.Code(data[1]),
+		Token:   token,
+		Options: options,
+		Payload: payload,
+	}, nil
+}
+
+// Decode a message in the given message format.
+func decodeFormat(f format, data []byte) (*rawMessage, error) {
+	switch f {
+	case formatTCP:
+		return decodeTCP(data)
+	case formatWebSocket:
+		return decodeWebSocket(data)
+	default:
+		return decode(data)
+	}
+}
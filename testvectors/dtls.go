@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// CoAP exchanges secured using DTLS in PreSharedKey mode (RFC 7252
+// Section 9.1.3.1), captured as a pcapng file. The PSK is written to a
+// separate file, allowing DTLS implementations (and Wireshark) to
+// decrypt the captured records. Since the DTLS handshake involves
+// random values, these captures are not deterministic and are only
+// written when requested explicitly.
+
+// Default port for the coaps URI scheme (RFC 7252 Section 6.2).
+const dtlsServerPort = 5684
+
+var (
+	dtlsIdentity = []byte("zoap")
+	dtlsPSK      = mustHex("7a6f61702d746573742d766563746f72")
+)
+
+// From RFC 7252:
+//
+//	Implementations in PreSharedKey mode MUST support the mandatory-to-
+//	implement cipher suite TLS_PSK_WITH_AES_128_CCM_8 as specified in
+//	[RFC6655].
+var dtlsSessions = []struct {
+	Name       string
+	Suite      dtls.CipherSuiteID
+	ClientPort uint16
+}{
+	{"psk-aes-128-ccm-8", dtls.TLS_PSK_WITH_AES_128_CCM_8, 56831},
+	{"psk-aes-128-gcm-sha256", dtls.TLS_PSK_WITH_AES_128_GCM_SHA256, 56832},
+}
+
+// A datagram sent during a DTLS session.
+type datagram struct {
+	FromClient bool
+	Data       []byte
+}
+
+// Connection recording all datagrams written to it.
+type recorder struct {
+	net.Conn
+	client bool
+
+	mu        *sync.Mutex
+	datagrams *[]datagram
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, err := r.Conn.Write(b)
+	if err == nil {
+		data := append([]byte{}, b...)
+		*r.datagrams = append(*r.datagrams, datagram{r.client, data})
+	}
+	return n, err
+}
+
+// Perform a DTLS handshake with the given cipher suite and exchange the
+// given request/response pairs over the secured connection. Returns all
+// datagrams sent by client and server in the order they were sent.
+func dtlsSession(suite dtls.CipherSuiteID, exchanges [][2][]byte) ([]datagram, error) {
+	var mu sync.Mutex
+	var datagrams []datagram
+
+	client, server := net.Pipe()
+	config := &dtls.Config{
+		PSK: func([]byte) ([]byte, error) {
+			return dtlsPSK, nil
+		},
+		PSKIdentityHint: dtlsIdentity,
+		CipherSuites:    []dtls.CipherSuiteID{suite},
+		// Avoid retransmissions of handshake flights.
+		FlightInterval: time.Minute,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- dtlsServer(&recorder{server, false, &mu, &datagrams}, config, exchanges)
+	}()
+
+	conn, err := dtls.Client(&recorder{client, true, &mu, &datagrams}, config)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for _, exchange := range exchanges {
+		_, err = conn.Write(exchange[0])
+		if err != nil {
+			return nil, err
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(buf[:n], exchange[1]) {
+			return nil, fmt.Errorf("unexpected response %x", buf[:n])
+		}
+	}
+
+	// Sends a close_notify alert to the server.
+	err = conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = <-errs
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return datagrams, nil
+}
+
+func dtlsServer(c net.Conn, config *dtls.Config, exchanges [][2][]byte) error {
+	conn, err := dtls.Server(c, config)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for _, exchange := range exchanges {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(buf[:n], exchange[0]) {
+			return fmt.Errorf("unexpected request %x", buf[:n])
+		}
+		_, err = conn.Write(exchange[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	// Wait for the close_notify alert of the client.
+	conn.Read(buf)
+	return c.Close()
+}
+
+// Request/response pairs exchanged in each DTLS session.
+func dtlsExchanges() ([][2][]byte, error) {
+	var exchanges [][2][]byte
+	for _, fn := range []seqFn{exchangeGet, exchangeNotFound} {
+		msgs, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		exchanges = append(exchanges, [2][]byte{msgs[0].Data, msgs[1].Data})
+	}
+	return exchanges, nil
+}
+
+type dtlsSessionKeys struct {
+	Name        string `json:"name"`
+	CipherSuite string `json:"cipher_suite"`
+	ClientPort  uint16 `json:"client_port"`
+	ServerPort  uint16 `json:"server_port"`
+}
+
+type dtlsKeys struct {
+	Identity string            `json:"psk_identity"`
+	PSK      string            `json:"psk"`
+	Sessions []dtlsSessionKeys `json:"sessions"`
+}
+
+// Write all DTLS sessions to dtls.pcapng and the key material used to
+// dtls.json in the given directory.
+func writeDTLS(dir string) error {
+	exchanges, err := dtlsExchanges()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	pcapHeader(&buf)
+
+	keys := dtlsKeys{
+		Identity: string(dtlsIdentity),
+		PSK:      hex.EncodeToString(dtlsPSK),
+	}
+
+	var n int
+	for _, session := range dtlsSessions {
+		datagrams, err := dtlsSession(session.Suite, exchanges)
+		if err != nil {
+			return fmt.Errorf("%s: %w", session.Name, err)
+		}
+
+		for _, d := range datagrams {
+			src, dst := pcapServer, pcapClient
+			sport, dport := uint16(dtlsServerPort), session.ClientPort
+			if d.FromClient {
+				src, dst = dst, src
+				sport, dport = dport, sport
+			}
+
+			packet := ipv4UDP(uint16(n), src, dst, sport, dport, d.Data)
+			pcapPacket(&buf, n, session.Name, packet)
+			n++
+		}
+
+		keys.Sessions = append(keys.Sessions, dtlsSessionKeys{
+			Name:        session.Name,
+			CipherSuite: session.Suite.String(),
+			ClientPort:  session.ClientPort,
+			ServerPort:  dtlsServerPort,
+		})
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "dtls.pcapng"), buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(keys, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "dtls.json"), append(data, '\n'), 0644)
+}
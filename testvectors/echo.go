@@ -0,0 +1,141 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Echo and Request-Tag options as specified in RFC 9175.
+const (
+	echoOption       coap.OptionID = 252
+	requestTagOption coap.OptionID = 292
+)
+
+var (
+	echoToken = []byte{0xec, 0x40}
+	echoValue = []byte{0x4a, 0x86, 0x9c, 0x1b, 0x62, 0x23, 0xd7, 0x5e}
+)
+
+func echoRequest(mid uint16, echo []byte) ([]byte, error) {
+	opts := coap.Options{
+		{ID: coap.URIPath, Value: []byte("lock")},
+	}
+	if echo != nil {
+		opts = append(opts, coap.Option{ID: echoOption, Value: echo})
+	}
+
+	return marshal(message.Message{
+		Code:      codes.PUT,
+		Token:     echoToken,
+		Payload:   []byte("0"),
+		MessageID: mid,
+		Type:      message.Confirmable,
+		Options:   opts,
+	})
+}
+
+// Request freshness verification using the Echo option (RFC 9175
+// Section 2.4). The server cannot verify the freshness of the initial
+// request and challenges the client with a 4.01 (Unauthorized) response
+// carrying an Echo option, the client then repeats the request with
+// the received Echo value.
+func echoSequence() ([]vector, error) {
+	req, err := echoRequest(0x0d30, nil)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := marshal(message.Message{
+		Code:      codes.Unauthorized,
+		Token:     echoToken,
+		Payload:   []byte{},
+		MessageID: 0x0d30,
+		Type:      message.Acknowledgement,
+		Options: coap.Options{
+			{ID: echoOption, Value: echoValue},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	retry, err := echoRequest(0x0d31, echoValue)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := marshal(message.Message{
+		Code:      codes.Changed,
+		Token:     echoToken,
+		Payload:   []byte{},
+		MessageID: 0x0d31,
+		Type:      message.Acknowledgement,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []vector{
+		{Data: req, Note: "request without Echo option"},
+		{Data: challenge, Note: "4.01 with Echo challenge"},
+		{Data: retry, Note: "request repeated with Echo option"},
+		{Data: resp, Note: "request processed"},
+	}, nil
+}
+
+// Two consecutive Block1 transfers to the same resource, distinguished
+// by their Request-Tag. The Request-Tag is included in every block of
+// a transfer, blocks with different Request-Tag values belong to
+// different operations and must not be combined.
+func requestTagSequence() ([]vector, error) {
+	const szx = 2
+
+	var msgs []vector
+	mid := uint16(0x0d40)
+	for _, tag := range [][]byte{{0x01}, {0x02}} {
+		body := blockBody(blockSize(szx) * 2)
+
+		var num uint32
+		for more := true; more; mid++ {
+			var block []byte
+			block, more = blockSlice(body, num, szx)
+
+			req, err := marshal(message.Message{
+				Code:      codes.PUT,
+				Token:     blockToken,
+				Payload:   block,
+				MessageID: mid,
+				Type:      message.Confirmable,
+				Options: coap.Options{
+					{ID: coap.URIPath, Value: []byte("large")},
+					{ID: coap.Block1, Value: blockValue(num, more, szx)},
+					{ID: requestTagOption, Value: tag},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			code := codes.Continue
+			if !more {
+				code = codes.Changed
+			}
+			resp, err := marshal(message.Message{
+				Code:      code,
+				Token:     blockToken,
+				Payload:   []byte{},
+				MessageID: mid,
+				Type:      message.Acknowledgement,
+				Options: coap.Options{
+					{ID: coap.Block1, Value: blockValue(num, more, szx)},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			msgs = append(msgs, vector{Data: req}, vector{Data: resp})
+			num++
+		}
+	}
+
+	return msgs, nil
+}
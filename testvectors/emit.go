@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	gofmt "go/format"
+	"strings"
+	"unicode"
+)
+
+// Test vectors are written as raw .bin files by default. Alternatively,
+// they can be written as Zig or Go source files containing a byte array.
+// This allows embedding them in test environments without file system
+// access.
+
+const generatedHeader = "Code generated by testvectors/generate.go. DO NOT EDIT."
+
+// Bytes per line in generated source files.
+const bytesPerLine = 12
+
+type emitter struct {
+	Ext    string
+	Encode func(name string, data []byte) ([]byte, error)
+}
+
+var emitters = map[string]emitter{
+	"bin": {Ext: ".bin", Encode: binSource},
+	"zig": {Ext: ".zig", Encode: zigSource},
+	"go":  {Ext: ".go", Encode: goSource},
+}
+
+func binSource(name string, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// Write the given bytes as comma-separated hex literals, indented with
+// the given string.
+func byteLiterals(w *bytes.Buffer, indent string, data []byte) {
+	for len(data) > 0 {
+		n := bytesPerLine
+		if len(data) < n {
+			n = len(data)
+		}
+
+		w.WriteString(indent)
+		for i, b := range data[:n] {
+			if i > 0 {
+				w.WriteByte(' ')
+			}
+			fmt.Fprintf(w, "0x%02x,", b)
+		}
+		w.WriteByte('\n')
+		data = data[n:]
+	}
+}
+
+func zigSource(name string, data []byte) ([]byte, error) {
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "// %s\n// Test vector: %s\n\n", generatedHeader, name)
+	w.WriteString("pub const data = [_]u8{\n")
+	byteLiterals(&w, "    ", data)
+	w.WriteString("};\n")
+
+	return w.Bytes(), nil
+}
+
+// Convert a test vector name to an exported Go identifier, e.g.
+// "block2-szx0-1" to "Block2Szx0_1".
+func goIdentifier(name string) string {
+	var ident string
+	for _, part := range strings.Split(name, "-") {
+		if part == "" {
+			continue
+		}
+
+		last := rune(0)
+		if len(ident) > 0 {
+			last = rune(ident[len(ident)-1])
+		}
+		if unicode.IsDigit(last) && unicode.IsDigit(rune(part[0])) {
+			ident += "_"
+		}
+		ident += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return ident
+}
+
+func goSource(name string, data []byte) ([]byte, error) {
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "// %s\n\npackage testvectors\n\n", generatedHeader)
+
+	ident := goIdentifier(name)
+	fmt.Fprintf(&w, "// %s is the %s test vector.\n", ident, name)
+	fmt.Fprintf(&w, "var %s = []byte{\n", ident)
+	byteLiterals(&w, "\t", data)
+	w.WriteString("}\n")
+
+	return gofmt.Source(w.Bytes())
+}
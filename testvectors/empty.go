@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Empty messages as specified in RFC 7252 Section 4.1.
+//
+// From RFC 7252:
+//
+//	An Empty message has the Code field set to 0.00. The Token Length
+//	field MUST be set to 0 and bytes of data MUST NOT be present after
+//	the Message ID field. If there are any bytes, they MUST be
+//	processed as a message format error.
+
+func emptyMessage(typ message.Type, mid uint16) ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.Empty,
+		Token:     []byte{},
+		Payload:   []byte{},
+		MessageID: mid,
+		Type:      typ,
+	})
+}
+
+func emptyACK() ([]byte, error) {
+	return emptyMessage(message.Acknowledgement, 0x0e00)
+}
+
+func emptyWithToken() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = ACK, TKL = 2, Code = 0.00, MID = 0x0e01
+		0x62, 0x00, 0x0e, 0x01,
+		// Token
+		0xe3, 0x97,
+	}, nil
+}
+
+func emptyWithPayload() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = ACK, TKL = 0, Code = 0.00, MID = 0x0e02
+		0x60, 0x00, 0x0e, 0x02,
+		// Payload marker and payload
+		0xff, 'x',
+	}, nil
+}
+
+// Confirmable request which is rejected by the recipient with a RST.
+//
+// From RFC 7252:
+//
+//	Rejecting a Confirmable message is effected by sending a matching
+//	Reset message and otherwise ignoring it.
+func resetSequence() ([]vector, error) {
+	req, err := marshal(message.Message{
+		Code:      codes.GET,
+		Token:     []byte{0xe3, 0x97},
+		Payload:   []byte{},
+		MessageID: 0x0e10,
+		Type:      message.Confirmable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rst, err := emptyMessage(message.Reset, 0x0e10)
+	if err != nil {
+		return nil, err
+	}
+
+	return []vector{
+		{Data: req, Note: "request"},
+		{Data: rst, Note: "reset with the message ID of the request"},
+	}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Validation and conditional requests using the ETag, If-Match, and
+// If-None-Match options (RFC 7252 Sections 5.10.6 and 5.10.8).
+
+var (
+	etagToken = []byte{0xe7, 0xa9}
+	etag1     = []byte{0x5c, 0x1d}
+	etag2     = []byte{0x33, 0xa6, 0x2b, 0xf0, 0x97, 0x01, 0x42, 0x8e}
+)
+
+func etagMessage(typ message.Type, code codes.Code, payload string, opts coap.Options) ([]byte, error) {
+	return marshal(message.Message{
+		Code:      code,
+		Token:     etagToken,
+		Payload:   []byte(payload),
+		MessageID: 0x0d33,
+		Type:      typ,
+		Options:   opts,
+	})
+}
+
+// Request with the given conditional options, which all precede the
+// Uri-Path option in the order of option numbers.
+func etagRequest(code codes.Code, payload string, opts ...coap.Option) ([]byte, error) {
+	opts = append(opts, coap.Option{ID: coap.URIPath, Value: []byte("config")})
+	if payload != "" {
+		opts = append(opts, coap.Option{ID: coap.ContentFormat, Value: uintValue(uint32(coap.TextPlain))})
+	}
+	return etagMessage(message.Confirmable, code, payload, opts)
+}
+
+// GET request including the ETag of a cached representation.
+func etagGet() ([]byte, error) {
+	return etagRequest(codes.GET, "", coap.Option{ID: coap.ETag, Value: etag1})
+}
+
+// From RFC 7252:
+//
+//	The ETag Option MAY occur zero, one, or multiple times in a request.
+func etagGetMultiple() ([]byte, error) {
+	return etagRequest(codes.GET, "",
+		coap.Option{ID: coap.ETag, Value: etag1},
+		coap.Option{ID: coap.ETag, Value: etag2})
+}
+
+func etagContent() ([]byte, error) {
+	return etagMessage(message.Acknowledgement, codes.Content, "on", coap.Options{
+		{ID: coap.ETag, Value: etag1},
+		{ID: coap.ContentFormat, Value: uintValue(uint32(coap.TextPlain))},
+	})
+}
+
+// Response indicating that the cached representation with the given
+// ETag is still valid, no payload is included.
+func etagValid() ([]byte, error) {
+	return etagMessage(message.Acknowledgement, codes.Valid, "", coap.Options{
+		{ID: coap.ETag, Value: etag2},
+	})
+}
+
+func ifMatch() ([]byte, error) {
+	return etagRequest(codes.PUT, "off", coap.Option{ID: coap.IfMatch, Value: etag1})
+}
+
+func ifMatchMultiple() ([]byte, error) {
+	return etagRequest(codes.PUT, "off",
+		coap.Option{ID: coap.IfMatch, Value: etag1},
+		coap.Option{ID: coap.IfMatch, Value: etag2})
+}
+
+// An If-Match option with an empty value makes the request conditional
+// on the existence of any current representation of the resource.
+func ifMatchEmpty() ([]byte, error) {
+	return etagRequest(codes.PUT, "off", coap.Option{ID: coap.IfMatch, Value: []byte{}})
+}
+
+func ifNoneMatch() ([]byte, error) {
+	return etagRequest(codes.PUT, "off", coap.Option{ID: coap.IfNoneMatch, Value: []byte{}})
+}
+
+func preconditionFailed() ([]byte, error) {
+	return etagMessage(message.Acknowledgement, codes.PreconditionFailed, "", nil)
+}
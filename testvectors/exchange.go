@@ -0,0 +1,147 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Canonical request/response exchanges (RFC 7252 Section 5.2) for
+// end-to-end server tests. Each exchange consists of a request and the
+// response(s) a server is expected to send for it.
+
+var exchangeToken = []byte{0xe7, 0x01}
+
+func exchangeRequest(mid uint16, path string, opts ...coap.Option) ([]byte, error) {
+	b := newBuilder(message.Confirmable, mid).
+		SetCode(codes.GET).
+		SetToken(exchangeToken).
+		AddString(coap.URIPath, path)
+	for _, o := range opts {
+		b.AddOption(o.ID, o.Value)
+	}
+	return b.Build()
+}
+
+func exchangeResponse(typ message.Type, mid uint16, code codes.Code, payload string) ([]byte, error) {
+	b := newBuilder(typ, mid).
+		SetCode(code).
+		SetToken(exchangeToken).
+		SetPayload([]byte(payload))
+	if payload != "" {
+		b.AddUint(coap.ContentFormat, uint32(coap.TextPlain))
+	}
+	return b.Build()
+}
+
+// GET request answered with a piggybacked response.
+func exchangeGet() ([]vector, error) {
+	req, err := exchangeRequest(0x0d70, "hello")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchangeResponse(message.Acknowledgement, 0x0d70, codes.Content, "Hello, World!")
+	if err != nil {
+		return nil, err
+	}
+
+	return []vector{
+		{Data: req, Role: "request"},
+		{Data: resp, Role: "response", Note: "piggybacked response"},
+	}, nil
+}
+
+// GET request which is first acknowledged with an empty ACK and later
+// answered with a separate response, which is in turn acknowledged by
+// the client (RFC 7252 Section 5.2.2).
+func exchangeSeparate() ([]vector, error) {
+	req, err := exchangeRequest(0x0d71, "slow")
+	if err != nil {
+		return nil, err
+	}
+	ack, err := emptyMessage(message.Acknowledgement, 0x0d71)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchangeResponse(message.Confirmable, 0x0f71, codes.Content, "Hello, World!")
+	if err != nil {
+		return nil, err
+	}
+	respACK, err := emptyMessage(message.Acknowledgement, 0x0f71)
+	if err != nil {
+		return nil, err
+	}
+
+	return []vector{
+		{Data: req, Role: "request"},
+		{Data: ack, Role: "ack", Note: "empty ACK"},
+		{Data: resp, Role: "response", Note: "separate response"},
+		{Data: respACK, Role: "response-ack", Note: "client acknowledges the separate response"},
+	}, nil
+}
+
+func exchangeNotFound() ([]vector, error) {
+	req, err := exchangeRequest(0x0d72, "missing")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchangeResponse(message.Acknowledgement, 0x0d72, codes.NotFound, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return []vector{
+		{Data: req, Role: "request"},
+		{Data: resp, Role: "response"},
+	}, nil
+}
+
+// GET request with an unrecognized critical option from the
+// experimental range (odd option numbers are critical).
+//
+// From RFC 7252:
+//
+//	Unrecognized options of class "critical" that occur in a
+//	Confirmable request MUST cause the return of a 4.02 (Bad Option)
+//	response.
+func exchangeBadOption() ([]vector, error) {
+	req, err := exchangeRequest(0x0d73, "hello", coap.Option{ID: 65001, Value: []byte{0x01}})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchangeResponse(message.Acknowledgement, 0x0d73, codes.BadOption, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return []vector{
+		{Data: req, Role: "request", Note: "unrecognized critical option 65001"},
+		{Data: resp, Role: "response"},
+	}, nil
+}
+
+// GET request with two unrecognized critical options, rejected with a
+// 4.02 (Bad Option) response whose diagnostic payload lists them.
+// Diagnostic payloads do not include a Content-Format option (see RFC
+// 7252 Section 5.5.2).
+func exchangeBadOptionDiagnostic() ([]vector, error) {
+	req, err := exchangeRequest(0x0d74, "hello",
+		coap.Option{ID: 65001, Value: []byte{0x01}},
+		coap.Option{ID: 65003, Value: []byte{0x02}})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := newBuilder(message.Acknowledgement, 0x0d74).
+		SetCode(codes.BadOption).
+		SetToken(exchangeToken).
+		SetPayload([]byte("unrecognized critical options: 65001, 65003")).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return []vector{
+		{Data: req, Role: "request", Note: "unrecognized critical options 65001 and 65003"},
+		{Data: resp, Role: "response", Note: "diagnostic payload"},
+	}, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Option delta and option length values at the boundaries of the
+// extended encodings (RFC 7252 Section 3.1):
+//
+//	  0-12: encoded directly in the 4-bit nibble.
+//	13-268: nibble 13, followed by 1 extension byte (value - 13).
+//	 269- : nibble 14, followed by 2 extension bytes (value - 269).
+//	    15: reserved, must be processed as a message format error.
+
+// Unassigned option number, used for the option length vectors to ensure
+// that the option delta is encoded in the nibble.
+const unassignedOption coap.OptionID = 2
+
+func singleOption(id coap.OptionID, value []byte) ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.GET,
+		Token:     []byte{},
+		Payload:   []byte{},
+		MessageID: 0x0d17,
+		Type:      message.Confirmable,
+		Options: coap.Options{
+			{ID: id, Value: value},
+		},
+	})
+}
+
+func optionDelta(delta coap.OptionID) genFn {
+	return func() ([]byte, error) {
+		return singleOption(delta, []byte{})
+	}
+}
+
+func optionLength(length int) genFn {
+	return func() ([]byte, error) {
+		return singleOption(unassignedOption, bytes.Repeat([]byte{'v'}, length))
+	}
+}
+
+// Content-Format option with a 3-byte value, exceeding the registered
+// maximum length of 2 bytes. The value itself (42) is valid, but senders
+// should encode it without leading zero bytes (RFC 7252 Section 3.2).
+func optionValueTooLong() ([]byte, error) {
+	return singleOption(coap.ContentFormat, []byte{0x00, 0x00, 0x2a})
+}
+
+func optionLength15() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 0, Code = GET, MID = 42
+		0x40, 0x01, 0x00, 0x2a,
+		// Option Delta = 2, Option Length = 15 (reserved)
+		0x2f, 0x00,
+	}, nil
+}
+
+func truncatedOptionDelta() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 0, Code = GET, MID = 42
+		0x40, 0x01, 0x00, 0x2a,
+		// Option Delta = 13, but the extension byte is missing
+		0xd0,
+	}, nil
+}
+
+func truncatedOptionLength() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 0, Code = GET, MID = 42
+		0x40, 0x01, 0x00, 0x2a,
+		// Option Delta = 2, Option Length = 14, but only one of the two
+		// extension bytes is present
+		0x2e, 0x00,
+	}, nil
+}
+
+func truncatedOptionValue() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 0, Code = GET, MID = 42
+		0x40, 0x01, 0x00, 0x2a,
+		// Option Delta = 2, Option Length = 4, but only 3 value bytes
+		0x24, 'v', 'v', 'v',
+	}, nil
+}
+
+// Options with large cumulative deltas, the last option has the largest
+// valid option number.
+func optionNumber65535() ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.GET,
+		Token:     []byte{},
+		Payload:   []byte{},
+		MessageID: 0x0d18,
+		Type:      message.Confirmable,
+		Options: coap.Options{
+			{ID: 1000, Value: []byte{}},
+			{ID: 30000, Value: []byte{}},
+			{ID: 65535, Value: []byte{}},
+		},
+	})
+}
+
+func optionNumberOverflow() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 0, Code = GET, MID = 42
+		0x40, 0x01, 0x00, 0x2a,
+		// Option Delta = 65000 (65000 - 269 = 0xfcdb), Option Length = 0
+		0xe0, 0xfc, 0xdb,
+		// Option Delta = 1000 (1000 - 269 = 0x02db), Option Length = 0,
+		// resulting in option number 66000
+		0xe0, 0x02, 0xdb,
+	}, nil
+}
+
+func optionDeltaOverflow() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 0, Code = GET, MID = 42
+		0x40, 0x01, 0x00, 0x2a,
+		// Option Delta = 65804 (maximum encodable delta), Option Length = 0
+		0xe0, 0xff, 0xff,
+	}, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Pseudo-random, but valid, CoAP messages for fuzzing. Since the
+// sequence of math/rand is stable for a given seed, the generated
+// corpus is reproducible.
+
+const (
+	fuzzMaxOptions     = 8
+	fuzzMaxOptionValue = 300  // Exceeds 268 to cover all length encodings
+	fuzzMaxPayload     = 1024 // Common upper bound for CoAP over UDP
+)
+
+var fuzzRequestCodes = []codes.Code{
+	codes.GET, codes.POST, codes.PUT, codes.DELETE,
+}
+
+var fuzzResponseCodes = []codes.Code{
+	codes.Created, codes.Deleted, codes.Valid, codes.Changed,
+	codes.Content, codes.Continue, codes.BadRequest, codes.NotFound,
+	codes.MethodNotAllowed, codes.InternalServerError, codes.NotImplemented,
+}
+
+var fuzzOptionIDs = []coap.OptionID{
+	coap.IfMatch, coap.URIHost, coap.ETag, coap.IfNoneMatch,
+	coap.Observe, coap.URIPort, coap.LocationPath, coap.URIPath,
+	coap.ContentFormat, coap.MaxAge, coap.URIQuery, coap.Accept,
+	coap.LocationQuery, coap.Block2, coap.Block1, coap.Size2,
+	coap.ProxyURI, coap.ProxyScheme, coap.Size1, coap.NoResponse,
+}
+
+// Registered options which may be included more than once (see RFC 7252
+// Section 5.10).
+var fuzzRepeatable = map[coap.OptionID]bool{
+	coap.IfMatch: true, coap.ETag: true, coap.LocationPath: true,
+	coap.URIPath: true, coap.URIQuery: true, coap.LocationQuery: true,
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+func randomOptions(r *rand.Rand) coap.Options {
+	n := r.Intn(fuzzMaxOptions + 1)
+	opts := make(coap.Options, 0, n)
+	seen := make(map[coap.OptionID]bool)
+	for i := 0; i < n; i++ {
+		var id coap.OptionID
+		if r.Intn(4) == 0 {
+			// Unregistered option number (possibly critical).
+			id = coap.OptionID(1 + r.Intn(65535))
+		} else {
+			id = fuzzOptionIDs[r.Intn(len(fuzzOptionIDs))]
+		}
+
+		// Values of registered options are kept within the length range
+		// of their definition (see RFC 7252 Table 4) and non-repeatable
+		// options are only included once. Options violating these rules
+		// are covered by the malformed test vectors instead.
+		minLen, maxLen := 0, fuzzMaxOptionValue
+		if def, ok := coap.CoapOptionDefs[id]; ok {
+			if seen[id] && !fuzzRepeatable[id] {
+				continue
+			}
+			minLen = def.MinLen
+			if def.MaxLen < maxLen {
+				maxLen = def.MaxLen
+			}
+		}
+		seen[id] = true
+
+		value := randomBytes(r, minLen+r.Intn(maxLen-minLen+1))
+		opts = append(opts, coap.Option{ID: id, Value: value})
+	}
+
+	// Options must be encoded in order of their Option Numbers, the
+	// order of repeated options is retained.
+	sort.SliceStable(opts, func(i, j int) bool {
+		return opts[i].ID < opts[j].ID
+	})
+	return opts
+}
+
+func randomMessage(r *rand.Rand) ([]byte, error) {
+	var code codes.Code
+	var typ message.Type
+	if r.Intn(2) == 0 {
+		code = fuzzRequestCodes[r.Intn(len(fuzzRequestCodes))]
+		typ = message.Type(r.Intn(2)) // CON or NON
+	} else {
+		code = fuzzResponseCodes[r.Intn(len(fuzzResponseCodes))]
+		typ = message.Type(r.Intn(3)) // CON, NON or ACK
+	}
+
+	return marshal(message.Message{
+		Code:      code,
+		Token:     randomBytes(r, r.Intn(coap.MaxTokenSize+1)),
+		Payload:   randomBytes(r, r.Intn(fuzzMaxPayload+1)),
+		MessageID: uint16(r.Intn(1 << 16)),
+		Type:      typ,
+		Options:   randomOptions(r),
+	})
+}
+
+// Write n random messages, generated from the given seed, to the given
+// directory. If reduce is set, the corpus is minimized beforehand and
+// may thus contain fewer than n messages.
+func writeFuzzCorpus(dir string, n int, seed int64, reduce bool) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	corpus := make([][]byte, n)
+	for i := range corpus {
+		corpus[i], err = randomMessage(r)
+		if err != nil {
+			return err
+		}
+	}
+	if reduce {
+		corpus = minimizeCorpus(corpus)
+	}
+
+	for i, data := range corpus {
+		fp := filepath.Join(dir, fmt.Sprintf("fuzz-%04d.bin", i))
+		err = os.WriteFile(fp, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
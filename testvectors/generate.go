@@ -1,114 +1,512 @@
 package main
 
 import (
-	"os"
+	"flag"
+	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 
+	coap "github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	"github.com/plgd-dev/go-coap/v2/udp/message"
-	coap "github.com/plgd-dev/go-coap/v2/message"
 )
 
 type genFn func() ([]byte, error)
 
+// Encode an option value in the uint format (RFC 7252 Section 3.2).
+func uintValue(v uint32) []byte {
+	buf := make([]byte, 4)
+	n, _ := coap.EncodeUint32(buf, v)
+	return buf[:n]
+}
+
 func withPayload() ([]byte, error) {
-	return message.Message{
+	return marshal(message.Message{
 		Code:      codes.DELETE,
 		Token:     []byte{},
 		Payload:   []byte("Hello"),
 		MessageID: 1,
 		Type:      message.Reset,
-	}.Marshal()
+	})
 }
 
 func basicHeader() ([]byte, error) {
-	return message.Message{
+	return marshal(message.Message{
 		Code:      codes.GET,
 		Token:     []byte{},
 		Payload:   []byte{},
 		MessageID: 2342,
 		Type:      message.Confirmable,
-	}.Marshal()
+	})
 }
 
 func withToken() ([]byte, error) {
-	return message.Message{
+	return marshal(message.Message{
 		Code:      codes.PUT,
 		Token:     []byte{23, 42},
 		Payload:   []byte{},
 		MessageID: 5,
 		Type:      message.Acknowledgement,
-	}.Marshal()
+	})
 }
 
 func withOptions() ([]byte, error) {
 	var opts coap.Options = []coap.Option{
-		coap.Option{2, []byte{0xff}},
-		coap.Option{23, []byte{13, 37}},
-		coap.Option{65535, []byte{}},
+		coap.Option{ID: 2, Value: []byte{0xff}},
+		coap.Option{ID: 23, Value: []byte{13, 37}},
+		coap.Option{ID: 65535, Value: []byte{}},
 	}
 
-	return message.Message{
+	return marshal(message.Message{
 		Code:      codes.GET,
 		Token:     []byte{},
 		Payload:   []byte{},
 		MessageID: 2342,
 		Type:      message.Confirmable,
 		Options:   opts,
-	}.Marshal()
+	})
 }
 
 func payloadAndOptions() ([]byte, error) {
 	var opts coap.Options = []coap.Option{
-		coap.Option{0, []byte("test")},
+		coap.Option{ID: 0, Value: []byte("test")},
 	}
 
-	return message.Message{
+	return marshal(message.Message{
 		Code:      codes.DELETE,
 		Token:     []byte{},
 		Payload:   []byte("foobar"),
 		MessageID: 255,
 		Type:      message.NonConfirmable,
 		Options:   opts,
-	}.Marshal()
+	})
+}
+
+// Message format of a test vector.
+type format int
+
+const (
+	formatUDP       format = iota // RFC 7252 Section 3
+	formatTCP                     // RFC 8323 Section 3.2
+	formatWebSocket               // RFC 8323 Section 4.4
+)
+
+func (f format) String() string {
+	switch f {
+	case formatTCP:
+		return "tcp"
+	case formatWebSocket:
+		return "websocket"
+	default:
+		return "udp"
+	}
+}
+
+type testCase struct {
+	Name   string
+	Func   genFn
+	Format format
+
+	// Non-empty if the generated message is malformed, describes
+	// why the message must be rejected by a parser.
+	Reject string
+
+	// Non-empty if the generated message must be silently ignored by
+	// the receiver (e.g. unknown version), describes why.
+	Ignore string
+
+	// Optional annotation, recorded in the manifest.
+	Note string
+}
+
+var testCases = []testCase{
+	{Name: "with-payload", Func: withPayload},
+	{Name: "basic-header", Func: basicHeader},
+	{Name: "with-token", Func: withToken},
+	{Name: "with-options", Func: withOptions},
+	{Name: "payload-and-options", Func: payloadAndOptions},
+
+	// Malformed messages
+	{Name: "bad-version", Func: badVersion, Ignore: "unknown version 2"},
+	{Name: "version-0", Func: version(0), Ignore: "unknown version 0"},
+	{Name: "version-3", Func: version(3), Ignore: "unknown version 3"},
+	{Name: "truncated-header", Func: truncatedHeader, Reject: "truncated header"},
+	{Name: "invalid-token-length", Func: invalidTokenLength, Reject: "reserved token length"},
+	{Name: "token-length-9", Func: reservedTokenLength(9), Reject: "reserved token length"},
+	{Name: "token-length-10", Func: reservedTokenLength(10), Reject: "reserved token length"},
+	{Name: "token-length-11", Func: reservedTokenLength(11), Reject: "reserved token length"},
+	{Name: "token-length-12", Func: reservedTokenLength(12), Reject: "reserved token length"},
+	{Name: "option-delta-15", Func: optionDelta15, Reject: "reserved option delta"},
+	{Name: "payload-marker-only", Func: payloadMarkerOnly, Reject: "payload marker without payload"},
+
+	// Empty messages
+	{Name: "empty-ack", Func: emptyACK},
+	{Name: "empty-with-token", Func: emptyWithToken, Reject: "empty message with token"},
+	{Name: "empty-with-payload", Func: emptyWithPayload, Reject: "empty message with payload"},
+
+	// Option delta and length encodings
+	{Name: "option-delta-12", Func: optionDelta(12)},
+	{Name: "option-delta-13", Func: optionDelta(13)},
+	{Name: "option-delta-268", Func: optionDelta(268)},
+	{Name: "option-delta-269", Func: optionDelta(269)},
+	{Name: "option-length-12", Func: optionLength(12)},
+	{Name: "option-length-13", Func: optionLength(13)},
+	{Name: "option-length-268", Func: optionLength(268)},
+	{Name: "option-length-269", Func: optionLength(269)},
+	{Name: "option-number-65535", Func: optionNumber65535},
+	{Name: "option-number-overflow", Func: optionNumberOverflow, Reject: "option number exceeds 65535"},
+	{Name: "option-delta-overflow", Func: optionDeltaOverflow, Reject: "option number exceeds 65535"},
+	{Name: "option-value-too-long", Func: optionValueTooLong, Note: "Content-Format value exceeds 2 bytes"},
+	{Name: "option-length-15", Func: optionLength15, Reject: "reserved option length"},
+	{Name: "truncated-option-delta", Func: truncatedOptionDelta, Reject: "truncated option delta"},
+	{Name: "truncated-option-length", Func: truncatedOptionLength, Reject: "truncated option length"},
+	{Name: "truncated-option-value", Func: truncatedOptionValue, Reject: "truncated option value"},
+
+	// Repeatable options
+	{Name: "repeated-uri-path", Func: repeatedURIPath},
+	{Name: "repeated-uri-query", Func: repeatedURIQuery},
+	{Name: "repeated-uri-path-and-query", Func: repeatedURIPathAndQuery},
+
+	// No-Response option (RFC 7967)
+	{Name: "no-response-0", Func: noResponse(0), Note: "interested in all responses"},
+	{Name: "no-response-2", Func: noResponse(2), Note: "suppress 2.xx"},
+	{Name: "no-response-8", Func: noResponse(8), Note: "suppress 4.xx"},
+	{Name: "no-response-10", Func: noResponse(10), Note: "suppress 2.xx and 4.xx"},
+	{Name: "no-response-16", Func: noResponse(16), Note: "suppress 5.xx"},
+	{Name: "no-response-18", Func: noResponse(18), Note: "suppress 2.xx and 5.xx"},
+	{Name: "no-response-24", Func: noResponse(24), Note: "suppress 4.xx and 5.xx"},
+	{Name: "no-response-26", Func: noResponse(26), Note: "suppress all responses"},
+
+	// Hop-Limit option (RFC 8768)
+	{Name: "hop-limit-1", Func: hopLimit(1), Note: "must not be forwarded"},
+	{Name: "hop-limit-16", Func: hopLimit(16), Note: "default value"},
+	{Name: "hop-limit-255", Func: hopLimit(255)},
+
+	// Size1 and Size2 options (RFC 7959)
+	{Name: "size1-request-1", Func: size1Request(200)},
+	{Name: "size1-request-2", Func: size1Request(1280)},
+	{Name: "size1-request-3", Func: size1Request(70000)},
+	{Name: "size1-request-4", Func: size1Request(16777216)},
+	{Name: "size1-too-large", Func: size1TooLarge, Note: "maximum acceptable size"},
+	{Name: "size2-request", Func: size2Request, Note: "size request"},
+	{Name: "size2-response-1", Func: size2Response(200)},
+	{Name: "size2-response-2", Func: size2Response(1280)},
+	{Name: "size2-response-3", Func: size2Response(70000)},
+	{Name: "size2-response-4", Func: size2Response(16777216)},
+
+	// ETag and conditional requests
+	{Name: "etag-get", Func: etagGet},
+	{Name: "etag-get-multiple", Func: etagGetMultiple},
+	{Name: "etag-content", Func: etagContent},
+	{Name: "etag-valid", Func: etagValid},
+	{Name: "if-match", Func: ifMatch},
+	{Name: "if-match-multiple", Func: ifMatchMultiple},
+	{Name: "if-match-empty", Func: ifMatchEmpty, Note: "matches any existing representation"},
+	{Name: "if-none-match", Func: ifNoneMatch, Note: "only performed if the target does not exist"},
+	{Name: "precondition-failed", Func: preconditionFailed},
+
+	// Uri-Host, Uri-Port, and Location-* options
+	{Name: "uri-host-port", Func: uriHostPort, Note: "coap://example.org:61616/res"},
+	{Name: "uri-path-utf8", Func: uriPathUTF8, Note: "coap://example.org/%C3%A4pfel"},
+	{Name: "uri-path-slash", Func: uriPathSlash, Note: "coap://example.org/a%2Fb"},
+	{Name: "uri-query-utf8", Func: uriQueryUTF8, Note: "coap://example.org/users?name=J%C3%BCrgen"},
+	{Name: "location-path", Func: locationPath, Note: "/items/42"},
+	{Name: "location-path-query", Func: locationPathQuery, Note: "/items/42?rev=1&a%26b"},
+	{Name: "location-path-utf8", Func: locationPathUTF8, Note: "/prices/%E2%82%AC"},
+
+	// Proxy-Uri and Proxy-Scheme options
+	{Name: "proxy-uri", Func: proxyURI},
+	{Name: "proxy-uri-long", Func: proxyURILong},
+	{Name: "proxy-scheme", Func: proxyScheme},
+	{Name: "proxy-uri-with-uri-path", Func: proxyURIWithURIPath, Note: "Uri-Path must not be included with Proxy-Uri"},
+
+	// Large payloads
+	{Name: "large-payload-1k", Func: largePayload(1 << 10)},
+	{Name: "large-payload-16k", Func: largePayload(16 << 10)},
+	{Name: "tcp-large-payload-1m", Func: tcpLargePayload(1 << 20), Format: formatTCP},
+
+	// Extended token lengths (RFC 8974)
+	{Name: "extended-token-13", Func: extendedToken(13), Note: rfc8974},
+	{Name: "extended-token-268", Func: extendedToken(268), Note: rfc8974},
+	{Name: "extended-token-269", Func: extendedToken(269), Note: rfc8974},
+	{Name: "extended-token-1024", Func: extendedToken(1024), Note: rfc8974},
+	{Name: "truncated-extended-token", Func: truncatedExtendedToken, Reject: "truncated token length"},
+
+	// OSCORE (RFC 8613 Appendix C)
+	{Name: "oscore-request-unprotected", Func: oscoreUnprotected(oscoreRequest)},
+	{Name: "oscore-response-unprotected", Func: oscoreUnprotected(oscoreResponse)},
+	{Name: "oscore-tv4-protected", Func: oscoreRequestVector("oscore-tv4-protected", 1)},
+	{Name: "oscore-tv5-protected", Func: oscoreRequestVector("oscore-tv5-protected", 2)},
+	{Name: "oscore-tv6-protected", Func: oscoreRequestVector("oscore-tv6-protected", 3)},
+	{Name: "oscore-tv7-protected", Func: oscoreTV7},
+	{Name: "oscore-tv8-protected", Func: oscoreTV8},
+
+	// CoAP over TCP
+	{Name: "tcp-ext-length-0", Func: tcpExtLength0, Format: formatTCP},
+	{Name: "tcp-ext-length-1", Func: tcpExtLength1, Format: formatTCP},
+	{Name: "tcp-ext-length-2", Func: tcpExtLength2, Format: formatTCP},
+	{Name: "tcp-ext-length-4", Func: tcpExtLength4, Format: formatTCP},
+	{Name: "tcp-csm", Func: csm, Format: formatTCP},
+	{Name: "tcp-csm-empty", Func: csmEmpty, Format: formatTCP},
+	{Name: "tcp-ping", Func: ping, Format: formatTCP},
+	{Name: "tcp-pong", Func: pong, Format: formatTCP},
+	{Name: "tcp-release", Func: release, Format: formatTCP},
+	{Name: "tcp-abort", Func: abort, Format: formatTCP},
+
+	// CoAP over WebSockets
+	{Name: "ws-request", Func: wsRequest, Format: formatWebSocket},
+	{Name: "ws-response", Func: wsResponse, Format: formatWebSocket},
+	{Name: "ws-no-token", Func: wsNoToken, Format: formatWebSocket},
+}
+
+// A single message of a sequence, optionally annotated with a note
+// which is recorded in the manifest (e.g. expected processing result).
+// If a role is given (e.g. "request"), it is used instead of the
+// position of the message for naming the vector.
+type vector struct {
+	Data []byte
+	Note string
+	Role string
+}
+
+type seqFn func() ([]vector, error)
+
+// A sequence of related messages (e.g. a block-wise transfer). Each
+// message is written to a separate file, named after the sequence and
+// suffixed with the role or the position of the message in the sequence.
+type sequence struct {
+	Name string
+	Func seqFn
+}
+
+var sequences = []sequence{
+	// Request/response exchanges
+	{Name: "exchange-get", Func: exchangeGet},
+	{Name: "exchange-separate", Func: exchangeSeparate},
+	{Name: "exchange-not-found", Func: exchangeNotFound},
+	{Name: "exchange-bad-option", Func: exchangeBadOption},
+	{Name: "exchange-bad-option-diagnostic", Func: exchangeBadOptionDiagnostic},
+
+	{Name: "multicast-discovery", Func: multicastDiscovery},
+	{Name: "reset", Func: resetSequence},
+	{Name: "block2-szx0", Func: block2Sequence(0, 0)},
+	{Name: "block2-szx2", Func: block2Sequence(2, 2)},
+	{Name: "block2-szx6", Func: block2Sequence(6, 6)},
+	{Name: "block2-late-negotiation", Func: block2Sequence(3, 2)},
+	{Name: "block1-szx0", Func: block1Sequence(0, 0)},
+	{Name: "block1-szx2", Func: block1Sequence(2, 2)},
+	{Name: "block1-szx6", Func: block1Sequence(6, 6)},
+	{Name: "block1-server-negotiation", Func: block1Sequence(6, 4)},
+	{Name: "q-block1", Func: qBlock1Sequence},
+	{Name: "q-block2", Func: qBlock2Sequence},
+	{Name: "echo", Func: echoSequence},
+	{Name: "request-tag", Func: requestTagSequence},
+	{Name: "observe", Func: observeSequence},
+	{Name: "observe-wrap", Func: observeWrapSequence},
+	{Name: "observe-reordered", Func: observeReorderedSequence},
+}
+
+// A generated test vector.
+type generated struct {
+	testCase
+	Sequence string
+	Data     []byte
+}
+
+// Generate all test vectors, including those of sequences.
+func generate() ([]generated, error) {
+	var vectors []generated
+	for _, testCase := range testCases {
+		data, err := testCase.Func()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", testCase.Name, err)
+		}
+		vectors = append(vectors, generated{testCase: testCase, Data: data})
+	}
+
+	for _, seq := range sequences {
+		msgs, err := seq.Func()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", seq.Name, err)
+		}
+
+		for i, msg := range msgs {
+			name := fmt.Sprintf("%s-%d", seq.Name, i)
+			if msg.Role != "" {
+				name = seq.Name + "-" + msg.Role
+			}
+
+			testCase := testCase{Name: name, Note: msg.Note}
+			vectors = append(vectors, generated{
+				testCase: testCase,
+				Sequence: seq.Name,
+				Data:     msg.Data,
+			})
+		}
+	}
+
+	return vectors, nil
+}
+
+// Whether the given vector matches the glob pattern passed via -only,
+// vectors of a sequence also match if the sequence name matches.
+func selected(v *generated) bool {
+	if *only == "" {
+		return true
+	}
+
+	for _, name := range []string{v.Name, v.Sequence} {
+		match, err := filepath.Match(*only, name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if match {
+			return true
+		}
+	}
+	return false
 }
 
+var (
+	outDir     = flag.String("out", "", "output `directory` (default \".\" for bin, \"./<mode>\" otherwise)")
+	only       = flag.String("only", "", "only write test vectors whose name matches the `glob`")
+	list       = flag.Bool("list", false, "list the names of all test vectors and exit")
+	compare    = flag.Bool("compare", false, "compare the go-coap encoding with other CoAP implementations and exit")
+	dtlsDir    = flag.String("dtls", "", "write DTLS-protected exchanges and their keys to the given `directory` and exit")
+	pcapFile   = flag.String("pcap", "", "write the test vectors to a pcapng `file` instead")
+	emitMode   = flag.String("emit", "bin", "output `mode` for test vectors: bin, zig, or go")
+	fuzzCorpus = flag.Int("fuzz-corpus", 0, "write `N` random messages to the fuzz corpus instead of the test vectors")
+	fuzzSeed   = flag.Int64("fuzz-seed", 5683, "seed used for generating the fuzz corpus")
+	fuzzMin    = flag.Bool("fuzz-minimize", true, "deduplicate and minimize the fuzz corpus")
+)
+
 func main() {
 	log.SetFlags(log.Lshortfile)
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s decode [-format format] file...\n", os.Args[0])
+		fmt.Fprintf(out, "       %s diff [-format format] a.bin b.bin\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	testCases := []struct{
-		Name string
-		Func genFn
-	}{
-		{ "with-payload", withPayload },
-		{ "basic-header", basicHeader },
-		{ "with-token", withToken },
-		{ "with-options", withOptions },
-		{ "payload-and-options", payloadAndOptions },
+	if flag.NArg() > 0 {
+		status, err := runSubcommand(flag.Arg(0), flag.Args()[1:])
+		if err != nil {
+			log.Print(err)
+		}
+		os.Exit(status)
 	}
 
-	// Directory where source file is located.
-	dir := filepath.Dir(os.Args[0])
+	if *fuzzCorpus > 0 {
+		dir := *outDir
+		if dir == "" {
+			dir = "corpus"
+		}
 
-	for _, testCase := range testCases {
-		fp := filepath.Join(dir, testCase.Name+".bin")
-		file, err := os.Create(fp)
+		err := writeFuzzCorpus(dir, *fuzzCorpus, *fuzzSeed, *fuzzMin)
 		if err != nil {
 			log.Fatal(err)
 		}
+		return
+	}
 
-		data, err := testCase.Func()
+	if *compare {
+		n, err := compareImplementations()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n > 0 {
+			log.Fatalf("found %d encoding disagreements", n)
+		}
+		return
+	}
+
+	if *dtlsDir != "" {
+		err := writeDTLS(*dtlsDir)
 		if err != nil {
-			file.Close()
 			log.Fatal(err)
 		}
+		return
+	}
 
-		_, err = file.Write(data)
+	emit, ok := emitters[*emitMode]
+	if !ok {
+		log.Fatalf("unknown output mode %q", *emitMode)
+	}
+
+	vectors, err := generate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *list {
+		for _, v := range vectors {
+			fmt.Println(v.Name)
+		}
+		return
+	}
+	if *pcapFile != "" {
+		err = writePcap(*pcapFile, vectors)
 		if err != nil {
-			file.Close()
 			log.Fatal(err)
 		}
-		file.Close()
+		return
+	}
+
+	dir := *outDir
+	if dir == "" {
+		dir = "."
+		if *emitMode != "bin" {
+			// Source files are written to a separate directory, a Go
+			// package in case of Go source files.
+			dir = *emitMode
+		}
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The manifest and the checksums always describe all test vectors,
+	// even if only some of them are written.
+	var manifest []manifestEntry
+	var sums fileChecksums
+	for i := range vectors {
+		v := &vectors[i]
+		fn := v.Name + emit.Ext
+
+		src, err := emit.Encode(v.Name, v.Data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if selected(v) {
+			err = os.WriteFile(filepath.Join(dir, fn), src, 0644)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		sums.add(fn, src)
+
+		entry, err := newManifestEntry(&v.testCase, fn, v.Data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		entry.Sequence = v.Sequence
+		manifest = append(manifest, entry)
+	}
+
+	err = writeManifest(filepath.Join(dir, "manifest.json"), manifest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = writeOSCOREContexts(filepath.Join(dir, "oscore-contexts.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = sums.write(dir, "manifest.json", "oscore-contexts.json")
+	if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -2,4 +2,14 @@ module github.com/nmeum/zoap/testvectors
 
 go 1.17
 
-require github.com/plgd-dev/go-coap/v2 v2.4.0
+require (
+	github.com/pion/dtls/v2 v2.0.1-0.20200503085337-8e86b3a7d585
+	github.com/plgd-dev/go-coap/v2 v2.4.0
+)
+
+require (
+	github.com/pion/logging v0.2.2 // indirect
+	github.com/pion/transport v0.10.0 // indirect
+	golang.org/x/crypto v0.0.0-20200510223506-06a226fb4e37 // indirect
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+)
@@ -0,0 +1,30 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// The Hop-Limit option as specified in RFC 8768. The option value is a
+// 1 byte uint between 1 and 255, the default value is 16. A proxy
+// receiving a request with a Hop-Limit of 1 must not forward it and
+// respond with 5.08 (Hop Limit Reached) instead.
+const hopLimitOption coap.OptionID = 16
+
+func hopLimit(limit uint32) genFn {
+	return func() ([]byte, error) {
+		return marshal(message.Message{
+			Code:      codes.GET,
+			Token:     []byte{0x40, 0x9e},
+			Payload:   []byte{},
+			MessageID: 0x0d22,
+			Type:      message.Confirmable,
+			Options: coap.Options{
+				{ID: coap.URIHost, Value: []byte("example.org")},
+				{ID: coap.URIPath, Value: []byte("hop")},
+				{ID: hopLimitOption, Value: uintValue(limit)},
+			},
+		})
+	}
+}
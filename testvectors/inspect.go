@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+// Subcommands for inspecting existing test vectors: decode prints a
+// human-readable breakdown of a vector and diff compares two vectors
+// field by field.
+
+// Names of options used by the test vectors, as registered in the CoAP
+// Option Numbers registry.
+var optionNames = map[uint32]string{
+	1:   "If-Match",
+	3:   "Uri-Host",
+	4:   "ETag",
+	5:   "If-None-Match",
+	6:   "Observe",
+	7:   "Uri-Port",
+	8:   "Location-Path",
+	9:   "OSCORE",
+	11:  "Uri-Path",
+	12:  "Content-Format",
+	14:  "Max-Age",
+	15:  "Uri-Query",
+	16:  "Hop-Limit",
+	17:  "Accept",
+	19:  "Q-Block1",
+	20:  "Location-Query",
+	23:  "Block2",
+	27:  "Block1",
+	28:  "Size2",
+	31:  "Q-Block2",
+	35:  "Proxy-Uri",
+	39:  "Proxy-Scheme",
+	60:  "Size1",
+	252: "Echo",
+	258: "No-Response",
+	292: "Request-Tag",
+}
+
+var signalingNames = map[codes.Code]string{
+	codes.CSM:     "CSM",
+	codes.Ping:    "Ping",
+	codes.Pong:    "Pong",
+	codes.Release: "Release",
+	codes.Abort:   "Abort",
+}
+
+// Signaling messages (RFC 8323 Section 5) define their own options.
+var signalingOptionNames = map[codes.Code]map[uint32]string{
+	codes.CSM:     {2: "Max-Message-Size", 4: "Block-Wise-Transfer"},
+	codes.Ping:    {2: "Custody"},
+	codes.Pong:    {2: "Custody"},
+	codes.Release: {2: "Alternative-Address", 4: "Hold-Off"},
+	codes.Abort:   {2: "Bad-CSM-Option"},
+}
+
+func optionName(c codes.Code, n uint32) string {
+	names := optionNames
+	if c>>5 == 7 {
+		names = signalingOptionNames[c]
+	}
+
+	if name, ok := names[n]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+func codeName(c codes.Code) string {
+	for _, d := range definedCodes {
+		if d.Code == c {
+			return d.Desc
+		}
+	}
+	if name, ok := signalingNames[c]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Format an option value as a string if it is printable UTF-8, and as
+// hex otherwise.
+func formatValue(v []byte) string {
+	if len(v) == 0 {
+		return "(empty)"
+	}
+	if utf8.Valid(v) {
+		printable := true
+		for _, r := range string(v) {
+			if r < 0x20 || r == 0x7f {
+				printable = false
+			}
+		}
+		if printable {
+			return fmt.Sprintf("%q", v)
+		}
+	}
+	return hex.EncodeToString(v)
+}
+
+func printMessage(w io.Writer, f format, m *rawMessage) {
+	if f == formatUDP {
+		fmt.Fprintf(w, "Version:    %d\n", m.Version)
+		fmt.Fprintf(w, "Type:       %s\n", typeNames[m.Type])
+	}
+	fmt.Fprintf(w, "Code:       %s (%s)\n", formatCode(m.Code), codeName(m.Code))
+	if f == formatUDP {
+		fmt.Fprintf(w, "Message ID: %d (0x%04x)\n", m.MessageID, m.MessageID)
+	}
+	fmt.Fprintf(w, "Token:      %s (%d bytes)\n", formatValue(m.Token), len(m.Token))
+
+	fmt.Fprintf(w, "Options:    %d\n", len(m.Options))
+	for _, o := range m.Options {
+		fmt.Fprintf(w, "  %5d %-20s %4d  %s\n", o.Number, optionName(m.Code, o.Number), len(o.Value), formatValue(o.Value))
+	}
+
+	fmt.Fprintf(w, "Payload:    %d bytes\n", len(m.Payload))
+	if len(m.Payload) > 0 {
+		fmt.Fprint(w, hex.Dump(m.Payload))
+	}
+}
+
+func loadMessage(f format, fp string) (*rawMessage, error) {
+	data, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := decodeFormat(f, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fp, err)
+	}
+	return m, nil
+}
+
+// Compare two decoded messages, writing one line per differing field.
+// Returns the number of differences.
+func diffMessages(w io.Writer, a, b *rawMessage) int {
+	var n int
+	diff := func(field string, x, y interface{}) {
+		fmt.Fprintf(w, "%s: %v != %v\n", field, x, y)
+		n++
+	}
+
+	if a.Version != b.Version {
+		diff("version", a.Version, b.Version)
+	}
+	if a.Type != b.Type {
+		diff("type", typeNames[a.Type], typeNames[b.Type])
+	}
+	if a.Code != b.Code {
+		diff("code", formatCode(a.Code), formatCode(b.Code))
+	}
+	if a.MessageID != b.MessageID {
+		diff("message ID", a.MessageID, b.MessageID)
+	}
+	if !bytes.Equal(a.Token, b.Token) {
+		diff("token", formatValue(a.Token), formatValue(b.Token))
+	}
+
+	for i := 0; i < len(a.Options) || i < len(b.Options); i++ {
+		field := fmt.Sprintf("option %d", i)
+		switch {
+		case i >= len(a.Options):
+			diff(field, "(missing)", optionName(b.Code, b.Options[i].Number))
+		case i >= len(b.Options):
+			diff(field, optionName(a.Code, a.Options[i].Number), "(missing)")
+		case a.Options[i].Number != b.Options[i].Number:
+			diff(field, optionName(a.Code, a.Options[i].Number), optionName(b.Code, b.Options[i].Number))
+		case !bytes.Equal(a.Options[i].Value, b.Options[i].Value):
+			diff(field+" "+optionName(a.Code, a.Options[i].Number),
+				formatValue(a.Options[i].Value), formatValue(b.Options[i].Value))
+		}
+	}
+
+	if !bytes.Equal(a.Payload, b.Payload) {
+		diff("payload", fmt.Sprintf("%d bytes", len(a.Payload)), fmt.Sprintf("%d bytes", len(b.Payload)))
+	}
+	return n
+}
+
+func parseFormat(name string) (format, error) {
+	for _, f := range []format{formatUDP, formatTCP, formatWebSocket} {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+	return formatUDP, fmt.Errorf("unknown message format %q", name)
+}
+
+// Run the given subcommand with the given arguments. Returns the exit
+// status of the subcommand.
+func runSubcommand(name string, args []string) (int, error) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	formatName := flags.String("format", "udp", "message `format` of the vectors: udp, tcp, or websocket")
+	flags.Parse(args)
+
+	f, err := parseFormat(*formatName)
+	if err != nil {
+		return 2, err
+	}
+
+	switch name {
+	case "decode":
+		for i, fp := range flags.Args() {
+			m, err := loadMessage(f, fp)
+			if err != nil {
+				return 1, err
+			}
+
+			if i > 0 {
+				fmt.Println()
+			}
+			if flags.NArg() > 1 {
+				fmt.Printf("%s:\n", fp)
+			}
+			printMessage(os.Stdout, f, m)
+		}
+		return 0, nil
+	case "diff":
+		if flags.NArg() != 2 {
+			return 2, fmt.Errorf("usage: diff [-format format] a.bin b.bin")
+		}
+
+		a, err := loadMessage(f, flags.Arg(0))
+		if err != nil {
+			return 1, err
+		}
+		b, err := loadMessage(f, flags.Arg(1))
+		if err != nil {
+			return 1, err
+		}
+
+		if diffMessages(os.Stdout, a, b) > 0 {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 2, fmt.Errorf("unknown subcommand %q", name)
+	}
+}
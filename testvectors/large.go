@@ -0,0 +1,44 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	tcp "github.com/plgd-dev/go-coap/v2/tcp/message"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Messages with payloads exceeding the typical path MTU. The 16 KiB
+// payload still fits in a single UDP datagram, while the 1 MiB payload
+// is only possible with the CoAP over TCP message format.
+
+var largeToken = []byte{0x1a, 0x26}
+
+func largeOptions() coap.Options {
+	return coap.Options{
+		{ID: coap.ContentFormat, Value: uintValue(uint32(coap.AppOctets))},
+	}
+}
+
+func largePayload(size int) genFn {
+	return func() ([]byte, error) {
+		return marshal(message.Message{
+			Code:      codes.Content,
+			Token:     largeToken,
+			Payload:   blockBody(size),
+			MessageID: 0x0d26,
+			Type:      message.Acknowledgement,
+			Options:   largeOptions(),
+		})
+	}
+}
+
+func tcpLargePayload(size int) genFn {
+	return func() ([]byte, error) {
+		return marshalTCP(tcp.Message{
+			Code:    codes.Content,
+			Token:   largeToken,
+			Payload: blockBody(size),
+			Options: largeOptions(),
+		})
+	}
+}
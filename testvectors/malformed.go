@@ -0,0 +1,84 @@
+package main
+
+// Malformed messages which must be rejected (or ignored) by a parser.
+// Since go-coap refuses to marshal most of these, they are either
+// hand-crafted or derived from a valid message by modifying the encoded
+// bytes.
+
+// From RFC 7252:
+//
+//	Implementations of this specification MUST set this field
+//	to 1 (01 binary). Other values are reserved for future versions.
+//	Messages with unknown version numbers MUST be silently ignored.
+func version(v byte) genFn {
+	return func() ([]byte, error) {
+		data, err := basicHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		data[0] = (data[0] & 0x3f) | (v << 6)
+		return data, nil
+	}
+}
+
+func badVersion() ([]byte, error) {
+	return version(2)()
+}
+
+func truncatedHeader() ([]byte, error) {
+	data, err := basicHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	// The fixed-size CoAP header is four bytes long.
+	return data[:3], nil
+}
+
+func invalidTokenLength() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 15
+		0x4f, 0x01, 0x00, 0x2a,
+		// Token bytes, present to ensure that only the TKL is invalid.
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14,
+	}, nil
+}
+
+func optionDelta15() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 0, Code = GET, MID = 42
+		0x40, 0x01, 0x00, 0x2a,
+		// Option Delta = 15 (reserved), Option Length = 1
+		0xf1, 0x00,
+	}, nil
+}
+
+func payloadMarkerOnly() ([]byte, error) {
+	// From RFC 7252:
+	//
+	//   The presence of a marker followed by a zero-length payload MUST
+	//   be processed as a message format error.
+	//
+	return []byte{
+		// Ver = 1, T = CON, TKL = 0, Code = GET, MID = 43
+		0x40, 0x01, 0x00, 0x2b,
+		// Uri-Path option "test"
+		0xb4, 't', 'e', 's', 't',
+		// Payload marker, not followed by a payload
+		0xff,
+	}, nil
+}
+
+// Message with the given reserved token length (9-12), the token bytes
+// are present to ensure that only the TKL is invalid.
+func reservedTokenLength(tkl int) genFn {
+	return func() ([]byte, error) {
+		// Ver = 1, T = CON, Code = GET, MID = 44
+		data := []byte{0x40 | byte(tkl), 0x01, 0x00, 0x2c}
+		for i := 0; i < tkl; i++ {
+			data = append(data, byte(i))
+		}
+		return data, nil
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+var typeNames = []string{"CON", "NON", "ACK", "RST"}
+
+// manifestOption describes an option, including the properties
+// encoded in its option number (RFC 7252 Section 5.4.6).
+type manifestOption struct {
+	Number     uint32 `json:"number"`
+	Value      string `json:"value"`
+	Critical   bool   `json:"critical,omitempty"`
+	Unsafe     bool   `json:"unsafe,omitempty"`
+	NoCacheKey bool   `json:"no_cache_key,omitempty"`
+}
+
+// From RFC 7252:
+//
+//	  0   1   2   3   4   5   6   7
+//	+---+---+---+---+---+---+---+---+
+//	|           | NoCacheKey| U | C |
+//	+---+---+---+---+---+---+---+---+
+func newManifestOption(o rawOption) manifestOption {
+	return manifestOption{
+		Number:     o.Number,
+		Value:      hex.EncodeToString(o.Value),
+		Critical:   o.Number&0x01 != 0,
+		Unsafe:     o.Number&0x02 != 0,
+		NoCacheKey: o.Number&0x1e == 0x1c,
+	}
+}
+
+// Payloads exceeding this size are only recorded by their size and
+// SHA-256 checksum to keep the manifest reasonably small.
+const maxManifestPayload = 1024
+
+// manifestMessage describes the expected decode result of a vector.
+// Byte strings (token, option values and payload) are hex encoded.
+// Type and Message ID are not present in the TCP message format.
+type manifestMessage struct {
+	Type          string           `json:"type,omitempty"`
+	Code          string           `json:"code"`
+	MessageID     *uint16          `json:"message_id,omitempty"`
+	Token         string           `json:"token"`
+	Options       []manifestOption `json:"options"`
+	Payload       *string          `json:"payload,omitempty"`
+	PayloadSize   int              `json:"payload_size,omitempty"`
+	PayloadSHA256 string           `json:"payload_sha256,omitempty"`
+}
+
+type manifestEntry struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+
+	// Name of the sequence this vector is part of, if any.
+	Sequence string `json:"sequence,omitempty"`
+
+	// Message format of this vector, omitted for the UDP format.
+	Format string `json:"format,omitempty"`
+
+	// Optional human-readable annotation, e.g. the expected result of
+	// processing this message as part of its sequence.
+	Note string `json:"note,omitempty"`
+
+	// Reason why a parser must reject this vector, empty if the vector
+	// is a valid CoAP message. The expected decode result is only
+	// present for valid vectors.
+	Reject string `json:"reject,omitempty"`
+
+	// Reason why a receiver must silently ignore this vector, the
+	// expected decode result is not present for ignored vectors.
+	Ignore string `json:"ignore,omitempty"`
+	*manifestMessage
+}
+
+func formatCode(c codes.Code) string {
+	return fmt.Sprintf("%d.%02d", c>>5, c&0x1f)
+}
+
+func newManifestMessage(f format, m *rawMessage) *manifestMessage {
+	options := make([]manifestOption, 0, len(m.Options))
+	for _, o := range m.Options {
+		options = append(options, newManifestOption(o))
+	}
+
+	msg := &manifestMessage{
+		Code:    formatCode(m.Code),
+		Token:   hex.EncodeToString(m.Token),
+		Options: options,
+	}
+	if len(m.Payload) > maxManifestPayload {
+		sum := sha256.Sum256(m.Payload)
+		msg.PayloadSize = len(m.Payload)
+		msg.PayloadSHA256 = hex.EncodeToString(sum[:])
+	} else {
+		payload := hex.EncodeToString(m.Payload)
+		msg.Payload = &payload
+	}
+	if f == formatUDP {
+		msg.Type = typeNames[m.Type]
+		msg.MessageID = &m.MessageID
+	}
+
+	return msg
+}
+
+func newManifestEntry(tc *testCase, fn string, data []byte) (manifestEntry, error) {
+	entry := manifestEntry{
+		Name:   tc.Name,
+		File:   fn,
+		Reject: tc.Reject,
+		Ignore: tc.Ignore,
+		Note:   tc.Note,
+	}
+	if tc.Format != formatUDP {
+		entry.Format = tc.Format.String()
+	}
+	if tc.Reject != "" || tc.Ignore != "" {
+		return entry, nil
+	}
+
+	msg, err := decodeFormat(tc.Format, data)
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("%s: %w", tc.Name, err)
+	}
+	entry.manifestMessage = newManifestMessage(tc.Format, msg)
+
+	return entry, nil
+}
+
+func writeManifest(fp string, entries []manifestEntry) error {
+	data, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fp, append(data, '\n'), 0644)
+}
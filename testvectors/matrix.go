@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// All combinations of message types and code classes (request, response,
+// and empty) as specified in RFC 7252 Section 4. All of these messages
+// are well-formed, whether the combination is valid is recorded as a
+// note, e.g. for testing the messaging layer state machine.
+
+var matrixTypes = []message.Type{
+	message.Confirmable,
+	message.NonConfirmable,
+	message.Acknowledgement,
+	message.Reset,
+}
+
+var matrixClasses = []struct {
+	Name string
+	Code codes.Code
+}{
+	{"request", codes.GET},
+	{"response", codes.Content},
+	{"empty", codes.Empty},
+}
+
+// Returns an empty string if the combination is valid, otherwise a
+// description of why it is invalid.
+func matrixInvalid(typ message.Type, code codes.Code) string {
+	switch {
+	case typ == message.NonConfirmable && code == codes.Empty:
+		return "NON messages must not be empty"
+	case typ == message.Acknowledgement && code != codes.Empty && code>>5 == 0:
+		return "ACK messages must carry a response or be empty"
+	case typ == message.Reset && code != codes.Empty:
+		return "RST messages must be empty"
+	default:
+		return ""
+	}
+}
+
+func matrixVector(typ message.Type, code codes.Code) genFn {
+	return func() ([]byte, error) {
+		// Empty messages must not carry a token (RFC 7252 Section 4.1).
+		token := []byte{0x37, 0x37}
+		if code == codes.Empty {
+			token = []byte{}
+		}
+
+		return marshal(message.Message{
+			Code:      code,
+			Token:     token,
+			Payload:   []byte{},
+			MessageID: 0x0d37,
+			Type:      typ,
+		})
+	}
+}
+
+func init() {
+	for _, typ := range matrixTypes {
+		for _, class := range matrixClasses {
+			note := "valid"
+			if reason := matrixInvalid(typ, class.Code); reason != "" {
+				note = "invalid: " + reason
+			}
+
+			testCases = append(testCases, testCase{
+				Name: fmt.Sprintf("matrix-%s-%s", strings.ToLower(typeNames[typ]), class.Name),
+				Func: matrixVector(typ, class.Code),
+				Note: note,
+			})
+		}
+	}
+}
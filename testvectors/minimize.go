@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Post-processing of the fuzz corpus. Random messages often only differ
+// in the content of their token, option values, and payload, which does
+// not affect the code paths taken by the parser. For this reason, each
+// message is minimized while preserving its parse outcome and messages
+// with an identical outcome are only retained once.
+
+// lengthClass returns the number of extended bytes required for encoding
+// the given option delta or length.
+func lengthClass(n uint32) int {
+	switch {
+	case n < 13:
+		return 0
+	case n < 269:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// minLength returns the smallest length encoded using the same number of
+// extended bytes as the given length.
+func minLength(n int) int {
+	switch lengthClass(uint32(n)) {
+	case 0:
+		if n > 0 {
+			return 1
+		}
+		return 0
+	case 1:
+		return 13
+	default:
+		return 269
+	}
+}
+
+// outcome describes the parse outcome of the given message, i.e. either
+// the decoding error or the structure of the decoded message. Messages
+// with the same outcome exercise the same code paths in the parser.
+func outcome(data []byte) string {
+	m, err := decode(data)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "%d %d %v tkl=%d", m.Version, m.Type, m.Code, minLength(len(m.Token)))
+
+	var last uint32
+	for _, o := range m.Options {
+		fmt.Fprintf(&w, " %d/%d/%d", o.Number, lengthClass(o.Number-last), minLength(len(o.Value)))
+		last = o.Number
+	}
+	if len(m.Payload) > 0 {
+		w.WriteString(" payload")
+	}
+
+	return w.String()
+}
+
+// encodeExtended returns the nibble and the extended bytes for the given
+// option delta or length.
+func encodeExtended(n uint32) (uint8, []byte) {
+	switch lengthClass(n) {
+	case 0:
+		return uint8(n), nil
+	case 1:
+		return 13, []byte{byte(n - 13)}
+	default:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(n-269))
+		return 14, ext
+	}
+}
+
+// encode encodes the given message in the UDP message format. Contrary to
+// go-coap, options are encoded exactly as given.
+func encode(m *rawMessage) []byte {
+	data := []byte{
+		m.Version<<6 | m.Type<<4 | uint8(len(m.Token)),
+		uint8(m.Code),
+		0, 0,
+	}
+	binary.BigEndian.PutUint16(data[2:], m.MessageID)
+	data = append(data, m.Token...)
+
+	var last uint32
+	for _, o := range m.Options {
+		delta, deltaExt := encodeExtended(o.Number - last)
+		length, lengthExt := encodeExtended(uint32(len(o.Value)))
+
+		data = append(data, delta<<4|length)
+		data = append(data, deltaExt...)
+		data = append(data, lengthExt...)
+		data = append(data, o.Value...)
+		last = o.Number
+	}
+
+	if len(m.Payload) > 0 {
+		data = append(data, 0xff)
+		data = append(data, m.Payload...)
+	}
+
+	return data
+}
+
+// minimize shrinks the token, all option values, and the payload of the
+// given message to the smallest length which is encoded in the same way.
+// If the minimized message does not preserve the parse outcome, the
+// original message is returned unmodified.
+func minimize(data []byte) []byte {
+	m, err := decode(data)
+	if err != nil || len(m.Token) > 8 {
+		return data
+	}
+
+	small := *m
+	small.Token = m.Token[:minLength(len(m.Token))]
+	small.Options = make([]rawOption, len(m.Options))
+	for i, o := range m.Options {
+		small.Options[i] = rawOption{o.Number, o.Value[:minLength(len(o.Value))]}
+	}
+	if len(m.Payload) > 0 {
+		small.Payload = m.Payload[:1]
+	}
+
+	reduced := encode(&small)
+	if outcome(reduced) != outcome(data) {
+		return data
+	}
+	return reduced
+}
+
+// minimizeCorpus minimizes all given messages and removes messages whose
+// parse outcome is identical to that of a preceding message.
+func minimizeCorpus(corpus [][]byte) [][]byte {
+	seen := make(map[string]bool)
+	result := make([][]byte, 0, len(corpus))
+
+	for _, data := range corpus {
+		key := outcome(data)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		result = append(result, minimize(data))
+	}
+
+	return result
+}
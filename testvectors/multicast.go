@@ -0,0 +1,68 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Resource discovery using multicast (RFC 7252 Section 8, RFC 6690).
+// The request is sent as a NON message without token to the All CoAP
+// Nodes address, each server responds with a unicast NON response. All
+// responses which echo the (empty) token of the request match it,
+// regardless of their message ID.
+
+var multicastResponses = []struct {
+	MessageID uint16
+	Links     string
+}{
+	{0x1a01, "</sensors/temp>;rt=\"temperature-c\";if=\"sensor\""},
+	{0x2b02, "</light>;rt=\"light-lux\";if=\"sensor\""},
+	{0x3c03, "</actuators/led>;if=\"core.a\""},
+}
+
+func multicastDiscovery() ([]vector, error) {
+	req, err := marshal(message.Message{
+		Code:      codes.GET,
+		Token:     []byte{},
+		Payload:   []byte{},
+		MessageID: 0x0d80,
+		Type:      message.NonConfirmable,
+		Options: coap.Options{
+			{ID: coap.URIPath, Value: []byte(".well-known")},
+			{ID: coap.URIPath, Value: []byte("core")},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	msgs := []vector{{Data: req, Note: "multicast request"}}
+
+	response := func(mid uint16, token []byte, links string) ([]byte, error) {
+		return marshal(message.Message{
+			Code:      codes.Content,
+			Token:     token,
+			Payload:   []byte(links),
+			MessageID: mid,
+			Type:      message.NonConfirmable,
+			Options: coap.Options{
+				{ID: coap.ContentFormat, Value: uintValue(uint32(coap.AppLinkFormat))},
+			},
+		})
+	}
+
+	for _, r := range multicastResponses {
+		resp, err := response(r.MessageID, []byte{}, r.Links)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, vector{Data: resp, Note: "matching response"})
+	}
+
+	// Response to a different request, must not be matched.
+	other, err := response(0x4d04, []byte{0x0d, 0x80}, "</other>")
+	if err != nil {
+		return nil, err
+	}
+	return append(msgs, vector{Data: other, Note: "token does not match request"}), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// The No-Response option as specified in RFC 7967.
+//
+// From RFC 7967:
+//
+//	| Value | Binary Representation | Description                    |
+//	| 2     | 00000010              | Not interested in 2.xx         |
+//	|       |                       | responses.                     |
+//	| 8     | 00001000              | Not interested in 4.xx         |
+//	|       |                       | responses.                     |
+//	| 16    | 00010000              | Not interested in 5.xx         |
+//	|       |                       | responses.                     |
+//
+// The values can be combined, a value of 0 indicates interest in all
+// responses.
+const noResponseOption coap.OptionID = 258
+
+func noResponse(mask uint32) genFn {
+	return func() ([]byte, error) {
+		return marshal(message.Message{
+			Code:      codes.PUT,
+			Token:     []byte{0x7e, 0x67},
+			Payload:   []byte("on"),
+			MessageID: 0x0d21,
+			Type:      message.NonConfirmable,
+			Options: coap.Options{
+				{ID: coap.URIPath, Value: []byte("light")},
+				{ID: noResponseOption, Value: uintValue(mask)},
+			},
+		})
+	}
+}
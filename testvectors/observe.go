@@ -0,0 +1,138 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Observe notifications as specified in RFC 7641.
+
+var observeToken = []byte{0x0b, 0x5e}
+
+func observeRequest(mid uint16, observe uint32) ([]byte, error) {
+	opts := coap.Options{
+		{ID: coap.Observe, Value: uintValue(observe)},
+		{ID: coap.URIPath, Value: []byte("temperature")},
+	}
+
+	return marshal(message.Message{
+		Code:      codes.GET,
+		Token:     observeToken,
+		Payload:   []byte{},
+		MessageID: mid,
+		Type:      message.Confirmable,
+		Options:   opts,
+	})
+}
+
+// Notification with the given Observe value. The first notification is
+// piggybacked on the ACK of the registration, later notifications are
+// sent as NON messages.
+func notification(typ message.Type, mid uint16, seq uint32, payload string) ([]byte, error) {
+	opts := coap.Options{
+		{ID: coap.Observe, Value: uintValue(seq)},
+		{ID: coap.ContentFormat, Value: uintValue(uint32(coap.TextPlain))},
+	}
+
+	return marshal(message.Message{
+		Code:      codes.Content,
+		Token:     observeToken,
+		Payload:   []byte(payload),
+		MessageID: mid,
+		Type:      typ,
+		Options:   opts,
+	})
+}
+
+// Notifications with the given Observe values and notes, following a
+// registration whose ACK carries the given initial Observe value.
+func observeNotifications(initial uint32, values []uint32, notes []string) ([]vector, error) {
+	reg, err := observeRequest(0x0b50, 0)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := notification(message.Acknowledgement, 0x0b50, initial, "21.0 C")
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []vector{
+		{Data: reg, Note: "registration"},
+		{Data: ack, Note: "fresh: initial notification"},
+	}
+	for i, v := range values {
+		n, err := notification(message.NonConfirmable, uint16(0x0c00+i), v, "21.5 C")
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, vector{Data: n, Note: notes[i]})
+	}
+
+	return msgs, nil
+}
+
+func observeSequence() ([]vector, error) {
+	msgs, err := observeNotifications(1000,
+		[]uint32{1001, 1002, 1003},
+		[]string{"fresh", "fresh", "fresh"})
+	if err != nil {
+		return nil, err
+	}
+
+	// From RFC 7641:
+	//
+	//   A client MAY explicitly deregister by issuing a GET request that
+	//   has the Token field set to the token of the observation to be
+	//   cancelled and includes an Observe Option with the value set to 1
+	//   (deregister).
+	//
+	dereg, err := observeRequest(0x0b51, 1)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := marshal(message.Message{
+		Code:      codes.Content,
+		Token:     observeToken,
+		Payload:   []byte("21.5 C"),
+		MessageID: 0x0b51,
+		Type:      message.Acknowledgement,
+		Options: coap.Options{
+			{ID: coap.ContentFormat, Value: uintValue(uint32(coap.TextPlain))},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(msgs,
+		vector{Data: dereg, Note: "deregistration"},
+		vector{Data: resp, Note: "response without Observe option"},
+	), nil
+}
+
+// Observe values are 24-bit sequence numbers which wrap around.
+func observeWrapSequence() ([]vector, error) {
+	return observeNotifications(0xfffffd,
+		[]uint32{0xfffffe, 0xffffff, 0, 1},
+		[]string{"fresh", "fresh", "fresh: wrap-around", "fresh"})
+}
+
+// From RFC 7641:
+//
+//	(V1 < V2 and V2 - V1 < 2^23) or
+//	(V1 > V2 and V1 - V2 > 2^23) or
+//	(T2 > T1 + 128 seconds)
+//
+// All notifications are assumed to be received within 128 seconds.
+func observeReorderedSequence() ([]vector, error) {
+	return observeNotifications(10,
+		[]uint32{12, 11, 13, 13 + 1<<23, 14},
+		[]string{
+			"fresh",
+			"stale: reordered",
+			"fresh",
+			"stale: difference is not less than 2^23",
+			"fresh",
+		})
+}
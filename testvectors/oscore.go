@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// OSCORE test vectors as specified in RFC 8613 Appendix C. The security
+// contexts are derived from the sample master secrets and the messages
+// are protected using them. All derived values are compared against the
+// values published in the RFC to detect implementation errors.
+
+const (
+	oscoreOption coap.OptionID = 9
+
+	// AES-CCM-16-64-128 (COSE algorithm 10)
+	oscoreAlg      = 10
+	oscoreKeyLen   = 16
+	oscoreNonceLen = 13
+	oscoreTagLen   = 8
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Minimal CBOR encoder, only supports what is needed for OSCORE.
+
+func cborHead(major byte, n int) []byte {
+	if n < 24 {
+		return []byte{major<<5 | byte(n)}
+	}
+	return []byte{major<<5 | 24, byte(n)}
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, len(b)), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, len(s)), s...)
+}
+
+func cborArray(items ...[]byte) []byte {
+	return append(cborHead(4, len(items)), bytes.Join(items, nil)...)
+}
+
+var cborNull = []byte{0xf6}
+
+// HKDF with SHA-256 as specified in RFC 5869.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	if salt == nil {
+		salt = make([]byte, sha256.Size)
+	}
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	var okm, t []byte
+	for i := byte(1); len(okm) < length; i++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(t)
+		expand.Write(info)
+		expand.Write([]byte{i})
+		t = expand.Sum(nil)
+		okm = append(okm, t...)
+	}
+
+	return okm[:length]
+}
+
+// AES-CCM as specified in RFC 3610 with a 13 byte nonce (L = 2) and
+// an 8 byte authentication tag (M = 8).
+func ccmSeal(key, nonce, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// CBC-MAC over B_0, the encoded additional data and the plaintext.
+	var mac [aes.BlockSize]byte
+	macBlock := func(b []byte) {
+		var padded [aes.BlockSize]byte
+		copy(padded[:], b)
+		for i := range mac {
+			mac[i] ^= padded[i]
+		}
+		block.Encrypt(mac[:], mac[:])
+	}
+	macData := func(data []byte) {
+		for len(data) > 0 {
+			n := aes.BlockSize
+			if len(data) < n {
+				n = len(data)
+			}
+			macBlock(data[:n])
+			data = data[n:]
+		}
+	}
+
+	// Flags = 64*Adata + 8*M' + L' with M' = (M-2)/2 and L' = L-1.
+	b0 := append([]byte{0x40 | ((oscoreTagLen-2)/2)<<3 | 1}, nonce...)
+	b0 = append(b0, byte(len(plaintext)>>8), byte(len(plaintext)))
+	macBlock(b0)
+	macData(append([]byte{byte(len(aad) >> 8), byte(len(aad))}, aad...))
+	macData(plaintext)
+
+	// Counter mode encryption, A_0 is used to encrypt the tag.
+	a0 := append([]byte{1}, nonce...)
+	a0 = append(a0, 0, 0)
+	stream := cipher.NewCTR(block, a0)
+
+	var s0 [aes.BlockSize]byte
+	stream.XORKeyStream(s0[:], s0[:])
+	ciphertext := make([]byte, len(plaintext), len(plaintext)+oscoreTagLen)
+	stream.XORKeyStream(ciphertext, plaintext)
+
+	for i := 0; i < oscoreTagLen; i++ {
+		ciphertext = append(ciphertext, mac[i]^s0[i])
+	}
+	return ciphertext, nil
+}
+
+type oscoreContext struct {
+	Name         string
+	MasterSecret []byte
+	MasterSalt   []byte
+	SenderID     []byte
+	RecipientID  []byte
+	IDContext    []byte
+
+	// Derived values
+	SenderKey    []byte
+	RecipientKey []byte
+	CommonIV     []byte
+}
+
+// Derive key or IV as specified in RFC 8613 Section 3.2.1.
+func (c *oscoreContext) derive(id []byte, typ string, length int) []byte {
+	idContext := cborNull
+	if c.IDContext != nil {
+		idContext = cborBytes(c.IDContext)
+	}
+
+	info := cborArray(cborBytes(id), idContext, []byte{oscoreAlg},
+		cborText(typ), []byte{byte(length)})
+	return hkdf(c.MasterSalt, c.MasterSecret, info, length)
+}
+
+func (c *oscoreContext) init() {
+	c.SenderKey = c.derive(c.SenderID, "Key", oscoreKeyLen)
+	c.RecipientKey = c.derive(c.RecipientID, "Key", oscoreKeyLen)
+	c.CommonIV = c.derive([]byte{}, "IV", oscoreNonceLen)
+}
+
+// Server side security context of the given client context.
+func (c oscoreContext) server() oscoreContext {
+	c.Name += "-server"
+	c.SenderID, c.RecipientID = c.RecipientID, c.SenderID
+	c.init()
+	return c
+}
+
+// Nonce as specified in RFC 8613 Section 5.2.
+func (c *oscoreContext) nonce(idPIV, piv []byte) []byte {
+	nonce := make([]byte, oscoreNonceLen)
+	nonce[0] = byte(len(idPIV))
+	copy(nonce[oscoreNonceLen-5-len(idPIV):], idPIV)
+	copy(nonce[oscoreNonceLen-len(piv):], piv)
+	for i := range nonce {
+		nonce[i] ^= c.CommonIV[i]
+	}
+	return nonce
+}
+
+// Class U options, all other options used in these vectors are class E.
+var oscoreClassU = map[coap.OptionID]bool{
+	coap.URIHost:     true,
+	coap.URIPort:     true,
+	coap.ProxyScheme: true,
+}
+
+// OSCORE option value as specified in RFC 8613 Section 6.1.
+func oscoreOptionValue(piv, kid, kidContext []byte, withKid bool) []byte {
+	flags := byte(len(piv))
+	if withKid {
+		flags |= 0x08
+	}
+	if kidContext != nil {
+		flags |= 0x10
+	}
+	if flags == 0 {
+		return []byte{}
+	}
+
+	value := append([]byte{flags}, piv...)
+	if kidContext != nil {
+		value = append(value, byte(len(kidContext)))
+		value = append(value, kidContext...)
+	}
+	if withKid {
+		value = append(value, kid...)
+	}
+	return value
+}
+
+type oscoreMessage struct {
+	Msg message.Message
+
+	// Partial IV and ID of the endpoint which generated it, used for
+	// the nonce. For responses without a Partial IV, the values of the
+	// request are used.
+	IDPIV []byte
+	PIV   []byte
+
+	// Request values, used for the additional authenticated data.
+	RequestKID []byte
+	RequestPIV []byte
+
+	// Whether the Partial IV and kid respectively are included in the
+	// OSCORE option (always the case for requests).
+	SendPIV bool
+	SendKID bool
+}
+
+// Protect a CoAP message as specified in RFC 8613 Section 8.
+func (c *oscoreContext) protect(m *oscoreMessage) ([]byte, error) {
+	var inner, outer coap.Options
+	for _, o := range m.Msg.Options {
+		if oscoreClassU[o.ID] {
+			outer = append(outer, o)
+		} else {
+			inner = append(inner, o)
+		}
+	}
+
+	plaintext := []byte{byte(m.Msg.Code)}
+	n, err := inner.Marshal(nil)
+	if err != coap.ErrTooSmall {
+		return nil, err
+	}
+	encoded := make([]byte, n)
+	if _, err = inner.Marshal(encoded); err != nil {
+		return nil, err
+	}
+	plaintext = append(plaintext, encoded...)
+	if len(m.Msg.Payload) > 0 {
+		plaintext = append(plaintext, 0xff)
+		plaintext = append(plaintext, m.Msg.Payload...)
+	}
+
+	// AAD as specified in RFC 8613 Section 5.4, without class I options.
+	aadArray := cborArray([]byte{1}, cborArray([]byte{oscoreAlg}),
+		cborBytes(m.RequestKID), cborBytes(m.RequestPIV), cborBytes([]byte{}))
+	aad := cborArray(cborText("Encrypt0"), cborBytes([]byte{}), cborBytes(aadArray))
+
+	ciphertext, err := ccmSeal(c.SenderKey, c.nonce(m.IDPIV, m.PIV), plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	var piv, kidContext []byte
+	if m.SendPIV {
+		piv = m.PIV
+	}
+	if m.SendKID {
+		kidContext = c.IDContext
+	}
+	value := oscoreOptionValue(piv, c.SenderID, kidContext, m.SendKID)
+	outer = outer.Add(coap.Option{ID: oscoreOption, Value: value})
+
+	// From RFC 8613:
+	//
+	//   The outer Code SHALL be set to 0.02 (POST) for requests without
+	//   Observe [...] and to 2.04 (Changed) for responses without Observe.
+	//
+	code := codes.POST
+	if m.Msg.Code >= codes.Created {
+		code = codes.Changed
+	}
+
+	outerMsg := m.Msg
+	outerMsg.Code = code
+	outerMsg.Options = outer
+	outerMsg.Payload = ciphertext
+	return marshal(outerMsg)
+}
+
+// Security contexts of RFC 8613 Appendix C.1 to C.3 (client side).
+var oscoreContexts = []oscoreContext{
+	{
+		Name:         "oscore-tv1",
+		MasterSecret: mustHex("0102030405060708090a0b0c0d0e0f10"),
+		MasterSalt:   mustHex("9e7ca92223786340"),
+		SenderID:     []byte{},
+		RecipientID:  []byte{0x01},
+	},
+	{
+		Name:         "oscore-tv2",
+		MasterSecret: mustHex("0102030405060708090a0b0c0d0e0f10"),
+		SenderID:     []byte{0x00},
+		RecipientID:  []byte{0x01},
+	},
+	{
+		Name:         "oscore-tv3",
+		MasterSecret: mustHex("0102030405060708090a0b0c0d0e0f10"),
+		MasterSalt:   mustHex("9e7ca92223786340"),
+		SenderID:     []byte{},
+		RecipientID:  []byte{0x01},
+		IDContext:    mustHex("37cbf3210017a2d3"),
+	},
+}
+
+// Derived values as published in RFC 8613 Appendix C.1 to C.3.
+var oscoreExpected = []struct {
+	SenderKey, RecipientKey, CommonIV string
+}{
+	{"f0910ed7295e6ad4b54fc793154302ff", "ffb14e093c94c9cac9471648b4f98710", "4622d4dd6d944168eefb54987c"},
+	{"321b26943253c7ffb6003b0b64d74041", "e57b5635815177cd679ab4bcec9d7dda", "be35ae297d2dace910c52e99f9"},
+	{"af2a1300a5e95788b356336eeecd2b92", "e39a0c7c77b43f03b4b39ab9a268699f", "2ca58fb85ff1b81c0b7181b85e"},
+}
+
+// Protected messages as published in RFC 8613 Appendix C.4 to C.8.
+var oscoreExpectedMessages = map[string]string{
+	"oscore-tv4-protected": "44025d1f00003974396c6f63616c686f7374620914ff612f1092f1776f1c1668b3825e",
+	"oscore-tv5-protected": "44025d1f00003974396c6f63616c686f737463091400ff4ed339a5a379b0b8bc731fffb0",
+	"oscore-tv6-protected": "44025d1f00003974396c6f63616c686f73746b19140837cbf3210017a2d3ff72cd7273fd331ac45cffbe55c3",
+	"oscore-tv7-protected": "64445d1f0000397490ffdbaad1e9a7e7b2a813d3c31524378303cdafae119106",
+	"oscore-tv8-protected": "64445d1f00003974920100ff4d4c13669384b67354b2b6175ff4b8658c666a6cf88e",
+}
+
+// Unprotected request of RFC 8613 Appendix C.4 to C.6:
+// GET coap://localhost/tv1
+var oscoreRequest = message.Message{
+	Code:      codes.GET,
+	Token:     []byte{0x00, 0x00, 0x39, 0x74},
+	Payload:   []byte{},
+	MessageID: 0x5d1f,
+	Type:      message.Confirmable,
+	Options: coap.Options{
+		{ID: coap.URIHost, Value: []byte("localhost")},
+		{ID: coap.URIPath, Value: []byte("tv1")},
+	},
+}
+
+// Unprotected response of RFC 8613 Appendix C.7 and C.8.
+var oscoreResponse = message.Message{
+	Code:      codes.Content,
+	Token:     []byte{0x00, 0x00, 0x39, 0x74},
+	Payload:   []byte("Hello World!"),
+	MessageID: 0x5d1f,
+	Type:      message.Acknowledgement,
+}
+
+// Derive the given client security context and compare the derived
+// values against those published in the RFC.
+func oscoreClient(tv int) (oscoreContext, error) {
+	c := oscoreContexts[tv-1]
+	c.init()
+
+	exp := oscoreExpected[tv-1]
+	if hex.EncodeToString(c.SenderKey) != exp.SenderKey ||
+		hex.EncodeToString(c.RecipientKey) != exp.RecipientKey ||
+		hex.EncodeToString(c.CommonIV) != exp.CommonIV {
+		return oscoreContext{}, fmt.Errorf("%s: derived context does not match RFC 8613", c.Name)
+	}
+
+	return c, nil
+}
+
+// Protect the given message using the security context of the given
+// test vector and compare the result against the RFC.
+func oscoreProtected(name string, tv int, server bool, m *oscoreMessage) ([]byte, error) {
+	c, err := oscoreClient(tv)
+	if err != nil {
+		return nil, err
+	}
+	if server {
+		c = c.server()
+	}
+
+	data, err := c.protect(m)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(data) != oscoreExpectedMessages[name] {
+		return nil, fmt.Errorf("%s: protected message does not match RFC 8613", name)
+	}
+
+	return data, nil
+}
+
+func oscoreUnprotected(m message.Message) genFn {
+	return func() ([]byte, error) {
+		return marshal(m)
+	}
+}
+
+// Sender Sequence Number 20 of the client in Appendix C.4 to C.6.
+var oscorePIV = []byte{0x14}
+
+func oscoreRequestVector(name string, tv int) genFn {
+	return func() ([]byte, error) {
+		kid := oscoreContexts[tv-1].SenderID
+		return oscoreProtected(name, tv, false, &oscoreMessage{
+			Msg:        oscoreRequest,
+			IDPIV:      kid,
+			PIV:        oscorePIV,
+			RequestKID: kid,
+			RequestPIV: oscorePIV,
+			SendPIV:    true,
+			SendKID:    true,
+		})
+	}
+}
+
+// Response without Partial IV, the nonce is derived from the request.
+func oscoreTV7() ([]byte, error) {
+	kid := oscoreContexts[0].SenderID
+	return oscoreProtected("oscore-tv7-protected", 1, true, &oscoreMessage{
+		Msg:        oscoreResponse,
+		IDPIV:      kid,
+		PIV:        oscorePIV,
+		RequestKID: kid,
+		RequestPIV: oscorePIV,
+	})
+}
+
+// Response with Partial IV (Sender Sequence Number 0 of the server).
+func oscoreTV8() ([]byte, error) {
+	kid := oscoreContexts[0].SenderID
+	return oscoreProtected("oscore-tv8-protected", 1, true, &oscoreMessage{
+		Msg:        oscoreResponse,
+		IDPIV:      oscoreContexts[0].RecipientID,
+		PIV:        []byte{0x00},
+		RequestKID: kid,
+		RequestPIV: oscorePIV,
+		SendPIV:    true,
+	})
+}
+
+// Write the security contexts, including the derived keys, to the given
+// file as JSON. Byte strings are hex encoded, an absent master salt or
+// ID context is recorded as null.
+func writeOSCOREContexts(fp string) error {
+	type jsonContext struct {
+		Name         string  `json:"name"`
+		MasterSecret string  `json:"master_secret"`
+		MasterSalt   *string `json:"master_salt"`
+		SenderID     string  `json:"sender_id"`
+		RecipientID  string  `json:"recipient_id"`
+		IDContext    *string `json:"id_context"`
+		SenderKey    string  `json:"sender_key"`
+		RecipientKey string  `json:"recipient_key"`
+		CommonIV     string  `json:"common_iv"`
+	}
+	optHex := func(b []byte) *string {
+		if b == nil {
+			return nil
+		}
+		s := hex.EncodeToString(b)
+		return &s
+	}
+
+	var contexts []jsonContext
+	for i := range oscoreContexts {
+		client, err := oscoreClient(i + 1)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range []oscoreContext{client, client.server()} {
+			contexts = append(contexts, jsonContext{
+				Name:         c.Name,
+				MasterSecret: hex.EncodeToString(c.MasterSecret),
+				MasterSalt:   optHex(c.MasterSalt),
+				SenderID:     hex.EncodeToString(c.SenderID),
+				RecipientID:  hex.EncodeToString(c.RecipientID),
+				IDContext:    optHex(c.IDContext),
+				SenderKey:    hex.EncodeToString(c.SenderKey),
+				RecipientKey: hex.EncodeToString(c.RecipientKey),
+				CommonIV:     hex.EncodeToString(c.CommonIV),
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(contexts, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fp, append(data, '\n'), 0644)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+)
+
+// Export of test vectors as a pcapng capture file. Each message is
+// wrapped in a UDP datagram and an IPv4 packet, requests are sent from
+// the client to the server and all other messages in the opposite
+// direction. Test vectors not using the UDP message format are skipped.
+//
+// See https://datatracker.ietf.org/doc/html/draft-ietf-opsawg-pcapng
+
+const (
+	pcapLinkTypeRaw = 101 // LINKTYPE_RAW, raw IPv4 or IPv6 packets
+	pcapClientPort  = 56830
+	pcapServerPort  = 5683
+)
+
+var (
+	pcapClient = net.IPv4(192, 0, 2, 1).To4()
+	pcapServer = net.IPv4(192, 0, 2, 2).To4()
+)
+
+func pcapPad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+type pcapSectionHeader struct {
+	ByteOrderMagic uint32
+	Major, Minor   uint16
+	SectionLength  int64
+}
+
+type pcapInterfaceDescription struct {
+	LinkType uint16
+	Reserved uint16
+	SnapLen  uint32
+}
+
+type pcapEnhancedPacket struct {
+	InterfaceID   uint32
+	TimestampHigh uint32
+	TimestampLow  uint32
+	CapturedLen   uint32
+	OriginalLen   uint32
+}
+
+// Encode a pcapng block with the given type, fixed-size header and
+// variable-length data (e.g. packet data and options).
+func pcapBlock(buf *bytes.Buffer, typ uint32, hdr interface{}, data []byte) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, hdr)
+	body.Write(data)
+
+	length := uint32(12 + body.Len())
+	binary.Write(buf, binary.LittleEndian, typ)
+	binary.Write(buf, binary.LittleEndian, length)
+	buf.Write(body.Bytes())
+	binary.Write(buf, binary.LittleEndian, length)
+}
+
+// Internet checksum as specified in RFC 1071.
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+func ipv4UDP(id uint16, src, dst net.IP, sport, dport uint16, payload []byte) []byte {
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], sport)
+	binary.BigEndian.PutUint16(udp[2:], dport)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	pseudo := append(append([]byte{}, src...), dst...)
+	pseudo = append(pseudo, 0, 17, udp[4], udp[5])
+	sum := checksum(append(pseudo, udp...))
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+
+	ip := []byte{
+		0x45, 0x00, 0, 0, // Version, IHL, TOS, total length
+		0, 0, 0x40, 0x00, // Identification, DF
+		64, 17, 0, 0, // TTL, protocol (UDP), checksum
+	}
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+	binary.BigEndian.PutUint16(ip[4:], id)
+	ip = append(append(ip, src...), dst...)
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+
+	return append(ip, udp...)
+}
+
+// Enhanced packet block, the name of the test vector is recorded as a
+// packet comment. Packets are timestamped one millisecond apart.
+func pcapPacket(buf *bytes.Buffer, seq int, name string, packet []byte) {
+	ts := uint64(seq) * 1000
+	hdr := pcapEnhancedPacket{
+		TimestampHigh: uint32(ts >> 32),
+		TimestampLow:  uint32(ts),
+		CapturedLen:   uint32(len(packet)),
+		OriginalLen:   uint32(len(packet)),
+	}
+
+	// opt_comment, followed by opt_endofopt
+	data := pcapPad(append([]byte{}, packet...))
+	data = append(data, 1, 0, byte(len(name)), byte(len(name)>>8))
+	data = pcapPad(append(data, name...))
+	data = append(data, 0, 0, 0, 0)
+
+	pcapBlock(buf, 6, &hdr, data)
+}
+
+// Section header and interface description block.
+func pcapHeader(buf *bytes.Buffer) {
+	pcapBlock(buf, 0x0a0d0d0a, &pcapSectionHeader{
+		ByteOrderMagic: 0x1a2b3c4d,
+		Major:          1,
+		SectionLength:  -1, // not specified
+	}, nil)
+	pcapBlock(buf, 1, &pcapInterfaceDescription{
+		LinkType: pcapLinkTypeRaw,
+	}, nil)
+}
+
+func writePcap(fp string, vectors []generated) error {
+	var buf bytes.Buffer
+	pcapHeader(&buf)
+
+	var n int
+	for i := range vectors {
+		v := &vectors[i]
+		if v.Format != formatUDP || !selected(v) {
+			continue
+		}
+
+		src, dst := pcapServer, pcapClient
+		sport, dport := uint16(pcapServerPort), uint16(pcapClientPort)
+		if len(v.Data) >= 4 && v.Data[1] != 0 && v.Data[1]>>5 == 0 {
+			src, dst = dst, src
+			sport, dport = dport, sport
+		}
+
+		packet := ipv4UDP(uint16(n), src, dst, sport, dport, v.Data)
+		pcapPacket(&buf, n, v.Name, packet)
+		n++
+	}
+
+	return os.WriteFile(fp, buf.Bytes(), 0644)
+}
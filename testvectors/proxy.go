@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Requests to a forward-proxy using the Proxy-Uri and Proxy-Scheme
+// options (RFC 7252 Section 5.10.2). Both options are critical and
+// unsafe to forward, a proxy which does not understand them must not
+// forward the request.
+
+func proxyRequest(mid uint16, opts coap.Options) ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.GET,
+		Token:     []byte{0x9f, 0x01},
+		Payload:   []byte{},
+		MessageID: mid,
+		Type:      message.Confirmable,
+		Options:   opts,
+	})
+}
+
+func proxyURI() ([]byte, error) {
+	return proxyRequest(0x0d32, coap.Options{
+		{ID: coap.ProxyURI, Value: []byte("coap://example.org/temperature")},
+	})
+}
+
+// Proxy-Uri whose length requires the two byte extended option length.
+func proxyURILong() ([]byte, error) {
+	uri := "http://example.org/" + strings.Repeat("a", 300)
+	return proxyRequest(0x0d33, coap.Options{
+		{ID: coap.ProxyURI, Value: []byte(uri)},
+	})
+}
+
+// From RFC 7252:
+//
+//	When a Proxy-Scheme Option is present, the absolute-URI is
+//	constructed as follows: a CoAP URI is constructed from the Uri-*
+//	options as defined in Section 6.5. In the resulting URI, the
+//	initial scheme up to, but not including, the following ":" is then
+//	replaced by the content of the Proxy-Scheme Option.
+func proxyScheme() ([]byte, error) {
+	return proxyRequest(0x0d34, coap.Options{
+		{ID: coap.URIHost, Value: []byte("example.org")},
+		{ID: coap.URIPath, Value: []byte("temperature")},
+		{ID: coap.ProxyScheme, Value: []byte("http")},
+	})
+}
+
+// From RFC 7252:
+//
+//	The Proxy-Uri Option MUST take precedence over any of the Uri-Host,
+//	Uri-Port, Uri-Path or Uri-Query options (each of which MUST NOT be
+//	included in a request containing the Proxy-Uri Option).
+func proxyURIWithURIPath() ([]byte, error) {
+	return proxyRequest(0x0d35, coap.Options{
+		{ID: coap.URIPath, Value: []byte("ignored")},
+		{ID: coap.ProxyURI, Value: []byte("coap://example.org/temperature")},
+	})
+}
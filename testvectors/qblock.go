@@ -0,0 +1,172 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Robust block-wise transfers using the Q-Block1 and Q-Block2 options as
+// specified in RFC 9177. The Q-Block options are encoded like the Block
+// options of RFC 7959, but blocks are sent as NON messages without
+// waiting for a response to each block. Both sequences lose one block
+// in transit, the loss is recovered by requesting the missing block.
+const (
+	qBlock1Option coap.OptionID = 19
+	qBlock2Option coap.OptionID = 31
+
+	// application/missing-blocks+cbor-seq
+	missingBlocksFormat = 272
+)
+
+const (
+	qBlockSZX    = 2
+	qBlockBlocks = 4
+)
+
+var qBlockETag = []byte{0x9e, 0x17}
+
+func qBlockToken(num uint32) []byte {
+	return []byte{0x9b, byte(num)}
+}
+
+// Q-Block1 transfer where the second block is lost. The server reports
+// the missing block in a 4.08 (Request Entity Incomplete) response whose
+// payload is a CBOR sequence of the missing block numbers.
+func qBlock1Sequence() ([]vector, error) {
+	body := blockBody(blockSize(qBlockSZX) * qBlockBlocks)
+
+	request := func(num uint32, mid uint16) ([]byte, error) {
+		block, more := blockSlice(body, num, qBlockSZX)
+		return marshal(message.Message{
+			Code:      codes.PUT,
+			Token:     qBlockToken(num),
+			Payload:   block,
+			MessageID: mid,
+			Type:      message.NonConfirmable,
+			Options: coap.Options{
+				{ID: coap.URIPath, Value: []byte("large")},
+				{ID: qBlock1Option, Value: blockValue(num, more, qBlockSZX)},
+				{ID: requestTagOption, Value: []byte{0x01}},
+			},
+		})
+	}
+
+	var msgs []vector
+	for num := uint32(0); num < qBlockBlocks; num++ {
+		req, err := request(num, 0x0d50+uint16(num))
+		if err != nil {
+			return nil, err
+		}
+
+		note := ""
+		if num == 1 {
+			note = "lost in transit"
+		}
+		msgs = append(msgs, vector{Data: req, Note: note})
+	}
+
+	missing, err := marshal(message.Message{
+		Code:      codes.RequestEntityIncomplete,
+		Token:     qBlockToken(qBlockBlocks - 1),
+		Payload:   []byte{0x01}, // CBOR unsigned integer 1
+		MessageID: 0x0e50,
+		Type:      message.NonConfirmable,
+		Options: coap.Options{
+			{ID: coap.ContentFormat, Value: uintValue(missingBlocksFormat)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	retry, err := request(1, 0x0d50+qBlockBlocks)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := marshal(message.Message{
+		Code:      codes.Changed,
+		Token:     qBlockToken(1),
+		Payload:   []byte{},
+		MessageID: 0x0e51,
+		Type:      message.NonConfirmable,
+		Options: coap.Options{
+			{ID: qBlock1Option, Value: blockValue(qBlockBlocks-1, false, qBlockSZX)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(msgs,
+		vector{Data: missing, Note: "missing blocks: 1"},
+		vector{Data: retry, Note: "retransmission of missing block"},
+		vector{Data: resp, Note: "body complete"},
+	), nil
+}
+
+// Q-Block2 transfer where the third block is lost. The client requests
+// the missing block using a Q-Block2 option with the block number.
+func qBlock2Sequence() ([]vector, error) {
+	body := blockBody(blockSize(qBlockSZX) * qBlockBlocks)
+
+	request := func(num uint32, mid uint16) ([]byte, error) {
+		return marshal(message.Message{
+			Code:      codes.GET,
+			Token:     qBlockToken(0x20 + num),
+			Payload:   []byte{},
+			MessageID: mid,
+			Type:      message.NonConfirmable,
+			Options: coap.Options{
+				{ID: coap.URIPath, Value: []byte("large")},
+				{ID: qBlock2Option, Value: blockValue(num, false, qBlockSZX)},
+			},
+		})
+	}
+	response := func(num uint32, token []byte) ([]byte, error) {
+		block, more := blockSlice(body, num, qBlockSZX)
+		return marshal(message.Message{
+			Code:      codes.Content,
+			Token:     token,
+			Payload:   block,
+			MessageID: 0x0e60 + uint16(num),
+			Type:      message.NonConfirmable,
+			Options: coap.Options{
+				{ID: coap.ETag, Value: qBlockETag},
+				{ID: qBlock2Option, Value: blockValue(num, more, qBlockSZX)},
+			},
+		})
+	}
+
+	req, err := request(0, 0x0d60)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []vector{{Data: req, Note: "request"}}
+
+	for num := uint32(0); num < qBlockBlocks; num++ {
+		resp, err := response(num, qBlockToken(0x20))
+		if err != nil {
+			return nil, err
+		}
+
+		note := ""
+		if num == 2 {
+			note = "lost in transit"
+		}
+		msgs = append(msgs, vector{Data: resp, Note: note})
+	}
+
+	retry, err := request(2, 0x0d61)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := response(2, qBlockToken(0x22))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(msgs,
+		vector{Data: retry, Note: "request for missing block"},
+		vector{Data: resp, Note: "body complete"},
+	), nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Repeatable options (RFC 7252 Section 5.4.5). Multiple instances of
+// the same option are encoded using an option delta of zero. Since the
+// order of Uri-Path and Uri-Query instances is significant, it must be
+// preserved by the parser.
+
+// Uri-Path segments, including empty segments (e.g. for a trailing slash).
+var repeatedPath = []string{"a", "", "b", "c", "", "d", "e", "f", "g", "h", "i", ""}
+
+// Uri-Query arguments, including repeated keys and an empty argument.
+var repeatedQuery = []string{"x=1", "y=2", "x=3", "", "flag", "x=4"}
+
+func repeatedOptions(id coap.OptionID, values []string) coap.Options {
+	var opts coap.Options
+	for _, v := range values {
+		opts = append(opts, coap.Option{ID: id, Value: []byte(v)})
+	}
+	return opts
+}
+
+func repeatedMessage(opts coap.Options) ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.GET,
+		Token:     []byte{},
+		Payload:   []byte{},
+		MessageID: 0x0d19,
+		Type:      message.Confirmable,
+		Options:   opts,
+	})
+}
+
+func repeatedURIPath() ([]byte, error) {
+	return repeatedMessage(repeatedOptions(coap.URIPath, repeatedPath))
+}
+
+func repeatedURIQuery() ([]byte, error) {
+	return repeatedMessage(repeatedOptions(coap.URIQuery, repeatedQuery))
+}
+
+func repeatedURIPathAndQuery() ([]byte, error) {
+	opts := repeatedOptions(coap.URIPath, repeatedPath)
+	opts = append(opts, repeatedOptions(coap.URIQuery, repeatedQuery)...)
+	return repeatedMessage(opts)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	tcp "github.com/plgd-dev/go-coap/v2/tcp/message"
+)
+
+// Signaling messages (7.xx) as specified in RFC 8323 Section 5. Option
+// numbers of signaling messages are specific to the signaling code.
+
+func csm() ([]byte, error) {
+	return marshalTCP(tcp.Message{
+		Code:    codes.CSM,
+		Token:   []byte{},
+		Payload: []byte{},
+		Options: coap.Options{
+			{ID: tcp.MaxMessageSize, Value: uintValue(8192)},
+			{ID: tcp.BlockWiseTransfer, Value: []byte{}},
+		},
+	})
+}
+
+// CSM without options which implies the default Max-Message-Size of
+// 1152 bytes and no support for block-wise transfers.
+func csmEmpty() ([]byte, error) {
+	return marshalTCP(tcp.Message{
+		Code:    codes.CSM,
+		Token:   []byte{},
+		Payload: []byte{},
+	})
+}
+
+func ping() ([]byte, error) {
+	return marshalTCP(tcp.Message{
+		Code:    codes.Ping,
+		Token:   []byte{0x91},
+		Payload: []byte{},
+		Options: coap.Options{
+			{ID: tcp.Custody, Value: []byte{}},
+		},
+	})
+}
+
+// From RFC 8323:
+//
+//	The receiver of a Ping message MUST send a Pong message with a
+//	Custody Option in response if and only if the Ping message
+//	carries a Custody Option.
+func pong() ([]byte, error) {
+	return marshalTCP(tcp.Message{
+		Code:    codes.Pong,
+		Token:   []byte{0x91},
+		Payload: []byte{},
+		Options: coap.Options{
+			{ID: tcp.Custody, Value: []byte{}},
+		},
+	})
+}
+
+func release() ([]byte, error) {
+	return marshalTCP(tcp.Message{
+		Code:    codes.Release,
+		Token:   []byte{},
+		Payload: []byte{},
+		Options: coap.Options{
+			{ID: tcp.AlternativeAddress, Value: []byte("[2001:db8::1]:5683")},
+			{ID: tcp.HoldOff, Value: uintValue(30)},
+		},
+	})
+}
+
+// Abort caused by a Max-Message-Size option (2) in a CSM, the payload
+// contains a diagnostic message.
+func abort() ([]byte, error) {
+	return marshalTCP(tcp.Message{
+		Code:    codes.Abort,
+		Token:   []byte{},
+		Payload: []byte("unsupported Max-Message-Size"),
+		Options: coap.Options{
+			{ID: tcp.BadCSMOption, Value: uintValue(uint32(tcp.MaxMessageSize))},
+		},
+	})
+}
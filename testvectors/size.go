@@ -0,0 +1,96 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Size1 and Size2 options as specified in RFC 7959 Section 4. The sizes
+// are chosen to require uint option values of 1, 2, 3, and 4 bytes.
+//
+// From RFC 7959:
+//
+//	In a request carrying a Block1 Option, to indicate the current
+//	estimate the client has of the total size of the resource
+//	representation, measured in bytes ("size indication").
+//
+//	In a 4.13 response, to indicate the maximum size that would have
+//	been acceptable, measured in bytes.
+
+var sizeToken = []byte{0x51, 0xe2}
+
+// First block of a Block1 transfer, indicating the total size of the
+// request body using Size1.
+func size1Request(size uint32) genFn {
+	return func() ([]byte, error) {
+		const szx = 2
+		block, _ := blockSlice(blockBody(blockSize(szx)), 0, szx)
+
+		return marshal(message.Message{
+			Code:      codes.PUT,
+			Token:     sizeToken,
+			Payload:   block,
+			MessageID: 0x0d24,
+			Type:      message.Confirmable,
+			Options: coap.Options{
+				{ID: coap.URIPath, Value: []byte("large")},
+				{ID: coap.Block1, Value: blockValue(0, true, szx)},
+				{ID: coap.Size1, Value: uintValue(size)},
+			},
+		})
+	}
+}
+
+func size1TooLarge() ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.RequestEntityTooLarge,
+		Token:     sizeToken,
+		Payload:   []byte{},
+		MessageID: 0x0d24,
+		Type:      message.Acknowledgement,
+		Options: coap.Options{
+			{ID: coap.Size1, Value: uintValue(1024)},
+		},
+	})
+}
+
+// From RFC 7959:
+//
+//	In a request, to ask the server to provide a size estimate along
+//	with the usual response ("size request").  For this usage, the
+//	value MUST be set to 0.
+func size2Request() ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.GET,
+		Token:     sizeToken,
+		Payload:   []byte{},
+		MessageID: 0x0d25,
+		Type:      message.Confirmable,
+		Options: coap.Options{
+			{ID: coap.URIPath, Value: []byte("large")},
+			{ID: coap.Size2, Value: uintValue(0)},
+		},
+	})
+}
+
+// First block of a Block2 transfer, indicating the total size of the
+// representation using Size2.
+func size2Response(size uint32) genFn {
+	return func() ([]byte, error) {
+		const szx = 2
+		block, _ := blockSlice(blockBody(blockSize(szx)), 0, szx)
+
+		return marshal(message.Message{
+			Code:      codes.Content,
+			Token:     sizeToken,
+			Payload:   block,
+			MessageID: 0x0d25,
+			Type:      message.Acknowledgement,
+			Options: coap.Options{
+				{ID: coap.Block2, Value: blockValue(0, true, szx)},
+				{ID: coap.Size2, Value: uintValue(size)},
+			},
+		})
+	}
+}
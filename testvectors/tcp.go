@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	tcp "github.com/plgd-dev/go-coap/v2/tcp/message"
+)
+
+// Messages in the CoAP over TCP message format as specified in RFC 8323.
+//
+// From RFC 8323:
+//
+//	| Len value  | Extended Length size  | Total length              |
+//	+------------+-----------------------+---------------------------+
+//	| 0-12       | 0                     | Len                       |
+//	| 13         | 1                     | Extended Length + 13      |
+//	| 14         | 2                     | Extended Length + 269     |
+//	| 15         | 4                     | Extended Length + 65805   |
+//
+// The length only covers options and payload (including the payload
+// marker), not the header and the token.
+
+var tcpToken = []byte{0x7c, 0xb0}
+
+// 2.05 response whose options and payload have the given total length.
+func tcpResponse(length int) ([]byte, error) {
+	// Content-Format option (1 byte) and payload marker (1 byte).
+	payload := bytes.Repeat([]byte{'x'}, length-2)
+
+	return marshalTCP(tcp.Message{
+		Code:    codes.Content,
+		Token:   tcpToken,
+		Payload: payload,
+		Options: coap.Options{
+			{ID: coap.ContentFormat, Value: []byte{}},
+		},
+	})
+}
+
+func tcpExtLength0() ([]byte, error) {
+	return marshalTCP(tcp.Message{
+		Code:    codes.GET,
+		Token:   tcpToken,
+		Payload: []byte{},
+		Options: coap.Options{
+			{ID: coap.URIPath, Value: []byte("tcp")},
+		},
+	})
+}
+
+func tcpExtLength1() ([]byte, error) {
+	return tcpResponse(13)
+}
+
+func tcpExtLength2() ([]byte, error) {
+	return tcpResponse(269)
+}
+
+func tcpExtLength4() ([]byte, error) {
+	return tcpResponse(65805)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+// Extended token lengths as specified in RFC 8974. Since go-coap only
+// supports tokens of up to 8 bytes, these messages are hand-crafted.
+//
+// From RFC 8974:
+//
+//	13:  An 8-bit unsigned integer directly precedes the Token field
+//	     and indicates the length of the Token minus 13.
+//
+//	14:  A 16-bit unsigned integer in network byte order directly
+//	     precedes the Token field and indicates the length of the
+//	     Token minus 269.
+
+const rfc8974 = "extended token length as specified in RFC 8974"
+
+func extendedToken(length int) genFn {
+	return func() ([]byte, error) {
+		var data []byte
+		if length < 269 {
+			// Ver = 1, T = CON, TKL = 13, Code = GET, MID = 42
+			data = []byte{0x4d, 0x01, 0x00, 0x2a, byte(length - 13)}
+		} else {
+			// Ver = 1, T = CON, TKL = 14, Code = GET, MID = 42
+			data = []byte{0x4e, 0x01, 0x00, 0x2a, 0, 0}
+			binary.BigEndian.PutUint16(data[4:], uint16(length-269))
+		}
+
+		for i := 0; i < length; i++ {
+			data = append(data, byte(i))
+		}
+		return data, nil
+	}
+}
+
+func truncatedExtendedToken() ([]byte, error) {
+	return []byte{
+		// Ver = 1, T = CON, TKL = 14, Code = GET, MID = 42
+		0x4e, 0x01, 0x00, 0x2a,
+		// Only one of the two extended token length bytes is present
+		0x00,
+	}, nil
+}
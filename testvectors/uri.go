@@ -0,0 +1,90 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Uri-Host, Uri-Port, and Location-* options. Contrary to URIs, these
+// options contain the decoded value of each component (RFC 7252 Section
+// 6.4), i.e. percent-encoded characters, including non-ASCII UTF-8
+// sequences, appear as raw bytes. The corresponding URI is recorded as
+// a note for these vectors.
+
+var uriToken = []byte{0x0a, 0x34}
+
+func uriRequest(opts coap.Options) ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.GET,
+		Token:     uriToken,
+		Payload:   []byte{},
+		MessageID: 0x0d34,
+		Type:      message.Confirmable,
+		Options:   opts,
+	})
+}
+
+func created(opts coap.Options) ([]byte, error) {
+	return marshal(message.Message{
+		Code:      codes.Created,
+		Token:     uriToken,
+		Payload:   []byte{},
+		MessageID: 0x0d34,
+		Type:      message.Acknowledgement,
+		Options:   opts,
+	})
+}
+
+func uriHostPort() ([]byte, error) {
+	return uriRequest(coap.Options{
+		{ID: coap.URIHost, Value: []byte("example.org")},
+		{ID: coap.URIPort, Value: uintValue(61616)},
+		{ID: coap.URIPath, Value: []byte("res")},
+	})
+}
+
+func uriPathUTF8() ([]byte, error) {
+	return uriRequest(coap.Options{
+		{ID: coap.URIHost, Value: []byte("example.org")},
+		{ID: coap.URIPath, Value: []byte("äpfel")},
+	})
+}
+
+func uriPathSlash() ([]byte, error) {
+	return uriRequest(coap.Options{
+		{ID: coap.URIHost, Value: []byte("example.org")},
+		{ID: coap.URIPath, Value: []byte("a/b")},
+	})
+}
+
+func uriQueryUTF8() ([]byte, error) {
+	return uriRequest(coap.Options{
+		{ID: coap.URIHost, Value: []byte("example.org")},
+		{ID: coap.URIPath, Value: []byte("users")},
+		{ID: coap.URIQuery, Value: []byte("name=Jürgen")},
+	})
+}
+
+func locationPath() ([]byte, error) {
+	return created(coap.Options{
+		{ID: coap.LocationPath, Value: []byte("items")},
+		{ID: coap.LocationPath, Value: []byte("42")},
+	})
+}
+
+func locationPathQuery() ([]byte, error) {
+	return created(coap.Options{
+		{ID: coap.LocationPath, Value: []byte("items")},
+		{ID: coap.LocationPath, Value: []byte("42")},
+		{ID: coap.LocationQuery, Value: []byte("rev=1")},
+		{ID: coap.LocationQuery, Value: []byte("a&b")},
+	})
+}
+
+func locationPathUTF8() ([]byte, error) {
+	return created(coap.Options{
+		{ID: coap.LocationPath, Value: []byte("prices")},
+		{ID: coap.LocationPath, Value: []byte("€")},
+	})
+}
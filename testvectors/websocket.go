@@ -0,0 +1,63 @@
+package main
+
+import (
+	coap "github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+// Messages in the CoAP over WebSockets message format (RFC 8323).
+//
+// From RFC 8323:
+//
+//	The message format shown in Figure 16 is the same as the CoAP over
+//	TCP message format (see Section 3.2) with one change: the Length
+//	(Len) field MUST be set to zero, because the WebSocket frame
+//	contains the length.
+//
+// Contrary to the UDP message format, there is neither a version, a
+// type, nor a Message ID and the code directly follows the first byte.
+
+var wsToken = []byte{0x3b, 0x50, 0xc4}
+
+// Since go-coap does not support the WebSocket message format, the
+// messages are marshaled manually using the go-coap option encoder.
+func wsMarshal(code codes.Code, token []byte, opts coap.Options, payload []byte) ([]byte, error) {
+	n, err := opts.Marshal(nil)
+	if err != coap.ErrTooSmall {
+		return nil, err
+	}
+	encoded := make([]byte, n)
+	_, err = opts.Marshal(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	data := append([]byte{byte(len(token)), byte(code)}, token...)
+	data = append(data, encoded...)
+	if len(payload) > 0 {
+		data = append(data, 0xff)
+		data = append(data, payload...)
+	}
+
+	return data, nil
+}
+
+func wsRequest() ([]byte, error) {
+	opts := coap.Options{
+		{ID: coap.URIPath, Value: []byte("ws")},
+	}
+	return wsMarshal(codes.GET, wsToken, opts, []byte{})
+}
+
+// Response whose options and payload exceed the maximum value of the Len
+// field in the TCP message format, the Len field must nonetheless be zero.
+func wsResponse() ([]byte, error) {
+	opts := coap.Options{
+		{ID: coap.ContentFormat, Value: uintValue(uint32(coap.TextPlain))},
+	}
+	return wsMarshal(codes.Content, wsToken, opts, []byte("Hello, WebSocket!"))
+}
+
+func wsNoToken() ([]byte, error) {
+	return wsMarshal(codes.DELETE, []byte{}, coap.Options{}, []byte{})
+}